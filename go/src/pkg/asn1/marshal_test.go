@@ -112,6 +112,7 @@ var marshalTests = []marshalTest{
 	{rawContentsStruct{nil, 64}, "3003020140"},
 	{rawContentsStruct{[]byte{0x30, 3, 1, 2, 3}, 64}, "3003010203"},
 	{RawValue{Tag: 1, Class: 2, IsCompound: false, Bytes: []byte{1, 2, 3}}, "8103010203"},
+	{RawValue{FullBytes: []byte{0x30, 3, 2, 1, 10}}, "300302010a"},
 	{testSET([]int{10}), "310302010a"},
 }
 
@@ -82,3 +82,28 @@ func TestWriter(t *testing.T) {
 			}
 		})
 }
+
+// Tests that data written before Flush can be read before Close.
+func TestFlush(t *testing.T) {
+	flushed := make(chan bool)
+	pipe(t,
+		func(compressor *Compressor) {
+			if _, err := compressor.Write([]byte("payload")); err != nil {
+				t.Fatalf("%v", err)
+			}
+			if err := compressor.Flush(); err != nil {
+				t.Fatalf("%v", err)
+			}
+			<-flushed
+		},
+		func(decompressor *Decompressor) {
+			b := make([]byte, len("payload"))
+			if _, err := io.ReadFull(decompressor, b); err != nil {
+				t.Fatalf("%v", err)
+			}
+			close(flushed)
+			if string(b) != "payload" {
+				t.Fatalf("payload is %q, want %q", string(b), "payload")
+			}
+		})
+}
@@ -5,9 +5,11 @@
 // Package cipher implements standard block cipher modes that can be wrapped
 // around low-level block cipher implementations.
 // See http://csrc.nist.gov/groups/ST/toolkit/BCM/current_modes.html
-// and NIST Special Publication 800-38A.
+// and NIST Special Publications 800-38A and 800-38D.
 package cipher
 
+import "os"
+
 // A Block represents an implementation of block cipher
 // using a given key.  It provides the capability to encrypt
 // or decrypt individual blocks.  The mode implementations
@@ -44,6 +46,36 @@ type BlockMode interface {
 	CryptBlocks(dst, src []byte)
 }
 
+// An AEAD is a cipher mode providing authenticated encryption with
+// associated data.
+type AEAD interface {
+	// NonceSize returns the size of the nonce that must be passed to Seal
+	// and Open.
+	NonceSize() int
+
+	// Overhead returns the maximum difference between the lengths of a
+	// plaintext and its ciphertext.
+	Overhead() int
+
+	// Seal encrypts and authenticates plaintext, authenticates the
+	// additional data and appends the result to dst, returning the updated
+	// slice.  The nonce must be NonceSize() bytes long and must never be
+	// used twice with the same key.
+	//
+	// The plaintext and dst may alias exactly or not at all.
+	Seal(dst, nonce, plaintext, data []byte) []byte
+
+	// Open decrypts and authenticates ciphertext, authenticates the
+	// additional data and, if successful, appends the resulting plaintext
+	// to dst, returning the updated slice.  The nonce must be NonceSize()
+	// bytes long and both it and the additional data must match the
+	// value passed to Seal.  If the ciphertext or the additional data has
+	// been tampered with, Open returns an error and nothing else.
+	//
+	// The ciphertext and dst may alias exactly or not at all.
+	Open(dst, nonce, ciphertext, data []byte) ([]byte, os.Error)
+}
+
 // Utility routines
 
 func shift1(dst, src []byte) byte {
@@ -0,0 +1,317 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Galois/Counter Mode (GCM).
+
+// GCM encrypts with a block cipher in counter mode and authenticates the
+// ciphertext, and any additional data, with a polynomial hash over
+// GF(2^128) keyed by the encryption of the zero block.
+
+// See NIST SP 800-38D and
+// http://csrc.nist.gov/groups/ST/toolkit/BCM/documents/proposedmodes/gcm/gcm-revised-spec.pdf
+
+package cipher
+
+import (
+	"crypto/subtle"
+	"os"
+)
+
+const (
+	gcmBlockSize = 16
+	gcmTagSize   = 16
+	gcmNonceSize = 12
+)
+
+// gcmFieldElement represents a value in GF(2^128).  To follow the GCM
+// standard, and so that getUint64 can load these values, the bits are
+// stored backwards:
+//
+//	the coefficient of x^0 is v.low >> 63,
+//	the coefficient of x^63 is v.low & 1,
+//	the coefficient of x^64 is v.high >> 63, and
+//	the coefficient of x^127 is v.high & 1.
+type gcmFieldElement struct {
+	low, high uint64
+}
+
+type gcm struct {
+	b Block
+	// productTable contains the first sixteen multiples of the hash key,
+	// H, indexed by the bit reversal of the multiplier.  See NewGCM.
+	productTable [16]gcmFieldElement
+}
+
+// NewGCM returns an AEAD which encrypts and authenticates using the given
+// Block in Galois/Counter Mode, with the standard 12-byte nonces and
+// 16-byte tags.  The Block's block size must be 16 bytes.
+func NewGCM(block Block) (AEAD, os.Error) {
+	if block.BlockSize() != gcmBlockSize {
+		return nil, os.NewError("cipher.NewGCM: block size must be 16 bytes")
+	}
+
+	var key [gcmBlockSize]byte
+	block.Encrypt(key[:], key[:])
+
+	g := &gcm{b: block}
+
+	// We precompute 16 multiples of the key.  Lookups in the table use
+	// four bits of a field element at a time, which come in reverse
+	// order, so rather than 4*H at index 4 (0100 in base 2) the table
+	// has it at index 2 (0010).
+	x := gcmFieldElement{getUint64(key[:8]), getUint64(key[8:])}
+	g.productTable[reverseBits(1)] = x
+
+	for i := 2; i < 16; i += 2 {
+		g.productTable[reverseBits(i)] = gcmDouble(&g.productTable[reverseBits(i/2)])
+		g.productTable[reverseBits(i+1)] = gcmAdd(&g.productTable[reverseBits(i)], &x)
+	}
+
+	return g, nil
+}
+
+func (g *gcm) NonceSize() int { return gcmNonceSize }
+
+func (g *gcm) Overhead() int { return gcmTagSize }
+
+func (g *gcm) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if len(nonce) != gcmNonceSize {
+		panic("cipher: incorrect nonce length given to GCM")
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+gcmTagSize)
+
+	// See the GCM specification, section 7.1.
+	var counter, tagMask [gcmBlockSize]byte
+	copy(counter[:], nonce)
+	counter[gcmBlockSize-1] = 1
+
+	g.b.Encrypt(tagMask[:], counter[:])
+	gcmInc32(&counter)
+
+	g.counterCrypt(out, plaintext, &counter)
+	g.auth(out[len(plaintext):], out[:len(plaintext)], data, &tagMask)
+
+	return ret
+}
+
+var errOpen = os.NewError("cipher: message authentication failed")
+
+func (g *gcm) Open(dst, nonce, ciphertext, data []byte) ([]byte, os.Error) {
+	if len(nonce) != gcmNonceSize {
+		panic("cipher: incorrect nonce length given to GCM")
+	}
+
+	if len(ciphertext) < gcmTagSize {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-gcmTagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-gcmTagSize]
+
+	// See the GCM specification, section 7.2.
+	var counter, tagMask [gcmBlockSize]byte
+	copy(counter[:], nonce)
+	counter[gcmBlockSize-1] = 1
+
+	g.b.Encrypt(tagMask[:], counter[:])
+	gcmInc32(&counter)
+
+	var expectedTag [gcmTagSize]byte
+	g.auth(expectedTag[:], ciphertext, data, &tagMask)
+
+	if subtle.ConstantTimeCompare(expectedTag[:], tag) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	g.counterCrypt(out, ciphertext, &counter)
+
+	return ret, nil
+}
+
+// reverseBits reverses the order of the bits of the 4-bit number in i.
+func reverseBits(i int) int {
+	i = ((i << 2) & 0xc) | ((i >> 2) & 0x3)
+	i = ((i << 1) & 0xa) | ((i >> 1) & 0x5)
+	return i
+}
+
+// gcmAdd returns the sum of two elements of GF(2^128).
+func gcmAdd(x, y *gcmFieldElement) gcmFieldElement {
+	// Addition in a field of characteristic 2 is just XOR.
+	return gcmFieldElement{x.low ^ y.low, x.high ^ y.high}
+}
+
+// gcmDouble returns twice an element of GF(2^128).
+func gcmDouble(x *gcmFieldElement) (double gcmFieldElement) {
+	msbSet := x.high&1 == 1
+
+	// Because of the bit ordering, doubling is a right shift.
+	double.high = x.high >> 1
+	double.high |= x.low << 63
+	double.low = x.low >> 1
+
+	// If the most significant bit was set before shifting, it becomes
+	// a term of x^128, which must be reduced.  The field polynomial is
+	// 1 + x + x^2 + x^7 + x^128, so subtracting it removes the x^128
+	// term and adds the other four; subtraction, like addition, is XOR.
+	if msbSet {
+		double.low ^= 0xe100000000000000
+	}
+
+	return
+}
+
+// gcmReductionTable holds the reductions of the terms of degree 128 to
+// 131 that multiplying by x^4 produces, indexed by their bits as they
+// fall off the end of a field element in mul.
+var gcmReductionTable = []uint16{
+	0x0000, 0x1c20, 0x3840, 0x2460, 0x7080, 0x6ca0, 0x48c0, 0x54e0,
+	0xe100, 0xfd20, 0xd940, 0xc560, 0x9180, 0x8da0, 0xa9c0, 0xb5e0,
+}
+
+// mul sets y to y*H, where H is the hash key.
+func (g *gcm) mul(y *gcmFieldElement) {
+	var z gcmFieldElement
+
+	for i := 0; i < 2; i++ {
+		word := y.high
+		if i == 1 {
+			word = y.low
+		}
+
+		// Horner's rule, four bits at a time: multiply z by x^4
+		// and add in the multiple of H that the next four bits of
+		// y call for.
+		for j := 0; j < 64; j += 4 {
+			msw := z.high & 0xf
+			z.high >>= 4
+			z.high |= z.low << 60
+			z.low >>= 4
+			z.low ^= uint64(gcmReductionTable[msw]) << 48
+
+			t := &g.productTable[word&0xf]
+
+			z.low ^= t.low
+			z.high ^= t.high
+			word >>= 4
+		}
+	}
+
+	*y = z
+}
+
+// updateBlocks extends the hash y with the blocks in blocks, whose length
+// must be a multiple of the block size.
+func (g *gcm) updateBlocks(y *gcmFieldElement, blocks []byte) {
+	for len(blocks) > 0 {
+		y.low ^= getUint64(blocks)
+		y.high ^= getUint64(blocks[8:])
+		g.mul(y)
+		blocks = blocks[gcmBlockSize:]
+	}
+}
+
+// update extends the hash y with data, padded with zeros
+// to a multiple of the block size.
+func (g *gcm) update(y *gcmFieldElement, data []byte) {
+	fullBlocks := len(data) / gcmBlockSize * gcmBlockSize
+	g.updateBlocks(y, data[:fullBlocks])
+
+	if len(data) != fullBlocks {
+		var partialBlock [gcmBlockSize]byte
+		copy(partialBlock[:], data[fullBlocks:])
+		g.updateBlocks(y, partialBlock[:])
+	}
+}
+
+// gcmInc32 increments the last four bytes of counterBlock,
+// a big-endian number, leaving the others alone.
+func gcmInc32(counterBlock *[gcmBlockSize]byte) {
+	for i := gcmBlockSize - 1; i >= gcmBlockSize-4; i-- {
+		counterBlock[i]++
+		if counterBlock[i] != 0 {
+			break
+		}
+	}
+}
+
+// sliceForAppend takes a slice and a requested number of bytes.  It returns
+// a slice with the contents of the given slice followed by that many bytes,
+// and a second slice that aliases into it and holds only the extra bytes.
+// If the original slice has sufficient capacity then no allocation is done.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
+
+// counterCrypt encrypts or decrypts in into out in counter mode,
+// starting from counter.
+func (g *gcm) counterCrypt(out, in []byte, counter *[gcmBlockSize]byte) {
+	var mask [gcmBlockSize]byte
+
+	for len(in) > 0 {
+		g.b.Encrypt(mask[:], counter[:])
+		gcmInc32(counter)
+
+		n := len(in)
+		if n > gcmBlockSize {
+			n = gcmBlockSize
+		}
+		for i := 0; i < n; i++ {
+			out[i] = in[i] ^ mask[i]
+		}
+		out = out[n:]
+		in = in[n:]
+	}
+}
+
+// auth calculates GHASH(ciphertext, additionalData), masks the result
+// with tagMask and writes it to out.
+func (g *gcm) auth(out, ciphertext, additionalData []byte, tagMask *[gcmTagSize]byte) {
+	var y gcmFieldElement
+	g.update(&y, additionalData)
+	g.update(&y, ciphertext)
+
+	// The lengths, in bits.
+	y.low ^= uint64(len(additionalData)) * 8
+	y.high ^= uint64(len(ciphertext)) * 8
+
+	g.mul(&y)
+
+	putUint64(out, y.low)
+	putUint64(out[8:], y.high)
+
+	for i := range out[:gcmTagSize] {
+		out[i] ^= tagMask[i]
+	}
+}
+
+func getUint64(data []byte) uint64 {
+	return uint64(data[0])<<56 |
+		uint64(data[1])<<48 |
+		uint64(data[2])<<40 |
+		uint64(data[3])<<32 |
+		uint64(data[4])<<24 |
+		uint64(data[5])<<16 |
+		uint64(data[6])<<8 |
+		uint64(data[7])
+}
+
+func putUint64(out []byte, v uint64) {
+	out[0] = byte(v >> 56)
+	out[1] = byte(v >> 48)
+	out[2] = byte(v >> 40)
+	out[3] = byte(v >> 32)
+	out[4] = byte(v >> 24)
+	out[5] = byte(v >> 16)
+	out[6] = byte(v >> 8)
+	out[7] = byte(v)
+}
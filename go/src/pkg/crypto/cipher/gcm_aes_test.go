@@ -0,0 +1,147 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// GCM AES test vectors.
+
+// See the test cases in appendix B of ``The Galois/Counter Mode of
+// Operation (GCM),'' D. McGrew and J. Viega, revised 2005.
+
+package cipher
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+var gcmAESTests = []struct {
+	name   string
+	key    string
+	nonce  string
+	plain  string
+	data   string
+	result string // ciphertext followed by tag
+}{
+	{
+		"Test Case 1",
+		"00000000000000000000000000000000",
+		"000000000000000000000000",
+		"",
+		"",
+		"58e2fccefa7e3061367f1d57a4e7455a",
+	},
+	{
+		"Test Case 2",
+		"00000000000000000000000000000000",
+		"000000000000000000000000",
+		"00000000000000000000000000000000",
+		"",
+		"0388dace60b6a392f328c2b971b2fe78ab6e47d42cec13bdf53a67b21257bddf",
+	},
+	{
+		"Test Case 3",
+		"feffe9928665731c6d6a8f9467308308",
+		"cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255",
+		"",
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f59854d5c2af327cd64a62cf35abd2ba6fab4",
+	},
+	{
+		"Test Case 4",
+		"feffe9928665731c6d6a8f9467308308",
+		"cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+		"feedfacedeadbeeffeedfacedeadbeefabaddad2",
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e0915bc94fbc3221a5db94fae95ae7121a47",
+	},
+	{
+		"Test Case 16",
+		"feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308",
+		"cafebabefacedbaddecaf888",
+		"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+		"feedfacedeadbeeffeedfacedeadbeefabaddad2",
+		"522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f66276fc6ece0f4e1768cddf8853bb2d551b",
+	},
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad test vector %q: %s", s, err)
+	}
+	return b
+}
+
+func TestGCM_AES(t *testing.T) {
+	for _, tt := range gcmAESTests {
+		test := tt.name
+		key := decodeHex(t, tt.key)
+		nonce := decodeHex(t, tt.nonce)
+		plain := decodeHex(t, tt.plain)
+		data := decodeHex(t, tt.data)
+		result := decodeHex(t, tt.result)
+
+		c, err := aes.NewCipher(key)
+		if err != nil {
+			t.Errorf("%s: NewCipher(%d bytes) = %s", test, len(key), err)
+			continue
+		}
+		aead, err := NewGCM(c)
+		if err != nil {
+			t.Errorf("%s: NewGCM: %s", test, err)
+			continue
+		}
+
+		sealed := aead.Seal(nil, nonce, plain, data)
+		if !bytes.Equal(sealed, result) {
+			t.Errorf("%s: Seal\nhave %x\nwant %x", test, sealed, result)
+			continue
+		}
+
+		opened, err := aead.Open(nil, nonce, sealed, data)
+		if err != nil {
+			t.Errorf("%s: Open: %s", test, err)
+			continue
+		}
+		if !bytes.Equal(opened, plain) {
+			t.Errorf("%s: Open\nhave %x\nwant %x", test, opened, plain)
+		}
+
+		// Any change to the ciphertext, tag or additional data
+		// must be detected.
+		sealed[0] ^= 0x80
+		if _, err := aead.Open(nil, nonce, sealed, data); err == nil {
+			t.Errorf("%s: Open accepted a tampered message", test)
+		}
+		sealed[0] ^= 0x80
+		if len(data) > 0 {
+			data[0] ^= 0x80
+			if _, err := aead.Open(nil, nonce, sealed, data); err == nil {
+				t.Errorf("%s: Open accepted tampered additional data", test)
+			}
+			data[0] ^= 0x80
+		}
+
+		// Seal and Open append to dst.
+		prefix := []byte("prefix")
+		sealed = aead.Seal(prefix, nonce, plain, data)
+		if !bytes.Equal(sealed[:len(prefix)], prefix) || !bytes.Equal(sealed[len(prefix):], result) {
+			t.Errorf("%s: Seal with dst\nhave %x", test, sealed)
+		}
+	}
+}
+
+func TestGCMBlockSize(t *testing.T) {
+	if _, err := NewGCM(testBlock(8)); err == nil {
+		t.Errorf("NewGCM accepted a 64-bit block cipher")
+	}
+}
+
+// testBlock is a Block of the given size that does nothing.
+type testBlock int
+
+func (b testBlock) BlockSize() int          { return int(b) }
+func (b testBlock) Encrypt(dst, src []byte) { copy(dst, src) }
+func (b testBlock) Decrypt(dst, src []byte) { copy(dst, src) }
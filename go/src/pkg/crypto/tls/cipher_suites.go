@@ -45,15 +45,33 @@ type cipherSuite struct {
 	// the ClientHello indicated that the client supports an elliptic curve
 	// and point format that we can handle.
 	elliptic bool
-	cipher   func(key, iv []byte, isRead bool) interface{}
-	mac      func(macKey []byte) hash.Hash
+	// If tls12 is set, the ciphersuite may only be used with TLS 1.2.
+	tls12  bool
+	cipher func(key, iv []byte, isRead bool) interface{}
+	// mac is nil for a suite whose cipher is an AEAD, which
+	// authenticates records itself.
+	mac func(macKey []byte) hash.Hash
 }
 
 var cipherSuites = map[uint16]*cipherSuite{
-	TLS_RSA_WITH_RC4_128_SHA:           &cipherSuite{16, 20, 0, rsaKA, false, cipherRC4, hmacSHA1},
-	TLS_RSA_WITH_AES_128_CBC_SHA:       &cipherSuite{16, 20, 16, rsaKA, false, cipherAES, hmacSHA1},
-	TLS_ECDHE_RSA_WITH_RC4_128_SHA:     &cipherSuite{16, 20, 0, ecdheRSAKA, true, cipherRC4, hmacSHA1},
-	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA: &cipherSuite{16, 20, 16, ecdheRSAKA, true, cipherAES, hmacSHA1},
+	TLS_RSA_WITH_RC4_128_SHA:              &cipherSuite{16, 20, 0, rsaKA, false, false, cipherRC4, hmacSHA1},
+	TLS_RSA_WITH_AES_128_CBC_SHA:          &cipherSuite{16, 20, 16, rsaKA, false, false, cipherAES, hmacSHA1},
+	TLS_RSA_WITH_AES_128_CBC_SHA256:       &cipherSuite{16, 32, 16, rsaKA, false, true, cipherAES, hmacSHA256},
+	TLS_RSA_WITH_AES_256_CBC_SHA256:       &cipherSuite{32, 32, 16, rsaKA, false, true, cipherAES, hmacSHA256},
+	TLS_RSA_WITH_AES_128_GCM_SHA256:       &cipherSuite{16, 0, 4, rsaKA, false, true, aeadAESGCM, nil},
+	TLS_ECDHE_RSA_WITH_RC4_128_SHA:        &cipherSuite{16, 20, 0, ecdheRSAKA, true, false, cipherRC4, hmacSHA1},
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:    &cipherSuite{16, 20, 16, ecdheRSAKA, true, false, cipherAES, hmacSHA1},
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256: &cipherSuite{16, 32, 16, ecdheRSAKA, true, true, cipherAES, hmacSHA256},
+	TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256: &cipherSuite{16, 0, 4, ecdheRSAKA, true, true, aeadAESGCM, nil},
+}
+
+// newMAC returns the suite's MAC keyed with macKey, or nil if its cipher
+// is an AEAD.
+func (s *cipherSuite) newMAC(macKey []byte) hash.Hash {
+	if s.mac == nil {
+		return nil
+	}
+	return s.mac(macKey)
 }
 
 func cipherRC4(key, iv []byte, isRead bool) interface{} {
@@ -69,10 +87,45 @@ func cipherAES(key, iv []byte, isRead bool) interface{} {
 	return cipher.NewCBCEncrypter(block, iv)
 }
 
+// aeadAESGCM returns AES in Galois/Counter Mode.  The iv is the implicit
+// part of each record's nonce, which the record itself completes: see RFC
+// 5288.
+func aeadAESGCM(key, iv []byte, isRead bool) interface{} {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	f := &fixedNonceAEAD{aead: aead}
+	copy(f.nonce[:], iv)
+	return f
+}
+
+// fixedNonceAEAD wraps an AEAD whose nonces all start with the same four
+// bytes.  Its own nonces are the remaining eight.
+type fixedNonceAEAD struct {
+	nonce [12]byte
+	aead  cipher.AEAD
+}
+
+func (f *fixedNonceAEAD) NonceSize() int { return 8 }
+func (f *fixedNonceAEAD) Overhead() int  { return f.aead.Overhead() }
+
+func (f *fixedNonceAEAD) Seal(dst, nonce, plaintext, data []byte) []byte {
+	copy(f.nonce[4:], nonce)
+	return f.aead.Seal(dst, f.nonce[:], plaintext, data)
+}
+
+func (f *fixedNonceAEAD) Open(dst, nonce, ciphertext, data []byte) ([]byte, os.Error) {
+	copy(f.nonce[4:], nonce)
+	return f.aead.Open(dst, f.nonce[:], ciphertext, data)
+}
+
 func hmacSHA1(key []byte) hash.Hash {
 	return hmac.NewSHA1(key)
 }
 
+func hmacSHA256(key []byte) hash.Hash {
+	return hmac.NewSHA256(key)
+}
+
 func rsaKA() keyAgreement {
 	return rsaKeyAgreement{}
 }
@@ -82,11 +135,16 @@ func ecdheRSAKA() keyAgreement {
 }
 
 // mutualCipherSuite returns a cipherSuite and its id given a list of supported
-// ciphersuites and the id requested by the peer.
-func mutualCipherSuite(have []uint16, want uint16) (suite *cipherSuite, id uint16) {
+// ciphersuites, the id requested by the peer and the version of the protocol
+// in use.
+func mutualCipherSuite(have []uint16, want uint16, version uint16) (suite *cipherSuite, id uint16) {
 	for _, id := range have {
 		if id == want {
-			return cipherSuites[id], id
+			suite = cipherSuites[id]
+			if suite != nil && suite.tls12 && version < VersionTLS12 {
+				return nil, 0
+			}
+			return suite, id
 		}
 	}
 	return
@@ -95,8 +153,13 @@ func mutualCipherSuite(have []uint16, want uint16) (suite *cipherSuite, id uint1
 // A list of the possible cipher suite ids. Taken from
 // http://www.iana.org/assignments/tls-parameters/tls-parameters.xml
 const (
-	TLS_RSA_WITH_RC4_128_SHA           uint16 = 0x0005
-	TLS_RSA_WITH_AES_128_CBC_SHA       uint16 = 0x002f
-	TLS_ECDHE_RSA_WITH_RC4_128_SHA     uint16 = 0xc011
-	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA uint16 = 0xc013
+	TLS_RSA_WITH_RC4_128_SHA              uint16 = 0x0005
+	TLS_RSA_WITH_AES_128_CBC_SHA          uint16 = 0x002f
+	TLS_RSA_WITH_AES_128_CBC_SHA256       uint16 = 0x003c
+	TLS_RSA_WITH_AES_256_CBC_SHA256       uint16 = 0x003d
+	TLS_RSA_WITH_AES_128_GCM_SHA256       uint16 = 0x009c
+	TLS_ECDHE_RSA_WITH_RC4_128_SHA        uint16 = 0xc011
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA    uint16 = 0xc013
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256 uint16 = 0xc027
+	TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 uint16 = 0xc02f
 )
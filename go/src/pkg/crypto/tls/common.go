@@ -5,6 +5,7 @@
 package tls
 
 import (
+	"container/list"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -20,8 +21,15 @@ const (
 	recordHeaderLen = 5            // record header length
 	maxHandshake    = 65536        // maximum handshake we support (protocol max is 16 MB)
 
-	minVersion = 0x0301 // minimum supported version - TLS 1.0
-	maxVersion = 0x0301 // maximum supported version - TLS 1.0
+	minVersion = VersionTLS10 // minimum supported version
+	maxVersion = VersionTLS12 // maximum supported version
+)
+
+// Protocol versions.
+const (
+	VersionTLS10 = 0x0301
+	VersionTLS11 = 0x0302
+	VersionTLS12 = 0x0303
 )
 
 // TLS record types.
@@ -38,6 +46,7 @@ const (
 const (
 	typeClientHello        uint8 = 1
 	typeServerHello        uint8 = 2
+	typeNewSessionTicket   uint8 = 4
 	typeCertificate        uint8 = 11
 	typeServerKeyExchange  uint8 = 12
 	typeCertificateRequest uint8 = 13
@@ -56,11 +65,13 @@ const (
 
 // TLS extension numbers
 var (
-	extensionServerName      uint16 = 0
-	extensionStatusRequest   uint16 = 5
-	extensionSupportedCurves uint16 = 10
-	extensionSupportedPoints uint16 = 11
-	extensionNextProtoNeg    uint16 = 13172 // not IANA assigned
+	extensionServerName          uint16 = 0
+	extensionStatusRequest       uint16 = 5
+	extensionSupportedCurves     uint16 = 10
+	extensionSupportedPoints     uint16 = 11
+	extensionSignatureAlgorithms uint16 = 13
+	extensionSessionTicket       uint16 = 35
+	extensionNextProtoNeg        uint16 = 13172 // not IANA assigned
 )
 
 // TLS Elliptic Curves
@@ -91,12 +102,37 @@ const (
 	// Rest of these are reserved by the TLS spec
 )
 
+// Hash functions for TLS 1.2 (RFC 5246, section 7.4.1.4.1)
+const (
+	hashSHA1   uint8 = 2
+	hashSHA256 uint8 = 4
+)
+
+// Signature algorithms for TLS 1.2 (RFC 5246, section 7.4.1.4.1)
+const (
+	signatureRSA uint8 = 1
+)
+
+// signatureAndHash mirrors the TLS 1.2 SignatureAndHashAlgorithm struct.
+type signatureAndHash struct {
+	hash, signature uint8
+}
+
+// supportedSignatureAlgorithms contains the signature and hash algorithms
+// that this package supports, in order of preference.
+var supportedSignatureAlgorithms = []signatureAndHash{
+	{hashSHA256, signatureRSA},
+	{hashSHA1, signatureRSA},
+}
+
 // ConnectionState records basic TLS details about the connection.
 type ConnectionState struct {
+	Version                    uint16 // TLS version used by the connection (e.g. VersionTLS12)
 	HandshakeComplete          bool
 	CipherSuite                uint16
 	NegotiatedProtocol         string
 	NegotiatedProtocolIsMutual bool
+	DidResume                  bool // the connection resumed an earlier session
 
 	// the certificate chain that was presented by the other side
 	PeerCertificates []*x509.Certificate
@@ -104,6 +140,28 @@ type ConnectionState struct {
 	VerifiedChains [][]*x509.Certificate
 }
 
+// ClientAuthType declares the policy the server will follow for
+// TLS Client Authentication.
+type ClientAuthType int
+
+const (
+	// NoClientCert means that no client certificate is requested.
+	NoClientCert ClientAuthType = iota
+	// RequestClientCert means that a client certificate is requested,
+	// but the client need not send one, and any that it sends is
+	// accepted without verification.
+	RequestClientCert
+	// RequireAnyClientCert means that the client must send a
+	// certificate, which is accepted without verification.
+	RequireAnyClientCert
+	// VerifyClientCertIfGiven means that the client need not send a
+	// certificate, but any that it sends must verify against ClientCAs.
+	VerifyClientCertIfGiven
+	// RequireAndVerifyClientCert means that the client must send a
+	// certificate that verifies against ClientCAs.
+	RequireAndVerifyClientCert
+)
+
 // A Config structure is used to configure a TLS client or server. After one
 // has been passed to a TLS function it must not be modified.
 type Config struct {
@@ -133,15 +191,117 @@ type Config struct {
 	// hosting.
 	ServerName string
 
-	// AuthenticateClient controls whether a server will request a certificate
-	// from the client. It does not require that the client send a
-	// certificate nor does it require that the certificate sent be
-	// anything more than self-signed.
+	// ClientAuth determines the server's policy for
+	// TLS Client Authentication. The default is NoClientCert.
+	ClientAuth ClientAuthType
+
+	// ClientCAs defines the set of root certificate authorities
+	// that servers use if required to verify a client certificate
+	// by the policy in ClientAuth.  If ClientCAs is nil, no client
+	// certificate can be verified.
+	ClientCAs *x509.CertPool
+
+	// AuthenticateClient is deprecated; use ClientAuth instead.
+	// Setting it when ClientAuth is NoClientCert has the effect
+	// of RequestClientCert.
 	AuthenticateClient bool
 
 	// CipherSuites is a list of supported cipher suites. If CipherSuites
 	// is nil, TLS uses a list of suites supported by the implementation.
 	CipherSuites []uint16
+
+	// MinVersion contains the minimum TLS version that is acceptable.
+	// If zero, then TLS 1.0 is taken as the minimum.
+	MinVersion uint16
+
+	// MaxVersion contains the maximum TLS version that is acceptable.
+	// If zero, then the maximum version supported by this package is
+	// used, which is currently TLS 1.2.
+	MaxVersion uint16
+
+	// SessionTicketsDisabled may be set to true to disable session
+	// tickets, as defined in RFC 5077, on both clients and servers.
+	// Whether from a ticket or a session ID, a server resumes no
+	// session more than seven days after it began.
+	SessionTicketsDisabled bool
+
+	// SessionTicketKey is used by servers to encrypt the session
+	// tickets that they issue.  If it is zero, a random key is chosen
+	// before the first server handshake.  Servers that share a
+	// SessionTicketKey can resume each other's sessions.  Anyone who
+	// learns the key can decrypt the connections that used tickets
+	// made with it, so it should be rotated: see SetSessionTicketKeys.
+	SessionTicketKey [32]byte
+
+	// ClientSessionCache holds the sessions that a client may resume.
+	// If it is nil, the client resumes no sessions.
+	ClientSessionCache ClientSessionCache
+
+	// ServerSessionCache holds the sessions that a server may resume
+	// by their session IDs.  If it is nil, the server gives out no
+	// session IDs, although it may still resume sessions from tickets.
+	ServerSessionCache ServerSessionCache
+
+	serverInitOnce sync.Once // guards calling serverInit
+
+	mutex             sync.RWMutex // protects sessionTicketKeys
+	sessionTicketKeys []ticketKey  // the first is used to encrypt new tickets
+}
+
+// serverInit sets up the session ticket keys, unless SetSessionTicketKeys
+// has already done so.
+func (c *Config) serverInit() {
+	if c.SessionTicketsDisabled {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.sessionTicketKeys) > 0 {
+		return
+	}
+
+	key := c.SessionTicketKey
+	isZero := true
+	for _, b := range key {
+		if b != 0 {
+			isZero = false
+			break
+		}
+	}
+	if isZero {
+		if _, err := io.ReadFull(c.rand(), key[:]); err != nil {
+			// Without a key there will be no tickets.
+			return
+		}
+	}
+	c.sessionTicketKeys = []ticketKey{newTicketKey(key)}
+}
+
+// SetSessionTicketKeys sets the keys of the session tickets of a server,
+// replacing SessionTicketKey.  New tickets are encrypted with the first key,
+// while tickets encrypted with any of them are accepted, so a key can be
+// rotated out by moving it down the list and then off the end.  Unlike the
+// fields of Config, the keys may be set while the Config is in use.
+func (c *Config) SetSessionTicketKeys(keys [][32]byte) {
+	if len(keys) == 0 {
+		panic("tls: SetSessionTicketKeys needs at least one key")
+	}
+
+	newKeys := make([]ticketKey, len(keys))
+	for i, key := range keys {
+		newKeys[i] = newTicketKey(key)
+	}
+
+	c.mutex.Lock()
+	c.sessionTicketKeys = newKeys
+	c.mutex.Unlock()
+}
+
+func (c *Config) ticketKeys() []ticketKey {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.sessionTicketKeys
 }
 
 func (c *Config) rand() io.Reader {
@@ -168,6 +328,13 @@ func (c *Config) rootCAs() *x509.CertPool {
 	return s
 }
 
+func (c *Config) clientAuth() ClientAuthType {
+	if c.ClientAuth == NoClientCert && c.AuthenticateClient {
+		return RequestClientCert
+	}
+	return c.ClientAuth
+}
+
 func (c *Config) cipherSuites() []uint16 {
 	s := c.CipherSuites
 	if s == nil {
@@ -176,6 +343,35 @@ func (c *Config) cipherSuites() []uint16 {
 	return s
 }
 
+func (c *Config) minVersion() uint16 {
+	if c.MinVersion == 0 {
+		return minVersion
+	}
+	return c.MinVersion
+}
+
+func (c *Config) maxVersion() uint16 {
+	if c.MaxVersion == 0 {
+		return maxVersion
+	}
+	return c.MaxVersion
+}
+
+// mutualVersion returns the protocol version to use given the advertised
+// version of the peer.
+func (c *Config) mutualVersion(vers uint16) (uint16, bool) {
+	minVersion := c.minVersion()
+	maxVersion := c.maxVersion()
+
+	if vers < minVersion {
+		return 0, false
+	}
+	if vers > maxVersion {
+		vers = maxVersion
+	}
+	return vers, true
+}
+
 // A Certificate is a chain of one or more certificates, leaf first.
 type Certificate struct {
 	Certificate [][]byte
@@ -185,6 +381,153 @@ type Certificate struct {
 	OCSPStaple []byte
 }
 
+// ClientSessionState contains the state that a client needs
+// to resume an earlier session.
+type ClientSessionState struct {
+	sessionTicket      []uint8             // the ticket the server issued, if any
+	sessionId          []uint8             // the ID the server gave, if any
+	vers               uint16              // the version of the session
+	cipherSuite        uint16              // the cipher suite of the session
+	masterSecret       []byte              // the master secret of the session
+	serverCertificates []*x509.Certificate // the certificate chain of the server
+	verifiedChains     [][]*x509.Certificate
+}
+
+// ClientSessionCache is a cache of ClientSessionState objects that a client
+// uses to resume sessions with a server.  A client looks up the session for
+// a server under the ServerName of its Config or, if that is empty, the
+// address of the server.  The methods may be called concurrently from
+// different goroutines.
+type ClientSessionCache interface {
+	// Get returns the ClientSessionState saved under sessionKey.
+	Get(sessionKey string) (session *ClientSessionState, ok bool)
+
+	// Put saves the ClientSessionState under sessionKey.
+	Put(sessionKey string, cs *ClientSessionState)
+}
+
+// ServerSessionCache is a cache of the sessions that a server resumes when
+// a client offers their session IDs.  The state of a session is an opaque
+// byte slice, which holds the master secret of the session and must be
+// kept accordingly.  The methods may be called concurrently from different
+// goroutines.
+type ServerSessionCache interface {
+	// Get returns the state of the session with the given ID.
+	Get(sessionId string) (state []byte, ok bool)
+
+	// Put saves the state of the session with the given ID.
+	Put(sessionId string, state []byte)
+}
+
+// lruSessionCache is a session cache that holds at most capacity sessions,
+// evicting the least recently used.
+type lruSessionCache struct {
+	sync.Mutex
+
+	m        map[string]*list.Element
+	q        *list.List
+	capacity int
+}
+
+type lruSessionCacheEntry struct {
+	sessionKey string
+	state      interface{}
+}
+
+const defaultSessionCacheCapacity = 64
+
+func newLRUSessionCache(capacity int) lruSessionCache {
+	if capacity < 1 {
+		capacity = defaultSessionCacheCapacity
+	}
+	return lruSessionCache{
+		m:        make(map[string]*list.Element),
+		q:        list.New(),
+		capacity: capacity,
+	}
+}
+
+func (c *lruSessionCache) put(sessionKey string, state interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.m[sessionKey]; ok {
+		elem.Value.(*lruSessionCacheEntry).state = state
+		c.q.MoveToFront(elem)
+		return
+	}
+
+	if c.q.Len() < c.capacity {
+		entry := &lruSessionCacheEntry{sessionKey, state}
+		c.m[sessionKey] = c.q.PushFront(entry)
+		return
+	}
+
+	// Reuse the entry of the least recently used session.
+	elem := c.q.Back()
+	entry := elem.Value.(*lruSessionCacheEntry)
+	c.m[entry.sessionKey] = nil, false
+	entry.sessionKey = sessionKey
+	entry.state = state
+	c.q.MoveToFront(elem)
+	c.m[sessionKey] = elem
+}
+
+func (c *lruSessionCache) get(sessionKey string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.m[sessionKey]; ok {
+		c.q.MoveToFront(elem)
+		return elem.Value.(*lruSessionCacheEntry).state, true
+	}
+	return nil, false
+}
+
+type lruClientSessionCache struct {
+	lruSessionCache
+}
+
+// NewLRUClientSessionCache returns a ClientSessionCache with the given
+// capacity that uses an LRU strategy.  If capacity is < 1, a default
+// capacity is used instead.
+func NewLRUClientSessionCache(capacity int) ClientSessionCache {
+	return &lruClientSessionCache{newLRUSessionCache(capacity)}
+}
+
+func (c *lruClientSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+	if state, ok := c.get(sessionKey); ok {
+		return state.(*ClientSessionState), true
+	}
+	return nil, false
+}
+
+func (c *lruClientSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+	c.put(sessionKey, cs)
+}
+
+type lruServerSessionCache struct {
+	lruSessionCache
+}
+
+// NewLRUServerSessionCache returns a ServerSessionCache with the given
+// capacity that uses an LRU strategy.  If capacity is < 1, a default
+// capacity is used instead.
+func NewLRUServerSessionCache(capacity int) ServerSessionCache {
+	return &lruServerSessionCache{newLRUSessionCache(capacity)}
+}
+
+func (c *lruServerSessionCache) Get(sessionId string) ([]byte, bool) {
+	if state, ok := c.get(sessionId); ok {
+		return state.([]byte), true
+	}
+	return nil, false
+}
+
+func (c *lruServerSessionCache) Put(sessionId string, state []byte) {
+	c.put(sessionId, state)
+}
+
 // A TLS record.
 type record struct {
 	contentType  recordType
@@ -197,18 +540,6 @@ type handshakeMessage interface {
 	unmarshal([]byte) bool
 }
 
-// mutualVersion returns the protocol version to use given the advertised
-// version of the peer.
-func mutualVersion(vers uint16) (uint16, bool) {
-	if vers < minVersion {
-		return 0, false
-	}
-	if vers > maxVersion {
-		vers = maxVersion
-	}
-	return vers, true
-}
-
 var emptyConfig Config
 
 func defaultConfig() *Config {
@@ -31,6 +31,7 @@ type Conn struct {
 	haveVers          bool       // version has been negotiated
 	config            *Config    // configuration passed to constructor
 	handshakeComplete bool
+	didResume         bool // whether this connection was a session resumption
 	cipherSuite       uint16
 	ocspResponse      []byte // stapled OCSP response
 	peerCertificates  []*x509.Certificate
@@ -108,10 +109,11 @@ func (c *Conn) SetWriteTimeout(nsec int64) os.Error {
 // connection, either sending or receiving.
 type halfConn struct {
 	sync.Mutex
-	cipher interface{} // cipher algorithm
-	mac    hash.Hash   // MAC algorithm
-	seq    [8]byte     // 64-bit sequence number
-	bfree  *block      // list of free blocks
+	version uint16      // protocol version
+	cipher  interface{} // cipher algorithm
+	mac     hash.Hash   // MAC algorithm
+	seq     [8]byte     // 64-bit sequence number
+	bfree   *block      // list of free blocks
 
 	nextCipher interface{} // next encryption state
 	nextMac    hash.Hash   // next MAC algorithm
@@ -119,7 +121,8 @@ type halfConn struct {
 
 // prepareCipherSpec sets the encryption and MAC states
 // that a subsequent changeCipherSpec will use.
-func (hc *halfConn) prepareCipherSpec(cipher interface{}, mac hash.Hash) {
+func (hc *halfConn) prepareCipherSpec(version uint16, cipher interface{}, mac hash.Hash) {
+	hc.version = version
 	hc.nextCipher = cipher
 	hc.nextMac = mac
 }
@@ -201,8 +204,42 @@ func roundUp(a, b int) int {
 	return a + (b-a%b)%b
 }
 
-// decrypt checks and strips the mac and decrypts the data in b.
-func (hc *halfConn) decrypt(b *block) (bool, alert) {
+// explicitIVLen returns the length of the IV that starts each record: a
+// block for a block cipher from TLS 1.1 on, the explicit part of the nonce
+// for an AEAD cipher, and nothing otherwise.
+//
+// We encrypt the IV along with the rest of the record, continuing the CBC
+// chain from the previous record.  The receiver decrypts it to garbage and
+// discards it, while the next block decrypts correctly, so the ciphertext
+// of the IV, which is unpredictable, acts as the IV of the record's data.
+// This is option 2(b) in RFC 4346, section 6.2.3.2.
+func (hc *halfConn) explicitIVLen() int {
+	switch c := hc.cipher.(type) {
+	case cipher.BlockMode:
+		if hc.version >= VersionTLS11 {
+			return c.BlockSize()
+		}
+	case cipher.AEAD:
+		return c.NonceSize()
+	}
+	return 0
+}
+
+// additionalData returns the data that an AEAD cipher authenticates along
+// with a record of n bytes of plaintext: the sequence number, the type and
+// version from the record header and n.  See RFC 5246, section 6.2.3.3.
+func (hc *halfConn) additionalData(header []byte, n int) []byte {
+	var ad [13]byte
+	copy(ad[:], hc.seq[:])
+	copy(ad[8:], header[:3])
+	ad[11] = byte(n >> 8)
+	ad[12] = byte(n)
+	return ad[:]
+}
+
+// decrypt checks and strips the mac and decrypts the data in b. It returns
+// the offset of the plaintext in b.data.
+func (hc *halfConn) decrypt(b *block) (bool, int, alert) {
 	// pull out payload
 	payload := b.data[recordHeaderLen:]
 
@@ -212,6 +249,7 @@ func (hc *halfConn) decrypt(b *block) (bool, alert) {
 	}
 
 	paddingGood := byte(255)
+	explicitIVLen := hc.explicitIVLen()
 
 	// decrypt
 	if hc.cipher != nil {
@@ -221,13 +259,14 @@ func (hc *halfConn) decrypt(b *block) (bool, alert) {
 		case cipher.BlockMode:
 			blockSize := c.BlockSize()
 
-			if len(payload)%blockSize != 0 || len(payload) < roundUp(macSize+1, blockSize) {
-				return false, alertBadRecordMAC
+			if len(payload)%blockSize != 0 || len(payload) < roundUp(explicitIVLen+macSize+1, blockSize) {
+				return false, 0, alertBadRecordMAC
 			}
 
 			c.CryptBlocks(payload, payload)
+			payload = payload[explicitIVLen:]
 			payload, paddingGood = removePadding(payload)
-			b.resize(recordHeaderLen + len(payload))
+			b.resize(recordHeaderLen + explicitIVLen + len(payload))
 
 			// note that we still have a timing side-channel in the
 			// MAC check, below. An attacker can align the record
@@ -239,6 +278,24 @@ func (hc *halfConn) decrypt(b *block) (bool, alert) {
 			//
 			// However, our behavior matches OpenSSL, so we leak
 			// only as much as they do.
+		case cipher.AEAD:
+			n := len(payload) - explicitIVLen - c.Overhead()
+			if n < 0 {
+				return false, 0, alertBadRecordMAC
+			}
+			nonce := payload[:explicitIVLen]
+			payload = payload[explicitIVLen:]
+			ad := hc.additionalData(b.data, n)
+			hc.incSeq()
+
+			var err os.Error
+			payload, err = c.Open(payload[:0], nonce, payload, ad)
+			if err != nil {
+				return false, 0, alertBadRecordMAC
+			}
+			b.data[3] = byte(n >> 8)
+			b.data[4] = byte(n)
+			b.resize(recordHeaderLen + explicitIVLen + n)
 		default:
 			panic("unknown cipher type")
 		}
@@ -247,27 +304,28 @@ func (hc *halfConn) decrypt(b *block) (bool, alert) {
 	// check, strip mac
 	if hc.mac != nil {
 		if len(payload) < macSize {
-			return false, alertBadRecordMAC
+			return false, 0, alertBadRecordMAC
 		}
 
 		// strip mac off payload, b.data
 		n := len(payload) - macSize
 		b.data[3] = byte(n >> 8)
 		b.data[4] = byte(n)
-		b.resize(recordHeaderLen + n)
+		b.resize(recordHeaderLen + explicitIVLen + n)
 		remoteMAC := payload[n:]
 
 		hc.mac.Reset()
 		hc.mac.Write(hc.seq[0:])
 		hc.incSeq()
-		hc.mac.Write(b.data)
+		hc.mac.Write(b.data[:recordHeaderLen])
+		hc.mac.Write(payload[:n])
 
 		if subtle.ConstantTimeCompare(hc.mac.Sum(), remoteMAC) != 1 || paddingGood != 255 {
-			return false, alertBadRecordMAC
+			return false, 0, alertBadRecordMAC
 		}
 	}
 
-	return true, 0
+	return true, recordHeaderLen + explicitIVLen, 0
 }
 
 // padToBlockSize calculates the needed padding block, if any, for a payload.
@@ -287,14 +345,17 @@ func padToBlockSize(payload []byte, blockSize int) (prefix, finalBlock []byte) {
 	return
 }
 
-// encrypt encrypts and macs the data in b.
-func (hc *halfConn) encrypt(b *block) (bool, alert) {
+// encrypt encrypts and macs the data in b.  The data follows an explicit IV
+// of explicitIVLen bytes, which is not covered by the mac.  For an AEAD
+// cipher the explicit IV is the explicit part of the nonce.
+func (hc *halfConn) encrypt(b *block, explicitIVLen int) (bool, alert) {
 	// mac
 	if hc.mac != nil {
 		hc.mac.Reset()
 		hc.mac.Write(hc.seq[0:])
 		hc.incSeq()
-		hc.mac.Write(b.data)
+		hc.mac.Write(b.data[:recordHeaderLen])
+		hc.mac.Write(b.data[recordHeaderLen+explicitIVLen:])
 		mac := hc.mac.Sum()
 		n := len(b.data)
 		b.resize(n + len(mac))
@@ -313,6 +374,14 @@ func (hc *halfConn) encrypt(b *block) (bool, alert) {
 			b.resize(recordHeaderLen + len(prefix) + len(finalBlock))
 			c.CryptBlocks(b.data[recordHeaderLen:], prefix)
 			c.CryptBlocks(b.data[recordHeaderLen+len(prefix):], finalBlock)
+		case cipher.AEAD:
+			n := len(payload) - explicitIVLen
+			b.resize(len(b.data) + c.Overhead())
+			nonce := b.data[recordHeaderLen : recordHeaderLen+explicitIVLen]
+			plaintext := b.data[recordHeaderLen+explicitIVLen:][:n]
+			ad := hc.additionalData(b.data, n)
+			hc.incSeq()
+			c.Seal(plaintext[:0], nonce, plaintext, ad)
 		default:
 			panic("unknown cipher type")
 		}
@@ -482,10 +551,11 @@ Again:
 
 	// Process message.
 	b, c.rawInput = c.in.splitBlock(b, recordHeaderLen+n)
-	b.off = recordHeaderLen
-	if ok, err := c.in.decrypt(b); !ok {
+	ok, off, err := c.in.decrypt(b)
+	if !ok {
 		return c.sendAlert(err)
 	}
+	b.off = off
 	data := b.data[b.off:]
 	if len(data) > maxPlaintext {
 		c.sendAlert(alertRecordOverflow)
@@ -583,18 +653,31 @@ func (c *Conn) writeRecord(typ recordType, data []byte) (n int, err os.Error) {
 		if m > maxPlaintext {
 			m = maxPlaintext
 		}
-		b.resize(recordHeaderLen + m)
+		explicitIVLen := c.out.explicitIVLen()
+		b.resize(recordHeaderLen + explicitIVLen + m)
 		b.data[0] = byte(typ)
 		vers := c.vers
 		if vers == 0 {
-			vers = maxVersion
+			// Some servers choke on a ClientHello in a record of
+			// a later version than they support, so use the lowest.
+			vers = VersionTLS10
 		}
 		b.data[1] = byte(vers >> 8)
 		b.data[2] = byte(vers)
 		b.data[3] = byte(m >> 8)
 		b.data[4] = byte(m)
-		copy(b.data[recordHeaderLen:], data)
-		c.out.encrypt(b)
+		if explicitIVLen > 0 {
+			explicitIV := b.data[recordHeaderLen : recordHeaderLen+explicitIVLen]
+			if _, ok := c.out.cipher.(cipher.AEAD); ok {
+				// A nonce must never repeat, which the
+				// sequence number never does.
+				copy(explicitIV, c.out.seq[:])
+			} else if _, err = io.ReadFull(c.config.rand(), explicitIV); err != nil {
+				break
+			}
+		}
+		copy(b.data[recordHeaderLen+explicitIVLen:], data)
+		c.out.encrypt(b, explicitIVLen)
 		_, err = c.conn.Write(b.data)
 		if err != nil {
 			break
@@ -649,10 +732,14 @@ func (c *Conn) readHandshake() (interface{}, os.Error) {
 		m = new(clientHelloMsg)
 	case typeServerHello:
 		m = new(serverHelloMsg)
+	case typeNewSessionTicket:
+		m = new(newSessionTicketMsg)
 	case typeCertificate:
 		m = new(certificateMsg)
 	case typeCertificateRequest:
-		m = new(certificateRequestMsg)
+		m = &certificateRequestMsg{
+			hasSignatureAndHash: c.vers >= VersionTLS12,
+		}
 	case typeCertificateStatus:
 		m = new(certificateStatusMsg)
 	case typeServerKeyExchange:
@@ -662,7 +749,9 @@ func (c *Conn) readHandshake() (interface{}, os.Error) {
 	case typeClientKeyExchange:
 		m = new(clientKeyExchangeMsg)
 	case typeCertificateVerify:
-		m = new(certificateVerifyMsg)
+		m = &certificateVerifyMsg{
+			hasSignatureAndHash: c.vers >= VersionTLS12,
+		}
 	case typeNextProtocol:
 		m = new(nextProtoMsg)
 	case typeFinished:
@@ -764,8 +853,10 @@ func (c *Conn) ConnectionState() ConnectionState {
 	var state ConnectionState
 	state.HandshakeComplete = c.handshakeComplete
 	if c.handshakeComplete {
+		state.Version = c.vers
 		state.NegotiatedProtocol = c.clientProtocol
 		state.NegotiatedProtocolIsMutual = !c.clientProtocolFallback
+		state.DidResume = c.didResume
 		state.CipherSuite = c.cipherSuite
 		state.PeerCertificates = c.peerCertificates
 		state.VerifiedChains = c.verifiedChains
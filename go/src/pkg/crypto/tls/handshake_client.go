@@ -5,23 +5,36 @@
 package tls
 
 import (
-	"crypto"
+	"bytes"
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
 	"io"
+	"net"
 	"os"
 )
 
-func (c *Conn) clientHandshake() os.Error {
-	finishedHash := newFinishedHash()
+// clientHandshakeState contains details of a client handshake in progress.
+// It's discarded once the handshake has completed.
+type clientHandshakeState struct {
+	c             *Conn
+	hello         *clientHelloMsg
+	serverHello   *serverHelloMsg
+	suite         *cipherSuite
+	suiteId       uint16
+	finishedHash  finishedHash
+	masterSecret  []byte
+	session       *ClientSessionState // the session being resumed, if any
+	sessionTicket []byte              // the ticket the server issued, if any
+}
 
+func (c *Conn) clientHandshake() os.Error {
 	if c.config == nil {
 		c.config = defaultConfig()
 	}
 
 	hello := &clientHelloMsg{
-		vers:               maxVersion,
+		vers:               c.config.maxVersion(),
 		cipherSuites:       c.config.cipherSuites(),
 		compressionMethods: []uint8{compressionNone},
 		random:             make([]byte, 32),
@@ -31,6 +44,9 @@ func (c *Conn) clientHandshake() os.Error {
 		supportedPoints:    []uint8{pointFormatUncompressed},
 		nextProtoNeg:       len(c.config.NextProtos) > 0,
 	}
+	if hello.vers >= VersionTLS12 {
+		hello.signatureAndHashes = supportedSignatureAlgorithms
+	}
 
 	t := uint32(c.config.time())
 	hello.random[0] = byte(t >> 24)
@@ -43,7 +59,34 @@ func (c *Conn) clientHandshake() os.Error {
 		return os.NewError("short read from Rand")
 	}
 
-	finishedHash.Write(hello.marshal())
+	var session *ClientSessionState
+	var cacheKey string
+	sessionCache := c.config.ClientSessionCache
+	if sessionCache != nil {
+		hello.ticketSupported = !c.config.SessionTicketsDisabled
+		cacheKey = clientSessionCacheKey(c.conn.RemoteAddr(), c.config)
+		if candidate, ok := sessionCache.Get(cacheKey); ok && c.canResume(candidate, hello) {
+			session = candidate
+		}
+	}
+
+	if session != nil {
+		if hello.ticketSupported {
+			hello.sessionTicket = session.sessionTicket
+		}
+		hello.sessionId = session.sessionId
+		if len(hello.sessionId) == 0 {
+			// We resume from the ticket alone, but a session ID
+			// is how the server tells us that it is resuming.
+			// See RFC 5077, section 3.4.
+			hello.sessionId = make([]byte, 16)
+			if _, err := io.ReadFull(c.config.rand(), hello.sessionId); err != nil {
+				c.sendAlert(alertInternalError)
+				return os.NewError("short read from Rand")
+			}
+		}
+	}
+
 	c.writeRecord(recordTypeHandshake, hello.marshal())
 
 	msg, err := c.readHandshake()
@@ -54,15 +97,20 @@ func (c *Conn) clientHandshake() os.Error {
 	if !ok {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
-	finishedHash.Write(serverHello.marshal())
 
-	vers, ok := mutualVersion(serverHello.vers)
-	if !ok {
+	vers, ok := c.config.mutualVersion(serverHello.vers)
+	if !ok || vers != serverHello.vers {
 		return c.sendAlert(alertProtocolVersion)
 	}
 	c.vers = vers
 	c.haveVers = true
 
+	// The hash of the handshake depends on the version, so
+	// it can only start now.
+	finishedHash := newFinishedHash(c.vers)
+	finishedHash.Write(hello.marshal())
+	finishedHash.Write(serverHello.marshal())
+
 	if serverHello.compressionMethod != compressionNone {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
@@ -72,12 +120,148 @@ func (c *Conn) clientHandshake() os.Error {
 		return os.NewError("server advertised unrequested NPN")
 	}
 
-	suite, suiteId := mutualCipherSuite(c.config.cipherSuites(), serverHello.cipherSuite)
+	if !hello.ticketSupported && serverHello.ticketSupported {
+		c.sendAlert(alertHandshakeFailure)
+		return os.NewError("server advertised unrequested session ticket")
+	}
+
+	suite, suiteId := mutualCipherSuite(c.config.cipherSuites(), serverHello.cipherSuite, c.vers)
 	if suite == nil {
 		return c.sendAlert(alertHandshakeFailure)
 	}
 
-	msg, err = c.readHandshake()
+	hs := &clientHandshakeState{
+		c:            c,
+		hello:        hello,
+		serverHello:  serverHello,
+		suite:        suite,
+		suiteId:      suiteId,
+		finishedHash: finishedHash,
+		session:      session,
+	}
+
+	isResume, err := hs.processServerHello()
+	if err != nil {
+		return err
+	}
+
+	// For an overview of TLS handshaking, see RFC 5246, section 7.3.
+	if isResume {
+		if err := hs.establishKeys(); err != nil {
+			return err
+		}
+		if err := hs.readSessionTicket(); err != nil {
+			return err
+		}
+		if err := hs.readFinished(); err != nil {
+			return err
+		}
+		if err := hs.sendFinished(); err != nil {
+			return err
+		}
+	} else {
+		if err := hs.doFullHandshake(); err != nil {
+			return err
+		}
+		if err := hs.establishKeys(); err != nil {
+			return err
+		}
+		if err := hs.sendFinished(); err != nil {
+			return err
+		}
+		if err := hs.readSessionTicket(); err != nil {
+			return err
+		}
+		if err := hs.readFinished(); err != nil {
+			return err
+		}
+	}
+
+	c.didResume = isResume
+	if sessionCache != nil {
+		if s := hs.newSession(); s != nil {
+			sessionCache.Put(cacheKey, s)
+		}
+	}
+
+	c.handshakeComplete = true
+	c.cipherSuite = suiteId
+	return nil
+}
+
+// canResume returns whether session suits the hello that is to offer it.
+func (c *Conn) canResume(session *ClientSessionState, hello *clientHelloMsg) bool {
+	if len(session.sessionId) == 0 && (len(session.sessionTicket) == 0 || !hello.ticketSupported) {
+		return false
+	}
+
+	// The server would not resume a session with a version or cipher
+	// suite that we no longer offer.
+	if session.vers < c.config.minVersion() || session.vers > c.config.maxVersion() {
+		return false
+	}
+	for _, id := range hello.cipherSuites {
+		if id == session.cipherSuite {
+			return true
+		}
+	}
+	return false
+}
+
+// chainIssuedBy returns whether one of the certificates of chain was issued
+// by one of the certificate authorities with the DER-encoded names cas.
+func chainIssuedBy(chain [][]byte, cas [][]byte) bool {
+	for _, asn1Data := range chain {
+		cert, err := x509.ParseCertificate(asn1Data)
+		if err != nil {
+			return false
+		}
+		for _, ca := range cas {
+			if bytes.Equal(cert.RawIssuer, ca) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientSessionCacheKey returns the key under which a client caches its
+// session with a server.
+func clientSessionCacheKey(serverAddr net.Addr, config *Config) string {
+	if len(config.ServerName) > 0 {
+		return config.ServerName
+	}
+	return serverAddr.String()
+}
+
+// processServerHello returns whether the server is resuming the session that
+// we offered, and restores the state of the session if so.
+func (hs *clientHandshakeState) processServerHello() (bool, os.Error) {
+	c := hs.c
+
+	if hs.session == nil || !bytes.Equal(hs.serverHello.sessionId, hs.hello.sessionId) {
+		return false, nil
+	}
+
+	if hs.session.vers != c.vers {
+		c.sendAlert(alertHandshakeFailure)
+		return false, os.NewError("server resumed a session with a different version")
+	}
+	if hs.session.cipherSuite != hs.suiteId {
+		c.sendAlert(alertHandshakeFailure)
+		return false, os.NewError("server resumed a session with a different cipher suite")
+	}
+
+	hs.masterSecret = hs.session.masterSecret
+	c.peerCertificates = hs.session.serverCertificates
+	c.verifiedChains = hs.session.verifiedChains
+	return true, nil
+}
+
+func (hs *clientHandshakeState) doFullHandshake() os.Error {
+	c := hs.c
+
+	msg, err := c.readHandshake()
 	if err != nil {
 		return err
 	}
@@ -85,7 +269,7 @@ func (c *Conn) clientHandshake() os.Error {
 	if !ok || len(certMsg.certificates) == 0 {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
-	finishedHash.Write(certMsg.marshal())
+	hs.finishedHash.Write(certMsg.marshal())
 
 	certs := make([]*x509.Certificate, len(certMsg.certificates))
 	for i, asn1Data := range certMsg.certificates {
@@ -126,7 +310,7 @@ func (c *Conn) clientHandshake() os.Error {
 
 	c.peerCertificates = certs
 
-	if serverHello.ocspStapling {
+	if hs.serverHello.ocspStapling {
 		msg, err = c.readHandshake()
 		if err != nil {
 			return err
@@ -135,7 +319,7 @@ func (c *Conn) clientHandshake() os.Error {
 		if !ok {
 			return c.sendAlert(alertUnexpectedMessage)
 		}
-		finishedHash.Write(cs.marshal())
+		hs.finishedHash.Write(cs.marshal())
 
 		if cs.statusType == statusTypeOCSP {
 			c.ocspResponse = cs.response
@@ -147,12 +331,12 @@ func (c *Conn) clientHandshake() os.Error {
 		return err
 	}
 
-	keyAgreement := suite.ka()
+	keyAgreement := hs.suite.ka()
 
 	skx, ok := msg.(*serverKeyExchangeMsg)
 	if ok {
-		finishedHash.Write(skx.marshal())
-		err = keyAgreement.processServerKeyExchange(c.config, hello, serverHello, certs[0], skx)
+		hs.finishedHash.Write(skx.marshal())
+		err = keyAgreement.processServerKeyExchange(c.config, hs.hello, hs.serverHello, certs[0], skx)
 		if err != nil {
 			c.sendAlert(alertUnexpectedMessage)
 			return err
@@ -164,9 +348,12 @@ func (c *Conn) clientHandshake() os.Error {
 		}
 	}
 
+	certRequested := false
 	transmitCert := false
 	certReq, ok := msg.(*certificateRequestMsg)
 	if ok {
+		certRequested = true
+
 		// We only accept certificates with RSA keys.
 		rsaAvail := false
 		for _, certType := range certReq.certificateTypes {
@@ -176,8 +363,9 @@ func (c *Conn) clientHandshake() os.Error {
 			}
 		}
 
-		// For now, only send a certificate back if the server gives us an
-		// empty list of certificateAuthorities.
+		// We send our certificate back if the server gives us an empty
+		// list of certificateAuthorities, or if one of them issued a
+		// certificate of our chain.
 		//
 		// RFC 4346 on the certificateAuthorities field:
 		// A list of the distinguished names of acceptable certificate
@@ -188,11 +376,18 @@ func (c *Conn) clientHandshake() os.Error {
 		// list is empty then the client MAY send any certificate of the
 		// appropriate ClientCertificateType, unless there is some
 		// external arrangement to the contrary.
-		if rsaAvail && len(certReq.certificateAuthorities) == 0 {
+		if rsaAvail && len(c.config.Certificates) > 0 &&
+			(len(certReq.certificateAuthorities) == 0 || chainIssuedBy(c.config.Certificates[0].Certificate, certReq.certificateAuthorities)) {
 			transmitCert = true
 		}
 
-		finishedHash.Write(certReq.marshal())
+		// In TLS 1.2, we can only sign with the hash of the handshake
+		// that we keep, SHA256, so the server must accept that.
+		if c.vers >= VersionTLS12 && !signatureAndHashAccepted(certReq.signatureAndHashes, signatureAndHash{hashSHA256, signatureRSA}) {
+			transmitCert = false
+		}
+
+		hs.finishedHash.Write(certReq.marshal())
 
 		msg, err = c.readHandshake()
 		if err != nil {
@@ -204,12 +399,14 @@ func (c *Conn) clientHandshake() os.Error {
 	if !ok {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
-	finishedHash.Write(shd.marshal())
+	hs.finishedHash.Write(shd.marshal())
 
 	var cert *x509.Certificate
-	if transmitCert {
+	if certRequested {
+		// If we have no suitable certificate, we still answer the
+		// request, with an empty list of certificates.
 		certMsg = new(certificateMsg)
-		if len(c.config.Certificates) > 0 {
+		if transmitCert {
 			cert, err = x509.ParseCertificate(c.config.Certificates[0].Certificate[0])
 			if err == nil && cert.PublicKeyAlgorithm == x509.RSA {
 				certMsg.certificates = c.config.Certificates[0].Certificate
@@ -217,68 +414,88 @@ func (c *Conn) clientHandshake() os.Error {
 				cert = nil
 			}
 		}
-		finishedHash.Write(certMsg.marshal())
+		hs.finishedHash.Write(certMsg.marshal())
 		c.writeRecord(recordTypeHandshake, certMsg.marshal())
 	}
 
-	preMasterSecret, ckx, err := keyAgreement.generateClientKeyExchange(c.config, hello, certs[0])
+	preMasterSecret, ckx, err := keyAgreement.generateClientKeyExchange(c.config, hs.hello, certs[0])
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
 	if ckx != nil {
-		finishedHash.Write(ckx.marshal())
+		hs.finishedHash.Write(ckx.marshal())
 		c.writeRecord(recordTypeHandshake, ckx.marshal())
 	}
 
 	if cert != nil {
-		certVerify := new(certificateVerifyMsg)
-		var digest [36]byte
-		copy(digest[0:16], finishedHash.serverMD5.Sum())
-		copy(digest[16:36], finishedHash.serverSHA1.Sum())
-		signed, err := rsa.SignPKCS1v15(c.config.rand(), c.config.Certificates[0].PrivateKey, crypto.MD5SHA1, digest[0:])
+		certVerify := &certificateVerifyMsg{
+			hasSignatureAndHash: c.vers >= VersionTLS12,
+			signatureAndHash:    signatureAndHash{hashSHA256, signatureRSA},
+		}
+		digest, hashFunc := hs.finishedHash.hashForClientCertificate()
+		signed, err := rsa.SignPKCS1v15(c.config.rand(), c.config.Certificates[0].PrivateKey, hashFunc, digest)
 		if err != nil {
 			return c.sendAlert(alertInternalError)
 		}
 		certVerify.signature = signed
 
-		finishedHash.Write(certVerify.marshal())
+		hs.finishedHash.Write(certVerify.marshal())
 		c.writeRecord(recordTypeHandshake, certVerify.marshal())
 	}
 
-	masterSecret, clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV :=
-		keysFromPreMasterSecret10(preMasterSecret, hello.random, serverHello.random, suite.macLen, suite.keyLen, suite.ivLen)
+	hs.masterSecret = masterFromPreMasterSecret(c.vers, preMasterSecret, hs.hello.random, hs.serverHello.random)
+	return nil
+}
 
-	clientCipher := suite.cipher(clientKey, clientIV, false /* not for reading */ )
-	clientHash := suite.mac(clientMAC)
-	c.out.prepareCipherSpec(clientCipher, clientHash)
-	c.writeRecord(recordTypeChangeCipherSpec, []byte{1})
+func (hs *clientHandshakeState) establishKeys() os.Error {
+	c := hs.c
 
-	if serverHello.nextProtoNeg {
-		nextProto := new(nextProtoMsg)
-		proto, fallback := mutualProtocol(c.config.NextProtos, serverHello.nextProtos)
-		nextProto.proto = proto
-		c.clientProtocol = proto
-		c.clientProtocolFallback = fallback
+	clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV :=
+		keysFromMasterSecret(c.vers, hs.masterSecret, hs.hello.random, hs.serverHello.random, hs.suite.macLen, hs.suite.keyLen, hs.suite.ivLen)
 
-		finishedHash.Write(nextProto.marshal())
-		c.writeRecord(recordTypeHandshake, nextProto.marshal())
+	clientCipher := hs.suite.cipher(clientKey, clientIV, false /* not for reading */ )
+	clientHash := hs.suite.newMAC(clientMAC)
+	c.out.prepareCipherSpec(c.vers, clientCipher, clientHash)
+
+	serverCipher := hs.suite.cipher(serverKey, serverIV, true /* for reading */ )
+	serverHash := hs.suite.newMAC(serverMAC)
+	c.in.prepareCipherSpec(c.vers, serverCipher, serverHash)
+
+	return nil
+}
+
+// readSessionTicket reads the ticket that the server sends before its
+// ChangeCipherSpec, if it said that it would.
+func (hs *clientHandshakeState) readSessionTicket() os.Error {
+	if !hs.serverHello.ticketSupported {
+		return nil
 	}
 
-	finished := new(finishedMsg)
-	finished.verifyData = finishedHash.clientSum(masterSecret)
-	finishedHash.Write(finished.marshal())
-	c.writeRecord(recordTypeHandshake, finished.marshal())
+	c := hs.c
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	sessionTicketMsg, ok := msg.(*newSessionTicketMsg)
+	if !ok {
+		return c.sendAlert(alertUnexpectedMessage)
+	}
+	hs.finishedHash.Write(sessionTicketMsg.marshal())
+
+	hs.sessionTicket = sessionTicketMsg.ticket
+	return nil
+}
+
+func (hs *clientHandshakeState) readFinished() os.Error {
+	c := hs.c
 
-	serverCipher := suite.cipher(serverKey, serverIV, true /* for reading */ )
-	serverHash := suite.mac(serverMAC)
-	c.in.prepareCipherSpec(serverCipher, serverHash)
 	c.readRecord(recordTypeChangeCipherSpec)
 	if c.err != nil {
 		return c.err
 	}
 
-	msg, err = c.readHandshake()
+	msg, err := c.readHandshake()
 	if err != nil {
 		return err
 	}
@@ -287,17 +504,79 @@ func (c *Conn) clientHandshake() os.Error {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
 
-	verify := finishedHash.serverSum(masterSecret)
+	verify := hs.finishedHash.serverSum(hs.masterSecret)
 	if len(verify) != len(serverFinished.verifyData) ||
 		subtle.ConstantTimeCompare(verify, serverFinished.verifyData) != 1 {
 		return c.sendAlert(alertHandshakeFailure)
 	}
+	hs.finishedHash.Write(serverFinished.marshal())
+	return nil
+}
 
-	c.handshakeComplete = true
-	c.cipherSuite = suiteId
+func (hs *clientHandshakeState) sendFinished() os.Error {
+	c := hs.c
+
+	c.writeRecord(recordTypeChangeCipherSpec, []byte{1})
+
+	if hs.serverHello.nextProtoNeg {
+		nextProto := new(nextProtoMsg)
+		proto, fallback := mutualProtocol(c.config.NextProtos, hs.serverHello.nextProtos)
+		nextProto.proto = proto
+		c.clientProtocol = proto
+		c.clientProtocolFallback = fallback
+
+		hs.finishedHash.Write(nextProto.marshal())
+		c.writeRecord(recordTypeHandshake, nextProto.marshal())
+	}
+
+	finished := new(finishedMsg)
+	finished.verifyData = hs.finishedHash.clientSum(hs.masterSecret)
+	hs.finishedHash.Write(finished.marshal())
+	c.writeRecord(recordTypeHandshake, finished.marshal())
 	return nil
 }
 
+// newSession returns the state that resuming the session of the completed
+// handshake will need, or nil if the server gave us no way to resume it.
+func (hs *clientHandshakeState) newSession() *ClientSessionState {
+	c := hs.c
+
+	sessionTicket := hs.sessionTicket
+	sessionId := hs.serverHello.sessionId
+	if c.didResume {
+		// Unless the server gave us a new ticket, the session
+		// stays as it was.
+		if sessionTicket == nil {
+			return nil
+		}
+		sessionId = hs.session.sessionId
+	}
+	if len(sessionTicket) == 0 && len(sessionId) == 0 {
+		return nil
+	}
+
+	return &ClientSessionState{
+		sessionTicket:      sessionTicket,
+		sessionId:          sessionId,
+		vers:               c.vers,
+		cipherSuite:        hs.suiteId,
+		masterSecret:       hs.masterSecret,
+		serverCertificates: c.peerCertificates,
+		verifiedChains:     c.verifiedChains,
+	}
+}
+
+// signatureAndHashAccepted returns whether want is among the signature and
+// hash algorithms that a peer accepts.
+func signatureAndHashAccepted(accepted []signatureAndHash, want signatureAndHash) bool {
+	for _, sah := range accepted {
+		if sah.hash == want.hash && sah.signature == want.signature {
+			return true
+		}
+	}
+	return false
+}
+
 // mutualProtocol finds the mutual Next Protocol Negotiation protocol given the
 // set of client and server supported protocols. The set of client supported
 // protocols must not be empty. It returns the resulting protocol and flag
@@ -41,6 +41,44 @@ func TestHandshakeClientRC4(t *testing.T) {
 	testClientScript(t, "RC4", rc4ClientScript, testConfig)
 }
 
+func TestLRUClientSessionCache(t *testing.T) {
+	keys := []string{"0", "1", "2", "3", "4"}
+	sessions := make([]*ClientSessionState, len(keys))
+	for i := range sessions {
+		sessions[i] = &ClientSessionState{vers: uint16(i)}
+	}
+
+	cache := NewLRUClientSessionCache(4)
+	for i := 0; i < 4; i++ {
+		cache.Put(keys[i], sessions[i])
+	}
+	// Using key 0 makes key 1 the least recently used.
+	if s, ok := cache.Get(keys[0]); !ok || s != sessions[0] {
+		t.Fatalf("session 0 missing from the cache")
+	}
+	cache.Put(keys[4], sessions[4])
+
+	for i, key := range keys {
+		s, ok := cache.Get(key)
+		if want := i != 1; ok != want {
+			t.Errorf("session %d: in cache: %v, want %v", i, ok, want)
+		} else if ok && s != sessions[i] {
+			t.Errorf("session %d: got the wrong session", i)
+		}
+	}
+
+	// Replacing a session does not evict another.
+	cache.Put(keys[2], sessions[0])
+	if s, _ := cache.Get(keys[2]); s != sessions[0] {
+		t.Errorf("session 2 was not replaced")
+	}
+	for _, i := range []int{0, 3, 4} {
+		if _, ok := cache.Get(keys[i]); !ok {
+			t.Errorf("session %d was evicted", i)
+		}
+	}
+}
+
 var connect = flag.Bool("connect", false, "connect to a TLS server on :10443")
 
 func TestRunClient(t *testing.T) {
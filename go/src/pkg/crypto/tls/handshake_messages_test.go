@@ -22,6 +22,8 @@ var tests = []interface{}{
 	&clientKeyExchangeMsg{},
 	&finishedMsg{},
 	&nextProtoMsg{},
+	&newSessionTicketMsg{},
+	&sessionState{},
 }
 
 type testMessage interface {
@@ -102,6 +104,14 @@ func randomString(n int, rand *rand.Rand) string {
 	return string(b)
 }
 
+func randomSignatureAndHashes(rand *rand.Rand) []signatureAndHash {
+	s := make([]signatureAndHash, rand.Intn(5)+1)
+	for i := range s {
+		s[i] = signatureAndHash{uint8(rand.Intn(256)), uint8(rand.Intn(256))}
+	}
+	return s
+}
+
 func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &clientHelloMsg{}
 	m.vers = uint16(rand.Intn(65536))
@@ -124,6 +134,13 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	for i := range m.supportedCurves {
 		m.supportedCurves[i] = uint16(rand.Intn(30000))
 	}
+	if rand.Intn(10) > 5 {
+		m.signatureAndHashes = randomSignatureAndHashes(rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.ticketSupported = true
+		m.sessionTicket = randomBytes(rand.Intn(300), rand)
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -139,12 +156,13 @@ func (*serverHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.nextProtoNeg = true
 
-		n := rand.Intn(10)
+		n := rand.Intn(10) + 1
 		m.nextProtos = make([]string, n)
 		for i := 0; i < n; i++ {
 			m.nextProtos[i] = randomString(20, rand)
 		}
 	}
+	m.ticketSupported = rand.Intn(10) > 5
 
 	return reflect.ValueOf(m)
 }
@@ -170,6 +188,35 @@ func (*certificateRequestMsg) Generate(rand *rand.Rand, size int) reflect.Value
 	return reflect.ValueOf(m)
 }
 
+// TLS 1.2 changed the format of CertificateRequest and CertificateVerify
+// messages.  Which one a message uses cannot be told from its bytes, so it
+// is set before unmarshaling.
+func TestMarshalUnmarshalTLS12(t *testing.T) {
+	rand := rand.New(rand.NewSource(0))
+	for i := 0; i < 10; i++ {
+		req := &certificateRequestMsg{
+			hasSignatureAndHash:    true,
+			certificateTypes:       randomBytes(rand.Intn(5)+1, rand),
+			signatureAndHashes:     randomSignatureAndHashes(rand),
+			certificateAuthorities: [][]byte{randomBytes(rand.Intn(15)+1, rand)},
+		}
+		req2 := &certificateRequestMsg{hasSignatureAndHash: true}
+		if !req2.unmarshal(req.marshal()) || !reflect.DeepEqual(req, req2) {
+			t.Errorf("#%d: certificateRequestMsg got:%#v want:%#v", i, req2, req)
+		}
+
+		verify := &certificateVerifyMsg{
+			hasSignatureAndHash: true,
+			signatureAndHash:    signatureAndHash{hashSHA256, signatureRSA},
+			signature:           randomBytes(rand.Intn(15)+1, rand),
+		}
+		verify2 := &certificateVerifyMsg{hasSignatureAndHash: true}
+		if !verify2.unmarshal(verify.marshal()) || !reflect.DeepEqual(verify, verify2) {
+			t.Errorf("#%d: certificateVerifyMsg got:%#v want:%#v", i, verify2, verify)
+		}
+	}
+}
+
 func (*certificateVerifyMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &certificateVerifyMsg{}
 	m.signature = randomBytes(rand.Intn(15)+1, rand)
@@ -204,3 +251,23 @@ func (*nextProtoMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	m.proto = randomString(rand.Intn(255), rand)
 	return reflect.ValueOf(m)
 }
+
+func (*newSessionTicketMsg) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &newSessionTicketMsg{}
+	m.ticket = randomBytes(rand.Intn(4), rand)
+	return reflect.ValueOf(m)
+}
+
+func (*sessionState) Generate(rand *rand.Rand, size int) reflect.Value {
+	s := &sessionState{}
+	s.vers = uint16(rand.Intn(10000))
+	s.cipherSuite = uint16(rand.Intn(10000))
+	s.createdAt = uint64(rand.Int63())
+	s.masterSecret = randomBytes(rand.Intn(100), rand)
+	numCerts := rand.Intn(20)
+	s.certificates = make([][]byte, numCerts)
+	for i := 0; i < numCerts; i++ {
+		s.certificates[i] = randomBytes(rand.Intn(10)+1, rand)
+	}
+	return reflect.ValueOf(s)
+}
@@ -5,7 +5,6 @@
 package tls
 
 import (
-	"crypto"
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
@@ -13,31 +12,106 @@ import (
 	"os"
 )
 
+// serverHandshakeState contains details of a server handshake in progress.
+// It's discarded once the handshake has completed.
+type serverHandshakeState struct {
+	c               *Conn
+	clientHello     *clientHelloMsg
+	hello           *serverHelloMsg
+	suite           *cipherSuite
+	suiteId         uint16
+	ellipticOk      bool
+	sessionState    *sessionState
+	finishedHash    finishedHash
+	masterSecret    []byte
+	certsFromClient [][]byte
+}
+
 func (c *Conn) serverHandshake() os.Error {
 	config := c.config
-	msg, err := c.readHandshake()
+	config.serverInitOnce.Do(func() { config.serverInit() })
+
+	hs := serverHandshakeState{c: c}
+	isResume, err := hs.readClientHello()
 	if err != nil {
 		return err
 	}
-	clientHello, ok := msg.(*clientHelloMsg)
+
+	// For an overview of TLS handshaking, see RFC 5246, section 7.3.
+	if isResume {
+		// The client has included a session ticket or ID that we can
+		// resume, so this is an abbreviated handshake.
+		if err := hs.doResumeHandshake(); err != nil {
+			return err
+		}
+		if err := hs.establishKeys(); err != nil {
+			return err
+		}
+		if err := hs.sendFinished(); err != nil {
+			return err
+		}
+		if err := hs.readFinished(); err != nil {
+			return err
+		}
+		c.didResume = true
+	} else {
+		// The client didn't include a session ticket or ID, or we
+		// couldn't resume it, so this is a full handshake.
+		if err := hs.doFullHandshake(); err != nil {
+			return err
+		}
+		if err := hs.establishKeys(); err != nil {
+			return err
+		}
+		if err := hs.readFinished(); err != nil {
+			return err
+		}
+		if err := hs.sendSessionTicket(); err != nil {
+			return err
+		}
+		if err := hs.sendFinished(); err != nil {
+			return err
+		}
+		if len(hs.hello.sessionId) > 0 {
+			config.ServerSessionCache.Put(string(hs.hello.sessionId), hs.newSessionState().marshal())
+		}
+	}
+	c.handshakeComplete = true
+	c.cipherSuite = hs.suiteId
+
+	return nil
+}
+
+// readClientHello reads a ClientHello message from the client and decides
+// whether we will perform session resumption.
+func (hs *serverHandshakeState) readClientHello() (isResume bool, err os.Error) {
+	config := hs.c.config
+	c := hs.c
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	hs.clientHello, ok = msg.(*clientHelloMsg)
 	if !ok {
-		return c.sendAlert(alertUnexpectedMessage)
+		return false, c.sendAlert(alertUnexpectedMessage)
 	}
-	vers, ok := mutualVersion(clientHello.vers)
+	vers, ok := config.mutualVersion(hs.clientHello.vers)
 	if !ok {
-		return c.sendAlert(alertProtocolVersion)
+		return false, c.sendAlert(alertProtocolVersion)
 	}
 	c.vers = vers
 	c.haveVers = true
 
-	finishedHash := newFinishedHash()
-	finishedHash.Write(clientHello.marshal())
+	hs.finishedHash = newFinishedHash(vers)
+	hs.finishedHash.Write(hs.clientHello.marshal())
 
-	hello := new(serverHelloMsg)
+	hs.hello = new(serverHelloMsg)
 
 	supportedCurve := false
 Curves:
-	for _, curve := range clientHello.supportedCurves {
+	for _, curve := range hs.clientHello.supportedCurves {
 		switch curve {
 		case curveP256, curveP384, curveP521:
 			supportedCurve = true
@@ -46,158 +120,271 @@ Curves:
 	}
 
 	supportedPointFormat := false
-	for _, pointFormat := range clientHello.supportedPoints {
+	for _, pointFormat := range hs.clientHello.supportedPoints {
 		if pointFormat == pointFormatUncompressed {
 			supportedPointFormat = true
 			break
 		}
 	}
-
-	ellipticOk := supportedCurve && supportedPointFormat
-
-	var suite *cipherSuite
-	var suiteId uint16
-FindCipherSuite:
-	for _, id := range clientHello.cipherSuites {
-		for _, supported := range config.cipherSuites() {
-			if id == supported {
-				suite = cipherSuites[id]
-				// Don't select a ciphersuite which we can't
-				// support for this client.
-				if suite.elliptic && !ellipticOk {
-					continue
-				}
-				suiteId = id
-				break FindCipherSuite
-			}
-		}
-	}
+	hs.ellipticOk = supportedCurve && supportedPointFormat
 
 	foundCompression := false
 	// We only support null compression, so check that the client offered it.
-	for _, compression := range clientHello.compressionMethods {
+	for _, compression := range hs.clientHello.compressionMethods {
 		if compression == compressionNone {
 			foundCompression = true
 			break
 		}
 	}
 
-	if suite == nil || !foundCompression {
-		return c.sendAlert(alertHandshakeFailure)
+	if !foundCompression {
+		return false, c.sendAlert(alertHandshakeFailure)
 	}
 
-	hello.vers = vers
-	hello.cipherSuite = suiteId
+	hs.hello.vers = vers
 	t := uint32(config.time())
-	hello.random = make([]byte, 32)
-	hello.random[0] = byte(t >> 24)
-	hello.random[1] = byte(t >> 16)
-	hello.random[2] = byte(t >> 8)
-	hello.random[3] = byte(t)
-	_, err = io.ReadFull(config.rand(), hello.random[4:])
+	hs.hello.random = make([]byte, 32)
+	hs.hello.random[0] = byte(t >> 24)
+	hs.hello.random[1] = byte(t >> 16)
+	hs.hello.random[2] = byte(t >> 8)
+	hs.hello.random[3] = byte(t)
+	_, err = io.ReadFull(config.rand(), hs.hello.random[4:])
 	if err != nil {
-		return c.sendAlert(alertInternalError)
+		return false, c.sendAlert(alertInternalError)
+	}
+	hs.hello.compressionMethod = compressionNone
+	if hs.clientHello.nextProtoNeg {
+		hs.hello.nextProtoNeg = true
+		hs.hello.nextProtos = config.NextProtos
 	}
-	hello.compressionMethod = compressionNone
-	if clientHello.nextProtoNeg {
-		hello.nextProtoNeg = true
-		hello.nextProtos = config.NextProtos
+
+	if hs.checkForResumption() {
+		return true, nil
+	}
+
+	for _, id := range hs.clientHello.cipherSuites {
+		if hs.setCipherSuite(id) {
+			break
+		}
+	}
+
+	if hs.suite == nil {
+		return false, c.sendAlert(alertHandshakeFailure)
+	}
+
+	return false, nil
+}
+
+// checkForResumption returns true if we should perform session resumption.
+func (hs *serverHandshakeState) checkForResumption() bool {
+	c := hs.c
+	config := c.config
+
+	ok := false
+	if len(hs.clientHello.sessionTicket) > 0 && !config.SessionTicketsDisabled {
+		hs.sessionState, ok = c.decryptTicket(hs.clientHello.sessionTicket)
+	}
+	if !ok && len(hs.clientHello.sessionId) > 0 && config.ServerSessionCache != nil {
+		if state, found := config.ServerSessionCache.Get(string(hs.clientHello.sessionId)); found {
+			hs.sessionState = new(sessionState)
+			ok = hs.sessionState.unmarshal(state)
+		}
 	}
-	if clientHello.ocspStapling && len(config.Certificates[0].OCSPStaple) > 0 {
-		hello.ocspStapling = true
+	if !ok {
+		return false
 	}
 
-	finishedHash.Write(hello.marshal())
-	c.writeRecord(recordTypeHandshake, hello.marshal())
+	// Sessions expire, even if the key of their ticket does not.
+	if config.time()-int64(hs.sessionState.createdAt) > maxSessionLifetime {
+		return false
+	}
+
+	// A session continues with the version it started with.
+	if hs.sessionState.vers != c.vers {
+		return false
+	}
+
+	// Check that the client is still offering the cipher suite of the
+	// session.
+	cipherSuiteOk := false
+	for _, id := range hs.clientHello.cipherSuites {
+		if id == hs.sessionState.cipherSuite {
+			cipherSuiteOk = true
+			break
+		}
+	}
+	if !cipherSuiteOk {
+		return false
+	}
+
+	// Check that we still support it too.
+	if !hs.setCipherSuite(hs.sessionState.cipherSuite) {
+		return false
+	}
+
+	// The session must suit our current policy on client certificates.
+	clientAuth := config.clientAuth()
+	sessionHasClientCerts := len(hs.sessionState.certificates) > 0
+	needClientCerts := clientAuth == RequireAnyClientCert || clientAuth == RequireAndVerifyClientCert
+	if needClientCerts && !sessionHasClientCerts {
+		return false
+	}
+	if sessionHasClientCerts && clientAuth == NoClientCert {
+		return false
+	}
+
+	return true
+}
+
+// setCipherSuite sets hs.suite to the cipher suite with the given id and
+// returns true, if it is one of ours and suits this client.
+func (hs *serverHandshakeState) setCipherSuite(id uint16) bool {
+	for _, supported := range hs.c.config.cipherSuites() {
+		if id == supported {
+			suite := cipherSuites[id]
+			// Don't select a ciphersuite which we can't
+			// support for this client.
+			if suite == nil || suite.elliptic && !hs.ellipticOk || suite.tls12 && hs.c.vers < VersionTLS12 {
+				return false
+			}
+			hs.suite = suite
+			hs.suiteId = id
+			return true
+		}
+	}
+	return false
+}
+
+// doResumeHandshake sends the ServerHello of an abbreviated handshake and
+// restores the state of the session being resumed.
+func (hs *serverHandshakeState) doResumeHandshake() os.Error {
+	c := hs.c
+
+	hs.hello.cipherSuite = hs.suiteId
+	// We echo the client's session ID, which is how the client knows
+	// that we are resuming, even when the session came from a ticket.
+	hs.hello.sessionId = hs.clientHello.sessionId
+	hs.finishedHash.Write(hs.hello.marshal())
+	c.writeRecord(recordTypeHandshake, hs.hello.marshal())
+
+	// The certificates of the client are checked again, since our
+	// ClientCAs may have changed since the session began.
+	if len(hs.sessionState.certificates) > 0 {
+		if _, err := hs.processCertsFromClient(hs.sessionState.certificates); err != nil {
+			return err
+		}
+	}
+
+	hs.masterSecret = hs.sessionState.masterSecret
+
+	return nil
+}
+
+func (hs *serverHandshakeState) doFullHandshake() os.Error {
+	config := hs.c.config
+	c := hs.c
 
 	if len(config.Certificates) == 0 {
 		return c.sendAlert(alertInternalError)
 	}
 
+	if hs.clientHello.ocspStapling && len(config.Certificates[0].OCSPStaple) > 0 {
+		hs.hello.ocspStapling = true
+	}
+
+	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !config.SessionTicketsDisabled && len(config.ticketKeys()) > 0
+	if config.ServerSessionCache != nil {
+		hs.hello.sessionId = make([]byte, 32)
+		if _, err := io.ReadFull(config.rand(), hs.hello.sessionId); err != nil {
+			return c.sendAlert(alertInternalError)
+		}
+	}
+	hs.hello.cipherSuite = hs.suiteId
+	hs.finishedHash.Write(hs.hello.marshal())
+	c.writeRecord(recordTypeHandshake, hs.hello.marshal())
+
 	certMsg := new(certificateMsg)
 	certMsg.certificates = config.Certificates[0].Certificate
-	finishedHash.Write(certMsg.marshal())
+	hs.finishedHash.Write(certMsg.marshal())
 	c.writeRecord(recordTypeHandshake, certMsg.marshal())
 
-	if hello.ocspStapling {
+	if hs.hello.ocspStapling {
 		certStatus := new(certificateStatusMsg)
 		certStatus.statusType = statusTypeOCSP
 		certStatus.response = config.Certificates[0].OCSPStaple
-		finishedHash.Write(certStatus.marshal())
+		hs.finishedHash.Write(certStatus.marshal())
 		c.writeRecord(recordTypeHandshake, certStatus.marshal())
 	}
 
-	keyAgreement := suite.ka()
+	keyAgreement := hs.suite.ka()
 
-	skx, err := keyAgreement.generateServerKeyExchange(config, clientHello, hello)
+	skx, err := keyAgreement.generateServerKeyExchange(config, hs.clientHello, hs.hello)
 	if err != nil {
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
 	if skx != nil {
-		finishedHash.Write(skx.marshal())
+		hs.finishedHash.Write(skx.marshal())
 		c.writeRecord(recordTypeHandshake, skx.marshal())
 	}
 
-	if config.AuthenticateClient {
+	if config.clientAuth() >= RequestClientCert {
 		// Request a client certificate
 		certReq := new(certificateRequestMsg)
 		certReq.certificateTypes = []byte{certTypeRSASign}
+		if c.vers >= VersionTLS12 {
+			// We can only verify a signature made with the hash
+			// of the handshake that we keep.
+			certReq.hasSignatureAndHash = true
+			certReq.signatureAndHashes = []signatureAndHash{{hashSHA256, signatureRSA}}
+		}
 		// An empty list of certificateAuthorities signals to
 		// the client that it may send any certificate in response
-		// to our request.
+		// to our request. When we know the CAs we trust, then
+		// we can send them down, so that the client can choose
+		// an appropriate certificate to give to us.
+		if config.ClientCAs != nil {
+			certReq.certificateAuthorities = config.ClientCAs.Subjects()
+		}
 
-		finishedHash.Write(certReq.marshal())
+		hs.finishedHash.Write(certReq.marshal())
 		c.writeRecord(recordTypeHandshake, certReq.marshal())
 	}
 
 	helloDone := new(serverHelloDoneMsg)
-	finishedHash.Write(helloDone.marshal())
+	hs.finishedHash.Write(helloDone.marshal())
 	c.writeRecord(recordTypeHandshake, helloDone.marshal())
 
 	var pub *rsa.PublicKey
-	if config.AuthenticateClient {
+	if config.clientAuth() >= RequestClientCert {
 		// Get client certificate
-		msg, err = c.readHandshake()
+		msg, err := c.readHandshake()
 		if err != nil {
 			return err
 		}
-		certMsg, ok = msg.(*certificateMsg)
+		certMsg, ok := msg.(*certificateMsg)
 		if !ok {
 			return c.sendAlert(alertUnexpectedMessage)
 		}
-		finishedHash.Write(certMsg.marshal())
-
-		certs := make([]*x509.Certificate, len(certMsg.certificates))
-		for i, asn1Data := range certMsg.certificates {
-			cert, err := x509.ParseCertificate(asn1Data)
-			if err != nil {
-				c.sendAlert(alertBadCertificate)
-				return os.NewError("could not parse client's certificate: " + err.String())
-			}
-			certs[i] = cert
-		}
+		hs.finishedHash.Write(certMsg.marshal())
 
-		// TODO(agl): do better validation of certs: max path length, name restrictions etc.
-		for i := 1; i < len(certs); i++ {
-			if err := certs[i-1].CheckSignatureFrom(certs[i]); err != nil {
+		if len(certMsg.certificates) == 0 {
+			// The client didn't actually send a certificate
+			switch config.clientAuth() {
+			case RequireAnyClientCert, RequireAndVerifyClientCert:
 				c.sendAlert(alertBadCertificate)
-				return os.NewError("could not validate certificate signature: " + err.String())
+				return os.NewError("tls: client didn't provide a certificate")
 			}
 		}
 
-		if len(certs) > 0 {
-			key, ok := certs[0].PublicKey.(*rsa.PublicKey)
-			if !ok {
-				return c.sendAlert(alertUnsupportedCertificate)
-			}
-			pub = key
-			c.peerCertificates = certs
+		pub, err = hs.processCertsFromClient(certMsg.certificates)
+		if err != nil {
+			return err
 		}
 	}
 
 	// Get client key exchange
-	msg, err = c.readHandshake()
+	msg, err := c.readHandshake()
 	if err != nil {
 		return err
 	}
@@ -205,11 +392,11 @@ FindCipherSuite:
 	if !ok {
 		return c.sendAlert(alertUnexpectedMessage)
 	}
-	finishedHash.Write(ckx.marshal())
+	hs.finishedHash.Write(ckx.marshal())
 
 	// If we received a client cert in response to our certificate request message,
 	// the client will send us a certificateVerifyMsg immediately after the
-	// clientKeyExchangeMsg.  This message is a MD5SHA1 digest of all preceding
+	// clientKeyExchangeMsg.  This message is a digest of all preceding
 	// handshake-layer messages that is signed using the private key corresponding
 	// to the client's certificate. This allows us to verify that the client is in
 	// possession of the private key of the certificate.
@@ -223,16 +410,20 @@ FindCipherSuite:
 			return c.sendAlert(alertUnexpectedMessage)
 		}
 
-		digest := make([]byte, 36)
-		copy(digest[0:16], finishedHash.serverMD5.Sum())
-		copy(digest[16:36], finishedHash.serverSHA1.Sum())
-		err = rsa.VerifyPKCS1v15(pub, crypto.MD5SHA1, digest, certVerify.signature)
+		if certVerify.hasSignatureAndHash &&
+			(certVerify.signatureAndHash.hash != hashSHA256 || certVerify.signatureAndHash.signature != signatureRSA) {
+			c.sendAlert(alertBadCertificate)
+			return os.NewError("client signed with an algorithm we did not ask for")
+		}
+
+		digest, hashFunc := hs.finishedHash.hashForClientCertificate()
+		err = rsa.VerifyPKCS1v15(pub, hashFunc, digest, certVerify.signature)
 		if err != nil {
 			c.sendAlert(alertBadCertificate)
 			return os.NewError("could not validate signature of connection nonces: " + err.String())
 		}
 
-		finishedHash.Write(certVerify.marshal())
+		hs.finishedHash.Write(certVerify.marshal())
 	}
 
 	preMasterSecret, err := keyAgreement.processClientKeyExchange(config, ckx)
@@ -240,20 +431,101 @@ FindCipherSuite:
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
+	hs.masterSecret = masterFromPreMasterSecret(c.vers, preMasterSecret, hs.clientHello.random, hs.hello.random)
+
+	return nil
+}
 
-	masterSecret, clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV :=
-		keysFromPreMasterSecret10(preMasterSecret, clientHello.random, hello.random, suite.macLen, suite.keyLen, suite.ivLen)
+// processCertsFromClient takes a chain of client certificates, either from a
+// Certificate message or from a session being resumed, and checks it against
+// the ClientAuth policy of the server.  It returns the public key of the
+// client, if it sent a certificate.
+func (hs *serverHandshakeState) processCertsFromClient(certificates [][]byte) (*rsa.PublicKey, os.Error) {
+	c := hs.c
+	config := c.config
+
+	if len(certificates) == 0 {
+		return nil, nil
+	}
+
+	certs := make([]*x509.Certificate, len(certificates))
+	for i, asn1Data := range certificates {
+		cert, err := x509.ParseCertificate(asn1Data)
+		if err != nil {
+			c.sendAlert(alertBadCertificate)
+			return nil, os.NewError("could not parse client's certificate: " + err.String())
+		}
+		certs[i] = cert
+	}
+
+	if config.clientAuth() >= VerifyClientCertIfGiven {
+		opts := x509.VerifyOptions{
+			Roots:         config.ClientCAs,
+			CurrentTime:   config.time(),
+			Intermediates: x509.NewCertPool(),
+		}
+		if opts.Roots == nil {
+			// With no roots, nothing verifies.
+			opts.Roots = x509.NewCertPool()
+		}
+
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		chains, err := certs[0].Verify(opts)
+		if err != nil {
+			c.sendAlert(alertBadCertificate)
+			return nil, os.NewError("tls: failed to verify client's certificate: " + err.String())
+		}
+		c.verifiedChains = chains
+	} else {
+		// TODO(agl): do better validation of certs: max path length, name restrictions etc.
+		for i := 1; i < len(certs); i++ {
+			if err := certs[i-1].CheckSignatureFrom(certs[i]); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return nil, os.NewError("could not validate certificate signature: " + err.String())
+			}
+		}
+	}
+
+	pub, ok := certs[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, c.sendAlert(alertUnsupportedCertificate)
+	}
+	c.peerCertificates = certs
+	hs.certsFromClient = certificates
+
+	return pub, nil
+}
+
+func (hs *serverHandshakeState) establishKeys() os.Error {
+	c := hs.c
+
+	clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV :=
+		keysFromMasterSecret(c.vers, hs.masterSecret, hs.clientHello.random, hs.hello.random, hs.suite.macLen, hs.suite.keyLen, hs.suite.ivLen)
+
+	clientCipher := hs.suite.cipher(clientKey, clientIV, true /* for reading */ )
+	clientHash := hs.suite.newMAC(clientMAC)
+	c.in.prepareCipherSpec(c.vers, clientCipher, clientHash)
+
+	serverCipher := hs.suite.cipher(serverKey, serverIV, false /* not for reading */ )
+	serverHash := hs.suite.newMAC(serverMAC)
+	c.out.prepareCipherSpec(c.vers, serverCipher, serverHash)
+
+	return nil
+}
+
+func (hs *serverHandshakeState) readFinished() os.Error {
+	c := hs.c
 
-	clientCipher := suite.cipher(clientKey, clientIV, true /* for reading */ )
-	clientHash := suite.mac(clientMAC)
-	c.in.prepareCipherSpec(clientCipher, clientHash)
 	c.readRecord(recordTypeChangeCipherSpec)
 	if err := c.error(); err != nil {
 		return err
 	}
 
-	if hello.nextProtoNeg {
-		msg, err = c.readHandshake()
+	if hs.hello.nextProtoNeg {
+		msg, err := c.readHandshake()
 		if err != nil {
 			return err
 		}
@@ -261,11 +533,11 @@ FindCipherSuite:
 		if !ok {
 			return c.sendAlert(alertUnexpectedMessage)
 		}
-		finishedHash.Write(nextProto.marshal())
+		hs.finishedHash.Write(nextProto.marshal())
 		c.clientProtocol = nextProto.proto
 	}
 
-	msg, err = c.readHandshake()
+	msg, err := c.readHandshake()
 	if err != nil {
 		return err
 	}
@@ -274,25 +546,59 @@ FindCipherSuite:
 		return c.sendAlert(alertUnexpectedMessage)
 	}
 
-	verify := finishedHash.clientSum(masterSecret)
+	verify := hs.finishedHash.clientSum(hs.masterSecret)
 	if len(verify) != len(clientFinished.verifyData) ||
 		subtle.ConstantTimeCompare(verify, clientFinished.verifyData) != 1 {
 		return c.sendAlert(alertHandshakeFailure)
 	}
 
-	finishedHash.Write(clientFinished.marshal())
+	hs.finishedHash.Write(clientFinished.marshal())
+	return nil
+}
+
+// sendSessionTicket sends the client a ticket for the session, if it asked
+// for one.
+func (hs *serverHandshakeState) sendSessionTicket() os.Error {
+	if !hs.hello.ticketSupported {
+		return nil
+	}
+
+	c := hs.c
+	m := new(newSessionTicketMsg)
+
+	var err os.Error
+	m.ticket, err = c.encryptTicket(hs.newSessionState())
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+
+	hs.finishedHash.Write(m.marshal())
+	c.writeRecord(recordTypeHandshake, m.marshal())
+
+	return nil
+}
+
+func (hs *serverHandshakeState) sendFinished() os.Error {
+	c := hs.c
 
-	serverCipher := suite.cipher(serverKey, serverIV, false /* not for reading */ )
-	serverHash := suite.mac(serverMAC)
-	c.out.prepareCipherSpec(serverCipher, serverHash)
 	c.writeRecord(recordTypeChangeCipherSpec, []byte{1})
 
 	finished := new(finishedMsg)
-	finished.verifyData = finishedHash.serverSum(masterSecret)
+	finished.verifyData = hs.finishedHash.serverSum(hs.masterSecret)
+	hs.finishedHash.Write(finished.marshal())
 	c.writeRecord(recordTypeHandshake, finished.marshal())
 
-	c.handshakeComplete = true
-	c.cipherSuite = suiteId
-
 	return nil
 }
+
+// newSessionState returns the state that resuming the session will need.
+func (hs *serverHandshakeState) newSessionState() *sessionState {
+	return &sessionState{
+		vers:         hs.c.vers,
+		cipherSuite:  hs.suiteId,
+		createdAt:    uint64(hs.c.config.time()),
+		masterSecret: hs.masterSecret,
+		certificates: hs.certsFromClient,
+	}
+}
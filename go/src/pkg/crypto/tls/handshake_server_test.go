@@ -7,13 +7,17 @@ package tls
 import (
 	"big"
 	"bytes"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"flag"
 	"io"
 	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 type zeroSource struct{}
@@ -36,9 +40,15 @@ func init() {
 	testConfig.Certificates[0].Certificate = [][]byte{testCertificate}
 	testConfig.Certificates[0].PrivateKey = testPrivateKey
 	testConfig.CipherSuites = []uint16{TLS_RSA_WITH_RC4_128_SHA}
+	// The scripts of the handshake tests were recorded with TLS 1.0.
+	testConfig.MaxVersion = VersionTLS10
 }
 
 func testClientHelloFailure(t *testing.T, m handshakeMessage, expected os.Error) {
+	testClientHelloFailureWithConfig(t, testConfig, m, expected)
+}
+
+func testClientHelloFailureWithConfig(t *testing.T, config *Config, m handshakeMessage, expected os.Error) {
 	// Create in-memory network connection,
 	// send message to server.  Should return
 	// expected error.
@@ -51,7 +61,7 @@ func testClientHelloFailure(t *testing.T, m handshakeMessage, expected os.Error)
 		cli.writeRecord(recordTypeHandshake, m.marshal())
 		c.Close()
 	}()
-	err := Server(s, testConfig).Handshake()
+	err := Server(s, config).Handshake()
 	s.Close()
 	if e, ok := err.(*net.OpError); !ok || e.Error != expected {
 		t.Errorf("Got error: %s; expected: %s", err, expected)
@@ -71,13 +81,13 @@ func TestRejectBadProtocolVersion(t *testing.T) {
 }
 
 func TestNoSuiteOverlap(t *testing.T) {
-	clientHello := &clientHelloMsg{nil, 0x0301, nil, nil, []uint16{0xff00}, []uint8{0}, false, "", false, nil, nil}
+	clientHello := &clientHelloMsg{nil, 0x0301, nil, nil, []uint16{0xff00}, []uint8{0}, false, "", false, nil, nil, nil, false, nil}
 	testClientHelloFailure(t, clientHello, alertHandshakeFailure)
 
 }
 
 func TestNoCompressionOverlap(t *testing.T) {
-	clientHello := &clientHelloMsg{nil, 0x0301, nil, nil, []uint16{TLS_RSA_WITH_RC4_128_SHA}, []uint8{0xff}, false, "", false, nil, nil}
+	clientHello := &clientHelloMsg{nil, 0x0301, nil, nil, []uint16{TLS_RSA_WITH_RC4_128_SHA}, []uint8{0xff}, false, "", false, nil, nil, nil, false, nil}
 	testClientHelloFailure(t, clientHello, alertHandshakeFailure)
 }
 
@@ -142,6 +152,359 @@ func TestHandshakeServerAES(t *testing.T) {
 	testServerScript(t, "AES", aesServerScript, aesConfig)
 }
 
+func TestRejectVersionBelowMinimum(t *testing.T) {
+	config := new(Config)
+	*config = *testConfig
+	config.MinVersion = VersionTLS11
+	config.MaxVersion = 0
+	clientHello := &clientHelloMsg{nil, VersionTLS10, nil, nil, []uint16{TLS_RSA_WITH_RC4_128_SHA}, []uint8{0}, false, "", false, nil, nil, nil, false, nil}
+	testClientHelloFailureWithConfig(t, config, clientHello, alertProtocolVersion)
+}
+
+var versionTests = []struct {
+	vers       uint16
+	suite      uint16
+	clientAuth ClientAuthType
+}{
+	{VersionTLS10, TLS_RSA_WITH_AES_128_CBC_SHA, NoClientCert},
+	{VersionTLS11, TLS_RSA_WITH_AES_128_CBC_SHA, NoClientCert},
+	{VersionTLS11, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, RequireAnyClientCert},
+	{VersionTLS12, TLS_RSA_WITH_RC4_128_SHA, NoClientCert},
+	{VersionTLS12, TLS_RSA_WITH_AES_128_CBC_SHA256, NoClientCert},
+	{VersionTLS12, TLS_RSA_WITH_AES_256_CBC_SHA256, RequireAnyClientCert},
+	{VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, RequireAnyClientCert},
+	{VersionTLS12, TLS_ECDHE_RSA_WITH_RC4_128_SHA, NoClientCert},
+	{VersionTLS12, TLS_RSA_WITH_AES_128_GCM_SHA256, NoClientCert},
+	{VersionTLS12, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, RequireAnyClientCert},
+}
+
+// TestHandshakeVersions runs our client against our server at each version,
+// and checks that they agree on the version and that data gets through.
+func TestHandshakeVersions(t *testing.T) {
+	const message = "hello, world\n"
+	for i, test := range versionTests {
+		config := new(Config)
+		*config = *testConfig
+		config.Rand = nil // ECDHE needs real randomness
+		config.CipherSuites = []uint16{test.suite}
+		config.MaxVersion = test.vers
+		config.ClientAuth = test.clientAuth
+
+		c, s := net.Pipe()
+		cli := Client(c, config)
+		go func() {
+			cli.Write([]byte(message))
+			cli.Close()
+			c.Close()
+		}()
+
+		srv := Server(s, config)
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, srv)
+		s.Close()
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if buf.String() != message {
+			t.Errorf("#%d: got %q, want %q", i, buf.String(), message)
+		}
+		state := srv.ConnectionState()
+		if state.Version != test.vers || state.CipherSuite != test.suite {
+			t.Errorf("#%d: got version %x, suite %x; want %x, %x", i, state.Version, state.CipherSuite, test.vers, test.suite)
+		}
+		if test.clientAuth != NoClientCert && len(state.PeerCertificates) != 1 {
+			t.Errorf("#%d: got %d client certificates, want 1", i, len(state.PeerCertificates))
+		}
+		if v := cli.ConnectionState().Version; v != test.vers {
+			t.Errorf("#%d: client got version %x, want %x", i, v, test.vers)
+		}
+	}
+}
+
+// A TLS 1.2 cipher suite must not be chosen for an older version.
+func TestNoTLS12SuiteBefore12(t *testing.T) {
+	clientHello := &clientHelloMsg{nil, VersionTLS11, nil, nil, []uint16{TLS_RSA_WITH_AES_128_CBC_SHA256}, []uint8{0}, false, "", false, nil, nil, nil, false, nil}
+	config := new(Config)
+	*config = *testConfig
+	config.CipherSuites = []uint16{TLS_RSA_WITH_AES_128_CBC_SHA256}
+	config.MaxVersion = 0
+	testClientHelloFailureWithConfig(t, config, clientHello, alertHandshakeFailure)
+}
+
+// testHandshake runs a handshake between a client and a server and returns
+// the resulting states of both sides.  They talk over loopback TCP, rather
+// than a pipe, so that either side can fail with an alert while the other is
+// still writing.  Each handshake uses a new port, so a client that is to
+// resume sessions must set ServerName, under which it caches them.
+func testHandshake(clientConfig, serverConfig *Config) (clientState, serverState ConnectionState, err os.Error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer l.Close()
+
+	done := make(chan os.Error, 1)
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		cli := Client(c, clientConfig)
+		err = cli.Handshake()
+		if err == nil {
+			clientState = cli.ConnectionState()
+		}
+		done <- err
+	}()
+
+	s, err := l.Accept()
+	if err != nil {
+		return
+	}
+	srv := Server(s, serverConfig)
+	err = srv.Handshake()
+	if err == nil {
+		serverState = srv.ConnectionState()
+	}
+	// Closing the connection unblocks the client if we failed.
+	s.Close()
+	if clientErr := <-done; err == nil {
+		err = clientErr
+	}
+	return
+}
+
+func TestResumption(t *testing.T) {
+	now := time.Seconds()
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		CipherSuites: []uint16{TLS_RSA_WITH_RC4_128_SHA, TLS_RSA_WITH_AES_128_CBC_SHA},
+		Time:         func() int64 { return now },
+	}
+	clientConfig := &Config{
+		ServerName:         "example.golang",
+		CipherSuites:       serverConfig.CipherSuites,
+		ClientSessionCache: NewLRUClientSessionCache(4),
+	}
+
+	step := 0
+	testResume := func(want bool) {
+		step++
+		clientState, serverState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("#%d: handshake failed: %s", step, err)
+		}
+		if clientState.DidResume != want || serverState.DidResume != want {
+			t.Errorf("#%d: client resumed: %v, server resumed: %v; want %v", step, clientState.DidResume, serverState.DidResume, want)
+		}
+		if clientState.CipherSuite != serverState.CipherSuite {
+			t.Errorf("#%d: client suite %x, server suite %x", step, clientState.CipherSuite, serverState.CipherSuite)
+		}
+	}
+
+	// Session tickets.
+	testResume(false)
+	testResume(true)
+
+	// A ticket encrypted with a key that the server has dropped is useless.
+	var key1, key2 [32]byte
+	key1[0] = 1
+	key2[0] = 2
+	serverConfig.SetSessionTicketKeys([][32]byte{key1})
+	testResume(false)
+	// Tickets encrypted with an older key are still accepted...
+	serverConfig.SetSessionTicketKeys([][32]byte{key2, key1})
+	testResume(true)
+	// ... until it is removed.
+	serverConfig.SetSessionTicketKeys([][32]byte{key2})
+	testResume(false)
+	testResume(true)
+
+	// Sessions expire, whatever their tickets.
+	now += maxSessionLifetime + 1
+	testResume(false)
+	testResume(true)
+
+	// Session IDs.
+	serverConfig.SessionTicketsDisabled = true
+	serverConfig.ServerSessionCache = NewLRUServerSessionCache(4)
+	clientConfig.SessionTicketsDisabled = true
+	testResume(false)
+	testResume(true)
+	now += maxSessionLifetime + 1
+	testResume(false)
+
+	// Sessions are cached under the name of the server.
+	clientConfig.ServerName = "example.com"
+	testResume(false)
+	testResume(true)
+}
+
+// clientAuthTime is a time at which the certificates made by
+// newClientAuthCert are valid.
+const clientAuthTime = 1300000000
+
+// newClientAuthCert makes a certificate for pub with the given subject, signed
+// by parent with priv.  If parent is nil, the certificate is self-signed.
+func newClientAuthCert(t *testing.T, serial int64, subject string, isCA bool, parent *x509.Certificate, pub *rsa.PublicKey, priv *rsa.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    time.SecondsToUTC(clientAuthTime - 3600),
+		NotAfter:     time.SecondsToUTC(clientAuthTime + 3600),
+
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	return cert
+}
+
+func TestClientAuth(t *testing.T) {
+	impostorKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	ca := newClientAuthCert(t, 1, "Test CA", true, nil, &testPrivateKey.PublicKey, testPrivateKey)
+	client := newClientAuthCert(t, 2, "client", false, ca, &testPrivateKey.PublicKey, testPrivateKey)
+	// The impostor CA has the name of the real one, so clients will send
+	// the certificates that it issues, but not its key.
+	impostorCA := newClientAuthCert(t, 3, "Test CA", true, nil, &impostorKey.PublicKey, impostorKey)
+	impostor := newClientAuthCert(t, 4, "impostor", false, impostorCA, &testPrivateKey.PublicKey, impostorKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	serverConfig := &Config{
+		Time:         func() int64 { return clientAuthTime },
+		Certificates: testConfig.Certificates,
+		CipherSuites: []uint16{TLS_RSA_WITH_RC4_128_SHA},
+		ClientCAs:    clientCAs,
+	}
+	clientConfigWithCert := func(cert *x509.Certificate) *Config {
+		config := &Config{
+			ServerName:   "example.golang",
+			CipherSuites: serverConfig.CipherSuites,
+		}
+		if cert != nil {
+			config.Certificates = []Certificate{{
+				Certificate: [][]byte{cert.Raw},
+				PrivateKey:  testPrivateKey,
+			}}
+		}
+		return config
+	}
+
+	tests := []struct {
+		clientAuth ClientAuthType
+		clientCert *x509.Certificate
+		ok         bool
+		verified   bool
+	}{
+		{NoClientCert, client, true, false},
+		{RequestClientCert, nil, true, false},
+		{RequestClientCert, impostor, true, false},
+		{RequireAnyClientCert, nil, false, false},
+		{RequireAnyClientCert, impostor, true, false},
+		{VerifyClientCertIfGiven, nil, true, false},
+		{VerifyClientCertIfGiven, client, true, true},
+		{VerifyClientCertIfGiven, impostor, false, false},
+		{RequireAndVerifyClientCert, nil, false, false},
+		{RequireAndVerifyClientCert, client, true, true},
+		{RequireAndVerifyClientCert, impostor, false, false},
+	}
+
+	for i, test := range tests {
+		serverConfig.ClientAuth = test.clientAuth
+		_, state, err := testHandshake(clientConfigWithCert(test.clientCert), serverConfig)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("#%d: handshake succeeded, want failure", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: handshake failed: %s", i, err)
+			continue
+		}
+
+		wantCerts := 0
+		if test.clientCert != nil && test.clientAuth != NoClientCert {
+			wantCerts = 1
+		}
+		if len(state.PeerCertificates) != wantCerts {
+			t.Errorf("#%d: got %d client certificates, want %d", i, len(state.PeerCertificates), wantCerts)
+		}
+		if verified := len(state.VerifiedChains) > 0; verified != test.verified {
+			t.Errorf("#%d: verified: %v, want %v", i, verified, test.verified)
+		} else if verified {
+			chain := state.VerifiedChains[0]
+			if len(chain) != 2 || !chain[0].Equal(client) || !chain[1].Equal(ca) {
+				t.Errorf("#%d: wrong verified chain", i)
+			}
+		}
+	}
+
+	// The deprecated AuthenticateClient requests a certificate but
+	// neither requires nor verifies it.
+	serverConfig.ClientAuth = NoClientCert
+	serverConfig.AuthenticateClient = true
+	for i, cert := range []*x509.Certificate{nil, impostor} {
+		_, state, err := testHandshake(clientConfigWithCert(cert), serverConfig)
+		if err != nil {
+			t.Errorf("AuthenticateClient #%d: handshake failed: %s", i, err)
+		} else if len(state.PeerCertificates) != i || len(state.VerifiedChains) != 0 {
+			t.Errorf("AuthenticateClient #%d: got %d client certificates, %d verified chains; want %d, 0", i, len(state.PeerCertificates), len(state.VerifiedChains), i)
+		}
+	}
+	serverConfig.AuthenticateClient = false
+
+	// A session without a client certificate is not resumed once one is
+	// required.
+	serverConfig.ClientAuth = VerifyClientCertIfGiven
+	clientConfig := clientConfigWithCert(nil)
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	serverConfig.ClientAuth = RequireAndVerifyClientCert
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Errorf("resumed a session without a client certificate")
+	}
+
+	// The certificate of a resumed session is verified again.
+	clientConfig = clientConfigWithCert(client)
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	_, state, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if !state.DidResume || len(state.PeerCertificates) != 1 || len(state.VerifiedChains) != 1 {
+		t.Errorf("resumed: %v, with %d certificates and %d chains; want true, 1, 1", state.DidResume, len(state.PeerCertificates), len(state.VerifiedChains))
+	}
+	serverConfig.ClientCAs = x509.NewCertPool()
+	serverConfig.ClientCAs.AddCert(impostorCA)
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Errorf("resumed a session whose client certificate no longer verifies")
+	}
+}
+
 var serve = flag.Bool("serve", false, "run a TLS server on :10443")
 
 func TestRunServer(t *testing.T) {
@@ -11,6 +11,7 @@ import (
 	"crypto/md5"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"io"
 	"os"
@@ -96,6 +97,62 @@ func md5SHA1Hash(slices ...[]byte) []byte {
 	return md5sha1
 }
 
+// sha1Hash calculates a SHA1 hash over the given byte slices.
+func sha1Hash(slices ...[]byte) []byte {
+	hsha1 := sha1.New()
+	for _, slice := range slices {
+		hsha1.Write(slice)
+	}
+	return hsha1.Sum()
+}
+
+// sha256Hash calculates a SHA256 hash over the given byte slices.
+func sha256Hash(slices ...[]byte) []byte {
+	hsha256 := sha256.New()
+	for _, slice := range slices {
+		hsha256.Write(slice)
+	}
+	return hsha256.Sum()
+}
+
+// hashForServerKeyExchange hashes the given slices and returns their digest
+// and the identifier of the hash function used.  The hashId argument is only
+// used for TLS 1.2; earlier versions always use MD5+SHA1.
+func hashForServerKeyExchange(hashId uint8, version uint16, slices ...[]byte) ([]byte, crypto.Hash, os.Error) {
+	if version >= VersionTLS12 {
+		switch hashId {
+		case hashSHA256:
+			return sha256Hash(slices...), crypto.SHA256, nil
+		case hashSHA1:
+			return sha1Hash(slices...), crypto.SHA1, nil
+		}
+		return nil, 0, os.NewError("tls: unknown hash function used by peer")
+	}
+	return md5SHA1Hash(slices...), crypto.MD5SHA1, nil
+}
+
+// pickTLS12HashForSignature returns the hash to sign a TLS 1.2
+// ServerKeyExchange with: the first of the client's signature and hash
+// algorithms, in its order of preference, that we support.  A client that
+// sends none is taken to support only SHA1 with RSA (RFC 5246, section
+// 7.4.1.4.1).
+func pickTLS12HashForSignature(clientSignatureAndHashes []signatureAndHash) (uint8, os.Error) {
+	if len(clientSignatureAndHashes) == 0 {
+		return hashSHA1, nil
+	}
+	for _, sah := range clientSignatureAndHashes {
+		if sah.signature != signatureRSA {
+			continue
+		}
+		for _, supported := range supportedSignatureAlgorithms {
+			if sah.hash == supported.hash {
+				return sah.hash, nil
+			}
+		}
+	}
+	return 0, os.NewError("tls: client doesn't support any common hash functions")
+}
+
 // ecdheRSAKeyAgreement implements a TLS key agreement where the server
 // generates a ephemeral EC public/private key pair and signs it. The
 // pre-master secret is then calculated using ECDH.
@@ -142,16 +199,35 @@ Curve:
 	serverECDHParams[3] = byte(len(ecdhePublic))
 	copy(serverECDHParams[4:], ecdhePublic)
 
-	md5sha1 := md5SHA1Hash(clientHello.random, hello.random, serverECDHParams)
-	sig, err := rsa.SignPKCS1v15(config.rand(), config.Certificates[0].PrivateKey, crypto.MD5SHA1, md5sha1)
+	var tls12HashId uint8
+	if hello.vers >= VersionTLS12 {
+		if tls12HashId, err = pickTLS12HashForSignature(clientHello.signatureAndHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	digest, hashFunc, err := hashForServerKeyExchange(tls12HashId, hello.vers, clientHello.random, hello.random, serverECDHParams)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := rsa.SignPKCS1v15(config.rand(), config.Certificates[0].PrivateKey, hashFunc, digest)
 	if err != nil {
 		return nil, os.NewError("failed to sign ECDHE parameters: " + err.String())
 	}
 
 	skx := new(serverKeyExchangeMsg)
-	skx.key = make([]byte, len(serverECDHParams)+2+len(sig))
+	sigAndHashLen := 0
+	if hello.vers >= VersionTLS12 {
+		sigAndHashLen = 2
+	}
+	skx.key = make([]byte, len(serverECDHParams)+sigAndHashLen+2+len(sig))
 	copy(skx.key, serverECDHParams)
 	k := skx.key[len(serverECDHParams):]
+	if hello.vers >= VersionTLS12 {
+		k[0] = tls12HashId
+		k[1] = signatureRSA
+		k = k[2:]
+	}
 	k[0] = byte(len(sig) >> 8)
 	k[1] = byte(len(sig))
 	copy(k[2:], sig)
@@ -208,6 +284,19 @@ func (ka *ecdheRSAKeyAgreement) processServerKeyExchange(config *Config, clientH
 	serverECDHParams := skx.key[:4+publicLen]
 
 	sig := skx.key[4+publicLen:]
+	var tls12HashId uint8
+	if serverHello.vers >= VersionTLS12 {
+		// In TLS 1.2, the signature is preceded by the
+		// algorithms that made it.
+		if len(sig) < 2 {
+			return errServerKeyExchange
+		}
+		if sig[1] != signatureRSA {
+			return os.NewError("server selected unsupported signature algorithm")
+		}
+		tls12HashId = sig[0]
+		sig = sig[2:]
+	}
 	if len(sig) < 2 {
 		return errServerKeyExchange
 	}
@@ -217,8 +306,11 @@ func (ka *ecdheRSAKeyAgreement) processServerKeyExchange(config *Config, clientH
 	}
 	sig = sig[2:]
 
-	md5sha1 := md5SHA1Hash(clientHello.random, serverHello.random, serverECDHParams)
-	return rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.MD5SHA1, md5sha1, sig)
+	digest, hashFunc, err := hashForServerKeyExchange(tls12HashId, serverHello.vers, clientHello.random, serverHello.random, serverECDHParams)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), hashFunc, digest, sig)
 }
 
 func (ka *ecdheRSAKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, os.Error) {
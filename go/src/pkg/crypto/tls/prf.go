@@ -5,9 +5,11 @@
 package tls
 
 import (
+	"crypto"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
 	"hash"
 	"os"
 )
@@ -63,6 +65,24 @@ func pRF10(result, secret, label, seed []byte) {
 	}
 }
 
+// pRF12 implements the TLS 1.2 pseudo-random function, as defined in RFC 5246, section 5.
+func pRF12(result, secret, label, seed []byte) {
+	labelAndSeed := make([]byte, len(label)+len(seed))
+	copy(labelAndSeed, label)
+	copy(labelAndSeed[len(label):], seed)
+
+	pHash(result, secret, labelAndSeed, sha256.New)
+}
+
+// prfForVersion returns the pseudo-random function of the given version
+// of the protocol.  TLS 1.1 uses that of TLS 1.0.
+func prfForVersion(version uint16) func(result, secret, label, seed []byte) {
+	if version >= VersionTLS12 {
+		return pRF12
+	}
+	return pRF10
+}
+
 const (
 	tlsRandomLength      = 32 // Length of a random nonce in TLS 1.1.
 	masterSecretLength   = 48 // Length of a master secret in TLS 1.1.
@@ -74,22 +94,35 @@ var keyExpansionLabel = []byte("key expansion")
 var clientFinishedLabel = []byte("client finished")
 var serverFinishedLabel = []byte("server finished")
 
-// keysFromPreMasterSecret generates the connection keys from the pre master
-// secret, given the lengths of the MAC key, cipher key and IV, as defined in
-// RFC 2246, section 6.3.
-func keysFromPreMasterSecret10(preMasterSecret, clientRandom, serverRandom []byte, macLen, keyLen, ivLen int) (masterSecret, clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV []byte) {
+// masterFromPreMasterSecret generates the master secret from the pre master
+// secret, given the version of the protocol.  See RFC 2246, section 8.1, and
+// RFC 5246, section 8.1.
+func masterFromPreMasterSecret(version uint16, preMasterSecret, clientRandom, serverRandom []byte) []byte {
 	var seed [tlsRandomLength * 2]byte
 	copy(seed[0:len(clientRandom)], clientRandom)
 	copy(seed[len(clientRandom):], serverRandom)
-	masterSecret = make([]byte, masterSecretLength)
-	pRF10(masterSecret, preMasterSecret, masterSecretLabel, seed[0:])
+	masterSecret := make([]byte, masterSecretLength)
+	prfForVersion(version)(masterSecret, preMasterSecret, masterSecretLabel, seed[0:])
+	return masterSecret
+}
 
+// keysFromMasterSecret generates the connection keys from the master secret,
+// given the version of the protocol and the lengths of the MAC key, cipher
+// key and IV, as defined in RFC 2246, section 6.3, and RFC 5246, section 6.3.
+// A resumed session keeps its master secret but generates new keys with the
+// randoms of each connection.
+//
+// From TLS 1.1 on, each record of a CBC cipher carries its own IV, so the
+// IVs generated here only seed the cipher state: the first block decrypted
+// from each record is the explicit IV and is discarded.
+func keysFromMasterSecret(version uint16, masterSecret, clientRandom, serverRandom []byte, macLen, keyLen, ivLen int) (clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV []byte) {
+	var seed [tlsRandomLength * 2]byte
 	copy(seed[0:len(clientRandom)], serverRandom)
 	copy(seed[len(serverRandom):], clientRandom)
 
 	n := 2*macLen + 2*keyLen + 2*ivLen
 	keyMaterial := make([]byte, n)
-	pRF10(keyMaterial, masterSecret, keyExpansionLabel, seed[0:])
+	prfForVersion(version)(keyMaterial, masterSecret, keyExpansionLabel, seed[0:])
 	clientMAC = keyMaterial[:macLen]
 	keyMaterial = keyMaterial[macLen:]
 	serverMAC = keyMaterial[:macLen]
@@ -107,27 +140,38 @@ func keysFromPreMasterSecret10(preMasterSecret, clientRandom, serverRandom []byt
 // A finishedHash calculates the hash of a set of handshake messages suitable
 // for including in a Finished message.
 type finishedHash struct {
-	clientMD5  hash.Hash
-	clientSHA1 hash.Hash
-	serverMD5  hash.Hash
-	serverSHA1 hash.Hash
+	client hash.Hash
+	server hash.Hash
+
+	// Prior to TLS 1.2, an additional MD5 hash is required.
+	clientMD5 hash.Hash
+	serverMD5 hash.Hash
+
+	version uint16
 }
 
-func newFinishedHash() finishedHash {
-	return finishedHash{md5.New(), sha1.New(), md5.New(), sha1.New()}
+func newFinishedHash(version uint16) finishedHash {
+	if version >= VersionTLS12 {
+		return finishedHash{sha256.New(), sha256.New(), nil, nil, version}
+	}
+	return finishedHash{sha1.New(), sha1.New(), md5.New(), md5.New(), version}
 }
 
 func (h finishedHash) Write(msg []byte) (n int, err os.Error) {
-	h.clientMD5.Write(msg)
-	h.clientSHA1.Write(msg)
-	h.serverMD5.Write(msg)
-	h.serverSHA1.Write(msg)
+	h.client.Write(msg)
+	h.server.Write(msg)
+
+	if h.version < VersionTLS12 {
+		h.clientMD5.Write(msg)
+		h.serverMD5.Write(msg)
+	}
 	return len(msg), nil
 }
 
-// finishedSum calculates the contents of the verify_data member of a Finished
-// message given the MD5 and SHA1 hashes of a set of handshake messages.
-func finishedSum(md5, sha1, label, masterSecret []byte) []byte {
+// finishedSum10 calculates the contents of the verify_data member of a TLS
+// 1.0 or 1.1 Finished message given the MD5 and SHA1 hashes of a set of
+// handshake messages.
+func finishedSum10(md5, sha1, label, masterSecret []byte) []byte {
 	seed := make([]byte, len(md5)+len(sha1))
 	copy(seed, md5)
 	copy(seed[len(md5):], sha1)
@@ -136,18 +180,41 @@ func finishedSum(md5, sha1, label, masterSecret []byte) []byte {
 	return out
 }
 
+// finishedSum12 calculates the contents of the verify_data member of a TLS
+// 1.2 Finished message given the SHA256 hash of a set of handshake messages.
+func finishedSum12(seed, label, masterSecret []byte) []byte {
+	out := make([]byte, finishedVerifyLength)
+	pRF12(out, masterSecret, label, seed)
+	return out
+}
+
 // clientSum returns the contents of the verify_data member of a client's
 // Finished message.
 func (h finishedHash) clientSum(masterSecret []byte) []byte {
-	md5 := h.clientMD5.Sum()
-	sha1 := h.clientSHA1.Sum()
-	return finishedSum(md5, sha1, clientFinishedLabel, masterSecret)
+	if h.version >= VersionTLS12 {
+		return finishedSum12(h.client.Sum(), clientFinishedLabel, masterSecret)
+	}
+	return finishedSum10(h.clientMD5.Sum(), h.client.Sum(), clientFinishedLabel, masterSecret)
 }
 
 // serverSum returns the contents of the verify_data member of a server's
 // Finished message.
 func (h finishedHash) serverSum(masterSecret []byte) []byte {
-	md5 := h.serverMD5.Sum()
-	sha1 := h.serverSHA1.Sum()
-	return finishedSum(md5, sha1, serverFinishedLabel, masterSecret)
+	if h.version >= VersionTLS12 {
+		return finishedSum12(h.server.Sum(), serverFinishedLabel, masterSecret)
+	}
+	return finishedSum10(h.serverMD5.Sum(), h.server.Sum(), serverFinishedLabel, masterSecret)
+}
+
+// hashForClientCertificate returns the digest of the handshake messages so
+// far that a client's CertificateVerify message signs, and the hash function
+// that made it.  In TLS 1.2 that is SHA256, the only hash we ask for.
+func (h finishedHash) hashForClientCertificate() ([]byte, crypto.Hash) {
+	if h.version >= VersionTLS12 {
+		return h.server.Sum(), crypto.SHA256
+	}
+	digest := make([]byte, md5.Size+sha1.Size)
+	copy(digest, h.serverMD5.Sum())
+	copy(digest[md5.Size:], h.server.Sum())
+	return digest, crypto.MD5SHA1
 }
@@ -47,7 +47,8 @@ func TestKeysFromPreMasterSecret(t *testing.T) {
 		in, _ := hex.DecodeString(test.preMasterSecret)
 		clientRandom, _ := hex.DecodeString(test.clientRandom)
 		serverRandom, _ := hex.DecodeString(test.serverRandom)
-		master, clientMAC, serverMAC, clientKey, serverKey, _, _ := keysFromPreMasterSecret10(in, clientRandom, serverRandom, test.macLen, test.keyLen, 0)
+		master := masterFromPreMasterSecret(VersionTLS10, in, clientRandom, serverRandom)
+		clientMAC, serverMAC, clientKey, serverKey, _, _ := keysFromMasterSecret(VersionTLS10, master, clientRandom, serverRandom, test.macLen, test.keyLen, 0)
 		masterString := hex.EncodeToString(master)
 		clientMACString := hex.EncodeToString(clientMAC)
 		serverMACString := hex.EncodeToString(serverMAC)
@@ -63,6 +64,23 @@ func TestKeysFromPreMasterSecret(t *testing.T) {
 	}
 }
 
+// This test vector for the TLS 1.2 PRF, with a 16-byte secret and seed
+// and 100 bytes of output, was posted to the IETF TLS working group list.
+var testPRF12Secret = "9bbe436ba940f017b17652849a71db35"
+var testPRF12Seed = "a0ba9f936cda311827a6f796ffd5198c"
+var testPRF12Label = "test label"
+var testPRF12Output = "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff70187347b66"
+
+func TestPRF12(t *testing.T) {
+	secret, _ := hex.DecodeString(testPRF12Secret)
+	seed, _ := hex.DecodeString(testPRF12Seed)
+	out := make([]byte, 100)
+	pRF12(out, secret, []byte(testPRF12Label), seed)
+	if s := hex.EncodeToString(out); s != testPRF12Output {
+		t.Errorf("got %s, want %s", s, testPRF12Output)
+	}
+}
+
 // These test vectors were generated from GnuTLS using `gnutls-cli --insecure -d 9 `
 var testKeysFromTests = []testKeysFromTest{
 	{
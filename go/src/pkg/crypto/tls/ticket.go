@@ -0,0 +1,218 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"io"
+	"os"
+)
+
+// maxSessionLifetime is the number of seconds for which a server resumes a
+// session after it began, however long its ticket key lives.
+const maxSessionLifetime = 7 * 24 * 60 * 60
+
+// sessionState contains the information that a server needs to resume a
+// session.  It is kept in a session ticket or in a ServerSessionCache.
+type sessionState struct {
+	vers         uint16
+	cipherSuite  uint16
+	createdAt    uint64 // when the session began, in seconds since the epoch
+	masterSecret []byte
+	certificates [][]byte // the certificate chain of the client, if any
+}
+
+func (s *sessionState) marshal() []byte {
+	length := 2 + 2 + 8 + 2 + len(s.masterSecret) + 2
+	for _, cert := range s.certificates {
+		length += 4 + len(cert)
+	}
+
+	ret := make([]byte, length)
+	x := ret
+	x[0] = byte(s.vers >> 8)
+	x[1] = byte(s.vers)
+	x[2] = byte(s.cipherSuite >> 8)
+	x[3] = byte(s.cipherSuite)
+	for i := 0; i < 8; i++ {
+		x[4+i] = byte(s.createdAt >> uint(56-8*i))
+	}
+	x[12] = byte(len(s.masterSecret) >> 8)
+	x[13] = byte(len(s.masterSecret))
+	x = x[14:]
+	copy(x, s.masterSecret)
+	x = x[len(s.masterSecret):]
+
+	x[0] = byte(len(s.certificates) >> 8)
+	x[1] = byte(len(s.certificates))
+	x = x[2:]
+
+	for _, cert := range s.certificates {
+		x[0] = byte(len(cert) >> 24)
+		x[1] = byte(len(cert) >> 16)
+		x[2] = byte(len(cert) >> 8)
+		x[3] = byte(len(cert))
+		copy(x[4:], cert)
+		x = x[4+len(cert):]
+	}
+
+	return ret
+}
+
+func (s *sessionState) unmarshal(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+
+	s.vers = uint16(data[0])<<8 | uint16(data[1])
+	s.cipherSuite = uint16(data[2])<<8 | uint16(data[3])
+	s.createdAt = 0
+	for _, b := range data[4:12] {
+		s.createdAt = s.createdAt<<8 | uint64(b)
+	}
+	masterSecretLen := int(data[12])<<8 | int(data[13])
+	data = data[14:]
+	if len(data) < masterSecretLen {
+		return false
+	}
+
+	s.masterSecret = data[:masterSecretLen]
+	data = data[masterSecretLen:]
+
+	if len(data) < 2 {
+		return false
+	}
+
+	numCerts := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+
+	s.certificates = make([][]byte, numCerts)
+	for i := range s.certificates {
+		if len(data) < 4 {
+			return false
+		}
+		certLen := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if certLen < 0 || len(data) < certLen {
+			return false
+		}
+		s.certificates[i] = data[:certLen]
+		data = data[certLen:]
+	}
+
+	if len(data) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// A ticketKey is the key material of one of the session ticket keys of a
+// server.
+type ticketKey struct {
+	// keyName identifies the key that encrypted a ticket, without
+	// revealing the key itself.
+	keyName [16]byte
+	aesKey  [16]byte
+	hmacKey [16]byte
+}
+
+// newTicketKey derives a ticketKey from the bytes of a session ticket key.
+func newTicketKey(b [32]byte) (key ticketKey) {
+	h := sha512.New()
+	h.Write(b[:])
+	hashed := h.Sum()
+	copy(key.keyName[:], hashed[:16])
+	copy(key.aesKey[:], hashed[16:32])
+	copy(key.hmacKey[:], hashed[32:48])
+	return
+}
+
+// ticketKeyNameLen is the length of the key name that starts each ticket.
+const ticketKeyNameLen = 16
+
+// encryptTicket returns a session ticket holding state.  The ticket is laid
+// out as RFC 5077, section 4, recommends: the name of the key, an IV, the
+// state encrypted with AES-128 in CTR mode and an HMAC-SHA256 of all that
+// precedes it.
+func (c *Conn) encryptTicket(state *sessionState) ([]byte, os.Error) {
+	keys := c.config.ticketKeys()
+	if len(keys) == 0 {
+		return nil, os.NewError("tls: no session ticket keys")
+	}
+	key := keys[0]
+
+	serialized := state.marshal()
+	encrypted := make([]byte, ticketKeyNameLen+aes.BlockSize+len(serialized)+sha256.Size)
+	keyName := encrypted[:ticketKeyNameLen]
+	iv := encrypted[ticketKeyNameLen : ticketKeyNameLen+aes.BlockSize]
+	macBytes := encrypted[len(encrypted)-sha256.Size:]
+
+	copy(keyName, key.keyName[:])
+	if _, err := io.ReadFull(c.config.rand(), iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key.aesKey[:])
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(encrypted[ticketKeyNameLen+aes.BlockSize:], serialized)
+
+	mac := hmac.NewSHA256(key.hmacKey[:])
+	mac.Write(encrypted[:len(encrypted)-sha256.Size])
+	copy(macBytes, mac.Sum())
+
+	return encrypted, nil
+}
+
+// decryptTicket returns the session state in a ticket from encryptTicket,
+// if the ticket is authentic and its key is still among ours.
+func (c *Conn) decryptTicket(encrypted []byte) (*sessionState, bool) {
+	if len(encrypted) < ticketKeyNameLen+aes.BlockSize+sha256.Size {
+		return nil, false
+	}
+
+	keyName := encrypted[:ticketKeyNameLen]
+	iv := encrypted[ticketKeyNameLen : ticketKeyNameLen+aes.BlockSize]
+	macBytes := encrypted[len(encrypted)-sha256.Size:]
+
+	var key *ticketKey
+	keys := c.config.ticketKeys()
+	for i := range keys {
+		if bytes.Equal(keyName, keys[i].keyName[:]) {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		// The ticket is from a key that has been rotated out,
+		// or from another server.
+		return nil, false
+	}
+
+	mac := hmac.NewSHA256(key.hmacKey[:])
+	mac.Write(encrypted[:len(encrypted)-sha256.Size])
+	if subtle.ConstantTimeCompare(macBytes, mac.Sum()) != 1 {
+		return nil, false
+	}
+
+	block, err := aes.NewCipher(key.aesKey[:])
+	if err != nil {
+		return nil, false
+	}
+	ciphertext := encrypted[ticketKeyNameLen+aes.BlockSize : len(encrypted)-sha256.Size]
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, ciphertext)
+
+	state := new(sessionState)
+	ok := state.unmarshal(plaintext)
+	return state, ok
+}
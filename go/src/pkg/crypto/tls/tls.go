@@ -2,8 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package tls partially implements the TLS 1.1 protocol, as specified in RFC
-// 4346.
+// Package tls partially implements TLS 1.2, as specified in RFC 5246, and
+// the earlier versions 1.0 and 1.1 (RFCs 2246 and 4346).
 package tls
 
 import (
@@ -0,0 +1,92 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"asn1"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"os"
+)
+
+// pkcs8 reflects an ASN.1, PKCS#8 PrivateKey. See
+// ftp://ftp.rsasecurity.com/pub/pkcs/pkcs-8/pkcs-8v1_2.asn.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	// optional attributes omitted.
+}
+
+// ParsePKCS8PrivateKey parses an unencrypted, PKCS#8 private key. It returns
+// an *rsa.PrivateKey or an *ecdsa.PrivateKey. See
+// http://www.rsa.com/rsalabs/node.asp?id=2130.
+func ParsePKCS8PrivateKey(der []byte) (key interface{}, err os.Error) {
+	var privKey pkcs8
+	rest, err := asn1.Unmarshal(der, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, asn1.SyntaxError{"trailing data"}
+	}
+
+	switch {
+	case privKey.Algo.Algorithm.Equal(oidPublicKeyRsa):
+		key, err = ParsePKCS1PrivateKey(privKey.PrivateKey)
+		if err != nil {
+			return nil, os.NewError("x509: failed to parse RSA private key embedded in PKCS#8: " + err.String())
+		}
+		return key, nil
+	case privKey.Algo.Algorithm.Equal(oidPublicKeyECDSA):
+		namedCurveOID := new(asn1.ObjectIdentifier)
+		if _, err := asn1.Unmarshal(privKey.Algo.Parameters.FullBytes, namedCurveOID); err != nil {
+			namedCurveOID = nil
+		}
+		key, err = parseECPrivateKey(namedCurveOID, privKey.PrivateKey)
+		if err != nil {
+			return nil, os.NewError("x509: failed to parse EC private key embedded in PKCS#8: " + err.String())
+		}
+		return key, nil
+	}
+
+	return nil, os.NewError("x509: PKCS#8 wrapping contained private key with unknown algorithm")
+}
+
+// MarshalPKCS8PrivateKey converts a private key to unencrypted, PKCS#8 DER
+// encoded form. The key must be an *rsa.PrivateKey or an *ecdsa.PrivateKey.
+func MarshalPKCS8PrivateKey(key interface{}) ([]byte, os.Error) {
+	var privKey pkcs8
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		privKey.Algo = pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyRsa,
+			Parameters: asn1.RawValue{Tag: 5},
+		}
+		privKey.PrivateKey = MarshalPKCS1PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		oid, ok := oidFromNamedCurve(key.Curve)
+		if !ok {
+			return nil, os.NewError("x509: unknown elliptic curve")
+		}
+		oidBytes, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		privKey.Algo = pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyECDSA,
+			Parameters: asn1.RawValue{FullBytes: oidBytes},
+		}
+		if privKey.PrivateKey, err = marshalECPrivateKeyWithOID(key, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, os.NewError("x509: only RSA and ECDSA private keys supported")
+	}
+
+	return asn1.Marshal(privKey)
+}
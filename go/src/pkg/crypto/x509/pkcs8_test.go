@@ -0,0 +1,71 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/hex"
+	"testing"
+)
+
+// Generated using:
+//   openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:512 | openssl pkcs8 -topk8 -nocrypt -outform DER
+var pkcs8RSAPrivateKeyHex = "30820154020100300d06092a864886f70d01010105000482013e3082013a020100024100ef66dbc85f9d4d7059b4730b261a8810bd232b9506dd22a9d6930e130aaa3bfa4d2722669197ae449a310940b65b480a515b3d9a486a3ce9c7dd0cfa33e8a5dd020301000102404839c3fd03bbb56d3be311024010a91443bba49e655fb9e17b770a3bfc6fb7800aebdf3005a60797d1e98538c4c993d392cb32caf7777a4514c80879fcdfae91022100ff5b14a460562e5efc248737eeea4f42b788a5a8ecba84483ac9670f45b5ed8b022100f001796162a329053de8939d076c8286fbfb3a7c208fd8fe2a2483f804157737022100c43c7e74840436c6900692538dab4b15fe6d68c1948b11b56057fe77621f195902200d8367bc2dfb95d1afbcaaa30ac9f74776649cd46458f514ca76a7df8d0b505d02203c5caf04575f9fec961064d67a2c1337831e2241b2e04b91604620f35ce579d0"
+
+// Generated using:
+//   openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256 | openssl pkcs8 -topk8 -nocrypt -outform DER
+var pkcs8P256PrivateKeyHex = "308187020100301306072a8648ce3d020106082a8648ce3d030107046d306b0201010420af99f24b6c331a8e9146872fa3f0ab23d89fa5371722f3ccdd29ded6e9feff03a14403420004c4797b63cdb06b8ba280c06ef3d2cf705a4ed2d06ce0541b50101cd8b382098db8cf9ea633710d5c06c903d66f305fb6cf88d27610bb4b104c1b729fa21c2f06"
+
+// Generated using:
+//   openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-521 | openssl pkcs8 -topk8 -nocrypt -outform DER
+var pkcs8P521PrivateKeyHex = "3081ee020100301006072a8648ce3d020106052b810400230481d63081d3020101044201c57f8ab6c0a393987cf5c2dc46aa555de460385932e1710cf2b3fdb65cd842369671618fcebc7ce3e53b231b455eb6ed53b0e2b2d9de9df419f8f6036993ff84b0a18189038186000401018603a115c98fc1797141e4fc63f4daba36937bcfbf31f53d3620aba405d6cf64f055d5302d0f7af085d8cf57481e0d938a4b82c0f55e1feb8594a52b8b20c00000861dae859abce274c74de074f7d51f46ff64e45ee34f387d86f19197d27cfe2045dc17c35795a86ac36a2109e849484a5f2a41aa6ed2976dec128494c046b1fed1"
+
+func TestPKCS8(t *testing.T) {
+	tests := []struct {
+		name   string
+		keyHex string
+		curve  *elliptic.Curve // nil for RSA keys
+	}{
+		{"RSA", pkcs8RSAPrivateKeyHex, nil},
+		{"P-256", pkcs8P256PrivateKeyHex, elliptic.P256()},
+		{"P-521", pkcs8P521PrivateKeyHex, elliptic.P521()},
+	}
+
+	for _, test := range tests {
+		derBytes, _ := hex.DecodeString(test.keyHex)
+		privKey, err := ParsePKCS8PrivateKey(derBytes)
+		if err != nil {
+			t.Errorf("%s: failed to decode PKCS#8: %s", test.name, err)
+			continue
+		}
+		switch key := privKey.(type) {
+		case *rsa.PrivateKey:
+			if test.curve != nil {
+				t.Errorf("%s: decoded an RSA key", test.name)
+				continue
+			}
+		case *ecdsa.PrivateKey:
+			if key.Curve != test.curve {
+				t.Errorf("%s: decoded an EC key on the wrong curve", test.name)
+				continue
+			}
+		default:
+			t.Errorf("%s: decoded key of unknown type %T", test.name, privKey)
+			continue
+		}
+
+		reserialized, err := MarshalPKCS8PrivateKey(privKey)
+		if err != nil {
+			t.Errorf("%s: failed to encode PKCS#8: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(derBytes, reserialized) {
+			t.Errorf("%s: marshaled PKCS#8 didn't match original. got %x, want %x", test.name, reserialized, derBytes)
+		}
+	}
+}
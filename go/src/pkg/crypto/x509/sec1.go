@@ -0,0 +1,106 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"asn1"
+	"big"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"os"
+)
+
+const ecPrivKeyVersion = 1
+
+// ecPrivateKey reflects an ASN.1 Elliptic Curve Private Key Structure.
+// References:
+//   RFC 5915
+//   SEC1 - http://www.secg.org/download/aid-780/sec1-v2.pdf
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// ParseECPrivateKey parses an ASN.1 Elliptic Curve Private Key Structure.
+func ParseECPrivateKey(der []byte) (key *ecdsa.PrivateKey, err os.Error) {
+	return parseECPrivateKey(nil, der)
+}
+
+// MarshalECPrivateKey marshals an EC private key into ASN.1, DER format.
+func MarshalECPrivateKey(key *ecdsa.PrivateKey) ([]byte, os.Error) {
+	oid, ok := oidFromNamedCurve(key.Curve)
+	if !ok {
+		return nil, os.NewError("x509: unknown elliptic curve")
+	}
+
+	return marshalECPrivateKeyWithOID(key, oid)
+}
+
+// marshalECPrivateKeyWithOID marshals an EC private key into ASN.1, DER
+// format. If oid is nil, the named curve is omitted, as it is when the key is
+// wrapped in PKCS#8.
+func marshalECPrivateKeyWithOID(key *ecdsa.PrivateKey, oid asn1.ObjectIdentifier) ([]byte, os.Error) {
+	publicKey := key.Curve.Marshal(key.X, key.Y)
+	return asn1.Marshal(ecPrivateKey{
+		Version:       ecPrivKeyVersion,
+		PrivateKey:    paddedBigBytes(key.D, (key.Curve.N.BitLen()+7)/8),
+		NamedCurveOID: oid,
+		PublicKey:     asn1.BitString{Bytes: publicKey, BitLength: len(publicKey) * 8},
+	})
+}
+
+// parseECPrivateKey parses an ASN.1 Elliptic Curve Private Key Structure.
+// The OID for the named curve may be provided from another source (such as
+// the PKCS#8 wrapping), in which case it is used in preference to any curve
+// named in the structure itself.
+func parseECPrivateKey(namedCurveOID *asn1.ObjectIdentifier, der []byte) (key *ecdsa.PrivateKey, err os.Error) {
+	var privKey ecPrivateKey
+	rest, err := asn1.Unmarshal(der, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, asn1.SyntaxError{"trailing data"}
+	}
+	if privKey.Version != ecPrivKeyVersion {
+		return nil, os.NewError("x509: unknown EC private key version")
+	}
+
+	var curve *elliptic.Curve
+	if namedCurveOID != nil {
+		curve = namedCurveFromOID(*namedCurveOID)
+	} else {
+		curve = namedCurveFromOID(privKey.NamedCurveOID)
+	}
+	if curve == nil {
+		return nil, os.NewError("x509: unknown elliptic curve")
+	}
+
+	k := new(big.Int).SetBytes(privKey.PrivateKey)
+	if k.Sign() <= 0 || k.Cmp(curve.N) >= 0 {
+		return nil, os.NewError("x509: invalid elliptic curve private key value")
+	}
+
+	priv := new(ecdsa.PrivateKey)
+	priv.Curve = curve
+	priv.D = k
+	priv.X, priv.Y = curve.ScalarBaseMult(privKey.PrivateKey)
+
+	return priv, nil
+}
+
+// paddedBigBytes returns the big-endian bytes of n, left-padded with zeros
+// to length bytes.
+func paddedBigBytes(n *big.Int, length int) []byte {
+	b := n.Bytes()
+	if len(b) >= length {
+		return b
+	}
+	padded := make([]byte, length)
+	copy(padded[length-len(b):], b)
+	return padded
+}
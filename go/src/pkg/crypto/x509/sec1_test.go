@@ -0,0 +1,41 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Generated using:
+//   openssl ecparam -genkey -name secp384r1 -noout -outform DER
+var ecKey384Hex = "3081a40201010430198f73c1b6e314145915d419e525baf3aea163447d206e5c1f51fb36f6f941527dc4c3a755876e777642fffd805e556ea00706052b81040022a16403620004eb1462c3a7fe9f5899030e3701a20be0f89aa71b60206ac537816932bec3dd8538c9fa218a0ae510556a57a5906c6fdbfde733fc8c9cd6c06f6ad22beeecac22859c978ef774380253d2d3bd9b72bd831a3404dc233f23f974d46fecf86932ef"
+
+// Generated using:
+//   openssl ecparam -genkey -name secp224r1 -noout -outform DER
+var ecKey224Hex = "3068020101041c72e8232ac6ba8006b54d2f96103e2eea6457ab8d0cebd74af5511e52a00706052b81040021a13c033a00048e0c2b2adcd00109ccaf02d30c442f5c47d12b5f86de9e5a6d7b0330aba7cb967306e52bb488242143c81ea2d7989f76e44e887607662371"
+
+func TestParseECPrivateKey(t *testing.T) {
+	for i, keyHex := range []string{ecKey384Hex, ecKey224Hex} {
+		derBytes, _ := hex.DecodeString(keyHex)
+		key, err := ParseECPrivateKey(derBytes)
+		if err != nil {
+			t.Errorf("#%d: failed to decode EC private key: %s", i, err)
+			continue
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			t.Errorf("#%d: public key is not on the curve", i)
+		}
+		serialized, err := MarshalECPrivateKey(key)
+		if err != nil {
+			t.Errorf("#%d: failed to encode EC private key: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(serialized, derBytes) {
+			t.Errorf("#%d: marshaled EC private key didn't match original. got %x, want %x", i, serialized, derBytes)
+		}
+	}
+}
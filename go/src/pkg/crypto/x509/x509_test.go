@@ -7,7 +7,10 @@ package x509
 import (
 	"asn1"
 	"big"
+	"bytes"
 	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509/pkix"
@@ -248,6 +251,16 @@ func TestCreateSelfSignedCertificate(t *testing.T) {
 		t.Errorf("Failed to parse name constraints: %#v", cert.PermittedDNSDomains)
 	}
 
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		t.Errorf("Subject and issuer of a self-signed certificate differ: %x %x", cert.RawSubject, cert.RawIssuer)
+	}
+
+	pool := NewCertPool()
+	pool.AddCert(cert)
+	if subjects := pool.Subjects(); len(subjects) != 1 || !bytes.Equal(subjects[0], cert.RawSubject) {
+		t.Errorf("Wrong subjects in pool: %x", subjects)
+	}
+
 	err = cert.CheckSignatureFrom(cert)
 	if err != nil {
 		t.Errorf("Signature verification failed: %s", err)
@@ -255,6 +268,156 @@ func TestCreateSelfSignedCertificate(t *testing.T) {
 	}
 }
 
+func TestCreateSelfSignedECDSACertificate(t *testing.T) {
+	tests := []struct {
+		curve   *elliptic.Curve
+		sigAlgo SignatureAlgorithm
+	}{
+		{elliptic.P224(), ECDSAWithSHA256},
+		{elliptic.P256(), ECDSAWithSHA256},
+		{elliptic.P384(), ECDSAWithSHA384},
+		{elliptic.P521(), ECDSAWithSHA512},
+	}
+
+	for i, test := range tests {
+		priv, err := ecdsa.GenerateKey(test.curve, rand.Reader)
+		if err != nil {
+			t.Errorf("#%d: failed to generate ECDSA key: %s", i, err)
+			continue
+		}
+
+		template := Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject: pkix.Name{
+				CommonName:   "test.example.com",
+				Organization: []string{"Acme Co"},
+			},
+			NotBefore: time.SecondsToUTC(1000),
+			NotAfter:  time.SecondsToUTC(100000),
+
+			KeyUsage:              KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+
+		derBytes, err := CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+		if err != nil {
+			t.Errorf("#%d: failed to create certificate: %s", i, err)
+			continue
+		}
+
+		cert, err := ParseCertificate(derBytes)
+		if err != nil {
+			t.Errorf("#%d: failed to parse certificate: %s", i, err)
+			continue
+		}
+
+		if cert.PublicKeyAlgorithm != ECDSA {
+			t.Errorf("#%d: parsed key algorithm was not ECDSA", i)
+		}
+		if cert.SignatureAlgorithm != test.sigAlgo {
+			t.Errorf("#%d: got signature algorithm %d, want %d", i, cert.SignatureAlgorithm, test.sigAlgo)
+		}
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			t.Errorf("#%d: parsed key was not an ECDSA key", i)
+			continue
+		}
+		if pub.Curve != test.curve || pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			t.Errorf("#%d: parsed key differs from the original", i)
+		}
+
+		if err = cert.CheckSignatureFrom(cert); err != nil {
+			t.Errorf("#%d: signature verification failed: %s", i, err)
+			continue
+		}
+
+		derBytes[len(derBytes)-1] ^= 0x80
+		if cert, err = ParseCertificate(derBytes); err == nil {
+			if err = cert.CheckSignatureFrom(cert); err == nil {
+				t.Errorf("#%d: corrupted signature verified", i)
+			}
+		}
+	}
+}
+
+func TestCheckSignatureKeyMismatch(t *testing.T) {
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	priv, err := ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %s", err)
+	}
+	template := Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test.example.com"},
+		NotBefore:    time.SecondsToUTC(1000),
+		NotAfter:     time.SecondsToUTC(100000),
+	}
+	derBytes, err := CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	cert, err := ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	if err := cert.CheckSignature(SHA1WithRSA, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("SHA1WithRSA signature failed to verify: %s", err)
+	}
+	// These hash with SHA-1 too, so only the type of key tells
+	// them apart from the algorithm that made the signature.
+	for _, algo := range []SignatureAlgorithm{DSAWithSHA1, ECDSAWithSHA1} {
+		if err := cert.CheckSignature(algo, cert.RawTBSCertificate, cert.Signature); err == nil {
+			t.Errorf("RSA key verified a signature with algorithm %d", algo)
+		}
+	}
+}
+
+func TestCreateCertificateRawIssuer(t *testing.T) {
+	// The subject of this certificate includes an emailAddress, which
+	// pkix.Name drops, so re-encoding parent.Subject would give a
+	// different issuer.
+	pemBlock, _ := pem.Decode([]byte(dsaCertPem))
+	parent, err := ParseCertificate(pemBlock.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	block, _ := pem.Decode([]byte(pemPrivateKey))
+	priv, err := ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %s", err)
+	}
+
+	template := Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf.example.com"},
+		NotBefore:    time.SecondsToUTC(1000),
+		NotAfter:     time.SecondsToUTC(100000),
+	}
+
+	derBytes, err := CreateCertificate(rand.Reader, &template, parent, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	cert, err := ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+	if !bytes.Equal(cert.RawIssuer, parent.RawSubject) {
+		t.Errorf("Issuer does not match the parent's subject: %x %x", cert.RawIssuer, parent.RawSubject)
+	}
+
+	crlBytes, err := parent.CreateCRL(rand.Reader, priv, nil, template.NotBefore, template.NotAfter)
+	if err != nil {
+		t.Fatalf("error creating CRL: %s", err)
+	}
+	if bytes.Index(crlBytes, parent.RawSubject) < 0 {
+		t.Errorf("CRL issuer does not match the signer's subject")
+	}
+}
+
 // Self-signed certificate using DSA with SHA1
 var dsaCertPem = `-----BEGIN CERTIFICATE-----
 MIIEDTCCA82gAwIBAgIJALHPghaoxeDhMAkGByqGSM44BAMweTELMAkGA1UEBhMC
@@ -377,6 +540,54 @@ func TestCRLCreation(t *testing.T) {
 	}
 }
 
+func TestECDSACRLCreation(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %s", err)
+	}
+
+	template := Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "test.example.com",
+		},
+		NotBefore: time.SecondsToUTC(1000),
+		NotAfter:  time.SecondsToUTC(100000),
+		KeyUsage:  KeyUsageCRLSign,
+	}
+	derBytes, err := CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, err := ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+
+	now := time.SecondsToUTC(1000)
+	expiry := time.SecondsToUTC(10000)
+	revokedCerts := []pkix.RevokedCertificate{
+		{
+			SerialNumber:   big.NewInt(42),
+			RevocationTime: now,
+		},
+	}
+
+	crlBytes, err := cert.CreateCRL(rand.Reader, priv, revokedCerts, now, expiry)
+	if err != nil {
+		t.Fatalf("error creating CRL: %s", err)
+	}
+
+	crl, err := ParseDERCRL(crlBytes)
+	if err != nil {
+		t.Fatalf("error reparsing CRL: %s", err)
+	}
+
+	if err = cert.CheckCRLSignature(crl); err != nil {
+		t.Errorf("CRL signature verification failed: %s", err)
+	}
+}
+
 func fromBase64(in string) []byte {
 	out := make([]byte, base64.StdEncoding.DecodedLen(len(in)))
 	_, err := base64.StdEncoding.Decode(out, []byte(in))
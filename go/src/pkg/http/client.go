@@ -35,6 +35,15 @@ type Client struct {
 	// If CheckRedirect is nil, the Client uses its default policy,
 	// which is to stop after 10 consecutive requests.
 	CheckRedirect func(req *Request, via []*Request) os.Error
+
+	// Jar specifies the cookie jar.  If Jar is non-nil, the client
+	// adds the jar's cookies to every outbound request, including
+	// each request made while following redirects, and stores the
+	// cookies of every response in the jar.
+	//
+	// If Jar is nil, cookies are only sent if they are explicitly
+	// set on the Request.
+	Jar CookieJar
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -83,7 +92,30 @@ func (c *Client) Do(req *Request) (resp *Response, err os.Error) {
 	if req.Method == "GET" || req.Method == "HEAD" {
 		return c.doFollowingRedirects(req)
 	}
-	return send(req, c.Transport)
+	return c.send(req)
+}
+
+// send issues an HTTP request, consulting c.Jar for the cookies to
+// send and storing any cookies set by the response.
+func (c *Client) send(req *Request) (resp *Response, err os.Error) {
+	if c.Jar != nil {
+		if req.Header == nil {
+			req.Header = make(Header)
+		}
+		for _, cookie := range c.Jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+	resp, err = send(req, c.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if c.Jar != nil {
+		if rc := resp.Cookies(); len(rc) > 0 {
+			c.Jar.SetCookies(req.URL, rc)
+		}
+	}
+	return resp, nil
 }
 
 // send issues an HTTP request.  Caller should close resp.Body when done reading from it.
@@ -157,8 +189,6 @@ func (c *Client) Get(url string) (r *Response, err os.Error) {
 }
 
 func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err os.Error) {
-	// TODO: if/when we add cookie support, the redirected request shouldn't
-	// necessarily supply the same cookies as the original.
 	var base *url.URL
 	redirectChecker := c.CheckRedirect
 	if redirectChecker == nil {
@@ -192,7 +222,7 @@ func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err os.Error)
 		}
 
 		urlStr = req.URL.String()
-		if r, err = send(req, c.Transport); err != nil {
+		if r, err = c.send(req); err != nil {
 			break
 		}
 		if shouldRedirect(r.StatusCode) {
@@ -238,7 +268,7 @@ func (c *Client) Post(url string, bodyType string, body io.Reader) (r *Response,
 		return nil, err
 	}
 	req.Header.Set("Content-Type", bodyType)
-	return send(req, c.Transport)
+	return c.send(req)
 }
 
 // PostForm issues a POST to the specified URL, 
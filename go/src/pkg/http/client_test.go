@@ -208,6 +208,46 @@ func TestRedirects(t *testing.T) {
 	}
 }
 
+func TestClientJarRedirects(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/login":
+			SetCookie(w, &Cookie{Name: "session", Value: "s3cr3t"})
+			Redirect(w, r, "/home", StatusFound)
+		case "/home":
+			c, err := r.Cookie("session")
+			if err != nil {
+				Error(w, "no session", StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, "session=%s", c.Value)
+		}
+	}))
+	defer ts.Close()
+
+	c := &Client{Jar: new(MemoryJar)}
+	for i := 0; i < 2; i++ {
+		path := "/login"
+		if i > 0 {
+			// The cookie set during the first redirect chain
+			// must be sent on later requests too.
+			path = "/home"
+		}
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("Get %s: %v", path, err)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, e := string(b), "session=s3cr3t"; g != e {
+			t.Errorf("Get %s: got body %q, want %q", path, g, e)
+		}
+	}
+}
+
 func TestStreamingGet(t *testing.T) {
 	say := make(chan string)
 	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
@@ -0,0 +1,341 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP response compression handler
+
+package http
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressMinSize is the response size below which a
+// CompressingHandler with no MinSize sends responses uncompressed.
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes lists the media types a CompressingHandler
+// with no Types compresses. An entry ending in "/" matches every
+// subtype of its type.
+var DefaultCompressTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/x-javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// CompressHandler returns a Handler that runs h and compresses its
+// responses as a CompressingHandler with default settings does.
+func CompressHandler(h Handler) Handler {
+	return &CompressingHandler{Handler: h}
+}
+
+// A CompressingHandler runs Handler, compressing its responses with
+// gzip or deflate, whichever the client's Accept-Encoding prefers.
+//
+// A response is compressed only if it has an allowed media type, is
+// at least MinSize bytes long, carries a body and has no
+// Content-Encoding of its own. Compressed responses lose their
+// Content-Length; every response that could have been compressed
+// gets "Vary: Accept-Encoding". The ResponseWriter passed to Handler
+// implements Flusher and Hijacker if the server's does.
+type CompressingHandler struct {
+	Handler Handler
+
+	// MinSize is the smallest response that is compressed.
+	// If zero, DefaultCompressMinSize is used.
+	MinSize int
+
+	// Types lists the media types that are compressed.
+	// If nil, DefaultCompressTypes is used.
+	Types []string
+
+	// Level is the compression level passed to compress/flate.
+	// If zero, flate.DefaultCompression is used.
+	Level int
+}
+
+func (h *CompressingHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	cw := &compressWriter{
+		h:        h,
+		w:        w,
+		encoding: acceptedEncoding(r.Header.Get("Accept-Encoding")),
+		head:     r.Method == "HEAD",
+	}
+	defer cw.close()
+	h.Handler.ServeHTTP(cw.wrap(), r)
+}
+
+func (h *CompressingHandler) minSize() int {
+	if h.MinSize > 0 {
+		return h.MinSize
+	}
+	return DefaultCompressMinSize
+}
+
+func (h *CompressingHandler) level() int {
+	if h.Level != 0 {
+		return h.Level
+	}
+	return flate.DefaultCompression
+}
+
+// compressible reports whether responses of the given Content-Type
+// may be compressed.
+func (h *CompressingHandler) compressible(ctype string) bool {
+	if i := strings.Index(ctype, ";"); i >= 0 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	types := h.Types
+	if types == nil {
+		types = DefaultCompressTypes
+	}
+	for _, t := range types {
+		if t == ctype || strings.HasSuffix(t, "/") && strings.HasPrefix(ctype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncoding returns the content coding, "gzip" or "deflate",
+// that the Accept-Encoding header value s prefers, or "" if neither
+// is acceptable.
+func acceptedEncoding(s string) string {
+	var q = map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		coding, qval := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			coding = strings.TrimSpace(part[:i])
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.Atof64(param[2:]); err == nil {
+					qval = f
+				}
+			}
+		}
+		q[strings.ToLower(coding)] = qval
+	}
+	best, bestq := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		qval, ok := q[coding]
+		if !ok {
+			qval, ok = q["*"]
+		}
+		if ok && qval > bestq {
+			best, bestq = coding, qval
+		}
+	}
+	return best
+}
+
+// compressWriter is the ResponseWriter a CompressingHandler passes to
+// its Handler. It buffers the start of the body until it knows
+// whether the response is worth compressing.
+type compressWriter struct {
+	h        *CompressingHandler
+	w        ResponseWriter
+	encoding string // negotiated content coding, or ""
+	head     bool   // HEAD request
+
+	code        int
+	wroteHeader bool
+	decided     bool
+	hijacked    bool
+	buf         []byte
+	zw          io.WriteCloser // non-nil if compressing
+}
+
+type flusherWriteCloser interface {
+	io.WriteCloser
+	Flush() os.Error
+}
+
+func (cw *compressWriter) Header() Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+	if !cw.bodyAllowed() {
+		cw.decide(false)
+		return
+	}
+	if cl := cw.Header().Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi64(cl)
+		if err == nil && n < int64(cw.h.minSize()) {
+			cw.decide(false)
+		}
+	}
+}
+
+func (cw *compressWriter) bodyAllowed() bool {
+	switch {
+	case cw.head, cw.code == StatusNoContent, cw.code == StatusNotModified,
+		cw.code == StatusPartialContent, cw.code < 200:
+		return false
+	}
+	return true
+}
+
+func (cw *compressWriter) Write(p []byte) (int, os.Error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(p)
+		}
+		return cw.w.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.h.minSize() {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide settles whether the response is compressed, writes the
+// header and the buffered start of the body. big says whether the
+// body is known to be large enough to be worth compressing.
+func (cw *compressWriter) decide(big bool) os.Error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	hdr := cw.Header()
+	if cw.bodyAllowed() && hdr.Get("Content-Encoding") == "" {
+		if hdr.Get("Content-Type") == "" && len(cw.buf) > 0 {
+			// Sniff now; the server would see compressed data.
+			hdr.Set("Content-Type", DetectContentType(cw.buf))
+		}
+		if cw.h.compressible(hdr.Get("Content-Type")) {
+			hdr.Add("Vary", "Accept-Encoding")
+			if big && cw.encoding != "" {
+				hdr.Del("Content-Length")
+				hdr.Set("Content-Encoding", cw.encoding)
+				cw.w.WriteHeader(cw.code)
+				if err := cw.startCompressor(); err != nil {
+					return err
+				}
+				_, err := cw.zw.Write(cw.buf)
+				cw.buf = nil
+				return err
+			}
+		}
+	}
+	cw.w.WriteHeader(cw.code)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.w.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) startCompressor() os.Error {
+	if cw.encoding == "deflate" {
+		cw.zw = flate.NewWriter(cw.w, cw.h.level())
+		return nil
+	}
+	zw, err := gzip.NewWriterLevel(cw.w, cw.h.level())
+	if err != nil {
+		return err
+	}
+	cw.zw = zw
+	return nil
+}
+
+// wrap returns cw as the ResponseWriter to pass to the Handler,
+// implementing Flusher and Hijacker only if the server's
+// ResponseWriter does.
+func (cw *compressWriter) wrap() ResponseWriter {
+	_, flusher := cw.w.(Flusher)
+	_, hijacker := cw.w.(Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackCompressWriter{cw}
+	case flusher:
+		return flushCompressWriter{cw}
+	case hijacker:
+		return hijackCompressWriter{cw}
+	}
+	return cw
+}
+
+type flushCompressWriter struct{ *compressWriter }
+
+func (w flushCompressWriter) Flush() { w.flush() }
+
+type hijackCompressWriter struct{ *compressWriter }
+
+func (w hijackCompressWriter) Hijack() (net.Conn, *bufio.ReadWriter, os.Error) {
+	return w.hijack()
+}
+
+type flushHijackCompressWriter struct{ *compressWriter }
+
+func (w flushHijackCompressWriter) Flush() { w.flush() }
+
+func (w flushHijackCompressWriter) Hijack() (net.Conn, *bufio.ReadWriter, os.Error) {
+	return w.hijack()
+}
+
+// flush sends what has been written so far, compressing it if the
+// response qualifies regardless of MinSize, since its final length
+// is not known. The server's ResponseWriter must be a Flusher.
+func (cw *compressWriter) flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	cw.decide(true)
+	if fz, ok := cw.zw.(flusherWriteCloser); ok {
+		fz.Flush()
+	}
+	cw.w.(Flusher).Flush()
+}
+
+// hijack hijacks the underlying connection if nothing has been
+// written yet. The server's ResponseWriter must be a Hijacker.
+func (cw *compressWriter) hijack() (net.Conn, *bufio.ReadWriter, os.Error) {
+	if cw.wroteHeader {
+		return nil, nil, os.NewError("http: Hijack after WriteHeader in compressing handler")
+	}
+	cw.hijacked = true
+	return cw.w.(Hijacker).Hijack()
+}
+
+// close finishes the response once the Handler has returned.
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	cw.decide(false)
+	if cw.zw != nil {
+		cw.zw.Close()
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	. "http"
+	"http/httptest"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var compressBody = strings.Repeat("compressible text ", 100)
+
+var compressTests = []struct {
+	accept   string
+	ctype    string
+	body     string
+	encoding string
+	vary     bool
+}{
+	{"gzip", "text/plain", compressBody, "gzip", true},
+	{"deflate", "text/plain", compressBody, "deflate", true},
+	{"gzip;q=0.5, deflate", "text/html", compressBody, "deflate", true},
+	{"*", "application/json", compressBody, "gzip", true},
+	{"gzip;q=0, *", "text/plain", compressBody, "deflate", true},
+	{"identity", "text/plain", compressBody, "", true},
+	{"", "text/plain", compressBody, "", true},
+	{"gzip", "text/plain", "short", "", true},
+	{"gzip", "image/png", compressBody, "", false},
+	{"gzip", "", compressBody, "gzip", true}, // sniffed as text/plain
+}
+
+func TestCompressHandler(t *testing.T) {
+	for i, tt := range compressTests {
+		h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+			if tt.ctype != "" {
+				w.Header().Set("Content-Type", tt.ctype)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+			io.WriteString(w, tt.body[:len(tt.body)/2])
+			io.WriteString(w, tt.body[len(tt.body)/2:])
+		}))
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if g := rw.HeaderMap.Get("Content-Encoding"); g != tt.encoding {
+			t.Errorf("#%d: Content-Encoding = %q; want %q", i, g, tt.encoding)
+			continue
+		}
+		if g := rw.HeaderMap.Get("Vary") == "Accept-Encoding"; g != tt.vary {
+			t.Errorf("#%d: Vary: Accept-Encoding set = %v; want %v", i, g, tt.vary)
+		}
+		var r io.Reader = rw.Body
+		switch tt.encoding {
+		case "gzip":
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				t.Errorf("#%d: gzip.NewReader: %v", i, err)
+				continue
+			}
+			r = gz
+		case "deflate":
+			r = flate.NewReader(r)
+		}
+		if tt.encoding != "" {
+			if cl := rw.HeaderMap.Get("Content-Length"); cl != "" {
+				t.Errorf("#%d: compressed response has Content-Length %s", i, cl)
+			}
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("#%d: reading body: %v", i, err)
+			continue
+		}
+		if string(body) != tt.body {
+			t.Errorf("#%d: body = %q; want %q", i, body, tt.body)
+		}
+	}
+}
+
+func TestCompressHandlerFlush(t *testing.T) {
+	flushed := make(chan bool)
+	rw := httptest.NewRecorder()
+	h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first")
+		w.(Flusher).Flush()
+		flushed <- true
+		<-flushed
+		io.WriteString(w, " second")
+	}))
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	done := make(chan bool)
+	go func() {
+		h.ServeHTTP(rw, req)
+		done <- true
+	}()
+	<-flushed
+	if !rw.Flushed {
+		t.Errorf("underlying ResponseWriter not flushed")
+	}
+	if rw.HeaderMap.Get("Content-Encoding") != "gzip" {
+		t.Errorf("flushed short response is not compressed")
+	}
+	flushed <- true
+	<-done
+	gz, err := gzip.NewReader(rw.Body)
+	if err != nil {
+		t.Fatal("gzip.NewReader:", err)
+	}
+	body, _ := ioutil.ReadAll(gz)
+	if string(body) != "first second" {
+		t.Errorf("body = %q; want %q", body, "first second")
+	}
+}
+
+// plainWriter hides the Flush method of its ResponseRecorder.
+type plainWriter struct {
+	rw *httptest.ResponseRecorder
+}
+
+func (w plainWriter) Header() Header                 { return w.rw.Header() }
+func (w plainWriter) Write(p []byte) (int, os.Error) { return w.rw.Write(p) }
+func (w plainWriter) WriteHeader(code int)           { w.rw.WriteHeader(code) }
+
+func TestCompressHandlerInterfaces(t *testing.T) {
+	for _, flusher := range []bool{false, true} {
+		var rw ResponseWriter = httptest.NewRecorder()
+		if !flusher {
+			rw = plainWriter{httptest.NewRecorder()}
+		}
+		h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+			if _, ok := w.(Flusher); ok != flusher {
+				t.Errorf("server Flusher %v: handler's ResponseWriter is Flusher %v", flusher, ok)
+			}
+			if _, ok := w.(Hijacker); ok {
+				t.Errorf("server Flusher %v: handler's ResponseWriter is a Hijacker", flusher)
+			}
+		}))
+		req, _ := NewRequest("GET", "http://example.com/", nil)
+		h.ServeHTTP(rw, req)
+	}
+}
@@ -13,6 +13,9 @@ import (
 	"io"
 	"net"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,15 +24,22 @@ import (
 type request struct {
 	pw        *io.PipeWriter
 	reqId     uint16
+	role      uint16
 	params    map[string]string
 	buf       [1024]byte
 	rawParams []byte
 	keepConn  bool
+	started   bool
+
+	// the FCGI_DATA stream, for the filter role
+	data  io.ReadCloser
+	dataW *io.PipeWriter
 }
 
-func newRequest(reqId uint16, flags uint8) *request {
+func newRequest(reqId uint16, role uint16, flags uint8) *request {
 	r := &request{
 		reqId:    reqId,
+		role:     role,
 		params:   map[string]string{},
 		keepConn: flags&flagKeepConn != 0,
 	}
@@ -41,23 +51,7 @@ func newRequest(reqId uint16, flags uint8) *request {
 func (r *request) parseParams() {
 	text := r.rawParams
 	r.rawParams = nil
-	for len(text) > 0 {
-		keyLen, n := readSize(text)
-		if n == 0 {
-			return
-		}
-		text = text[n:]
-		valLen, n := readSize(text)
-		if n == 0 {
-			return
-		}
-		text = text[n:]
-		key := readString(text, keyLen)
-		text = text[keyLen:]
-		val := readString(text, valLen)
-		text = text[valLen:]
-		r.params[key] = val
-	}
+	parsePairs(text, r.params)
 }
 
 // response implements http.ResponseWriter.
@@ -66,6 +60,8 @@ type response struct {
 	header      http.Header
 	w           *bufWriter
 	wroteHeader bool
+	discardBody bool
+	vars        map[string]string // set by SetVariable
 }
 
 func newResponse(c *child, req *request) *response {
@@ -84,6 +80,9 @@ func (r *response) Write(data []byte) (int, os.Error) {
 	if !r.wroteHeader {
 		r.WriteHeader(http.StatusOK)
 	}
+	if r.discardBody {
+		return len(data), nil
+	}
 	return r.w.Write(data)
 }
 
@@ -92,6 +91,10 @@ func (r *response) WriteHeader(code int) {
 		return
 	}
 	r.wroteHeader = true
+	if r.req.role == roleAuthorizer {
+		r.writeAuthorizerHeader(code)
+		return
+	}
 	if code == http.StatusNotModified {
 		// Must not have body.
 		r.header.Del("Content-Type")
@@ -110,6 +113,46 @@ func (r *response) WriteHeader(code int) {
 	r.w.WriteString("\r\n")
 }
 
+// writeAuthorizerHeader writes the response of an authorizer. A 200
+// response grants access: its body is of no use to the web server, and
+// its variables are what the server passes on. Any other response
+// denies access and is relayed to the client, so Variable- headers are
+// dropped from it.
+func (r *response) writeAuthorizerHeader(code int) {
+	if code == http.StatusOK {
+		r.discardBody = true
+		var lines []string
+		for k, vv := range r.header {
+			if !strings.HasPrefix(k, "Variable-") {
+				continue
+			}
+			for _, v := range vv {
+				lines = append(lines, k+": "+v+"\r\n")
+			}
+		}
+		for name, v := range r.vars {
+			lines = append(lines, "Variable-"+name+": "+v+"\r\n")
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			r.w.WriteString(line)
+		}
+		fmt.Fprintf(r.w, "Status: %d %s\r\n\r\n", code, http.StatusText(code))
+		return
+	}
+	for k := range r.header {
+		if strings.HasPrefix(k, "Variable-") {
+			r.header.Del(k)
+		}
+	}
+	if r.header.Get("Content-Type") == "" {
+		r.header.Set("Content-Type", "text/html; charset=utf-8")
+	}
+	fmt.Fprintf(r.w, "Status: %d %s\r\n", code, http.StatusText(code))
+	r.header.Write(r.w)
+	r.w.WriteString("\r\n")
+}
+
 func (r *response) Flush() {
 	if !r.wroteHeader {
 		r.WriteHeader(http.StatusOK)
@@ -123,12 +166,50 @@ func (r *response) Close() os.Error {
 }
 
 type child struct {
-	conn    *conn
-	handler http.Handler
+	conn  *conn
+	roles *Child
+
+	mu     sync.Mutex
+	active map[*http.Request]*request // for ProcessEnv and FilterData
+}
+
+func newChild(rwc net.Conn, roles *Child) *child {
+	return &child{
+		conn:   newConn(rwc),
+		roles:  roles,
+		active: map[*http.Request]*request{},
+	}
+}
+
+func (c *child) register(r *http.Request, req *request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active[r] = req
+}
+
+func (c *child) unregister(r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active[r] = nil, false
+}
+
+func (c *child) lookup(r *http.Request) *request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active[r]
 }
 
-func newChild(rwc net.Conn, handler http.Handler) *child {
-	return &child{newConn(rwc), handler}
+// handler returns the handler for role, or nil if the role is not served.
+func (c *child) handler(role uint16) http.Handler {
+	switch role {
+	case roleResponder:
+		return c.roles.Responder
+	case roleAuthorizer:
+		return c.roles.Authorizer
+	case roleFilter:
+		return c.roles.Filter
+	}
+	return nil
 }
 
 func (c *child) serve() {
@@ -157,11 +238,11 @@ func (c *child) serve() {
 			if err := br.read(rec.content()); err != nil {
 				return
 			}
-			if br.role != roleResponder {
+			if c.handler(br.role) == nil {
 				c.conn.writeEndRequest(rec.h.Id, 0, statusUnknownRole)
 				break
 			}
-			requests[rec.h.Id] = newRequest(rec.h.Id, br.flags)
+			requests[rec.h.Id] = newRequest(rec.h.Id, br.role, br.flags)
 		case typeParams:
 			// NOTE(eds): Technically a key-value pair can straddle the boundary
 			// between two packets. We buffer until we've received all parameters.
@@ -170,29 +251,59 @@ func (c *child) serve() {
 				break
 			}
 			req.parseParams()
+			if req.role == roleAuthorizer {
+				// An authorizer receives no stdin; the
+				// request is complete.
+				requests[rec.h.Id] = nil, false
+				req.started = true
+				go c.serveRequest(req, nil)
+			}
 		case typeStdin:
 			content := rec.content()
-			if req.pw == nil {
+			if !req.started {
 				var body io.ReadCloser
 				if len(content) > 0 {
 					// body could be an io.LimitReader, but it shouldn't matter
 					// as long as both sides are behaving.
 					body, req.pw = io.Pipe()
 				}
+				if req.role == roleFilter {
+					req.data, req.dataW = io.Pipe()
+				}
+				req.started = true
 				go c.serveRequest(req, body)
 			}
 			if len(content) > 0 {
 				// TODO(eds): This blocks until the handler reads from the pipe.
 				// If the handler takes a long time, it might be a problem.
 				req.pw.Write(content)
-			} else if req.pw != nil {
+				break
+			}
+			if req.pw != nil {
 				req.pw.Close()
 			}
+			if req.role != roleFilter {
+				// The web server sends nothing more for this
+				// request, so its ID may be reused once the
+				// response has been sent.
+				requests[rec.h.Id] = nil, false
+			}
 		case typeGetValues:
 			values := map[string]string{"FCGI_MPXS_CONNS": "1"}
-			c.conn.writePairs(0, typeGetValuesResult, values)
+			c.conn.writePairs(typeGetValuesResult, 0, values)
 		case typeData:
-			// If the filter role is implemented, read the data stream here.
+			if req.dataW == nil {
+				// Only filters have a data stream, and it
+				// follows stdin.
+				break
+			}
+			if content := rec.content(); len(content) > 0 {
+				// Like stdin, this blocks until the handler reads.
+				req.dataW.Write(content)
+				break
+			}
+			req.dataW.Close()
+			requests[rec.h.Id] = nil, false
 		case typeAbortRequest:
 			requests[rec.h.Id] = nil, false
 			c.conn.writeEndRequest(rec.h.Id, 0, statusRequestComplete)
@@ -216,12 +327,17 @@ func (c *child) serveRequest(req *request, body io.ReadCloser) {
 		r.WriteHeader(http.StatusInternalServerError)
 		c.conn.writeRecord(typeStderr, req.reqId, []byte(err.String()))
 	} else {
-		httpReq.Body = body
-		c.handler.ServeHTTP(r, httpReq)
+		httpReq.Body = &requestBody{body, c}
+		c.register(httpReq, req)
+		c.handler(req.role).ServeHTTP(r, httpReq)
+		c.unregister(httpReq)
 	}
 	if body != nil {
 		body.Close()
 	}
+	if req.data != nil {
+		req.data.Close()
+	}
 	r.Close()
 	c.conn.writeEndRequest(req.reqId, 0, statusRequestComplete)
 	if !req.keepConn {
@@ -229,12 +345,102 @@ func (c *child) serveRequest(req *request, body io.ReadCloser) {
 	}
 }
 
+// requestBody is the Body of the http.Requests a child serves. Besides
+// the request's stdin, it leads ProcessEnv and FilterData to the child
+// serving the request.
+type requestBody struct {
+	rc io.ReadCloser // nil if the request has no body
+	c  *child
+}
+
+func (b *requestBody) Read(p []byte) (int, os.Error) {
+	if b.rc == nil {
+		return 0, os.EOF
+	}
+	return b.rc.Read(p)
+}
+
+func (b *requestBody) Close() os.Error {
+	if b.rc == nil {
+		return nil
+	}
+	return b.rc.Close()
+}
+
+func lookupRequest(r *http.Request) *request {
+	if b, ok := r.Body.(*requestBody); ok {
+		return b.c.lookup(r)
+	}
+	return nil
+}
+
+// ProcessEnv returns the FastCGI parameters the web server sent with r,
+// including those not reflected in r itself, such as FCGI_DATA_LENGTH
+// for a filter. The map must not be modified. ProcessEnv returns nil
+// if r is not being served by this package, once its handler has
+// returned, or if r.Body has been replaced.
+func ProcessEnv(r *http.Request) map[string]string {
+	if req := lookupRequest(r); req != nil {
+		return req.params
+	}
+	return nil
+}
+
+// FilterData returns the FCGI_DATA stream of a request being served by
+// a Child's Filter handler: the file the filter is to process. The
+// web server sends it after the request body, so r.Body must be read
+// to EOF first. FilterData returns nil for other requests.
+func FilterData(r *http.Request) io.Reader {
+	if req := lookupRequest(r); req != nil && req.data != nil {
+		return req.data
+	}
+	return nil
+}
+
+// SetVariable sets the variable name to value in the response of a
+// Child's Authorizer handler. If the handler grants access, the web
+// server passes the variable on to later applications. Unlike a
+// Variable- header, whose name http.Header canonicalizes, name is sent
+// exactly as given, so SetVariable(w, "REMOTE_USER", u) sets
+// REMOTE_USER. SetVariable must be called before WriteHeader, and does
+// nothing if w is not an authorizer's ResponseWriter.
+func SetVariable(w http.ResponseWriter, name, value string) {
+	r, ok := w.(*response)
+	if !ok || r.req.role != roleAuthorizer || r.wroteHeader {
+		return
+	}
+	if r.vars == nil {
+		r.vars = map[string]string{}
+	}
+	r.vars[name] = value
+}
+
+// Child serves FastCGI requests, dispatching each to the handler for
+// the role the web server requested. Requests for a role whose handler
+// is nil are refused with FCGI_UNKNOWN_ROLE.
+type Child struct {
+	// Responder generates responses to HTTP requests, as a CGI
+	// program would.
+	Responder http.Handler
+
+	// Authorizer decides whether HTTP requests may proceed. It
+	// is called with no request body. A 200 response grants access,
+	// and the variables set on it with SetVariable, as well as its
+	// headers named "Variable-Name", are passed by the web server to
+	// later applications as the variable Name; its body is
+	// discarded. Any other response denies access and is sent to
+	// the client.
+	Authorizer http.Handler
+
+	// Filter responds like a responder, but also processes the
+	// file returned by FilterData.
+	Filter http.Handler
+}
+
 // Serve accepts incoming FastCGI connections on the listener l, creating a new
-// service thread for each. The service threads read requests and then call handler
-// to reply to them.
+// service thread for each, like the package-level Serve.
 // If l is nil, Serve accepts connections on stdin.
-// If handler is nil, http.DefaultServeMux is used.
-func Serve(l net.Listener, handler http.Handler) os.Error {
+func (ch *Child) Serve(l net.Listener) os.Error {
 	if l == nil {
 		var err os.Error
 		l, err = net.FileListener(os.Stdin)
@@ -243,16 +449,25 @@ func Serve(l net.Listener, handler http.Handler) os.Error {
 		}
 		defer l.Close()
 	}
-	if handler == nil {
-		handler = http.DefaultServeMux
-	}
 	for {
 		rw, err := l.Accept()
 		if err != nil {
 			return err
 		}
-		c := newChild(rw, handler)
+		c := newChild(rw, ch)
 		go c.serve()
 	}
 	panic("unreachable")
 }
+
+// Serve accepts incoming FastCGI connections on the listener l, creating a new
+// service thread for each. The service threads read requests and then call handler
+// to reply to them. Only the responder role is served.
+// If l is nil, Serve accepts connections on stdin.
+// If handler is nil, http.DefaultServeMux is used.
+func Serve(l net.Listener, handler http.Handler) os.Error {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return (&Child{Responder: handler}).Serve(l)
+}
@@ -0,0 +1,134 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fcgi
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// roleParams returns the parameters of a GET request for uri.
+func roleParams(uri string) map[string]string {
+	return map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"REQUEST_URI":     uri,
+		"HTTP_HOST":       "example.com",
+	}
+}
+
+// serveRecords runs a child for roles on one connection, sends it a
+// single request for uri and returns the request's stdout stream and
+// the protocol status of its END_REQUEST.
+func serveRecords(t *testing.T, roles *Child, role uint16, uri string, stdin, data []byte) (stdout string, status uint8) {
+	buf := new(bytes.Buffer)
+	c := newConn(&nilCloser{buf})
+	c.writeBeginRequest(1, role, 0)
+	c.writePairs(typeParams, 1, roleParams(uri))
+	if role != roleAuthorizer {
+		w := newWriter(c, typeStdin, 1)
+		w.Write(stdin)
+		w.Close()
+	}
+	if role == roleFilter {
+		w := newWriter(c, typeData, 1)
+		w.Write(data)
+		w.Close()
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go newChild(server, roles).serve()
+	go client.Write(buf.Bytes())
+
+	var rec record
+	var out []byte
+	for {
+		if err := rec.read(client); err != nil {
+			t.Fatal("reading record:", err)
+		}
+		switch rec.h.Type {
+		case typeStdout:
+			out = append(out, rec.content()...)
+		case typeEndRequest:
+			return string(out), rec.content()[4]
+		}
+	}
+	panic("unreachable")
+}
+
+func TestChildUnknownRole(t *testing.T) {
+	roles := &Child{Responder: http.NotFoundHandler()}
+	if _, status := serveRecords(t, roles, roleAuthorizer, "/doc", nil, nil); status != statusUnknownRole {
+		t.Errorf("authorizer request to a responder: status = %d; want %d", status, statusUnknownRole)
+	}
+}
+
+func TestChildAuthorizer(t *testing.T) {
+	roles := &Child{Authorizer: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Variable-User", "bob")
+		if r.URL.Path != "/doc" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ignored body"))
+	})}
+	out, status := serveRecords(t, roles, roleAuthorizer, "/doc", nil, nil)
+	if status != statusRequestComplete {
+		t.Fatalf("status = %d; want %d", status, statusRequestComplete)
+	}
+	if e := "Variable-User: bob\r\nStatus: 200 OK\r\n\r\n"; out != e {
+		t.Errorf("granted response = %q; want %q", out, e)
+	}
+
+	out, _ = serveRecords(t, roles, roleAuthorizer, "/secret", nil, nil)
+	if !strings.HasPrefix(out, "Status: 403 Forbidden\r\n") {
+		t.Errorf("denied response %q does not start with 403 status", out)
+	}
+	if strings.Contains(out, "Variable-") {
+		t.Errorf("denied response %q contains Variable- headers", out)
+	}
+}
+
+func TestChildAuthorizerVariables(t *testing.T) {
+	roles := &Child{Authorizer: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetVariable(w, "REMOTE_USER", "bob")
+		SetVariable(w, "AUTH_TYPE", "Basic")
+	})}
+	out, status := serveRecords(t, roles, roleAuthorizer, "/doc", nil, nil)
+	if status != statusRequestComplete {
+		t.Fatalf("status = %d; want %d", status, statusRequestComplete)
+	}
+	e := "Variable-AUTH_TYPE: Basic\r\nVariable-REMOTE_USER: bob\r\nStatus: 200 OK\r\n\r\n"
+	if out != e {
+		t.Errorf("granted response = %q; want %q", out, e)
+	}
+}
+
+func TestChildFilter(t *testing.T) {
+	roles := &Child{Filter: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		data, err := ioutil.ReadAll(FilterData(r))
+		if err != nil {
+			t.Errorf("reading filter data: %v", err)
+		}
+		if ProcessEnv(r)["HTTP_HOST"] != "example.com" {
+			t.Errorf("ProcessEnv = %v; missing HTTP_HOST", ProcessEnv(r))
+		}
+		fmt.Fprintf(w, "body=%s data=%s", body, bytes.ToUpper(data))
+	})}
+	out, status := serveRecords(t, roles, roleFilter, "/doc", []byte("in"), []byte("file contents"))
+	if status != statusRequestComplete {
+		t.Fatalf("status = %d; want %d", status, statusRequestComplete)
+	}
+	if e := "\r\n\r\nbody=in data=FILE CONTENTS"; !strings.HasSuffix(out, e) {
+		t.Errorf("filter response = %q; want suffix %q", out, e)
+	}
+}
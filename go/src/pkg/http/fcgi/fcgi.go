@@ -2,8 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package fcgi implements the FastCGI protocol.
-// Currently only the responder role is supported.
+// Package fcgi implements the FastCGI protocol, both as a child
+// application (Serve) and as the web server forwarding requests to one
+// (Handler). A child may serve the responder, authorizer and filter
+// roles; Handler speaks the responder role.
 // The protocol is defined at http://www.fastcgi.com/drupal/node/6?q=node/22
 package fcgi
 
@@ -43,7 +45,7 @@ const (
 )
 
 const (
-	roleResponder = iota + 1 // only Responders are implemented.
+	roleResponder = iota + 1
 	roleAuthorizer
 	roleFilter
 )
@@ -172,7 +174,7 @@ func (c *conn) writePairs(recType uint8, reqId uint16, pairs map[string]string)
 	b := make([]byte, 8)
 	for k, v := range pairs {
 		n := encodeSize(b, uint32(len(k)))
-		n += encodeSize(b[n:], uint32(len(k)))
+		n += encodeSize(b[n:], uint32(len(v)))
 		if _, err := w.Write(b[:n]); err != nil {
 			return err
 		}
@@ -203,6 +205,30 @@ func readSize(s []byte) (uint32, int) {
 	return size, n
 }
 
+// parsePairs decodes the name-value pairs in text into m.
+func parsePairs(text []byte, m map[string]string) {
+	for len(text) > 0 {
+		keyLen, n := readSize(text)
+		if n == 0 {
+			return
+		}
+		text = text[n:]
+		valLen, n := readSize(text)
+		if n == 0 {
+			return
+		}
+		text = text[n:]
+		if uint64(keyLen)+uint64(valLen) > uint64(len(text)) {
+			return
+		}
+		key := readString(text, keyLen)
+		text = text[keyLen:]
+		val := readString(text, valLen)
+		text = text[valLen:]
+		m[key] = val
+	}
+}
+
 func readString(s []byte, size uint32) string {
 	if size > uint32(len(s)) {
 		return ""
@@ -0,0 +1,583 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fcgi
+
+// This file implements FastCGI from the perspective of the web server,
+// forwarding requests to a FastCGI application.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"http"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errCantMultiplex = os.NewError("fcgi: application cannot multiplex connections")
+	errOverloaded    = os.NewError("fcgi: application is overloaded")
+	errUnknownRole   = os.NewError("fcgi: application does not implement the role")
+	errConnClosed    = os.NewError("fcgi: connection to application closed")
+)
+
+// Handler forwards requests to a FastCGI application in the responder
+// role, much as cgi.Handler runs a CGI executable. Connections to the
+// application are opened with FCGI_KEEP_CONN and reused. If the
+// application reports FCGI_MPXS_CONNS, concurrent requests share a
+// connection, each under its own request ID.
+type Handler struct {
+	Network string // network of the application's socket, "tcp" or "unix"
+	Addr    string // address of the application's socket
+	Path    string // SCRIPT_FILENAME reported to the application, if any
+	Root    string // root URI prefix of handler or empty for "/"
+
+	Env    []string    // extra parameters to send, if any, as "key=value"
+	Logger *log.Logger // optional log for errors or nil to use log.Print
+
+	// MaxConns limits the number of connections opened to the
+	// application. Once it is reached, requests wait for a
+	// connection with spare capacity. Zero means no limit.
+	MaxConns int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	conns   []*clientConn
+	dialing int
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	creq, err := h.newRequest()
+	if err != nil {
+		rw.WriteHeader(http.StatusBadGateway)
+		h.printf("fcgi: %v", err)
+		return
+	}
+	if err := creq.begin(h.params(req)); err != nil {
+		creq.cc.fail(err)
+		creq.release()
+		rw.WriteHeader(http.StatusBadGateway)
+		h.printf("fcgi: %v", err)
+		return
+	}
+	go creq.sendStdin(req.Body)
+
+	complete := false
+	defer func() {
+		if !complete {
+			creq.abort()
+		}
+		creq.release()
+	}()
+
+	linebody := bufio.NewReader(creq.stdout)
+	headers, statusCode, err := h.readHeader(linebody)
+	if err != nil {
+		if err == errCantMultiplex || err == errOverloaded {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		h.printf("fcgi: %v", err)
+		return
+	}
+	for k, vv := range headers {
+		for _, v := range vv {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.WriteHeader(statusCode)
+
+	if _, err = io.Copy(rw, linebody); err != nil {
+		h.printf("fcgi: copy error: %v", err)
+		return
+	}
+	complete = true
+}
+
+// CloseIdleConnections closes the connections to the application
+// that have no request in progress.
+func (h *Handler) CloseIdleConnections() {
+	h.mu.Lock()
+	var idle []*clientConn
+	for _, cc := range h.conns {
+		if len(cc.reqs) == 0 {
+			idle = append(idle, cc)
+		}
+	}
+	h.mu.Unlock()
+	for _, cc := range idle {
+		cc.fail(errConnClosed)
+	}
+}
+
+func (h *Handler) printf(format string, v ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// params returns the FastCGI parameters describing req. They are
+// the CGI meta-variables cgi.Handler passes in the environment.
+func (h *Handler) params(req *http.Request) map[string]string {
+	root := h.Root
+	if root == "" {
+		root = "/"
+	}
+	pathInfo := req.URL.Path
+	if root != "/" && strings.HasPrefix(pathInfo, root) {
+		pathInfo = pathInfo[len(root):]
+	}
+	port := "80"
+	if i := strings.LastIndex(req.Host, ":"); i >= 0 && i > strings.LastIndex(req.Host, "]") {
+		port = req.Host[i+1:]
+	}
+
+	p := map[string]string{
+		"SERVER_SOFTWARE":   "go",
+		"SERVER_NAME":       req.Host,
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"HTTP_HOST":         req.Host,
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    req.Method,
+		"QUERY_STRING":      req.URL.RawQuery,
+		"REQUEST_URI":       req.URL.RawPath,
+		"PATH_INFO":         pathInfo,
+		"SCRIPT_NAME":       root,
+		"REMOTE_ADDR":       req.RemoteAddr,
+		"REMOTE_HOST":       req.RemoteAddr,
+		"SERVER_PORT":       port,
+	}
+	if h.Path != "" {
+		p["SCRIPT_FILENAME"] = h.Path
+	}
+	if req.TLS != nil {
+		p["HTTPS"] = "on"
+	}
+	for k, v := range req.Header {
+		k = strings.Map(upperCaseAndUnderscore, k)
+		joinStr := ", "
+		if k == "COOKIE" {
+			joinStr = "; "
+		}
+		p["HTTP_"+k] = strings.Join(v, joinStr)
+	}
+	if req.ContentLength > 0 {
+		p["CONTENT_LENGTH"] = strconv.Itoa64(req.ContentLength)
+	}
+	if ctype := req.Header.Get("Content-Type"); ctype != "" {
+		p["CONTENT_TYPE"] = ctype
+	}
+	for _, e := range h.Env {
+		if i := strings.Index(e, "="); i > 0 {
+			p[e[:i]] = e[i+1:]
+		}
+	}
+	return p
+}
+
+func upperCaseAndUnderscore(rune int) int {
+	switch {
+	case rune >= 'a' && rune <= 'z':
+		return rune - ('a' - 'A')
+	case rune == '-', rune == '=':
+		return '_'
+	}
+	return rune
+}
+
+// readHeader reads the CGI response header the application writes
+// at the start of its stdout stream.
+func (h *Handler) readHeader(r *bufio.Reader) (headers http.Header, statusCode int, err os.Error) {
+	headers = make(http.Header)
+	for {
+		line, isPrefix, err := r.ReadLine()
+		if isPrefix {
+			return nil, 0, os.NewError("long header line from application")
+		}
+		if err == os.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		parts := strings.SplitN(string(line), ":", 2)
+		if len(parts) < 2 {
+			h.printf("fcgi: bogus header line: %s", string(line))
+			continue
+		}
+		header, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if header == "Status" {
+			if len(val) < 3 {
+				return nil, 0, fmt.Errorf("bogus status (short): %q", val)
+			}
+			if statusCode, err = strconv.Atoi(val[0:3]); err != nil {
+				return nil, 0, fmt.Errorf("bogus status: %q", val)
+			}
+			continue
+		}
+		headers.Add(header, val)
+	}
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+		if headers.Get("Location") != "" {
+			statusCode = http.StatusFound
+		}
+	}
+	return headers, statusCode, nil
+}
+
+// newRequest reserves a request ID on a connection with spare
+// capacity, dialing a new connection if none has any.
+func (h *Handler) newRequest() (*clientRequest, os.Error) {
+	h.mu.Lock()
+	if h.cond == nil {
+		h.cond = sync.NewCond(&h.mu)
+	}
+	for {
+		for _, cc := range h.conns {
+			if creq := cc.newRequestLocked(); creq != nil {
+				h.mu.Unlock()
+				return creq, nil
+			}
+		}
+		if h.MaxConns <= 0 || len(h.conns)+h.dialing < h.MaxConns {
+			break
+		}
+		h.cond.Wait()
+	}
+	h.dialing++
+	h.mu.Unlock()
+
+	rwc, err := net.Dial(h.Network, h.Addr)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dialing--
+	if err != nil {
+		h.cond.Broadcast()
+		return nil, err
+	}
+	cc := newClientConn(h, rwc)
+	h.conns = append(h.conns, cc)
+	creq := cc.newRequestLocked()
+	go cc.serve()
+	return creq, nil
+}
+
+// clientConn is a connection to the application. Its request table
+// and capabilities are guarded by the Handler's mutex.
+type clientConn struct {
+	h    *Handler
+	conn *conn
+	br   *bufio.Reader
+
+	reqs    map[uint16]*clientRequest
+	lastId  uint16
+	mpxs    bool // the application reported FCGI_MPXS_CONNS
+	maxReqs int  // FCGI_MAX_REQS, or 0 if unknown
+	dead    bool
+}
+
+func newClientConn(h *Handler, rwc io.ReadWriteCloser) *clientConn {
+	return &clientConn{
+		h:    h,
+		conn: newConn(rwc),
+		br:   bufio.NewReader(rwc),
+		reqs: make(map[uint16]*clientRequest),
+	}
+}
+
+// newRequestLocked allocates a request on cc, or returns nil if cc
+// is at capacity. Until the application has answered the
+// FCGI_GET_VALUES query, a connection carries one request at a time.
+func (cc *clientConn) newRequestLocked() *clientRequest {
+	if cc.dead {
+		return nil
+	}
+	limit := 1
+	if cc.mpxs {
+		limit = cc.maxReqs
+		if limit <= 0 || limit > 1<<16-1 {
+			limit = 1<<16 - 1
+		}
+	}
+	if len(cc.reqs) >= limit {
+		return nil
+	}
+	for {
+		cc.lastId++
+		if cc.lastId == 0 {
+			cc.lastId = 1
+		}
+		if cc.reqs[cc.lastId] == nil {
+			break
+		}
+	}
+	creq := &clientRequest{id: cc.lastId, cc: cc, stdout: newOutputBuffer(), refs: 3}
+	cc.reqs[creq.id] = creq
+	return creq
+}
+
+// fail closes cc and fails every request in progress on it with err.
+func (cc *clientConn) fail(err os.Error) {
+	h := cc.h
+	h.mu.Lock()
+	if cc.dead {
+		h.mu.Unlock()
+		return
+	}
+	cc.dead = true
+	for i, c := range h.conns {
+		if c == cc {
+			copy(h.conns[i:], h.conns[i+1:])
+			h.conns = h.conns[:len(h.conns)-1]
+			break
+		}
+	}
+	reqs := cc.reqs
+	cc.reqs = make(map[uint16]*clientRequest)
+	if h.cond != nil {
+		h.cond.Broadcast()
+	}
+	h.mu.Unlock()
+
+	cc.conn.Close()
+	for _, creq := range reqs {
+		creq.stdout.CloseWithError(err)
+	}
+}
+
+func (cc *clientConn) request(id uint16) *clientRequest {
+	cc.h.mu.Lock()
+	defer cc.h.mu.Unlock()
+	return cc.reqs[id]
+}
+
+// serve reads records from the application and dispatches them to
+// the requests they belong to.
+func (cc *clientConn) serve() {
+	var rec record
+	cc.conn.writePairs(typeGetValues, 0, map[string]string{
+		"FCGI_MPXS_CONNS": "",
+		"FCGI_MAX_REQS":   "",
+	})
+	for {
+		if err := rec.read(cc.br); err != nil {
+			if err == os.EOF {
+				err = errConnClosed
+			}
+			cc.fail(err)
+			return
+		}
+		if rec.h.Type == typeGetValuesResult {
+			// Applications may end the result with an empty
+			// record, as they would a stream.
+			if content := rec.content(); len(content) > 0 {
+				cc.setValues(content)
+			}
+			continue
+		}
+		creq := cc.request(rec.h.Id)
+		if creq == nil {
+			// Ignore records for requests we don't know.
+			continue
+		}
+		switch rec.h.Type {
+		case typeStdout:
+			if content := rec.content(); len(content) > 0 {
+				creq.stdout.Write(content)
+			}
+		case typeStderr:
+			if content := rec.content(); len(content) > 0 {
+				cc.h.printf("fcgi: application error: %s", content)
+			}
+		case typeEndRequest:
+			cc.endRequest(creq, rec.content())
+		}
+	}
+}
+
+func (cc *clientConn) setValues(content []byte) {
+	values := map[string]string{}
+	parsePairs(content, values)
+	cc.h.mu.Lock()
+	defer cc.h.mu.Unlock()
+	cc.mpxs = values["FCGI_MPXS_CONNS"] == "1"
+	cc.maxReqs, _ = strconv.Atoi(values["FCGI_MAX_REQS"])
+	if cc.mpxs && cc.h.cond != nil {
+		cc.h.cond.Broadcast()
+	}
+}
+
+func (cc *clientConn) endRequest(creq *clientRequest, content []byte) {
+	var err os.Error
+	if len(content) >= 8 {
+		switch content[4] {
+		case statusCantMultiplex:
+			err = errCantMultiplex
+		case statusOverloaded:
+			err = errOverloaded
+		case statusUnknownRole:
+			err = errUnknownRole
+		}
+		if appStatus := binary.BigEndian.Uint32(content); appStatus != 0 && err == nil {
+			cc.h.printf("fcgi: application exited with status %d", appStatus)
+		}
+	}
+
+	cc.h.mu.Lock()
+	creq.ended = true
+	if err == errCantMultiplex {
+		cc.mpxs = false
+	}
+	cc.h.mu.Unlock()
+	creq.release()
+
+	creq.stdout.CloseWithError(err)
+}
+
+// clientRequest is one request in progress on a clientConn.
+//
+// Its ID may only be reused once nothing more will be written under
+// it: after the application's END_REQUEST, the end of the stdin
+// stream and the end of ServeHTTP have each released a reference.
+type clientRequest struct {
+	id     uint16
+	cc     *clientConn
+	stdout *outputBuffer
+
+	// guarded by the Handler's mutex
+	refs  int
+	ended bool // END_REQUEST received
+}
+
+func (creq *clientRequest) release() {
+	h := creq.cc.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if creq.refs--; creq.refs > 0 {
+		return
+	}
+	if creq.cc.reqs[creq.id] == creq {
+		creq.cc.reqs[creq.id] = nil, false
+	}
+	if h.cond != nil {
+		h.cond.Broadcast()
+	}
+}
+
+// begin sends the BEGIN_REQUEST and PARAMS records.
+func (creq *clientRequest) begin(params map[string]string) os.Error {
+	c := creq.cc.conn
+	if err := c.writeBeginRequest(creq.id, roleResponder, flagKeepConn); err != nil {
+		return err
+	}
+	return c.writePairs(typeParams, creq.id, params)
+}
+
+// sendStdin copies body to the application as the request's stdin
+// stream, aborting the request if body cannot be read.
+func (creq *clientRequest) sendStdin(body io.Reader) {
+	defer creq.release()
+	w := newWriter(creq.cc.conn, typeStdin, creq.id)
+	if body != nil {
+		if _, err := io.Copy(w, body); err != nil {
+			creq.abort()
+			return
+		}
+	}
+	if err := w.Close(); err != nil {
+		creq.cc.fail(err)
+	}
+}
+
+// abort asks the application to abandon the request, unless it has
+// already ended, and discards any further output.
+func (creq *clientRequest) abort() {
+	creq.stdout.discard(errConnClosed)
+	h := creq.cc.h
+	h.mu.Lock()
+	ended := creq.ended || creq.cc.dead
+	h.mu.Unlock()
+	if !ended {
+		creq.cc.conn.writeRecord(typeAbortRequest, creq.id, nil)
+	}
+}
+
+// outputBuffer holds a request's stdout stream until ServeHTTP
+// copies it to the HTTP client. Writes never block, so a slow client
+// does not hold up the other requests sharing its connection.
+type outputBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  os.Error // returned once buf is drained; nil while open
+}
+
+func newOutputBuffer() *outputBuffer {
+	b := new(outputBuffer)
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *outputBuffer) Write(p []byte) (int, os.Error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.buf.Write(p)
+	b.cond.Signal()
+	return len(p), nil
+}
+
+func (b *outputBuffer) Read(p []byte) (int, os.Error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.buf.Len() > 0 {
+		return b.buf.Read(p)
+	}
+	return 0, b.err
+}
+
+// CloseWithError ends the stream. Once the buffered output has been
+// read, reads return err, or os.EOF if err is nil.
+func (b *outputBuffer) CloseWithError(err os.Error) {
+	if err == nil {
+		err = os.EOF
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+// discard ends the stream with err and drops any buffered output.
+func (b *outputBuffer) discard(err os.Error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
@@ -0,0 +1,184 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fcgi
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"http/httptest"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPairs(t *testing.T) {
+	pairs := map[string]string{
+		"SHORT":                  "v",
+		"EMPTY":                  "",
+		strings.Repeat("K", 200): strings.Repeat("v", 300),
+	}
+	buf := new(bytes.Buffer)
+	c := newConn(&nilCloser{buf})
+	if err := c.writePairs(typeParams, 1, pairs); err != nil {
+		t.Fatal("writePairs:", err)
+	}
+	var rec record
+	var content []byte
+	for buf.Len() > 0 {
+		if err := rec.read(buf); err != nil {
+			t.Fatal("reading record:", err)
+		}
+		content = append(content, rec.content()...)
+	}
+	got := map[string]string{}
+	parsePairs(content, got)
+	if !reflect.DeepEqual(got, pairs) {
+		t.Errorf("parsePairs = %v; want %v", got, pairs)
+	}
+}
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	mu sync.Mutex
+	n  int
+}
+
+func (l *countingListener) Accept() (net.Conn, os.Error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.n++
+		l.mu.Unlock()
+	}
+	return c, err
+}
+
+func TestHandlerMultiplex(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	l := &countingListener{Listener: ln}
+	defer l.Close()
+
+	// Each response waits until both requests have arrived, so
+	// they can only complete if they are served concurrently. The
+	// body is read first: the child delivers stdin synchronously,
+	// so an unread body holds up the other request.
+	arrived := make(chan bool, 2)
+	release := make(chan bool)
+	go func() {
+		<-arrived
+		<-arrived
+		close(release)
+	}()
+	go Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		arrived <- true
+		<-release
+		w.Header().Set("X-Path", r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+
+	h := &Handler{Network: "tcp", Addr: l.Addr().String(), MaxConns: 1}
+	defer h.CloseIdleConnections()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("body%d", i)
+			req, _ := http.NewRequest("POST", "http://example.com/path", strings.NewReader(body))
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+			if rw.Code != http.StatusCreated {
+				t.Errorf("request %d: code = %d; want %d", i, rw.Code, http.StatusCreated)
+			}
+			if g := rw.HeaderMap.Get("X-Path"); g != "/path" {
+				t.Errorf("request %d: X-Path = %q; want /path", i, g)
+			}
+			if g, e := rw.Body.String(), "POST "+body; g != e {
+				t.Errorf("request %d: body = %q; want %q", i, g, e)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n != 1 {
+		t.Errorf("application accepted %d connections; want 1", l.n)
+	}
+}
+
+// blockingRecorder is a ResponseRecorder whose Write blocks until
+// unblock is closed, reporting on writing when it first does.
+type blockingRecorder struct {
+	*httptest.ResponseRecorder
+	writing chan bool // buffered
+	unblock chan bool
+}
+
+func (r *blockingRecorder) Write(p []byte) (int, os.Error) {
+	select {
+	case r.writing <- true:
+	default:
+	}
+	<-r.unblock
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestHandlerSlowClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer l.Close()
+
+	slowBody := strings.Repeat("x", 1<<16)
+	go Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			w.Write([]byte(slowBody))
+		} else {
+			w.Write([]byte("fast"))
+		}
+	}))
+
+	h := &Handler{Network: "tcp", Addr: l.Addr().String(), MaxConns: 1}
+	defer h.CloseIdleConnections()
+
+	// The slow request's client stops reading partway through its
+	// response; the fast request on the same connection must still
+	// complete.
+	slow := &blockingRecorder{httptest.NewRecorder(), make(chan bool, 1), make(chan bool)}
+	done := make(chan bool)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com/slow", nil)
+		h.ServeHTTP(slow, req)
+		done <- true
+	}()
+	<-slow.writing
+
+	fast := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/fast", nil)
+	h.ServeHTTP(fast, req)
+	if g := fast.Body.String(); g != "fast" {
+		t.Errorf("fast body = %q; want %q", g, "fast")
+	}
+
+	close(slow.unblock)
+	<-done
+	if g := slow.Body.String(); g != slowBody {
+		t.Errorf("slow body has %d bytes; want %d", len(g), len(slowBody))
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"url"
+)
+
+// A CookieJar manages storage and use of cookies in HTTP requests.
+//
+// Implementations of CookieJar must be safe for concurrent use by multiple
+// goroutines.
+type CookieJar interface {
+	// SetCookies handles the receipt of the cookies in a reply for the
+	// given URL.  It may or may not choose to save the cookies, depending
+	// on the jar's policy and implementation.
+	SetCookies(u *url.URL, cookies []*Cookie)
+
+	// Cookies returns the cookies to send in a request for the given URL.
+	// It is up to the implementation to honor the standard cookie use
+	// restrictions such as in RFC 6265.
+	Cookies(u *url.URL) []*Cookie
+}
+
+// MemoryJar is a CookieJar that keeps cookies in memory, following
+// the storage model of RFC 6265 section 5.3.  Domain and path
+// attributes are checked against the URL that set the cookie,
+// expired cookies are discarded, and Secure cookies are only
+// returned for https URLs.  Since a MemoryJar is only consulted for
+// HTTP requests, HttpOnly cookies are stored and sent like any other.
+//
+// The zero value for MemoryJar is an empty jar ready to use.
+type MemoryJar struct {
+	lk sync.Mutex
+	// entries maps a cookie's domain to the cookies for that
+	// domain, keyed by path and name.
+	entries map[string]map[string]*jarEntry
+	seq     int64 // creation order of entries, for sorting
+}
+
+// A jarEntry is a cookie in a MemoryJar.
+type jarEntry struct {
+	name     string
+	value    string
+	domain   string
+	path     string
+	hostOnly bool
+	secure   bool
+	expires  int64 // seconds since epoch; 0 for session cookies
+	seq      int64
+}
+
+func (e *jarEntry) key() string { return e.path + ";" + e.name }
+
+func (e *jarEntry) expired(now int64) bool {
+	return e.expires != 0 && e.expires <= now
+}
+
+// SetCookies implements the SetCookies method of the CookieJar interface.
+// Cookies are ignored unless u is an http or https URL.
+func (j *MemoryJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	host, ok := jarHost(u)
+	if !ok {
+		return
+	}
+	now := time.Seconds()
+
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	for _, c := range cookies {
+		e, ok := newJarEntry(c, host, u.Path, now)
+		if !ok {
+			continue
+		}
+		if j.entries == nil {
+			j.entries = make(map[string]map[string]*jarEntry)
+		}
+		m := j.entries[e.domain]
+		if e.expired(now) {
+			// The server asked us to delete the cookie.
+			if m != nil {
+				m[e.key()] = nil, false
+			}
+			continue
+		}
+		if m == nil {
+			m = make(map[string]*jarEntry)
+			j.entries[e.domain] = m
+		}
+		if old, ok := m[e.key()]; ok {
+			// RFC 6265 section 5.3 step 11.3: keep the creation
+			// time of the cookie being replaced.
+			e.seq = old.seq
+		} else {
+			j.seq++
+			e.seq = j.seq
+		}
+		m[e.key()] = e
+	}
+}
+
+// Cookies implements the Cookies method of the CookieJar interface.
+// It returns the cookies to send for u, longest path first.
+func (j *MemoryJar) Cookies(u *url.URL) []*Cookie {
+	host, ok := jarHost(u)
+	if !ok {
+		return nil
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	https := strings.ToLower(u.Scheme) == "https"
+	now := time.Seconds()
+
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	var selected []*jarEntry
+	for _, domain := range jarDomains(host) {
+		m := j.entries[domain]
+		for key, e := range m {
+			if e.expired(now) {
+				m[key] = nil, false
+				continue
+			}
+			if e.hostOnly && domain != host {
+				continue
+			}
+			if e.secure && !https {
+				continue
+			}
+			if !cookiePathMatch(e.path, path) {
+				continue
+			}
+			selected = append(selected, e)
+		}
+		if len(m) == 0 {
+			j.entries[domain] = nil, false
+		}
+	}
+	sort.Sort(byPathLength(selected))
+
+	cookies := make([]*Cookie, len(selected))
+	for i, e := range selected {
+		cookies[i] = &Cookie{Name: e.name, Value: e.value}
+	}
+	return cookies
+}
+
+// newJarEntry builds the jar entry for cookie c received from host for
+// a request to path, as described in RFC 6265 section 5.3.  It reports
+// false if the cookie must be ignored.
+func newJarEntry(c *Cookie, host, path string, now int64) (e *jarEntry, ok bool) {
+	e = &jarEntry{
+		name:   c.Name,
+		value:  c.Value,
+		secure: c.Secure,
+	}
+
+	domain := strings.ToLower(c.Domain)
+	if len(domain) > 0 && domain[0] == '.' {
+		domain = domain[1:]
+	}
+	if len(domain) > 0 && domain[len(domain)-1] == '.' {
+		// A trailing dot makes the attribute useless.
+		return nil, false
+	}
+	switch {
+	case domain == "" || domain == host:
+		e.domain, e.hostOnly = host, domain == ""
+	case net.ParseIP(host) != nil:
+		// IP addresses only domain-match themselves.
+		return nil, false
+	case !strings.HasSuffix(host, "."+domain):
+		return nil, false
+	case strings.Index(domain, ".") < 0:
+		// Without a public suffix list we refuse at least
+		// top-level domains such as "com".
+		return nil, false
+	default:
+		e.domain = domain
+	}
+
+	e.path = c.Path
+	if e.path == "" || e.path[0] != '/' {
+		e.path = defaultCookiePath(path)
+	}
+
+	switch {
+	case c.MaxAge < 0:
+		e.expires = now
+	case c.MaxAge > 0:
+		e.expires = now + int64(c.MaxAge)
+	case c.Expires.Year != 0:
+		e.expires = c.Expires.Seconds()
+		if e.expires <= 0 {
+			e.expires = now
+		}
+	}
+	return e, true
+}
+
+// jarHost returns the canonical host name of u for cookie matching:
+// lower case, without port or IPv6 brackets.  It reports false if u
+// is not an http or https URL.
+func jarHost(u *url.URL) (host string, ok bool) {
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	default:
+		return "", false
+	}
+	host = strings.ToLower(u.Host)
+	if hasPort(host) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		host = host[:len(host)-1]
+	}
+	return host, host != ""
+}
+
+// jarDomains returns host and each of its parent domains, the
+// candidate keys under which cookies for host may be stored.
+func jarDomains(host string) []string {
+	domains := []string{host}
+	if net.ParseIP(host) != nil {
+		return domains
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] == '.' {
+			domains = append(domains, host[i+1:])
+		}
+	}
+	return domains
+}
+
+// defaultCookiePath returns the default-path of a request path,
+// per RFC 6265 section 5.1.4.
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// cookiePathMatch reports whether the request path reqPath path-matches
+// cookiePath, per RFC 6265 section 5.1.4.
+func cookiePathMatch(cookiePath, reqPath string) bool {
+	if !strings.HasPrefix(reqPath, cookiePath) {
+		return false
+	}
+	return len(reqPath) == len(cookiePath) ||
+		cookiePath[len(cookiePath)-1] == '/' ||
+		reqPath[len(cookiePath)] == '/'
+}
+
+// byPathLength sorts jar entries by decreasing path length, then by
+// creation order, as recommended by RFC 6265 section 5.4.
+type byPathLength []*jarEntry
+
+func (s byPathLength) Len() int      { return len(s) }
+func (s byPathLength) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPathLength) Less(i, j int) bool {
+	if len(s[i].path) != len(s[j].path) {
+		return len(s[i].path) > len(s[j].path)
+	}
+	return s[i].seq < s[j].seq
+}
@@ -0,0 +1,152 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "http"
+	"strings"
+	"testing"
+	"url"
+)
+
+type jarSet struct {
+	url     string
+	cookies []*Cookie
+}
+
+var jarTests = []struct {
+	desc string
+	set  []jarSet
+	url  string
+	want string // cookies as they would appear in a Cookie header
+}{
+	{
+		"host-only cookie",
+		[]jarSet{{"http://www.example.com/", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"http://www.example.com/",
+		"a=1",
+	},
+	{
+		"host-only cookie not sent to subdomain",
+		[]jarSet{{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"http://www.example.com/",
+		"",
+	},
+	{
+		"domain cookie sent to subdomain",
+		[]jarSet{{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1", Domain: ".example.com"}}}},
+		"http://www.example.com/",
+		"a=1",
+	},
+	{
+		"domain must domain-match host",
+		[]jarSet{{"http://www.example.com/", []*Cookie{&Cookie{Name: "a", Value: "1", Domain: "other.com"}}}},
+		"http://other.com/",
+		"",
+	},
+	{
+		"top-level domain refused",
+		[]jarSet{{"http://www.example.com/", []*Cookie{&Cookie{Name: "a", Value: "1", Domain: "com"}}}},
+		"http://www.example.com/",
+		"",
+	},
+	{
+		"port is ignored",
+		[]jarSet{{"http://127.0.0.1:8080/", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"http://127.0.0.1:9090/",
+		"a=1",
+	},
+	{
+		"default path",
+		[]jarSet{{"http://example.com/a/b", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"http://example.com/ab",
+		"",
+	},
+	{
+		"path match",
+		[]jarSet{{"http://example.com/a/b", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"http://example.com/a/c",
+		"a=1",
+	},
+	{
+		"longer paths first",
+		[]jarSet{{"http://example.com/", []*Cookie{
+			&Cookie{Name: "a", Value: "1", Path: "/"},
+			&Cookie{Name: "b", Value: "2", Path: "/x/y"},
+			&Cookie{Name: "c", Value: "3", Path: "/x"},
+		}}},
+		"http://example.com/x/y/z",
+		"b=2; c=3; a=1",
+	},
+	{
+		"secure cookie only over https",
+		[]jarSet{{"https://example.com/", []*Cookie{
+			&Cookie{Name: "a", Value: "1", Secure: true},
+			&Cookie{Name: "b", Value: "2"},
+		}}},
+		"http://example.com/",
+		"b=2",
+	},
+	{
+		"secure cookie over https",
+		[]jarSet{{"https://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1", Secure: true}}}},
+		"https://example.com/",
+		"a=1",
+	},
+	{
+		"httponly cookie",
+		[]jarSet{{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1", HttpOnly: true}}}},
+		"http://example.com/",
+		"a=1",
+	},
+	{
+		"replace cookie",
+		[]jarSet{
+			{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1"}, &Cookie{Name: "b", Value: "2"}}},
+			{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "3"}}},
+		},
+		"http://example.com/",
+		"a=3; b=2",
+	},
+	{
+		"delete with Max-Age",
+		[]jarSet{
+			{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1"}, &Cookie{Name: "b", Value: "2"}}},
+			{"http://example.com/", []*Cookie{&Cookie{Name: "a", Value: "", MaxAge: -1}}},
+		},
+		"http://example.com/",
+		"b=2",
+	},
+	{
+		"non-http scheme",
+		[]jarSet{{"ftp://example.com/", []*Cookie{&Cookie{Name: "a", Value: "1"}}}},
+		"ftp://example.com/",
+		"",
+	},
+}
+
+func TestMemoryJar(t *testing.T) {
+	for _, tt := range jarTests {
+		jar := new(MemoryJar)
+		for _, s := range tt.set {
+			u, err := url.Parse(s.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jar.SetCookies(u, s.cookies)
+		}
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.String())
+		}
+		if g := strings.Join(got, "; "); g != tt.want {
+			t.Errorf("%s: got cookies %q, want %q", tt.desc, g, tt.want)
+		}
+	}
+}
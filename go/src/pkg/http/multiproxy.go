@@ -0,0 +1,292 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP reverse proxy spreading requests over several backends
+
+package http
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+	"url"
+)
+
+// A BalancePolicy chooses which backend of a MultiHostReverseProxy
+// receives the next request.
+type BalancePolicy int
+
+const (
+	// RoundRobin sends requests to the healthy backends in turn.
+	RoundRobin BalancePolicy = iota
+
+	// LeastConnections sends each request to the healthy backend
+	// with the fewest requests in progress, breaking ties in
+	// round-robin order.
+	LeastConnections
+)
+
+const (
+	defaultMaxFails  = 3
+	defaultEjectTime = 30e9
+)
+
+var errNoBackend = os.NewError("http: no healthy backend")
+
+// MultiHostReverseProxy is an HTTP Handler that proxies each request
+// to one of several backends, like a ReverseProxy per backend.
+//
+// Backends are health checked passively: a backend that cannot be
+// reached MaxFails times in a row is ejected for EjectTime nanoseconds.
+// They may also be probed actively; see StartHealthChecks. A request
+// that fails is retried on another backend if its method is
+// idempotent and it has no body.
+type MultiHostReverseProxy struct {
+	// ReverseProxy supplies the Transport and FlushInterval used
+	// for every backend. Its Director must be nil, as each backend
+	// rewrites requests itself; requests to a proxy with a Director
+	// fail with status 500.
+	ReverseProxy
+
+	// Policy selects the backend for each request.
+	Policy BalancePolicy
+
+	// MaxFails is the number of consecutive failures after which a
+	// backend is ejected. If zero, 3 is used.
+	MaxFails int
+
+	// EjectTime is how long, in nanoseconds, an ejected backend
+	// receives no requests. If zero, 30 seconds is used.
+	EjectTime int64
+
+	// MaxRetries is the number of further backends an idempotent
+	// request is tried on after its first attempt fails.
+	MaxRetries int
+
+	mu       sync.Mutex
+	backends []*backend
+	next     int       // round-robin position
+	stop     chan bool // non-nil while health checks run
+}
+
+// backend is one target of a MultiHostReverseProxy. Its fields other
+// than url and director are guarded by the proxy's mutex.
+type backend struct {
+	url      *url.URL
+	director func(*Request)
+
+	active       int   // requests in progress
+	fails        int   // consecutive failed requests
+	ejectedUntil int64 // nanoseconds; passively ejected until then
+	probeFailed  bool  // the last active health probe failed
+}
+
+// NewMultiHostReverseProxy returns a new MultiHostReverseProxy that
+// spreads requests over targets, rewriting URLs to each target as
+// NewSingleHostReverseProxy does.
+func NewMultiHostReverseProxy(targets []*url.URL) *MultiHostReverseProxy {
+	p := new(MultiHostReverseProxy)
+	for _, target := range targets {
+		p.backends = append(p.backends, &backend{url: target, director: singleHostDirector(target)})
+	}
+	return p
+}
+
+func (p *MultiHostReverseProxy) maxFails() int {
+	if p.MaxFails > 0 {
+		return p.MaxFails
+	}
+	return defaultMaxFails
+}
+
+func (p *MultiHostReverseProxy) ejectTime() int64 {
+	if p.EjectTime > 0 {
+		return p.EjectTime
+	}
+	return defaultEjectTime
+}
+
+func (p *MultiHostReverseProxy) ServeHTTP(rw ResponseWriter, req *Request) {
+	if p.Director != nil {
+		log.Printf("http: MultiHostReverseProxy cannot use a Director")
+		rw.WriteHeader(StatusInternalServerError)
+		return
+	}
+	attempts := 1
+	if isIdempotent(req) {
+		attempts += p.MaxRetries
+	}
+	var tried []*backend
+	var err os.Error
+	for i := 0; i < attempts; i++ {
+		b := p.pick(tried)
+		if b == nil {
+			if err == nil {
+				err = errNoBackend
+			}
+			break
+		}
+		tried = append(tried, b)
+		var res *Response
+		res, err = p.roundTrip(req, b.director)
+		if err != nil {
+			p.done(b, false)
+			log.Printf("http: proxy error from %s: %v", b.url.Host, err)
+			continue
+		}
+		p.copyResponse(rw, res)
+		p.done(b, true)
+		return
+	}
+	if err == errNoBackend {
+		rw.WriteHeader(StatusServiceUnavailable)
+		return
+	}
+	rw.WriteHeader(StatusBadGateway)
+}
+
+// isIdempotent reports whether req may safely be sent again after a
+// failed attempt.
+func isIdempotent(req *Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return req.Body == nil || req.ContentLength == 0
+	}
+	return false
+}
+
+// pick chooses a healthy backend not in tried according to p.Policy,
+// and counts the request against it. It returns nil if there is none.
+func (p *MultiHostReverseProxy) pick(tried []*backend) *backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Nanoseconds()
+	n := len(p.backends)
+	var best *backend
+	bestIndex := 0
+	for i := 0; i < n; i++ {
+		j := (p.next + i) % n
+		b := p.backends[j]
+		if b.probeFailed || b.ejectedUntil > now || containsBackend(tried, b) {
+			continue
+		}
+		if best == nil || p.Policy == LeastConnections && b.active < best.active {
+			best, bestIndex = b, j
+		}
+		if p.Policy == RoundRobin {
+			break
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	p.next = (bestIndex + 1) % n
+	best.active++
+	return best
+}
+
+func containsBackend(list []*backend, b *backend) bool {
+	for _, x := range list {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// done records the end of a request to b.
+func (p *MultiHostReverseProxy) done(b *backend, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.active--
+	if ok {
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.fails >= p.maxFails() {
+		b.ejectedUntil = time.Nanoseconds() + p.ejectTime()
+	}
+}
+
+// StartHealthChecks starts probing every backend each interval
+// nanoseconds with a GET request for path, resolved against the
+// backend's URL. A backend whose probe fails or gets a response
+// other than 2xx or 3xx receives no requests until a later probe
+// succeeds; a successful probe also ends a passive ejection.
+func (p *MultiHostReverseProxy) StartHealthChecks(path string, interval int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan bool)
+	go p.healthLoop(path, interval, p.stop)
+}
+
+// StopHealthChecks stops the probes started by StartHealthChecks.
+func (p *MultiHostReverseProxy) StopHealthChecks() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *MultiHostReverseProxy) healthLoop(path string, interval int64, stop chan bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		p.probeAll(path)
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+	panic("unreached")
+}
+
+func (p *MultiHostReverseProxy) probeAll(path string) {
+	p.mu.Lock()
+	backends := make([]*backend, len(p.backends))
+	copy(backends, p.backends)
+	p.mu.Unlock()
+
+	for _, b := range backends {
+		healthy := p.probe(b, path)
+		p.mu.Lock()
+		b.probeFailed = !healthy
+		if healthy {
+			b.fails = 0
+			b.ejectedUntil = 0
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *MultiHostReverseProxy) probe(b *backend, path string) bool {
+	u, err := b.url.Parse(path)
+	if err != nil {
+		return false
+	}
+	req, err := NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return false
+	}
+	transport := p.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
@@ -151,6 +151,10 @@ type Request struct {
 	// TLS-enabled connections before invoking a handler;
 	// otherwise it leaves the field nil.
 	TLS *tls.ConnectionState
+
+	// pathVars holds the segments captured by the Router pattern
+	// that matched the request. See PathValue.
+	pathVars map[string]string
 }
 
 // ProtoAtLeast returns whether the HTTP protocol used
@@ -55,7 +55,13 @@ func singleJoiningSlash(a, b string) string {
 // target's path is "/base" and the incoming request was for "/dir",
 // the target request will be for /base/dir.
 func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
-	director := func(req *Request) {
+	return &ReverseProxy{Director: singleHostDirector(target)}
+}
+
+// singleHostDirector returns a Director rewriting requests to target,
+// as described for NewSingleHostReverseProxy.
+func singleHostDirector(target *url.URL) func(*Request) {
+	return func(req *Request) {
 		req.URL.Scheme = target.Scheme
 		req.URL.Host = target.Host
 		req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
@@ -66,10 +72,21 @@ func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
 		}
 		req.URL.RawQuery = target.RawQuery
 	}
-	return &ReverseProxy{Director: director}
 }
 
 func (p *ReverseProxy) ServeHTTP(rw ResponseWriter, req *Request) {
+	res, err := p.roundTrip(req, p.Director)
+	if err != nil {
+		log.Printf("http: proxy error: %v", err)
+		rw.WriteHeader(StatusInternalServerError)
+		return
+	}
+	p.copyResponse(rw, res)
+}
+
+// roundTrip sends a copy of req, rewritten by director, using
+// p.Transport.
+func (p *ReverseProxy) roundTrip(req *Request, director func(*Request)) (*Response, os.Error) {
 	transport := p.Transport
 	if transport == nil {
 		transport = DefaultTransport
@@ -77,8 +94,14 @@ func (p *ReverseProxy) ServeHTTP(rw ResponseWriter, req *Request) {
 
 	outreq := new(Request)
 	*outreq = *req // includes shallow copies of maps, but okay
+	if req.URL != nil {
+		// The director rewrites the URL; leave req's alone so
+		// it can be sent again.
+		u := *req.URL
+		outreq.URL = &u
+	}
 
-	p.Director(outreq)
+	director(outreq)
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
 	outreq.ProtoMinor = 1
@@ -88,13 +111,11 @@ func (p *ReverseProxy) ServeHTTP(rw ResponseWriter, req *Request) {
 		outreq.Header.Set("X-Forwarded-For", clientIp)
 	}
 
-	res, err := transport.RoundTrip(outreq)
-	if err != nil {
-		log.Printf("http: proxy error: %v", err)
-		rw.WriteHeader(StatusInternalServerError)
-		return
-	}
+	return transport.RoundTrip(outreq)
+}
 
+// copyResponse copies res, headers and body, to rw.
+func (p *ReverseProxy) copyResponse(rw ResponseWriter, res *Response) {
 	hdr := rw.Header()
 	for k, vv := range res.Header {
 		for _, v := range vv {
@@ -112,6 +133,7 @@ func (p *ReverseProxy) ServeHTTP(rw ResponseWriter, req *Request) {
 			}
 		}
 		io.Copy(dst, res.Body)
+		res.Body.Close()
 	}
 }
 
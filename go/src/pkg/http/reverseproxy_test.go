@@ -10,6 +10,8 @@ import (
 	. "http"
 	"http/httptest"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"testing"
 	"url"
 )
@@ -64,3 +66,245 @@ func TestReverseProxy(t *testing.T) {
 		t.Errorf("got body %q; expected %q", g, e)
 	}
 }
+
+func TestMultiHostReverseProxy(t *testing.T) {
+	var targets []*url.URL
+	for _, name := range []string{"b0", "dead", "b1"} {
+		name := name
+		backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Write([]byte(name))
+		}))
+		u, err := url.Parse(backend.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, u)
+		if name == "dead" {
+			backend.Close()
+		} else {
+			defer backend.Close()
+		}
+	}
+	proxy := NewMultiHostReverseProxy(targets)
+	proxy.MaxFails = 1
+	proxy.MaxRetries = 1
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	// The second request goes to the dead backend, is retried on
+	// b1, and the dead backend is ejected from then on.
+	for i, want := range []string{"b0", "b1", "b0", "b1"} {
+		res, err := Get(frontend.URL)
+		if err != nil {
+			t.Fatalf("request %d: Get: %v", i, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || string(body) != want {
+			t.Errorf("request %d: got %d %q; want 200 %q", i, res.StatusCode, body, want)
+		}
+	}
+
+	// Requests with a body are not retried.
+	proxy = NewMultiHostReverseProxy([]*url.URL{targets[1], targets[0]})
+	proxy.MaxRetries = 1
+	frontend2 := httptest.NewServer(proxy)
+	defer frontend2.Close()
+	res, err := Post(frontend2.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusBadGateway {
+		t.Errorf("POST to dead backend: got status %d; want %d", res.StatusCode, StatusBadGateway)
+	}
+}
+
+// newNamedBackend starts a server answering requests with its name,
+// unless handler, if not nil, handles them first and returns true.
+func newNamedBackend(t *testing.T, name string, handler func(ResponseWriter, *Request) bool) (*httptest.Server, *url.URL) {
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if handler == nil || !handler(w, r) {
+			w.Write([]byte(name))
+		}
+	}))
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, u
+}
+
+// getBody returns the status and body of a GET of u.
+func getBody(t *testing.T, u string) (int, string) {
+	res, err := Get(u)
+	if err != nil {
+		t.Fatalf("Get %s: %v", u, err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	return res.StatusCode, string(body)
+}
+
+func TestMultiHostReverseProxyLeastConnections(t *testing.T) {
+	arrived := make(chan bool)
+	release := make(chan bool)
+	b0, u0 := newNamedBackend(t, "b0", func(w ResponseWriter, r *Request) bool {
+		if r.URL.Path == "/slow" {
+			arrived <- true
+			<-release
+		}
+		return false
+	})
+	defer b0.Close()
+	b1, u1 := newNamedBackend(t, "b1", nil)
+	defer b1.Close()
+
+	proxy := NewMultiHostReverseProxy([]*url.URL{u0, u1})
+	proxy.Policy = LeastConnections
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	slow := make(chan string)
+	go func() {
+		_, body := getBody(t, frontend.URL+"/slow")
+		slow <- body
+	}()
+	<-arrived
+
+	// While b0 is busy, b1 gets every request, though round-robin
+	// would send the second to b0.
+	for i := 0; i < 2; i++ {
+		if code, body := getBody(t, frontend.URL); code != 200 || body != "b1" {
+			t.Errorf("request %d: got %d %q; want 200 %q", i, code, body, "b1")
+		}
+	}
+	close(release)
+	if body := <-slow; body != "b0" {
+		t.Errorf("slow request: got %q; want %q", body, "b0")
+	}
+}
+
+func TestMultiHostReverseProxyHealthChecks(t *testing.T) {
+	var mu sync.Mutex
+	healthy := false
+	b0, u0 := newNamedBackend(t, "b0", func(w ResponseWriter, r *Request) bool {
+		if r.URL.Path != "/health" {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			w.WriteHeader(StatusInternalServerError)
+		}
+		return true
+	})
+	defer b0.Close()
+	// Each round of probes visits b0 and then b1, so when b1 is
+	// probed, b0's state from that round is in effect.
+	probed := make(chan bool, 1)
+	b1, u1 := newNamedBackend(t, "b1", func(w ResponseWriter, r *Request) bool {
+		if r.URL.Path != "/health" {
+			return false
+		}
+		select {
+		case probed <- true:
+		default:
+		}
+		return true
+	})
+	defer b1.Close()
+
+	proxy := NewMultiHostReverseProxy([]*url.URL{u0, u1})
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+	proxy.StartHealthChecks("/health", 10e6)
+	defer proxy.StopHealthChecks()
+
+	<-probed
+	for i := 0; i < 2; i++ {
+		if code, body := getBody(t, frontend.URL); code != 200 || body != "b1" {
+			t.Errorf("with b0 failing probes, request %d: got %d %q; want 200 %q", i, code, body, "b1")
+		}
+	}
+
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+	// The first probe of b1 seen may end a round that probed b0
+	// before it recovered; the round of the second cannot have.
+	select {
+	case <-probed:
+	default:
+	}
+	<-probed
+	<-probed
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		_, body := getBody(t, frontend.URL)
+		seen[body] = true
+	}
+	if !seen["b0"] || !seen["b1"] {
+		t.Errorf("with both healthy, requests went to %v; want b0 and b1", seen)
+	}
+}
+
+func TestMultiHostReverseProxyRetry(t *testing.T) {
+	// b0 hangs up on every request.
+	hits := make(chan string, 10)
+	b0, u0 := newNamedBackend(t, "b0", func(w ResponseWriter, r *Request) bool {
+		hits <- r.Method
+		conn, _, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return true
+		}
+		conn.Close()
+		return true
+	})
+	defer b0.Close()
+	b1, u1 := newNamedBackend(t, "b1", nil)
+	defer b1.Close()
+
+	proxy := NewMultiHostReverseProxy([]*url.URL{u0, u1})
+	proxy.MaxFails = 100
+	proxy.MaxRetries = 1
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	for _, method := range []string{"GET", "DELETE"} {
+		req, _ := NewRequest(method, frontend.URL, nil)
+		res, err := DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || string(body) != "b1" {
+			t.Errorf("%s: got %d %q; want 200 %q", method, res.StatusCode, body, "b1")
+		}
+		if m := <-hits; m != method {
+			t.Errorf("%s: b0 got %s", method, m)
+		}
+	}
+
+	// Without retries, the failure is the client's.
+	proxy = NewMultiHostReverseProxy([]*url.URL{u0, u1})
+	frontend2 := httptest.NewServer(proxy)
+	defer frontend2.Close()
+	if code, _ := getBody(t, frontend2.URL); code != StatusBadGateway {
+		t.Errorf("without retries: got status %d; want %d", code, StatusBadGateway)
+	}
+}
+
+func TestMultiHostReverseProxyDirector(t *testing.T) {
+	b0, u0 := newNamedBackend(t, "b0", nil)
+	defer b0.Close()
+	proxy := NewMultiHostReverseProxy([]*url.URL{u0})
+	proxy.Director = func(*Request) {}
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+	if code, _ := getBody(t, frontend.URL); code != StatusInternalServerError {
+		t.Errorf("with a Director: got status %d; want %d", code, StatusInternalServerError)
+	}
+}
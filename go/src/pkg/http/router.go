@@ -0,0 +1,352 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP request router with methods, hosts and path variables
+
+package http
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// Router is an HTTP request multiplexer like ServeMux whose patterns
+// may also name a method and capture parts of the path.
+//
+// A pattern has the form
+//
+//	[METHOD ][HOST]/[SEGMENT/...]
+//
+// A METHOD, such as "GET" or "POST", restricts the pattern to requests
+// with that method; a GET pattern also matches HEAD requests. A HOST
+// restricts it to requests for that host, compared without regard to
+// case and, if HOST has no port, to the request's port. Each SEGMENT
+// of the path is one of
+//
+//	name      matches the segment "name" exactly
+//	{name}    matches any non-empty segment, captured as name
+//	{name...} matches the rest of the path, captured as name;
+//	          allowed only as the last segment
+//
+// A pattern ending in a slash, like "/images/", matches the whole
+// subtree below it, as a trailing {name...} would without capturing
+// anything. The values captured for a request are returned by its
+// PathValue method.
+//
+// When several patterns match a request the most specific one wins:
+// a pattern with a host beats one without; then, comparing the paths
+// segment by segment from the left, a literal segment beats a {name}
+// segment, which beats a {name...} or subtree; then a pattern whose
+// method equals the request's beats a GET pattern serving a HEAD
+// request, which beats a pattern with no method. Two patterns with
+// the same method and host whose paths differ only in the names of
+// their variables would be equally specific, so registering the
+// second one panics.
+//
+// If patterns match the request's path but none its method, Router
+// replies 405 Method Not Allowed listing the allowed methods. Like
+// ServeMux, Router redirects requests for unclean paths, and requests
+// for a subtree's root without its trailing slash unless a pattern
+// for the root itself is registered.
+type Router struct {
+	entries []*routeEntry
+	keys    map[string]*routeEntry // conflict key -> entry
+}
+
+// NewRouter allocates and returns a new Router.
+func NewRouter() *Router { return &Router{keys: make(map[string]*routeEntry)} }
+
+// Segment kinds, in increasing order of specificity.
+const (
+	segWildcard = iota // {name...} or trailing slash
+	segVar             // {name}
+	segLiteral         // name
+)
+
+type routeSegment struct {
+	kind int
+	s    string // literal text or variable name ("" for a trailing slash)
+}
+
+type routeEntry struct {
+	pattern string
+	method  string
+	host    string
+	segs    []routeSegment
+	handler Handler
+
+	// implicit marks the entry redirecting a subtree's root without
+	// its trailing slash; a pattern registered explicitly replaces it.
+	implicit bool
+}
+
+// parseRoute parses pattern into a routeEntry, panicking if it is
+// malformed.
+func parseRoute(pattern string) *routeEntry {
+	bad := func(why string) {
+		panic("http: invalid pattern " + pattern + ": " + why)
+	}
+	e := &routeEntry{pattern: pattern}
+	rest := pattern
+	if i := strings.Index(rest, " "); i >= 0 {
+		e.method, rest = rest[:i], strings.TrimLeft(rest[i+1:], " ")
+		if e.method == "" {
+			bad("empty method")
+		}
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		bad("missing path")
+	}
+	e.host, rest = strings.ToLower(rest[:i]), rest[i+1:]
+
+	seen := make(map[string]bool)
+	parts := strings.Split(rest, "/")
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "":
+			if !last {
+				bad("empty segment")
+			}
+			e.segs = append(e.segs, routeSegment{segWildcard, ""})
+		case part[0] == '{' && part[len(part)-1] == '}':
+			name, kind := part[1:len(part)-1], segVar
+			if strings.HasSuffix(name, "...") {
+				name, kind = name[:len(name)-3], segWildcard
+				if !last {
+					bad("{" + name + "...} must be the last segment")
+				}
+			}
+			if name == "" || strings.IndexAny(name, "{}.") >= 0 {
+				bad("bad variable name")
+			}
+			if seen[name] {
+				bad("duplicate variable " + name)
+			}
+			seen[name] = true
+			e.segs = append(e.segs, routeSegment{kind, name})
+		case strings.IndexAny(part, "{}") >= 0:
+			bad("braces must enclose a whole segment")
+		default:
+			e.segs = append(e.segs, routeSegment{segLiteral, part})
+		}
+	}
+	return e
+}
+
+// key returns a string that is equal for two entries exactly when
+// they are equally specific for every request either matches.
+func (e *routeEntry) key() string {
+	parts := make([]string, len(e.segs))
+	for i, seg := range e.segs {
+		switch seg.kind {
+		case segLiteral:
+			parts[i] = seg.s
+		case segVar:
+			parts[i] = "{}"
+		case segWildcard:
+			parts[i] = "{...}"
+		}
+	}
+	return e.method + " " + e.host + "/" + strings.Join(parts, "/")
+}
+
+// matchHost reports whether the entry applies to requests for host.
+func (e *routeEntry) matchHost(host string) bool {
+	if e.host == "" {
+		return true
+	}
+	host = strings.ToLower(host)
+	if host == e.host {
+		return true
+	}
+	if strings.Index(e.host, ":") < 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			return h == e.host
+		}
+	}
+	return false
+}
+
+// matchPath matches the entry's segments against path, returning the
+// captured variables, or ok == false if it does not match.
+func (e *routeEntry) matchPath(path string) (vars map[string]string, ok bool) {
+	segs := strings.Split(path[1:], "/")
+	for i, seg := range e.segs {
+		if i >= len(segs) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segLiteral:
+			if segs[i] != seg.s {
+				return nil, false
+			}
+		case segVar:
+			if segs[i] == "" {
+				return nil, false
+			}
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			vars[seg.s] = segs[i]
+		case segWildcard:
+			if seg.s != "" {
+				if vars == nil {
+					vars = make(map[string]string)
+				}
+				vars[seg.s] = strings.Join(segs[i:], "/")
+			}
+			return vars, true
+		}
+	}
+	return vars, len(segs) == len(e.segs)
+}
+
+// methodRank ranks how specifically the entry's method matches the
+// request method m, 0 meaning not at all.
+func (e *routeEntry) methodRank(m string) int {
+	switch {
+	case e.method == m:
+		return 3
+	case e.method == "GET" && m == "HEAD":
+		return 2
+	case e.method == "":
+		return 1
+	}
+	return 0
+}
+
+// moreSpecific reports whether a takes precedence over b for a
+// request both match with method m.
+func moreSpecific(a, b *routeEntry, m string) bool {
+	if (a.host != "") != (b.host != "") {
+		return a.host != ""
+	}
+	for i := 0; i < len(a.segs) && i < len(b.segs); i++ {
+		if a.segs[i].kind != b.segs[i].kind {
+			return a.segs[i].kind > b.segs[i].kind
+		}
+	}
+	return a.methodRank(m) > b.methodRank(m)
+}
+
+// Handle registers the handler for the given pattern.
+// It panics if the pattern is malformed or conflicts with one
+// already registered.
+func (rt *Router) Handle(pattern string, handler Handler) {
+	e := parseRoute(pattern)
+	e.handler = handler
+	rt.add(e)
+
+	// Helpful behavior:
+	// If pattern is /tree/ or /tree/{rest...}, redirect /tree to /tree/.
+	if n := len(e.segs); n > 1 && e.segs[n-1].kind == segWildcard {
+		rt.add(&routeEntry{
+			pattern:  pattern,
+			method:   e.method,
+			host:     e.host,
+			segs:     e.segs[:n-1],
+			handler:  HandlerFunc(redirectToSubtree),
+			implicit: true,
+		})
+	}
+}
+
+func (rt *Router) add(e *routeEntry) {
+	k := e.key()
+	old := rt.keys[k]
+	switch {
+	case old == nil:
+		rt.keys[k] = e
+		rt.entries = append(rt.entries, e)
+	case e.implicit:
+		// Keep the existing entry.
+	case old.implicit:
+		*old = *e
+	default:
+		panic("http: pattern " + e.pattern + " conflicts with " + old.pattern)
+	}
+}
+
+func redirectToSubtree(w ResponseWriter, r *Request) {
+	w.Header().Set("Location", r.URL.Path+"/")
+	w.WriteHeader(StatusMovedPermanently)
+}
+
+// HandleFunc registers the handler function for the given pattern.
+func (rt *Router) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	rt.Handle(pattern, HandlerFunc(handler))
+}
+
+// match returns the entry that takes precedence for a request with
+// the given method, host and path, and the variables it captures.
+// If no entry matches but some match all but the method, it returns
+// their methods instead.
+func (rt *Router) match(method, host, path string) (best *routeEntry, vars map[string]string, allowed []string) {
+	for _, e := range rt.entries {
+		if !e.matchHost(host) {
+			continue
+		}
+		v, ok := e.matchPath(path)
+		if !ok {
+			continue
+		}
+		if e.methodRank(method) == 0 {
+			allowed = append(allowed, e.method)
+			if e.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+		if best == nil || moreSpecific(e, best, method) {
+			best, vars = e, v
+		}
+	}
+	if best != nil {
+		return best, vars, nil
+	}
+	return nil, nil, allowed
+}
+
+// ServeHTTP dispatches the request to the handler whose pattern
+// takes precedence for it, after recording the path variables the
+// pattern captures.
+func (rt *Router) ServeHTTP(w ResponseWriter, r *Request) {
+	if p := cleanPath(r.URL.Path); p != r.URL.Path {
+		w.Header().Set("Location", p)
+		w.WriteHeader(StatusMovedPermanently)
+		return
+	}
+	e, vars, allowed := rt.match(r.Method, r.Host, r.URL.Path)
+	if e == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(uniqueSorted(allowed), ", "))
+			Error(w, "405 method not allowed", StatusMethodNotAllowed)
+			return
+		}
+		NotFound(w, r)
+		return
+	}
+	r.pathVars = vars
+	e.handler.ServeHTTP(w, r)
+}
+
+func uniqueSorted(list []string) []string {
+	sort.Strings(list)
+	var out []string
+	for _, s := range list {
+		if len(out) == 0 || s != out[len(out)-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// PathValue returns the path segment captured as name by the Router
+// pattern that matched r, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathVars[name]
+}
@@ -0,0 +1,137 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	. "http"
+	"http/httptest"
+	"testing"
+)
+
+var routerPatterns = []string{
+	"GET /",
+	"/static/",
+	"/files/{path...}",
+	"GET /items/{id}",
+	"DELETE /items/{id}",
+	"GET /items/new",
+	"/items/{id}/parts/{part}",
+	"example.com/items/{id}",
+	"POST /users/{name}/",
+}
+
+var routerTests = []struct {
+	method, host, path string
+	code               int
+	pattern            string // pattern of the handler that ran
+	vars               string // PathValue of id, part, path, name
+	location           string
+}{
+	{"GET", "a.org", "/", 200, "GET /", "", ""},
+	{"GET", "a.org", "/other/thing", 200, "GET /", "", ""},
+	{"GET", "a.org", "/static/css/a.css", 200, "/static/", "", ""},
+	{"GET", "a.org", "/static", 301, "", "", "/static/"},
+	{"GET", "a.org", "/files/a/b.txt", 200, "/files/{path...}", "path=a/b.txt", ""},
+	{"GET", "a.org", "/files/", 200, "/files/{path...}", "path=", ""},
+	{"GET", "a.org", "/items/42", 200, "GET /items/{id}", "id=42", ""},
+	{"HEAD", "a.org", "/items/42", 200, "GET /items/{id}", "id=42", ""},
+	{"DELETE", "a.org", "/items/42", 200, "DELETE /items/{id}", "id=42", ""},
+	{"GET", "a.org", "/items/new", 200, "GET /items/new", "", ""},
+	{"DELETE", "a.org", "/items/new", 200, "DELETE /items/{id}", "id=new", ""},
+	{"PUT", "a.org", "/items/42", 405, "", "", ""},
+	{"PUT", "a.org", "/items/42/parts/7", 200, "/items/{id}/parts/{part}", "id=42 part=7", ""},
+	{"GET", "Example.COM:8080", "/items/42", 200, "example.com/items/{id}", "id=42", ""},
+	{"POST", "a.org", "/users/bob/inbox", 200, "POST /users/{name}/", "name=bob", ""},
+	{"GET", "a.org", "/items/../static/x", 301, "", "", "/static/x"},
+}
+
+func TestRouter(t *testing.T) {
+	rt := NewRouter()
+	for _, p := range routerPatterns {
+		p := p
+		rt.HandleFunc(p, func(w ResponseWriter, r *Request) {
+			w.Header().Set("X-Pattern", p)
+			var vars string
+			for _, name := range []string{"id", "part", "path", "name"} {
+				v := r.PathValue(name)
+				if v == "" && !(name == "path" && p == "/files/{path...}") {
+					continue
+				}
+				if vars != "" {
+					vars += " "
+				}
+				vars += fmt.Sprintf("%s=%s", name, v)
+			}
+			w.Header().Set("X-Vars", vars)
+		})
+	}
+	for _, tt := range routerTests {
+		req, _ := NewRequest(tt.method, "http://"+tt.host+tt.path, nil)
+		req.URL.Path = tt.path
+		rw := httptest.NewRecorder()
+		rt.ServeHTTP(rw, req)
+		desc := tt.method + " " + tt.host + tt.path
+		if rw.Code != tt.code {
+			t.Errorf("%s: code = %d; want %d", desc, rw.Code, tt.code)
+			continue
+		}
+		if g := rw.HeaderMap.Get("X-Pattern"); g != tt.pattern {
+			t.Errorf("%s: served by %q; want %q", desc, g, tt.pattern)
+		}
+		if g := rw.HeaderMap.Get("X-Vars"); g != tt.vars {
+			t.Errorf("%s: vars = %q; want %q", desc, g, tt.vars)
+		}
+		if g := rw.HeaderMap.Get("Location"); g != tt.location {
+			t.Errorf("%s: Location = %q; want %q", desc, g, tt.location)
+		}
+		if tt.code == StatusMethodNotAllowed {
+			if g, e := rw.HeaderMap.Get("Allow"), "DELETE, GET, HEAD"; g != e {
+				t.Errorf("%s: Allow = %q; want %q", desc, g, e)
+			}
+		}
+	}
+}
+
+var routerConflicts = []struct {
+	first, second string
+	conflict      bool
+}{
+	{"/a/{x}", "/a/{y}", true},
+	{"/files/", "/files/{rest...}", true},
+	{"GET /a/{x}", "/a/{x}", false},
+	{"GET /a/{x}", "POST /a/{x}", false},
+	{"/a/{x}", "/a/b", false},
+	{"/a/{x}", "host.com/a/{x}", false},
+	{"GET host.com/a/{x}/b", "GET HOST.com/a/{y}/b", true},
+}
+
+func TestRouterConflicts(t *testing.T) {
+	for _, tt := range routerConflicts {
+		rt := NewRouter()
+		rt.Handle(tt.first, NotFoundHandler())
+		func() {
+			defer func() {
+				if (recover() != nil) != tt.conflict {
+					t.Errorf("registering %q after %q: panicked = %v; want %v", tt.second, tt.first, !tt.conflict, tt.conflict)
+				}
+			}()
+			rt.Handle(tt.second, NotFoundHandler())
+		}()
+	}
+}
+
+func TestRouterBadPatterns(t *testing.T) {
+	for _, p := range []string{"", "GET", "/a//b", "/{x...}/b", "/{x}/{x}", "/a{x}", "/{}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("pattern %q did not panic", p)
+				}
+			}()
+			NewRouter().Handle(p, NotFoundHandler())
+		}()
+	}
+}
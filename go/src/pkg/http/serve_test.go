@@ -19,6 +19,7 @@ import (
 	"net"
 	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -932,6 +933,216 @@ func TestAcceptMaxFds(t *testing.T) {
 	}
 }
 
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	addr := l.Addr().String()
+
+	inHandler := make(chan bool)
+	release := make(chan bool)
+	var mu sync.Mutex
+	var states []ConnState
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			inHandler <- true
+			<-release
+			io.WriteString(w, "done")
+		}),
+		ConnState: func(c net.Conn, state ConnState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+		},
+	}
+	serveErr := make(chan os.Error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	body := make(chan string, 1)
+	go func() {
+		res, err := Get("http://" + addr + "/")
+		if err != nil {
+			body <- err.String()
+			return
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		body <- string(b)
+	}()
+	<-inHandler
+
+	shutdown := make(chan int, 1)
+	go func() {
+		forced, err := srv.Shutdown(0)
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		shutdown <- forced
+	}()
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("Serve = %v; want ErrServerClosed", err)
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("Dial succeeded after Shutdown")
+	}
+
+	close(release)
+	if g, e := <-body, "done"; g != e {
+		t.Errorf("in-flight request got body %q; want %q", g, e)
+	}
+	if forced := <-shutdown; forced != 0 {
+		t.Errorf("Shutdown forced %d connections closed; want 0", forced)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if e := []ConnState{StateNew, StateActive, StateClosed}; !reflect.DeepEqual(states, e) {
+		t.Errorf("connection states = %v; want %v", states, e)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+
+	inHandler := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	idleState := make(chan bool, 1)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			if r.URL.Path == "/idle" {
+				return
+			}
+			inHandler <- true
+			<-release
+		}),
+		ConnState: func(c net.Conn, state ConnState) {
+			if state == StateIdle {
+				select {
+				case idleState <- true:
+				default:
+				}
+			}
+		},
+	}
+	go srv.Serve(l)
+
+	// One idle keep-alive connection, one stuck in its handler.
+	idle, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer idle.Close()
+	fmt.Fprintf(idle, "GET /idle HTTP/1.1\r\nHost: foo\r\n\r\n")
+	<-idleState
+	active, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer active.Close()
+	fmt.Fprintf(active, "GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	<-inHandler
+
+	const second = 1000000000 /* nanos */
+	forced, err := srv.Shutdown(0.1 * second)
+	if err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if forced != 1 {
+		t.Errorf("Shutdown forced %d connections closed; want 1", forced)
+	}
+	idleRes, err := ReadResponse(bufio.NewReader(idle), &Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("reading response on idle connection: %v", err)
+	}
+	ioutil.ReadAll(idleRes.Body)
+	for _, c := range []net.Conn{idle, active} {
+		c.SetReadTimeout(5 * second)
+		if n, err := c.Read(make([]byte, 1)); n != 0 || err != os.EOF {
+			t.Errorf("Read = %v, %v; want 0, EOF", n, err)
+		}
+	}
+}
+
+// Test that a request that has begun to arrive on an idle connection
+// when Shutdown is called is served rather than cut off.
+func TestServerShutdownRequestInFlight(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+
+	states := make(chan ConnState, 10)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			io.WriteString(w, r.URL.Path)
+		}),
+		ConnState: func(c net.Conn, state ConnState) {
+			states <- state
+		},
+	}
+	serveErr := make(chan os.Error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	get := func(path string) {
+		res, err := ReadResponse(br, &Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("reading response for %s: %v", path, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		if string(body) != path {
+			t.Errorf("got body %q; want %q", body, path)
+		}
+	}
+
+	fmt.Fprintf(c, "GET /first HTTP/1.1\r\nHost: foo\r\n\r\n")
+	get("/first")
+	for _, e := range []ConnState{StateNew, StateActive, StateIdle} {
+		if g := <-states; g != e {
+			t.Fatalf("connection state %v; want %v", g, e)
+		}
+	}
+
+	// Begin a second request and wait for the server to notice it.
+	fmt.Fprintf(c, "GET /second HTTP/1.1\r\n")
+	if g := <-states; g != StateActive {
+		t.Fatalf("connection state %v; want %v", g, StateActive)
+	}
+
+	shutdown := make(chan int, 1)
+	go func() {
+		forced, err := srv.Shutdown(0)
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		shutdown <- forced
+	}()
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Errorf("Serve = %v; want ErrServerClosed", err)
+	}
+
+	fmt.Fprintf(c, "Host: foo\r\n\r\n")
+	get("/second")
+	if forced := <-shutdown; forced != 0 {
+		t.Errorf("Shutdown forced %d connections closed; want 0", forced)
+	}
+}
+
 func BenchmarkClientServer(b *testing.B) {
 	b.StopTimer()
 	ts := httptest.NewServer(HandlerFunc(func(rw ResponseWriter, r *Request) {
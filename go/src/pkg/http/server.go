@@ -34,6 +34,7 @@ var (
 	ErrBodyNotAllowed  = os.NewError("http: response status code does not allow body")
 	ErrHijacked        = os.NewError("Conn has been hijacked")
 	ErrContentLength   = os.NewError("Conn.Write wrote more than the declared Content-Length")
+	ErrServerClosed    = os.NewError("http: Server closed")
 )
 
 // Objects implementing the Handler interface can be
@@ -102,6 +103,11 @@ type conn struct {
 	hijacked   bool                 // connection has been hijacked by handler
 	tlsState   *tls.ConnectionState // or nil when not using TLS
 	body       []byte
+
+	// guarded by server.mu
+	state      ConnState
+	stateTime  int64 // when state was entered, in nanoseconds
+	closedIdle bool  // closed by Shutdown while not active
 }
 
 // A response represents the server side of an HTTP response.
@@ -344,6 +350,11 @@ func (w *response) WriteHeader(code int) {
 		w.closeAfterReply = true
 	}
 
+	if w.conn.server.shuttingDown() {
+		// Tell the client not to reuse a connection that
+		// we are about to close.
+		w.header.Set("Connection", "close")
+	}
 	if w.header.Get("Connection") == "close" {
 		w.closeAfterReply = true
 	}
@@ -576,12 +587,14 @@ func (c *conn) close() {
 
 // Serve a new connection.
 func (c *conn) serve() {
+	rwc := c.rwc
 	defer func() {
 		err := recover()
 		if err == nil {
 			return
 		}
-		c.rwc.Close()
+		rwc.Close()
+		c.setState(rwc, StateClosed)
 
 		var buf bytes.Buffer
 		fmt.Fprintf(&buf, "http: panic serving %v: %v\n", c.remoteAddr, err)
@@ -590,6 +603,12 @@ func (c *conn) serve() {
 	}()
 
 	for {
+		// Wait for a request to begin, then mark the connection
+		// active before reading the rest, so that Shutdown no
+		// longer takes it for idle.
+		if p, _ := c.buf.Peek(1); len(p) == 0 || !c.setActive(rwc) {
+			break
+		}
 		w, err := c.readRequest()
 		if err != nil {
 			if err == errTooLarge {
@@ -651,11 +670,13 @@ func (c *conn) serve() {
 			return
 		}
 		w.finishRequest()
-		if w.closeAfterReply {
+		if w.closeAfterReply || c.server.shuttingDown() {
 			break
 		}
+		c.setState(rwc, StateIdle)
 	}
 	c.close()
+	c.setState(rwc, StateClosed)
 }
 
 // Hijack implements the Hijacker.Hijack method. Our response is both a ResponseWriter
@@ -669,6 +690,7 @@ func (w *response) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err os.Error)
 	buf = w.conn.buf
 	w.conn.rwc = nil
 	w.conn.buf = nil
+	w.conn.setState(rwc, StateHijacked)
 	return
 }
 
@@ -949,6 +971,235 @@ type Server struct {
 	ReadTimeout    int64   // the net.Conn.SetReadTimeout value for new connections
 	WriteTimeout   int64   // the net.Conn.SetWriteTimeout value for new connections
 	MaxHeaderBytes int     // maximum size of request headers, DefaultMaxHeaderBytes if 0
+
+	// ConnState specifies an optional callback function that is
+	// called when a client connection changes state.  See the
+	// ConnState type and associated constants for details.
+	ConnState func(net.Conn, ConnState)
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]bool
+	conns     map[*conn]net.Conn // tracked connections, by serving conn
+}
+
+// A ConnState represents the state of a client connection to a server.
+// It's used by the optional Server.ConnState hook.
+type ConnState int
+
+const (
+	// StateNew represents a new connection that is expected to
+	// send a request immediately.  Connections begin at this
+	// state and then transition to either StateActive or
+	// StateClosed.
+	StateNew ConnState = iota
+
+	// StateActive represents a connection that has read one or
+	// more bytes of a request and is reading the rest of it or
+	// running its handler.  After the request is handled, the
+	// state transitions to StateClosed, StateHijacked, or
+	// StateIdle.
+	StateActive
+
+	// StateIdle represents a connection that has finished
+	// handling a request and is in the keep-alive state, waiting
+	// for a new request.  Connections transition from StateIdle
+	// to either StateActive or StateClosed.
+	StateIdle
+
+	// StateHijacked represents a hijacked connection.
+	// This is a terminal state; the Server no longer tracks it.
+	StateHijacked
+
+	// StateClosed represents a closed connection.
+	// This is a terminal state.
+	StateClosed
+)
+
+var stateName = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+func (c ConnState) String() string {
+	return stateName[c]
+}
+
+// setState records that c, served over rwc, has entered state and
+// calls the server's ConnState hook.
+func (c *conn) setState(rwc net.Conn, state ConnState) {
+	srv := c.server
+	srv.mu.Lock()
+	if state == StateNew {
+		if srv.conns == nil {
+			srv.conns = make(map[*conn]net.Conn)
+		}
+		srv.conns[c] = rwc
+		if srv.closing {
+			// Accepted while shutting down; serve will
+			// notice the closed connection and clean up.
+			rwc.Close()
+		}
+	}
+	c.state = state
+	c.stateTime = time.Nanoseconds()
+	srv.mu.Unlock()
+	if hook := srv.ConnState; hook != nil {
+		hook(rwc, state)
+	}
+	if state == StateHijacked || state == StateClosed {
+		// Stop tracking c only after the hook has run, so
+		// that Shutdown returns after the last notification.
+		srv.mu.Lock()
+		srv.conns[c] = nil, false
+		srv.mu.Unlock()
+	}
+}
+
+// setActive records that c, served over rwc, has begun to receive a
+// request.  It reports false if Shutdown has already closed c as
+// idle, in which case the request cannot be answered.
+func (c *conn) setActive(rwc net.Conn) bool {
+	srv := c.server
+	srv.mu.Lock()
+	if c.closedIdle {
+		srv.mu.Unlock()
+		return false
+	}
+	c.state = StateActive
+	c.stateTime = time.Nanoseconds()
+	srv.mu.Unlock()
+	if hook := srv.ConnState; hook != nil {
+		hook(rwc, StateActive)
+	}
+	return true
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closing
+}
+
+// trackListener adds l to the set of listeners closed by Shutdown
+// and Close.  It reports false if the server is already shutting
+// down.
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		srv.listeners[l] = false, false
+		return true
+	}
+	if srv.closing {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]bool)
+	}
+	srv.listeners[l] = true
+	return true
+}
+
+// beginClose marks the server as shutting down and closes its
+// listeners.  srv.mu must be held.
+func (srv *Server) beginClose() (err os.Error) {
+	srv.closing = true
+	for l := range srv.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		srv.listeners[l] = false, false
+	}
+	return
+}
+
+// newConnGrace is how long Shutdown lets a new connection
+// send its first request before treating it as idle.
+const newConnGrace = 5e9 // 5s
+
+// closeConns closes the tracked connections and returns how many
+// it closed.  If idleOnly is true, only idle connections are closed:
+// those waiting for another request, and new ones that have sent
+// nothing for newConnGrace.  srv.mu must be held.
+func (srv *Server) closeConns(idleOnly bool) (n int) {
+	now := time.Nanoseconds()
+	for c, rwc := range srv.conns {
+		if c.closedIdle {
+			// Closed already; serve is cleaning up.
+			continue
+		}
+		if idleOnly {
+			switch {
+			case c.state == StateIdle:
+			case c.state == StateNew && now-c.stateTime >= newConnGrace:
+			default:
+				continue
+			}
+			c.closedIdle = true
+		}
+		rwc.Close()
+		n++
+	}
+	return
+}
+
+// Close immediately closes all listeners and all connections of
+// the server, including those that are running a handler.  Any
+// pending call to Serve returns ErrServerClosed.
+//
+// Close returns any error returned from closing the listeners.
+func (srv *Server) Close() os.Error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	err := srv.beginClose()
+	srv.closeConns(false)
+	return err
+}
+
+// shutdownPollInterval is how often Shutdown checks whether the
+// server's connections have finished.
+const shutdownPollInterval = 50e6 // 50ms
+
+// Shutdown gracefully shuts down the server.  It closes all
+// listeners, so that any pending call to Serve returns
+// ErrServerClosed, then closes idle connections and waits for the
+// requests in progress to finish.  Connections are closed as soon
+// as their current response has been written.  New connections are
+// given a few seconds to send a request before they count as idle.
+//
+// If ns is positive, Shutdown waits at most ns nanoseconds and then
+// closes the connections that remain, returning how many it had to
+// close in forced.  If ns is zero or negative, Shutdown waits
+// indefinitely.
+//
+// Shutdown does not wait for hijacked connections; callers should
+// track and close those themselves.
+func (srv *Server) Shutdown(ns int64) (forced int, err os.Error) {
+	srv.mu.Lock()
+	err = srv.beginClose()
+	srv.mu.Unlock()
+
+	deadline := time.Nanoseconds() + ns
+	for {
+		srv.mu.Lock()
+		srv.closeConns(true)
+		if len(srv.conns) == 0 {
+			srv.mu.Unlock()
+			return 0, err
+		}
+		if ns > 0 && time.Nanoseconds() >= deadline {
+			forced = srv.closeConns(false)
+			srv.mu.Unlock()
+			return forced, err
+		}
+		srv.mu.Unlock()
+		time.Sleep(shutdownPollInterval)
+	}
+	panic("not reached")
 }
 
 // ListenAndServe listens on the TCP network address srv.Addr and then
@@ -969,11 +1220,20 @@ func (srv *Server) ListenAndServe() os.Error {
 // Serve accepts incoming connections on the Listener l, creating a
 // new service thread for each.  The service threads read requests and
 // then call srv.Handler to reply to them.
+//
+// After Shutdown or Close, Serve returns ErrServerClosed.
 func (srv *Server) Serve(l net.Listener) os.Error {
 	defer l.Close()
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
 	for {
 		rw, e := l.Accept()
 		if e != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
 			if ne, ok := e.(net.Error); ok && ne.Temporary() {
 				log.Printf("http: Accept error: %v", e)
 				continue
@@ -990,6 +1250,7 @@ func (srv *Server) Serve(l net.Listener) os.Error {
 		if err != nil {
 			continue
 		}
+		c.setState(rw, StateNew)
 		go c.serve()
 	}
 	panic("not reached")
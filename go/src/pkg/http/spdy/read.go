@@ -164,7 +164,7 @@ func parseHeaderValueBlock(r io.Reader, streamId uint32) (http.Header, os.Error)
 			e = &Error{UnlowercasedHeaderName, streamId}
 			name = strings.ToLower(name)
 		}
-		if h[name] != nil {
+		if h[http.CanonicalHeaderKey(name)] != nil {
 			e = &Error{DuplicateHeaders, streamId}
 		}
 		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
@@ -201,7 +201,9 @@ func (f *Framer) readSynStreamFrame(h ControlFrameHeader, frame *SynStreamFrame)
 
 	reader := f.r
 	if !f.headerCompressionDisabled {
-		f.uncorkHeaderDecompressor(int64(h.length - 10))
+		if err = f.uncorkHeaderDecompressor(int64(h.length - 10)); err != nil {
+			return err
+		}
 		reader = f.headerDecompressor
 	}
 
@@ -235,7 +237,9 @@ func (f *Framer) readSynReplyFrame(h ControlFrameHeader, frame *SynReplyFrame) o
 	}
 	reader := f.r
 	if !f.headerCompressionDisabled {
-		f.uncorkHeaderDecompressor(int64(h.length - 6))
+		if err = f.uncorkHeaderDecompressor(int64(h.length - 6)); err != nil {
+			return err
+		}
 		reader = f.headerDecompressor
 	}
 	frame.Headers, err = parseHeaderValueBlock(reader, frame.StreamId)
@@ -268,7 +272,9 @@ func (f *Framer) readHeadersFrame(h ControlFrameHeader, frame *HeadersFrame) os.
 	}
 	reader := f.r
 	if !f.headerCompressionDisabled {
-		f.uncorkHeaderDecompressor(int64(h.length - 6))
+		if err = f.uncorkHeaderDecompressor(int64(h.length - 6)); err != nil {
+			return err
+		}
 		reader = f.headerDecompressor
 	}
 	frame.Headers, err = parseHeaderValueBlock(reader, frame.StreamId)
@@ -0,0 +1,185 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spdy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"http"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"url"
+)
+
+// ServeConn runs a server-side SPDY session on c, calling handler
+// for every stream the client opens, each in its own goroutine. If
+// handler is nil, http.DefaultServeMux is used. ServeConn returns
+// once the session ends.
+func ServeConn(c io.ReadWriteCloser, handler http.Handler) os.Error {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	s, err := NewSession(c, true)
+	if err != nil {
+		return err
+	}
+	var remoteAddr string
+	if nc, ok := c.(net.Conn); ok {
+		remoteAddr = nc.RemoteAddr().String()
+	}
+	var tlsState *tls.ConnectionState
+	if tc, ok := c.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		tlsState = &state
+	}
+	for {
+		st, err := s.Accept()
+		if err != nil {
+			if err == ErrSessionClosed {
+				err = nil
+			}
+			return err
+		}
+		go serveStream(st, handler, remoteAddr, tlsState)
+	}
+	panic("not reached")
+}
+
+func serveStream(st *Stream, handler http.Handler, remoteAddr string, tlsState *tls.ConnectionState) {
+	h, err := st.Header()
+	if err != nil {
+		return
+	}
+	req, err := requestFromHeader(h)
+	if err != nil {
+		st.Reset(ProtocolError)
+		return
+	}
+	req.Body = streamBody{st}
+	req.RemoteAddr = remoteAddr
+	req.TLS = tlsState
+
+	w := &responseWriter{stream: st, req: req, header: make(http.Header)}
+	handler.ServeHTTP(w, req)
+	w.finish()
+}
+
+// requestFromHeader builds an http.Request from the SYN_STREAM
+// headers of a stream. SPDY/2 carries the request line in the
+// "method", "url" and "version" headers.
+func requestFromHeader(h http.Header) (*http.Request, os.Error) {
+	method, rawurl, vers := h.Get("Method"), h.Get("Url"), h.Get("Version")
+	if method == "" || rawurl == "" || vers == "" {
+		return nil, &Error{InvalidHeaderPresent, 0}
+	}
+	req := &http.Request{
+		Method:        method,
+		RawURL:        rawurl,
+		Proto:         strings.ToUpper(vers),
+		Header:        make(http.Header),
+		ContentLength: -1,
+	}
+	var ok bool
+	if req.ProtoMajor, req.ProtoMinor, ok = http.ParseHTTPVersion(req.Proto); !ok {
+		return nil, &Error{InvalidHeaderPresent, 0}
+	}
+	var err os.Error
+	if req.URL, err = url.Parse(rawurl); err != nil {
+		return nil, err
+	}
+	for k, vv := range h {
+		switch k {
+		case "Method", "Url", "Version":
+			continue
+		}
+		req.Header[k] = vv
+	}
+	req.Host = req.URL.Host
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	if cl := req.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi64(cl); err == nil && n >= 0 {
+			req.ContentLength = n
+		}
+	}
+	return req, nil
+}
+
+// streamBody is a request body backed by a stream. Closing it is a
+// no-op: the stream stays open for the response.
+type streamBody struct {
+	st *Stream
+}
+
+func (b streamBody) Read(p []byte) (int, os.Error) { return b.st.Read(p) }
+func (b streamBody) Close() os.Error               { return nil }
+
+// responseWriter implements http.ResponseWriter on top of the
+// SYN_REPLY and DATA frames of a stream.
+type responseWriter struct {
+	stream      *Stream
+	req         *http.Request
+	header      http.Header
+	wroteHeader bool
+	noBody      bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.noBody = w.req.Method == "HEAD" || code == http.StatusNotModified ||
+		code == http.StatusNoContent || code >= 100 && code < 200
+
+	h := make(http.Header)
+	for k, vv := range w.header {
+		if !invalidRespHeaders[k] {
+			h[k] = vv
+		}
+	}
+	h.Set("Status", fmt.Sprintf("%d %s", code, http.StatusText(code)))
+	h.Set("Version", "HTTP/1.1")
+	w.stream.Reply(h, w.noBody)
+}
+
+func (w *responseWriter) Write(p []byte) (int, os.Error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.noBody {
+		return 0, http.ErrBodyNotAllowed
+	}
+	return w.stream.Write(p)
+}
+
+// Flush sends the reply headers if they have not been sent yet.
+// Data needs no flushing: each Write is sent to the peer at once.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (w *responseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.stream.Close()
+}
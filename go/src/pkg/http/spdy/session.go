@@ -0,0 +1,618 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spdy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"http"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSessionClosed = os.NewError("spdy: session closed")
+	ErrGoAway        = os.NewError("spdy: session is going away")
+	ErrStreamClosed  = os.NewError("spdy: write on closed stream")
+	ErrNotReplied    = os.NewError("spdy: write before reply")
+	ErrReplied       = os.NewError("spdy: stream already replied")
+)
+
+// StreamError is returned from Stream operations after the stream
+// was reset, either by the peer or locally.
+type StreamError struct {
+	StreamId uint32
+	Status   StatusCode
+}
+
+func (e *StreamError) String() string {
+	return fmt.Sprintf("spdy: stream %d reset with status %d", e.StreamId, e.Status)
+}
+
+const (
+	// maxDataChunk is the largest payload Stream.Write puts in a
+	// single DATA frame, so one large write does not monopolize
+	// the connection.
+	maxDataChunk = 16 << 10
+
+	// maxStreamBuffer is the number of unread bytes a stream may
+	// buffer before it is reset with FlowControlError. SPDY/2 has
+	// no WINDOW_UPDATE, so this is the only back-pressure there is.
+	maxStreamBuffer = 1 << 20
+
+	// maxPendingStreams bounds how many peer-initiated streams may
+	// wait for Accept before new ones are refused.
+	maxPendingStreams = 100
+
+	maxStreamId = 1<<31 - 1
+)
+
+// A Session multiplexes SPDY streams over a single connection. It
+// owns the connection's Framer: one goroutine reads and dispatches
+// incoming frames, and writes from any goroutine are serialized.
+type Session struct {
+	conn   io.ReadWriteCloser
+	bw     *bufio.Writer
+	framer *Framer
+	server bool
+
+	wmu sync.Mutex // serializes frame writes; acquired before mu
+
+	mu           sync.Mutex
+	streams      map[uint32]*Stream
+	nextId       uint32 // next locally-initiated stream id
+	lastRemoteId uint32 // highest stream id accepted from the peer
+	nextPingId   uint32
+	pings        map[uint32]chan bool
+	goneAway     bool // we sent GOAWAY; new peer streams are refused
+	peerGoneAway bool // peer sent GOAWAY; no new local streams
+	closed       bool
+	err          os.Error // why the session closed
+
+	accept chan *Stream
+	done   chan bool // closed when the session closes
+}
+
+// NewSession starts a SPDY session on conn. server selects which
+// side of the connection this is, and therefore the parity of the
+// stream and ping ids the session allocates: clients use odd ids,
+// servers even ones.
+func NewSession(conn io.ReadWriteCloser, server bool) (*Session, os.Error) {
+	bw := bufio.NewWriter(conn)
+	framer, err := NewFramer(bw, bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		conn:       conn,
+		bw:         bw,
+		framer:     framer,
+		server:     server,
+		streams:    make(map[uint32]*Stream),
+		nextId:     1,
+		nextPingId: 1,
+		pings:      make(map[uint32]chan bool),
+		accept:     make(chan *Stream, maxPendingStreams),
+		done:       make(chan bool),
+	}
+	if server {
+		s.nextId = 2
+		s.nextPingId = 2
+	}
+	go s.run()
+	return s, nil
+}
+
+// isLocalId reports whether id has the parity of ids this side
+// of the session allocates.
+func (s *Session) isLocalId(id uint32) bool {
+	return (id%2 == 0) == s.server
+}
+
+// Accept waits for and returns the next stream opened by the peer.
+func (s *Session) Accept() (*Stream, os.Error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil, s.err
+}
+
+// OpenStream opens a new stream by sending a SYN_STREAM frame with
+// the given headers. If fin is set, the stream is half-closed
+// immediately and no data may be written to it.
+func (s *Session) OpenStream(h http.Header, fin bool) (*Stream, os.Error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		err := s.err
+		s.mu.Unlock()
+		return nil, err
+	}
+	if s.peerGoneAway || s.goneAway || s.nextId > maxStreamId {
+		s.mu.Unlock()
+		return nil, ErrGoAway
+	}
+	st := newStream(s, s.nextId, true)
+	st.localClosed = fin
+	s.nextId += 2
+	s.streams[st.Id] = st
+	s.mu.Unlock()
+
+	frame := &SynStreamFrame{StreamId: st.Id, Headers: h}
+	if fin {
+		frame.CFHeader.Flags = ControlFlagFin
+	}
+	if err := s.writeFrameLocked(frame); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Ping sends a PING frame and waits for the peer to echo it,
+// returning the round trip time in nanoseconds.
+func (s *Session) Ping() (int64, os.Error) {
+	s.mu.Lock()
+	if s.closed {
+		err := s.err
+		s.mu.Unlock()
+		return 0, err
+	}
+	id := s.nextPingId
+	s.nextPingId += 2
+	ch := make(chan bool, 1)
+	s.pings[id] = ch
+	s.mu.Unlock()
+
+	start := time.Nanoseconds()
+	if err := s.writeFrame(&PingFrame{Id: id}); err != nil {
+		return 0, err
+	}
+	if _, ok := <-ch; !ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return 0, s.err
+	}
+	return time.Nanoseconds() - start, nil
+}
+
+// GoAway tells the peer that no further streams will be accepted.
+// Streams already accepted continue normally.
+func (s *Session) GoAway() os.Error {
+	s.mu.Lock()
+	s.goneAway = true
+	last := s.lastRemoteId
+	s.mu.Unlock()
+	return s.writeFrame(&GoAwayFrame{LastGoodStreamId: last})
+}
+
+// Close closes the underlying connection, failing every stream
+// that is still open.
+func (s *Session) Close() os.Error {
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
+
+// canOpen reports whether OpenStream may currently succeed.
+func (s *Session) canOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed && !s.goneAway && !s.peerGoneAway && s.nextId <= maxStreamId
+}
+
+func (s *Session) shutdown(err os.Error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	for id, ch := range s.pings {
+		close(ch)
+		s.pings[id] = nil, false
+	}
+	close(s.done)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	for _, st := range streams {
+		st.fail(err)
+	}
+}
+
+func (s *Session) writeFrame(f Frame) os.Error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.writeFrameLocked(f)
+}
+
+// writeFrameLocked writes and flushes f. s.wmu must be held.
+func (s *Session) writeFrameLocked(f Frame) os.Error {
+	s.mu.Lock()
+	closed, err := s.closed, s.err
+	s.mu.Unlock()
+	if closed {
+		return err
+	}
+	err = s.framer.WriteFrame(f)
+	if err == nil {
+		err = s.bw.Flush()
+	}
+	if err != nil {
+		s.shutdown(err)
+	}
+	return err
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[id] = nil, false
+}
+
+// reset sends RST_STREAM for id and fails the local stream, if any.
+func (s *Session) reset(id uint32, status StatusCode) os.Error {
+	if st := s.stream(id); st != nil {
+		st.fail(&StreamError{id, status})
+		s.removeStream(id)
+	}
+	return s.writeFrame(&RstStreamFrame{StreamId: id, Status: status})
+}
+
+// run reads frames from the connection until it fails, dispatching
+// each one to the stream it belongs to.
+func (s *Session) run() {
+	for {
+		frame, err := s.framer.ReadFrame()
+		if err != nil {
+			if err == os.EOF {
+				s.shutdown(ErrSessionClosed)
+				return
+			}
+			if se, ok := err.(*Error); ok && se.StreamId != 0 {
+				// The header block decompressed and parsed
+				// in full but broke the rules for a stream,
+				// so the zlib stream is intact and only that
+				// stream is unusable.
+				s.reset(se.StreamId, ProtocolError)
+				continue
+			}
+			// Every header block in the session is decoded by
+			// one zlib stream, so after any other bad frame,
+			// later header blocks cannot be trusted. The whole
+			// session goes away.
+			s.GoAway()
+			s.shutdown(err)
+			return
+		}
+		switch f := frame.(type) {
+		case *SynStreamFrame:
+			s.handleSynStream(f)
+		case *SynReplyFrame:
+			s.handleHeaders(f.StreamId, f.Headers, f.CFHeader.Flags, true)
+		case *HeadersFrame:
+			s.handleHeaders(f.StreamId, f.Headers, f.CFHeader.Flags, false)
+		case *DataFrame:
+			s.handleData(f)
+		case *RstStreamFrame:
+			if st := s.stream(f.StreamId); st != nil {
+				st.fail(&StreamError{f.StreamId, f.Status})
+				s.removeStream(f.StreamId)
+			}
+		case *PingFrame:
+			s.handlePing(f)
+		case *GoAwayFrame:
+			s.handleGoAway(f)
+		}
+	}
+}
+
+func (s *Session) handleSynStream(f *SynStreamFrame) {
+	id := f.StreamId
+	s.mu.Lock()
+	if id == 0 || id > maxStreamId || s.isLocalId(id) || id <= s.lastRemoteId {
+		s.mu.Unlock()
+		s.reset(id, ProtocolError)
+		return
+	}
+	if s.goneAway {
+		s.mu.Unlock()
+		s.reset(id, RefusedStream)
+		return
+	}
+	s.lastRemoteId = id
+	st := newStream(s, id, false)
+	st.header = f.Headers
+	st.remoteClosed = f.CFHeader.Flags&ControlFlagFin != 0
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	select {
+	case s.accept <- st:
+	default:
+		s.reset(id, RefusedStream)
+	}
+}
+
+// handleHeaders processes SYN_REPLY (reply set) and HEADERS frames.
+func (s *Session) handleHeaders(id uint32, h http.Header, flags ControlFlags, reply bool) {
+	st := s.stream(id)
+	if st == nil {
+		s.reset(id, InvalidStream)
+		return
+	}
+	st.mu.Lock()
+	switch {
+	case reply && (!st.local || st.header != nil):
+		// SYN_REPLY for a stream we did not open, or a second one.
+		st.mu.Unlock()
+		s.reset(id, ProtocolError)
+		return
+	case !reply && st.header == nil, st.remoteClosed:
+		st.mu.Unlock()
+		s.reset(id, ProtocolError)
+		return
+	}
+	if st.header == nil {
+		st.header = h
+	} else {
+		for k, vv := range h {
+			for _, v := range vv {
+				st.header.Add(k, v)
+			}
+		}
+	}
+	if flags&ControlFlagFin != 0 {
+		st.remoteClosed = true
+	}
+	done := st.localClosed && st.remoteClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if done {
+		s.removeStream(id)
+	}
+}
+
+func (s *Session) handleData(f *DataFrame) {
+	st := s.stream(f.StreamId)
+	if st == nil {
+		s.reset(f.StreamId, InvalidStream)
+		return
+	}
+	st.mu.Lock()
+	if st.header == nil || st.remoteClosed {
+		st.mu.Unlock()
+		s.reset(f.StreamId, ProtocolError)
+		return
+	}
+	if st.buf.Len()+len(f.Data) > maxStreamBuffer {
+		st.mu.Unlock()
+		s.reset(f.StreamId, FlowControlError)
+		return
+	}
+	st.buf.Write(f.Data)
+	if f.Flags&DataFlagFin != 0 {
+		st.remoteClosed = true
+	}
+	done := st.localClosed && st.remoteClosed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	if done {
+		s.removeStream(f.StreamId)
+	}
+}
+
+func (s *Session) handlePing(f *PingFrame) {
+	if !s.isLocalId(f.Id) {
+		s.writeFrame(&PingFrame{Id: f.Id})
+		return
+	}
+	s.mu.Lock()
+	ch := s.pings[f.Id]
+	s.pings[f.Id] = nil, false
+	s.mu.Unlock()
+	if ch != nil {
+		ch <- true
+	}
+}
+
+func (s *Session) handleGoAway(f *GoAwayFrame) {
+	s.mu.Lock()
+	s.peerGoneAway = true
+	var refused []*Stream
+	for id, st := range s.streams {
+		if st.local && id > f.LastGoodStreamId {
+			refused = append(refused, st)
+			s.streams[id] = nil, false
+		}
+	}
+	s.mu.Unlock()
+	for _, st := range refused {
+		st.fail(&StreamError{st.Id, RefusedStream})
+	}
+}
+
+// A Stream is one bidirectional SPDY stream within a Session. Read
+// returns the DATA sent by the peer and Write sends DATA to it; each
+// direction is closed independently.
+type Stream struct {
+	Id      uint32
+	session *Session
+	local   bool // opened by this side of the session
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	header       http.Header // SYN_STREAM or SYN_REPLY headers from the peer
+	buf          bytes.Buffer
+	replied      bool // SYN_REPLY sent, for peer-initiated streams
+	localClosed  bool
+	remoteClosed bool
+	err          os.Error
+}
+
+func newStream(s *Session, id uint32, local bool) *Stream {
+	st := &Stream{Id: id, session: s, local: local}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// fail wakes every waiter on st with err.
+func (st *Stream) fail(err os.Error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.cond.Broadcast()
+}
+
+// Header returns the headers sent by the peer: the SYN_STREAM
+// headers for an accepted stream, or the SYN_REPLY headers for an
+// opened one, in which case Header waits for the reply to arrive.
+// Headers from later HEADERS frames are merged in as they arrive.
+func (st *Stream) Header() (http.Header, os.Error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.header == nil && st.err == nil {
+		st.cond.Wait()
+	}
+	if st.header == nil {
+		return nil, st.err
+	}
+	return st.header, nil
+}
+
+// Reply sends the SYN_REPLY for a stream opened by the peer. If fin
+// is set, the stream is half-closed and no data may be written.
+func (st *Stream) Reply(h http.Header, fin bool) os.Error {
+	st.mu.Lock()
+	if st.local || st.replied {
+		st.mu.Unlock()
+		return ErrReplied
+	}
+	if st.err != nil {
+		err := st.err
+		st.mu.Unlock()
+		return err
+	}
+	st.replied = true
+	st.localClosed = fin
+	done := st.localClosed && st.remoteClosed
+	st.mu.Unlock()
+
+	frame := &SynReplyFrame{StreamId: st.Id, Headers: h}
+	if fin {
+		frame.CFHeader.Flags = ControlFlagFin
+	}
+	err := st.session.writeFrame(frame)
+	if done {
+		st.session.removeStream(st.Id)
+	}
+	return err
+}
+
+// Read reads data sent by the peer. It returns os.EOF once the peer
+// has half-closed the stream and all data has been read.
+func (st *Stream) Read(p []byte) (n int, err os.Error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.buf.Len() == 0 && !st.remoteClosed && st.err == nil {
+		st.cond.Wait()
+	}
+	if st.buf.Len() > 0 {
+		return st.buf.Read(p)
+	}
+	if st.err != nil {
+		return 0, st.err
+	}
+	return 0, os.EOF
+}
+
+// Write sends p to the peer in one or more DATA frames. A stream
+// opened by the peer must be replied to before it is written.
+func (st *Stream) Write(p []byte) (n int, err os.Error) {
+	if err = st.checkWritable(); err != nil {
+		return 0, err
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxDataChunk {
+			chunk = chunk[:maxDataChunk]
+		}
+		if err = st.session.writeFrame(&DataFrame{StreamId: st.Id, Data: chunk}); err != nil {
+			return
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+func (st *Stream) checkWritable() os.Error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch {
+	case st.err != nil:
+		return st.err
+	case st.localClosed:
+		return ErrStreamClosed
+	case !st.local && !st.replied:
+		return ErrNotReplied
+	}
+	return nil
+}
+
+// Close half-closes the stream, telling the peer that no more data
+// will be written. A peer-initiated stream that was never replied
+// to is replied to with empty headers.
+func (st *Stream) Close() os.Error {
+	st.mu.Lock()
+	if st.err != nil || st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	if !st.local && !st.replied {
+		st.mu.Unlock()
+		return st.Reply(http.Header{}, true)
+	}
+	st.localClosed = true
+	done := st.remoteClosed
+	st.mu.Unlock()
+
+	err := st.session.writeFrame(&DataFrame{StreamId: st.Id, Flags: DataFlagFin})
+	if done {
+		st.session.removeStream(st.Id)
+	}
+	return err
+}
+
+// Reset abruptly terminates the stream in both directions by
+// sending RST_STREAM with the given status.
+func (st *Stream) Reset(status StatusCode) os.Error {
+	st.mu.Lock()
+	finished := st.err != nil || st.localClosed && st.remoteClosed
+	st.mu.Unlock()
+	if finished {
+		return nil
+	}
+	return st.session.reset(st.Id, status)
+}
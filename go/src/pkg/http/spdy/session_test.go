@@ -0,0 +1,348 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spdy
+
+import (
+	"bytes"
+	"fmt"
+	"http"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSessionPair(t *testing.T) (client, server *Session) {
+	c, s := net.Pipe()
+	client, err := NewSession(c, false)
+	if err != nil {
+		t.Fatal("NewSession(client):", err)
+	}
+	server, err = NewSession(s, true)
+	if err != nil {
+		t.Fatal("NewSession(server):", err)
+	}
+	return
+}
+
+func TestSessionStream(t *testing.T) {
+	client, server := newSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		st, err := server.Accept()
+		if err != nil {
+			t.Error("Accept:", err)
+			return
+		}
+		h, _ := st.Header()
+		body, _ := ioutil.ReadAll(st)
+		st.Reply(http.Header{"Echo": {h.Get("Greeting")}}, false)
+		st.Write(bytes.ToUpper(body))
+		st.Close()
+	}()
+
+	st, err := client.OpenStream(http.Header{"Greeting": {"hello"}}, false)
+	if err != nil {
+		t.Fatal("OpenStream:", err)
+	}
+	if st.Id != 1 {
+		t.Errorf("first client stream id = %d; want 1", st.Id)
+	}
+	st.Write([]byte("ping"))
+	st.Close()
+	h, err := st.Header()
+	if err != nil {
+		t.Fatal("Header:", err)
+	}
+	if g := h.Get("Echo"); g != "hello" {
+		t.Errorf("reply header Echo = %q; want %q", g, "hello")
+	}
+	body, err := ioutil.ReadAll(st)
+	if err != nil {
+		t.Fatal("ReadAll:", err)
+	}
+	if string(body) != "PING" {
+		t.Errorf("body = %q; want %q", body, "PING")
+	}
+	if _, err := st.Write([]byte("x")); err != ErrStreamClosed {
+		t.Errorf("Write after Close = %v; want ErrStreamClosed", err)
+	}
+}
+
+func TestSessionReset(t *testing.T) {
+	client, server := newSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		st, err := server.Accept()
+		if err != nil {
+			return
+		}
+		st.Reset(RefusedStream)
+	}()
+
+	st, err := client.OpenStream(http.Header{}, true)
+	if err != nil {
+		t.Fatal("OpenStream:", err)
+	}
+	_, err = st.Header()
+	se, ok := err.(*StreamError)
+	if !ok || se.Status != RefusedStream || se.StreamId != st.Id {
+		t.Errorf("Header error = %v; want RefusedStream on stream %d", err, st.Id)
+	}
+}
+
+func TestSessionPing(t *testing.T) {
+	client, server := newSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	for _, s := range []*Session{client, server} {
+		if _, err := s.Ping(); err != nil {
+			t.Errorf("server=%v: Ping: %v", s.server, err)
+		}
+	}
+}
+
+func TestSessionGoAway(t *testing.T) {
+	client, server := newSessionPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream(http.Header{}, true)
+	if err != nil {
+		t.Fatal("OpenStream:", err)
+	}
+	accepted, err := server.Accept()
+	if err != nil {
+		t.Fatal("Accept:", err)
+	}
+	if err := server.GoAway(); err != nil {
+		t.Fatal("GoAway:", err)
+	}
+	// A ping round trip guarantees the client has seen the GOAWAY.
+	if _, err := client.Ping(); err != nil {
+		t.Fatal("Ping:", err)
+	}
+	if _, err := client.OpenStream(http.Header{}, true); err != ErrGoAway {
+		t.Errorf("OpenStream after GOAWAY = %v; want ErrGoAway", err)
+	}
+
+	// The stream accepted before GOAWAY still completes.
+	accepted.Reply(http.Header{"Status": {"200 OK"}}, true)
+	if _, err := st.Header(); err != nil {
+		t.Errorf("Header on pre-GOAWAY stream: %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := newSessionPair(t)
+	defer server.Close()
+
+	st, err := client.OpenStream(http.Header{}, false)
+	if err != nil {
+		t.Fatal("OpenStream:", err)
+	}
+	if _, err := server.Accept(); err != nil {
+		t.Fatal("Accept:", err)
+	}
+	client.Close()
+	if _, err := st.Read(make([]byte, 1)); err != ErrSessionClosed {
+		t.Errorf("Read after session Close = %v; want ErrSessionClosed", err)
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Errorf("server Accept succeeded after client Close")
+	}
+}
+
+func TestSessionBadHeaderBlock(t *testing.T) {
+	c, sc := net.Pipe()
+	defer c.Close()
+	server, err := NewSession(sc, true)
+	if err != nil {
+		t.Fatal("NewSession:", err)
+	}
+	defer server.Close()
+
+	// An uncompressed header block cannot be decoded by the
+	// server's zlib stream.
+	framer, err := NewFramer(c, c)
+	if err != nil {
+		t.Fatal("NewFramer:", err)
+	}
+	framer.headerCompressionDisabled = true
+	go framer.WriteFrame(&SynStreamFrame{StreamId: 1, Headers: http.Header{"Url": {"/"}}})
+
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatal("ReadFrame:", err)
+	}
+	if _, ok := frame.(*GoAwayFrame); !ok {
+		t.Fatalf("server sent %#v; want GOAWAY", frame)
+	}
+	if _, err := framer.ReadFrame(); err == nil {
+		t.Errorf("session still open after a bad header block")
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Errorf("Accept succeeded after a bad header block")
+	}
+}
+
+func TestSessionBadHeaders(t *testing.T) {
+	c, sc := net.Pipe()
+	defer c.Close()
+	server, err := NewSession(sc, true)
+	if err != nil {
+		t.Fatal("NewSession:", err)
+	}
+	defer server.Close()
+
+	// The framer lowercases header names, so this header block
+	// names "url" twice. It decompresses fine, so only the stream
+	// is reset and the session carries on.
+	framer, err := NewFramer(c, c)
+	if err != nil {
+		t.Fatal("NewFramer:", err)
+	}
+	go func() {
+		framer.WriteFrame(&SynStreamFrame{StreamId: 1, Headers: http.Header{"Url": {"/"}, "url": {"/x"}}})
+		framer.WriteFrame(&SynStreamFrame{StreamId: 3, Headers: http.Header{"Url": {"/"}}})
+	}()
+
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		t.Fatal("ReadFrame:", err)
+	}
+	if rst, ok := frame.(*RstStreamFrame); !ok || rst.StreamId != 1 || rst.Status != ProtocolError {
+		t.Fatalf("server sent %#v; want RST_STREAM for stream 1", frame)
+	}
+	st, err := server.Accept()
+	if err != nil {
+		t.Fatal("Accept:", err)
+	}
+	if st.Id != 3 {
+		t.Errorf("accepted stream %d; want 3", st.Id)
+	}
+}
+
+func TestTransportServeConn(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Connection", "close")
+		fmt.Fprintf(w, "%s %s %s host=%s body=%s", r.Proto, r.Method, r.URL.Path, r.Host, body)
+	})
+	dials := 0
+	tr := &Transport{
+		Dial: func(_, addr string) (net.Conn, os.Error) {
+			dials++
+			c, s := net.Pipe()
+			go ServeConn(s, handler)
+			return c, nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+	ht := new(http.Transport)
+	ht.RegisterProtocol("spdy", tr)
+	client := &http.Client{Transport: ht}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Post("spdy://example.com/foo", "text/plain", strings.NewReader("data"))
+		if err != nil {
+			t.Fatalf("request %d: Post: %v", i, err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("request %d: ReadAll: %v", i, err)
+		}
+		if res.StatusCode != 200 {
+			t.Errorf("request %d: StatusCode = %d; want 200", i, res.StatusCode)
+		}
+		if g := res.Header.Get("Connection"); g != "" {
+			t.Errorf("request %d: Connection header %q was sent over SPDY", i, g)
+		}
+		if e := "HTTP/1.1 POST /foo host=example.com body=data"; string(body) != e {
+			t.Errorf("request %d: body = %q; want %q", i, body, e)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("dialed %d times; want one session for both requests", dials)
+	}
+}
+
+func TestTransportConcurrentDial(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	})
+	var mu sync.Mutex
+	dials := make(map[string]int)
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	tr := &Transport{
+		Dial: func(_, addr string) (net.Conn, os.Error) {
+			mu.Lock()
+			dials[addr]++
+			mu.Unlock()
+			if addr == "slow.example:443" {
+				select {
+				case started <- true:
+				default:
+				}
+				<-release
+			}
+			c, s := net.Pipe()
+			go ServeConn(s, handler)
+			return c, nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+	get := func(host string, done chan os.Error) {
+		req, _ := http.NewRequest("GET", "spdy://"+host+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		done <- err
+	}
+
+	slow := make(chan os.Error, 3)
+	go get("slow.example", slow)
+	<-started
+	go get("slow.example", slow)
+	go get("slow.example", slow)
+
+	// A dial to one host does not hold up requests to another.
+	fast := make(chan os.Error, 1)
+	go get("fast.example", fast)
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Errorf("fast.example: %v", err)
+		}
+	case <-time.After(5e9):
+		close(release)
+		t.Fatal("request to fast.example waited for the dial of slow.example")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-slow; err != nil {
+			t.Errorf("slow.example: %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if n := dials["slow.example:443"]; n != 1 {
+		t.Errorf("dialed slow.example %d times; want the requests to share one dial", n)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package spdy
+
+import (
+	"crypto/tls"
+	"http"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NextProto is the protocol name negotiated via TLS NPN for this
+// version of SPDY.
+const NextProto = "spdy/2"
+
+// Transport is an http.RoundTripper that sends requests over SPDY
+// sessions, keeping one session per host:port. It is intended to be
+// registered with an http.Transport:
+//
+//	t := new(http.Transport)
+//	t.RegisterProtocol("spdy", new(spdy.Transport))
+//	c := &http.Client{Transport: t}
+//	res, err := c.Get("spdy://www.google.com/")
+//
+// The request is sent to the server as an https URL.
+type Transport struct {
+	// Dial specifies the dial function for creating sessions.
+	// If Dial is nil, the Transport dials a TLS connection and
+	// requires the server to negotiate NextProto.
+	Dial func(net, addr string) (c net.Conn, err os.Error)
+
+	lk       sync.Mutex
+	sessions map[string]*Session
+	dials    map[string]*dialCall // sessions being dialed, by host:port
+}
+
+// A dialCall is a session being dialed, which every getSession
+// caller for its host:port waits for.
+type dialCall struct {
+	done chan bool // closed once s and err are set
+	s    *Session
+	err  os.Error
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, os.Error) {
+	if req.URL == nil {
+		return nil, os.NewError("spdy: nil Request.URL")
+	}
+	addr := req.URL.Host
+	if !hasPort(addr) {
+		addr += ":443"
+	}
+	h := requestHeader(req)
+
+	var st *Stream
+	for tries := 0; ; tries++ {
+		s, err := t.getSession(addr)
+		if err != nil {
+			return nil, err
+		}
+		st, err = s.OpenStream(h, req.Body == nil)
+		if err == nil {
+			break
+		}
+		t.dropSession(addr, s)
+		if err != ErrGoAway || tries > 0 {
+			return nil, err
+		}
+	}
+
+	if req.Body != nil {
+		go func() {
+			_, err := io.Copy(st, req.Body)
+			req.Body.Close()
+			if err != nil {
+				st.Reset(Cancel)
+				return
+			}
+			st.Close()
+		}()
+	}
+
+	rh, err := st.Header()
+	if err != nil {
+		return nil, err
+	}
+	return responseFromHeader(rh, req, st)
+}
+
+// CloseIdleConnections closes every session the Transport holds.
+// Requests in flight on those sessions fail.
+func (t *Transport) CloseIdleConnections() {
+	t.lk.Lock()
+	sessions := t.sessions
+	t.sessions = nil
+	t.lk.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+// getSession returns a session to addr that can open streams,
+// dialing one if needed. The dial happens without t.lk held, so
+// requests to other hosts are not held up; concurrent callers for
+// the same host share it.
+func (t *Transport) getSession(addr string) (*Session, os.Error) {
+	t.lk.Lock()
+	if s := t.sessions[addr]; s != nil {
+		if s.canOpen() {
+			t.lk.Unlock()
+			return s, nil
+		}
+		t.sessions[addr] = nil, false
+	}
+	if d := t.dials[addr]; d != nil {
+		t.lk.Unlock()
+		<-d.done
+		return d.s, d.err
+	}
+	d := &dialCall{done: make(chan bool)}
+	if t.dials == nil {
+		t.dials = make(map[string]*dialCall)
+	}
+	t.dials[addr] = d
+	t.lk.Unlock()
+
+	d.s, d.err = t.newSession(addr)
+
+	t.lk.Lock()
+	t.dials[addr] = nil, false
+	if d.err == nil {
+		if t.sessions == nil {
+			t.sessions = make(map[string]*Session)
+		}
+		t.sessions[addr] = d.s
+	}
+	t.lk.Unlock()
+	close(d.done)
+	return d.s, d.err
+}
+
+func (t *Transport) newSession(addr string) (*Session, os.Error) {
+	c, err := t.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewSession(c, false)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (t *Transport) dropSession(addr string, s *Session) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if t.sessions[addr] == s {
+		t.sessions[addr] = nil, false
+	}
+}
+
+func (t *Transport) dial(addr string) (net.Conn, os.Error) {
+	if t.Dial != nil {
+		return t.Dial("tcp", addr)
+	}
+	c, err := tls.Dial("tcp", addr, &tls.Config{NextProtos: []string{NextProto}})
+	if err != nil {
+		return nil, err
+	}
+	if p := c.ConnectionState().NegotiatedProtocol; p != NextProto {
+		c.Close()
+		return nil, os.NewError("spdy: server negotiated protocol " + strconv.Quote(p))
+	}
+	return c, nil
+}
+
+func hasPort(s string) bool { return strings.LastIndex(s, ":") > strings.LastIndex(s, "]") }
+
+// requestHeader returns the SYN_STREAM headers for req.
+func requestHeader(req *http.Request) http.Header {
+	h := make(http.Header)
+	for k, vv := range req.Header {
+		if !invalidReqHeaders[k] {
+			h[k] = vv
+		}
+	}
+	u := *req.URL
+	u.Scheme = "https"
+	u.RawUserinfo = ""
+	u.Fragment = ""
+	host := req.Host
+	if host == "" {
+		host = u.Host
+	}
+	h.Set("Method", req.Method)
+	h.Set("Url", u.String())
+	h.Set("Version", "HTTP/1.1")
+	h.Set("Host", host)
+	if req.ContentLength > 0 {
+		h.Set("Content-Length", strconv.Itoa64(req.ContentLength))
+	}
+	return h
+}
+
+// responseFromHeader builds an http.Response from the SYN_REPLY
+// headers of st. SPDY/2 carries the status line in the "status"
+// and "version" headers.
+func responseFromHeader(h http.Header, req *http.Request, st *Stream) (*http.Response, os.Error) {
+	status, vers := h.Get("Status"), h.Get("Version")
+	if len(status) < 3 || vers == "" {
+		st.Reset(ProtocolError)
+		return nil, &Error{InvalidHeaderPresent, st.Id}
+	}
+	code, err := strconv.Atoi(status[:3])
+	if err != nil {
+		st.Reset(ProtocolError)
+		return nil, &Error{InvalidHeaderPresent, st.Id}
+	}
+	res := &http.Response{
+		Status:        status,
+		StatusCode:    code,
+		Proto:         strings.ToUpper(vers),
+		Header:        make(http.Header),
+		Body:          &responseBody{st: st},
+		ContentLength: -1,
+		Request:       req,
+	}
+	var ok bool
+	if res.ProtoMajor, res.ProtoMinor, ok = http.ParseHTTPVersion(res.Proto); !ok {
+		st.Reset(ProtocolError)
+		return nil, &Error{InvalidHeaderPresent, st.Id}
+	}
+	for k, vv := range h {
+		if k != "Status" && k != "Version" {
+			res.Header[k] = vv
+		}
+	}
+	if cl := res.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi64(cl); err == nil && n >= 0 {
+			res.ContentLength = n
+		}
+	}
+	return res, nil
+}
+
+// responseBody is a response body backed by a stream. Closing it
+// before EOF cancels the stream.
+type responseBody struct {
+	st  *Stream
+	eof bool
+}
+
+func (b *responseBody) Read(p []byte) (n int, err os.Error) {
+	n, err = b.st.Read(p)
+	if err == os.EOF {
+		b.eof = true
+	}
+	return
+}
+
+func (b *responseBody) Close() os.Error {
+	if !b.eof {
+		b.st.Reset(Cancel)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package http
 import (
 	"bufio"
 	"compress/gzip"
+	"container/list"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -17,6 +18,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 	"url"
 )
 
@@ -35,13 +37,13 @@ const DefaultMaxIdleConnsPerHost = 2
 // https, and http proxies (for either http or https with CONNECT).
 // Transport can also cache connections for future re-use.
 type Transport struct {
-	lk       sync.Mutex
-	idleConn map[string][]*persistConn
-	altProto map[string]RoundTripper // nil or map of URI scheme => RoundTripper
-
-	// TODO: tunable on global max cached connections
-	// TODO: tunable on timeout on cached connections
-	// TODO: optional pipelining
+	lk        sync.Mutex
+	idleConn  map[string][]*persistConn
+	idleLRU   *list.List                // of *persistConn, least recently used first
+	connCount map[string]int            // open or dialing connections per cache key
+	connWait  map[string][]*connWaiter  // getConn callers waiting for a connection
+	pipeConn  map[string][]*persistConn // busy connections accepting pipelined requests
+	altProto  map[string]RoundTripper   // nil or map of URI scheme => RoundTripper
 
 	// Proxy specifies a function to return a proxy for a given
 	// Request. If the function returns a non-nil error, the
@@ -61,6 +63,33 @@ type Transport struct {
 	// (keep-alive) to keep to keep per-host.  If zero,
 	// DefaultMaxIdleConnsPerHost is used.
 	MaxIdleConnsPerHost int
+
+	// MaxIdleConns, if non-zero, controls the maximum number of
+	// idle (keep-alive) connections kept across all hosts.  When
+	// the limit is exceeded, the least recently used idle
+	// connection is closed.
+	MaxIdleConns int
+
+	// IdleConnTimeout, if non-zero, is the maximum time in
+	// nanoseconds an idle (keep-alive) connection remains cached
+	// before it is closed.
+	IdleConnTimeout int64
+
+	// MaxConnsPerHost, if non-zero, limits the number of
+	// connections, active or idle, to each host.  A request that
+	// would exceed the limit waits until another request to the
+	// same host finishes with its connection.
+	MaxConnsPerHost int
+
+	// MaxPipelineDepth, if greater than one, enables HTTP/1.1
+	// pipelining: up to MaxPipelineDepth idempotent requests
+	// without a body (GET, HEAD, OPTIONS, TRACE, PUT and DELETE)
+	// may be written on one connection before their responses
+	// arrive.  Responses are still returned in request order.
+	// Pipelined requests that get no response because the
+	// connection failed or was closed by the server are retried on
+	// another connection.
+	MaxPipelineDepth int
 }
 
 // ProxyFromEnvironment returns the URL of the proxy to use for a
@@ -121,18 +150,59 @@ func (t *Transport) RoundTrip(req *Request) (resp *Response, err os.Error) {
 		return nil, err
 	}
 
-	// Get the cached or newly-created connection to either the
-	// host (for http or https), the http proxy, or the http proxy
-	// pre-CONNECTed to https server.  In any case, we'll be ready
-	// to send it requests.
-	pconn, err := t.getConn(cm)
-	if err != nil {
-		return nil, err
+	pipeline := t.canPipeline(req)
+	for try := 0; ; try++ {
+		// Get the cached or newly-created connection to either the
+		// host (for http or https), the http proxy, or the http proxy
+		// pre-CONNECTed to https server.  In any case, we'll be ready
+		// to send it requests.
+		pconn, err := t.getConn(cm, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = pconn.roundTrip(req, pipeline)
+		if ue, ok := err.(*unansweredError); ok {
+			if try < maxPipelineRetries {
+				continue
+			}
+			err = ue.err
+		}
+		return resp, err
 	}
+	panic("not reached")
+}
 
-	return pconn.roundTrip(req)
+// maxPipelineRetries is the number of times a pipelined request
+// that went unanswered is retried on another connection.
+const maxPipelineRetries = 3
+
+// canPipeline reports whether req may be pipelined, and therefore
+// also safely retried if it goes unanswered.
+func (t *Transport) canPipeline(req *Request) bool {
+	if t.MaxPipelineDepth <= 1 || t.DisableKeepAlives || req.Close || req.Body != nil {
+		return false
+	}
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
 }
 
+// An unansweredError is the error for a request that was not sent,
+// or was sent but received no part of a response, before its
+// connection failed.
+type unansweredError struct {
+	err os.Error
+}
+
+func (e *unansweredError) String() string { return e.err.String() }
+
+// errUnanswered is the unansweredError cause for requests still
+// queued on a connection after it stopped reading responses.
+var errUnanswered = os.NewError("http: connection closed before response was received")
+
 // RegisterProtocol registers a new protocol with scheme.
 // The Transport will pass requests using the given scheme to rt.
 // It is rt's responsibility to simulate HTTP request semantics.
@@ -166,10 +236,13 @@ func (t *Transport) CloseIdleConnections() {
 	}
 	for _, conns := range t.idleConn {
 		for _, pconn := range conns {
+			pconn.stopIdleTimer()
+			pconn.idleElem = nil
 			pconn.close()
 		}
 	}
 	t.idleConn = nil
+	t.idleLRU = nil
 }
 
 //
@@ -214,6 +287,11 @@ func (cm *connectMethod) proxyAuth() string {
 func (t *Transport) putIdleConn(pconn *persistConn) {
 	t.lk.Lock()
 	defer t.lk.Unlock()
+	if pconn.expectingResponse() {
+		// Pipelined requests are still outstanding.
+		return
+	}
+	t.removePipeConnLocked(pconn)
 	if t.DisableKeepAlives || t.MaxIdleConnsPerHost < 0 {
 		pconn.close()
 		return
@@ -222,6 +300,12 @@ func (t *Transport) putIdleConn(pconn *persistConn) {
 		return
 	}
 	key := pconn.cacheKey
+	if waiters := t.connWait[key]; len(waiters) > 0 {
+		// Hand the connection straight to a getConn
+		// caller blocked on MaxConnsPerHost.
+		t.popConnWaiterLocked(key).ch <- connGrant{pconn, false}
+		return
+	}
 	max := t.MaxIdleConnsPerHost
 	if max == 0 {
 		max = DefaultMaxIdleConnsPerHost
@@ -230,30 +314,38 @@ func (t *Transport) putIdleConn(pconn *persistConn) {
 		pconn.close()
 		return
 	}
-	t.idleConn[key] = append(t.idleConn[key], pconn)
-}
-
-func (t *Transport) getIdleConn(cm *connectMethod) (pconn *persistConn) {
-	t.lk.Lock()
-	defer t.lk.Unlock()
 	if t.idleConn == nil {
 		t.idleConn = make(map[string][]*persistConn)
 	}
-	key := cm.String()
+	t.idleConn[key] = append(t.idleConn[key], pconn)
+	if t.idleLRU == nil {
+		t.idleLRU = list.New()
+	}
+	pconn.idleElem = t.idleLRU.PushBack(pconn)
+	if t.IdleConnTimeout > 0 {
+		pconn.idleGen++
+		gen := pconn.idleGen
+		pconn.idleTimer = time.AfterFunc(t.IdleConnTimeout, func() {
+			t.closeIdleConnIfUnused(pconn, gen)
+		})
+	}
+	if t.MaxIdleConns > 0 && t.idleLRU.Len() > t.MaxIdleConns {
+		oldest := t.idleLRU.Front().Value.(*persistConn)
+		t.removeIdleConnLocked(oldest)
+		oldest.close()
+	}
+}
+
+// getIdleConnLocked removes and returns the most recently used idle
+// connection for key, or nil if there is none.  t.lk must be held.
+func (t *Transport) getIdleConnLocked(key string) (pconn *persistConn) {
 	for {
-		pconns, ok := t.idleConn[key]
-		if !ok {
+		pconns := t.idleConn[key]
+		if len(pconns) == 0 {
 			return nil
 		}
-		if len(pconns) == 1 {
-			pconn = pconns[0]
-			t.idleConn[key] = nil, false
-		} else {
-			// 2 or more cached connections; pop last
-			// TODO: queue?
-			pconn = pconns[len(pconns)-1]
-			t.idleConn[key] = pconns[0 : len(pconns)-1]
-		}
+		pconn = pconns[len(pconns)-1]
+		t.removeIdleConnLocked(pconn)
 		if !pconn.isBroken() {
 			return
 		}
@@ -261,6 +353,210 @@ func (t *Transport) getIdleConn(cm *connectMethod) (pconn *persistConn) {
 	return
 }
 
+// removeIdleConnLocked removes pconn from the idle connection cache,
+// if it is there.  t.lk must be held.
+func (t *Transport) removeIdleConnLocked(pconn *persistConn) {
+	if pconn.idleElem == nil {
+		return
+	}
+	pconn.stopIdleTimer()
+	t.idleLRU.Remove(pconn.idleElem)
+	pconn.idleElem = nil
+
+	key := pconn.cacheKey
+	pconns := t.idleConn[key]
+	for i, pc := range pconns {
+		if pc != pconn {
+			continue
+		}
+		copy(pconns[i:], pconns[i+1:])
+		pconns = pconns[:len(pconns)-1]
+		break
+	}
+	if len(pconns) == 0 {
+		t.idleConn[key] = nil, false
+	} else {
+		t.idleConn[key] = pconns
+	}
+}
+
+// closeIdleConnIfUnused closes pconn if it has been sitting in the
+// idle cache since its idle timer with generation gen was started.
+func (t *Transport) closeIdleConnIfUnused(pconn *persistConn, gen int) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if pconn.idleElem == nil || pconn.idleGen != gen {
+		return
+	}
+	t.removeIdleConnLocked(pconn)
+	pconn.close()
+}
+
+// A connWaiter is a getConn caller blocked on MaxConnsPerHost.
+type connWaiter struct {
+	pipeline bool           // the caller may share a pipelined connection
+	ch       chan connGrant // buffered
+}
+
+// A connGrant is what a connWaiter is woken with: an idle
+// connection, a pipelined connection already expecting the
+// caller's response (shared is true), or, if pc is nil, permission
+// to dial.
+type connGrant struct {
+	pc     *persistConn
+	shared bool
+}
+
+// popConnWaiterLocked removes and returns the longest waiting
+// getConn caller for key.  t.lk must be held.
+func (t *Transport) popConnWaiterLocked(key string) *connWaiter {
+	waiters := t.connWait[key]
+	w := waiters[0]
+	if len(waiters) == 1 {
+		t.connWait[key] = nil, false
+	} else {
+		t.connWait[key] = waiters[1:]
+	}
+	return w
+}
+
+// getPipeConnLocked returns the least loaded connection for key on
+// which another request may be pipelined, or nil if there is none.
+// The returned connection is already expecting the request's
+// response.  t.lk must be held.
+func (t *Transport) getPipeConnLocked(key string) *persistConn {
+	var best *persistConn
+	bestN := 0
+	for _, pc := range t.pipeConn[key] {
+		n := pc.numPending()
+		if pc.isBroken() || n >= t.MaxPipelineDepth {
+			continue
+		}
+		if best == nil || n < bestN {
+			best, bestN = pc, n
+		}
+	}
+	if best != nil {
+		best.expectResponse()
+	}
+	return best
+}
+
+// addPipeConnLocked makes pconn available for pipelined requests.
+// t.lk must be held.
+func (t *Transport) addPipeConnLocked(pconn *persistConn) {
+	if pconn.pipelined {
+		return
+	}
+	pconn.pipelined = true
+	key := pconn.cacheKey
+	if t.pipeConn == nil {
+		t.pipeConn = make(map[string][]*persistConn)
+	}
+	t.pipeConn[key] = append(t.pipeConn[key], pconn)
+
+	// Callers blocked on MaxConnsPerHost may pipeline on it too.
+	var kept []*connWaiter
+	for _, w := range t.connWait[key] {
+		if w.pipeline && pconn.numPending() < t.MaxPipelineDepth {
+			pconn.expectResponse()
+			w.ch <- connGrant{pconn, true}
+			continue
+		}
+		kept = append(kept, w)
+	}
+	if len(kept) == 0 {
+		t.connWait[key] = nil, false
+	} else {
+		t.connWait[key] = kept
+	}
+}
+
+// removePipeConnLocked stops pipelining requests on pconn.
+// t.lk must be held.
+func (t *Transport) removePipeConnLocked(pconn *persistConn) {
+	if !pconn.pipelined {
+		return
+	}
+	pconn.pipelined = false
+	key := pconn.cacheKey
+	pconns := t.pipeConn[key]
+	for i, pc := range pconns {
+		if pc == pconn {
+			copy(pconns[i:], pconns[i+1:])
+			pconns = pconns[:len(pconns)-1]
+			break
+		}
+	}
+	if len(pconns) == 0 {
+		t.pipeConn[key] = nil, false
+	} else {
+		t.pipeConn[key] = pconns
+	}
+}
+
+// reserveConn returns an idle connection for key if there is one,
+// or, if pipeline is true, a busy connection on which to pipeline
+// the request, in which case shared is true.  Otherwise it reserves
+// room for a new connection to be dialed, waiting for another
+// connection to key to become idle or be closed if MaxConnsPerHost
+// connections are already open.  If reserveConn returns nil, the
+// caller must dial and, on failure, call releaseConn.
+func (t *Transport) reserveConn(key string, pipeline bool) (pc *persistConn, shared bool) {
+	t.lk.Lock()
+	if pc := t.getIdleConnLocked(key); pc != nil {
+		t.lk.Unlock()
+		return pc, false
+	}
+	if pipeline {
+		if pc := t.getPipeConnLocked(key); pc != nil {
+			t.lk.Unlock()
+			return pc, true
+		}
+	}
+	if t.connCount == nil {
+		t.connCount = make(map[string]int)
+	}
+	if t.MaxConnsPerHost <= 0 || t.connCount[key] < t.MaxConnsPerHost {
+		t.connCount[key]++
+		t.lk.Unlock()
+		return nil, false
+	}
+	w := &connWaiter{pipeline, make(chan connGrant, 1)}
+	if t.connWait == nil {
+		t.connWait = make(map[string][]*connWaiter)
+	}
+	t.connWait[key] = append(t.connWait[key], w)
+	t.lk.Unlock()
+
+	g := <-w.ch
+	return g.pc, g.shared
+}
+
+// releaseConn records that a connection to key, or an attempt to
+// dial one, has ended.
+func (t *Transport) releaseConn(key string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if len(t.connWait[key]) > 0 {
+		t.popConnWaiterLocked(key).ch <- connGrant{}
+		return
+	}
+	if t.connCount[key]--; t.connCount[key] <= 0 {
+		t.connCount[key] = 0, false
+	}
+}
+
+// connDone is called by pconn's readLoop when the connection has
+// been closed and will not be used again.
+func (t *Transport) connDone(pconn *persistConn) {
+	t.lk.Lock()
+	t.removeIdleConnLocked(pconn)
+	t.removePipeConnLocked(pconn)
+	t.lk.Unlock()
+	t.releaseConn(pconn.cacheKey)
+}
+
 func (t *Transport) dial(network, addr string) (c net.Conn, err os.Error) {
 	if t.Dial != nil {
 		return t.Dial(network, addr)
@@ -271,12 +567,37 @@ func (t *Transport) dial(network, addr string) (c net.Conn, err os.Error) {
 // getConn dials and creates a new persistConn to the target as
 // specified in the connectMethod.  This includes doing a proxy CONNECT
 // and/or setting up TLS.  If this doesn't return an error, the persistConn
-// is ready to write requests to.
-func (t *Transport) getConn(cm *connectMethod) (*persistConn, os.Error) {
-	if pc := t.getIdleConn(cm); pc != nil {
-		return pc, nil
+// is ready to write requests to, and expects one more response.
+//
+// If pipeline is true, the request may share a connection with
+// other pipelined requests still waiting for their responses.
+func (t *Transport) getConn(cm *connectMethod, pipeline bool) (*persistConn, os.Error) {
+	key := cm.String()
+	pconn, shared := t.reserveConn(key, pipeline)
+	if shared {
+		// Already expecting our response.
+		return pconn, nil
 	}
+	if pconn == nil {
+		var err os.Error
+		pconn, err = t.dialConn(cm)
+		if err != nil {
+			t.releaseConn(key)
+			return nil, err
+		}
+	}
+	pconn.expectResponse()
+	if pipeline {
+		t.lk.Lock()
+		t.addPipeConnLocked(pconn)
+		t.lk.Unlock()
+	}
+	return pconn, nil
+}
 
+// dialConn dials and creates a new persistConn as described for
+// getConn.
+func (t *Transport) dialConn(cm *connectMethod) (*persistConn, os.Error) {
 	conn, err := t.dial("tcp", cm.addr())
 	if err != nil {
 		if cm.proxyURL != nil {
@@ -292,6 +613,10 @@ func (t *Transport) getConn(cm *connectMethod) (*persistConn, os.Error) {
 		cacheKey: cm.String(),
 		conn:     conn,
 		reqch:    make(chan requestAndChan, 50),
+		closech:  make(chan bool),
+	}
+	if t.MaxPipelineDepth > cap(pconn.reqch) {
+		pconn.reqch = make(chan requestAndChan, t.MaxPipelineDepth)
 	}
 	newClientConnFunc := NewClientConn
 
@@ -468,11 +793,30 @@ type persistConn struct {
 	cc                *ClientConn
 	br                *bufio.Reader
 	reqch             chan requestAndChan // written by roundTrip(); read by readLoop()
+	closech           chan bool           // closed when the connection is closed
 	mutateRequestFunc func(*Request)      // nil or func to modify each outbound request
 
+	// wlk serializes writing a request with queueing it on reqch,
+	// so that readLoop sees requests in the order they were written.
+	wlk sync.Mutex
+
 	lk                   sync.Mutex // guards numExpectedResponses and broken
 	numExpectedResponses int
 	broken               bool // an error has happened on this connection; marked broken so it's not reused.
+
+	// Guarded by t.lk.
+	idleElem  *list.Element // position in t.idleLRU; nil if not idle
+	idleTimer *time.Timer   // closes the connection after t.IdleConnTimeout
+	idleGen   int           // incremented each time idleTimer is started
+	pipelined bool          // in t.pipeConn
+}
+
+// stopIdleTimer stops pc's idle timeout, if any.  pc.t.lk must be held.
+func (pc *persistConn) stopIdleTimer() {
+	if pc.idleTimer != nil {
+		pc.idleTimer.Stop()
+		pc.idleTimer = nil
+	}
 }
 
 func (pc *persistConn) isBroken() bool {
@@ -487,7 +831,22 @@ func (pc *persistConn) expectingResponse() bool {
 	return pc.numExpectedResponses > 0
 }
 
+func (pc *persistConn) numPending() int {
+	pc.lk.Lock()
+	defer pc.lk.Unlock()
+	return pc.numExpectedResponses
+}
+
+// expectResponse records that a request is about to be sent on pc.
+func (pc *persistConn) expectResponse() {
+	pc.lk.Lock()
+	pc.numExpectedResponses++
+	pc.lk.Unlock()
+}
+
 func (pc *persistConn) readLoop() {
+	defer pc.t.connDone(pc)
+	defer pc.failPending()
 	alive := true
 	for alive {
 		pb, err := pc.br.Peek(1)
@@ -505,8 +864,15 @@ func (pc *persistConn) readLoop() {
 			pc.close()
 			return
 		}
+		peekErr := err
 
-		rc := <-pc.reqch
+		var rc requestAndChan
+		select {
+		case rc = <-pc.reqch:
+		case <-pc.closech:
+			// The request we expected will never be queued.
+			return
+		}
 		resp, err := pc.cc.readUsing(rc.req, func(buf *bufio.Reader, forReq *Request) (*Response, os.Error) {
 			resp, err := ReadResponse(buf, forReq)
 			if err != nil || resp.ContentLength == 0 {
@@ -529,7 +895,14 @@ func (pc *persistConn) readLoop() {
 			resp.Body = &bodyEOFSignal{body: resp.Body}
 			return resp, err
 		})
+		pc.lk.Lock()
+		pc.numExpectedResponses--
+		pc.lk.Unlock()
 
+		if err != nil && err != ErrPersistEOF && peekErr != nil {
+			// Not a byte of the response arrived.
+			err = &unansweredError{err}
+		}
 		if err == ErrPersistEOF {
 			// Succeeded, but we can't send any more
 			// persistent connections on this again.  We
@@ -542,14 +915,20 @@ func (pc *persistConn) readLoop() {
 
 		hasBody := resp != nil && resp.ContentLength != 0
 		var waitForBodyRead chan bool
-		if alive {
-			if hasBody {
-				waitForBodyRead = make(chan bool)
-				resp.Body.(*bodyEOFSignal).fn = func() {
+		if hasBody {
+			// Even when the connection won't be reused, it
+			// stays open (and counts against MaxConnsPerHost)
+			// until the body has been consumed.
+			waitForBodyRead = make(chan bool)
+			resp.Body.(*bodyEOFSignal).fn = func() {
+				if alive {
 					pc.t.putIdleConn(pc)
-					waitForBodyRead <- true
 				}
-			} else {
+				waitForBodyRead <- true
+			}
+		}
+		if alive {
+			if !hasBody {
 				// When there's no response body, we immediately
 				// reuse the TCP connection (putIdleConn), but
 				// we need to prevent ClientConn.Read from
@@ -575,6 +954,22 @@ func (pc *persistConn) readLoop() {
 	}
 }
 
+// failPending closes pc and fails the requests queued on it whose
+// responses will now never be read.
+func (pc *persistConn) failPending() {
+	pc.close()
+	pc.wlk.Lock()
+	defer pc.wlk.Unlock()
+	for {
+		select {
+		case rc := <-pc.reqch:
+			rc.ch <- responseAndError{nil, &unansweredError{errUnanswered}}
+		default:
+			return
+		}
+	}
+}
+
 type responseAndError struct {
 	res *Response
 	err os.Error
@@ -582,7 +977,7 @@ type responseAndError struct {
 
 type requestAndChan struct {
 	req *Request
-	ch  chan responseAndError
+	ch  chan responseAndError // buffered
 
 	// did the Transport (as opposed to the client code) add an
 	// Accept-Encoding gzip header? only if it we set it do
@@ -590,7 +985,16 @@ type requestAndChan struct {
 	addedGzip bool
 }
 
-func (pc *persistConn) roundTrip(req *Request) (resp *Response, err os.Error) {
+// roundTrip sends req on pc, which must already be expecting its
+// response, and waits for the response.  If pipelined is false,
+// unansweredErrors are reported as their underlying error.
+func (pc *persistConn) roundTrip(req *Request, pipelined bool) (resp *Response, err os.Error) {
+	defer func() {
+		if ue, ok := err.(*unansweredError); ok && !pipelined {
+			err = ue.err
+		}
+	}()
+
 	if pc.mutateRequestFunc != nil {
 		pc.mutateRequestFunc(req)
 	}
@@ -608,29 +1012,38 @@ func (pc *persistConn) roundTrip(req *Request) (resp *Response, err os.Error) {
 		req.Header.Set("Accept-Encoding", "gzip")
 	}
 
-	pc.lk.Lock()
-	pc.numExpectedResponses++
-	pc.lk.Unlock()
-
-	err = pc.cc.Write(req)
+	ch := make(chan responseAndError, 1)
+	pc.wlk.Lock()
+	if pc.isBroken() {
+		err = errUnanswered
+	} else {
+		err = pc.cc.Write(req)
+	}
 	if err != nil {
+		pc.wlk.Unlock()
 		pc.close()
-		return
+		if requestedGzip {
+			// Let a retry ask for gzip again.
+			req.Header.Del("Accept-Encoding")
+		}
+		return nil, &unansweredError{err}
 	}
-
-	ch := make(chan responseAndError, 1)
 	pc.reqch <- requestAndChan{req, ch, requestedGzip}
-	re := <-ch
-	pc.lk.Lock()
-	pc.numExpectedResponses--
-	pc.lk.Unlock()
+	pc.wlk.Unlock()
 
+	re := <-ch
+	if _, ok := re.err.(*unansweredError); ok && requestedGzip {
+		req.Header.Del("Accept-Encoding")
+	}
 	return re.res, re.err
 }
 
 func (pc *persistConn) close() {
 	pc.lk.Lock()
 	defer pc.lk.Unlock()
+	if !pc.broken {
+		close(pc.closech)
+	}
 	pc.broken = true
 	pc.cc.Close()
 	pc.conn.Close()
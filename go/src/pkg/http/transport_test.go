@@ -7,6 +7,7 @@
 package http_test
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"crypto/rand"
@@ -15,6 +16,7 @@ import (
 	"http/httptest"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -23,9 +25,6 @@ import (
 	"url"
 )
 
-// TODO: test 5 pipelined requests with responses: 1) OK, 2) OK, Connection: Close
-//       and then verify that the final 2 responses get errors back.
-
 // hostPortHandler writes back the client's "host:port".
 var hostPortHandler = HandlerFunc(func(w ResponseWriter, r *Request) {
 	if r.FormValue("close") == "true" {
@@ -251,6 +250,217 @@ func TestTransportMaxPerHostIdleConns(t *testing.T) {
 	}
 }
 
+// closeNotifyConn sends on closed when it is first closed.
+type closeNotifyConn struct {
+	net.Conn
+	closed chan bool // buffered
+}
+
+func (c *closeNotifyConn) Close() os.Error {
+	select {
+	case c.closed <- true:
+	default:
+	}
+	return c.Conn.Close()
+}
+
+func TestTransportIdleConnTimeout(t *testing.T) {
+	ts := httptest.NewServer(hostPortHandler)
+	defer ts.Close()
+
+	closed := make(chan bool, 1)
+	dialer := func(netz, addr string) (net.Conn, os.Error) {
+		c, err := net.Dial(netz, addr)
+		if err == nil {
+			c = &closeNotifyConn{c, closed}
+		}
+		return c, err
+	}
+	tr := &Transport{IdleConnTimeout: 50e6, Dial: dialer}
+	c := &Client{Transport: tr}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+
+	if e, g := 1, len(tr.IdleConnKeysForTesting()); e != g {
+		t.Fatalf("after Get expected %d idle conn cache keys; got %d", e, g)
+	}
+	select {
+	case <-closed:
+	case <-time.After(10e9):
+		t.Fatalf("idle connection not closed after IdleConnTimeout")
+	}
+	if e, g := 0, len(tr.IdleConnKeysForTesting()); e != g {
+		t.Errorf("after IdleConnTimeout expected %d idle conn cache keys; got %d", e, g)
+	}
+}
+
+func TestTransportMaxIdleConns(t *testing.T) {
+	ts1 := httptest.NewServer(hostPortHandler)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(hostPortHandler)
+	defer ts2.Close()
+
+	tr := &Transport{MaxIdleConns: 1}
+	c := &Client{Transport: tr}
+	for _, u := range []string{ts1.URL, ts2.URL} {
+		res, err := c.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+	}
+
+	// The connection to ts1 is the least recently used and must
+	// have been evicted.
+	keys := tr.IdleConnKeysForTesting()
+	if e, g := 1, len(keys); e != g {
+		t.Fatalf("expected %d idle conn cache keys; got %d", e, g)
+	}
+	if e := "|http|" + ts2.Listener.Addr().String(); keys[0] != e {
+		t.Errorf("expected idle cache key %q; got %q", e, keys[0])
+	}
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	gotReq := make(chan string)
+	release := make(chan bool)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		gotReq <- r.RemoteAddr
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	tr := &Transport{MaxConnsPerHost: 1}
+	c := &Client{Transport: tr}
+
+	const n = 3
+	donech := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			res, err := c.Get(ts.URL)
+			if err != nil {
+				t.Error(err)
+			} else {
+				ioutil.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			donech <- true
+		}()
+	}
+
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addrs = append(addrs, <-gotReq)
+		release <- true
+	}
+	for i := 0; i < n; i++ {
+		<-donech
+	}
+	// The server answers one request at a time on a connection, so
+	// sharing a single connection means none of them ran concurrently.
+	for _, addr := range addrs[1:] {
+		if addr != addrs[0] {
+			t.Errorf("requests used connections %v; want all on one connection", addrs)
+			break
+		}
+	}
+}
+
+// pipelineServer accepts connections on l.  On each, it reads
+// nreq[i] requests before writing any response, then answers them in
+// order with their paths, asking the client to close the connection
+// after closeAfter[i] responses if closeAfter[i] is non-zero.
+func pipelineServer(t *testing.T, l net.Listener, nreq, closeAfter []int) {
+	for i := range nreq {
+		c, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		br := bufio.NewReader(c)
+		var paths []string
+		for j := 0; j < nreq[i]; j++ {
+			req, err := ReadRequest(br)
+			if err != nil {
+				t.Errorf("conn %d: ReadRequest #%d: %v", i, j, err)
+				c.Close()
+				return
+			}
+			paths = append(paths, req.URL.Path)
+		}
+		for j, path := range paths {
+			closing := closeAfter[i] != 0 && j+1 == closeAfter[i]
+			conn := ""
+			if closing {
+				conn = "Connection: close\r\n"
+			}
+			fmt.Fprintf(c, "HTTP/1.1 200 OK\r\n%sContent-Length: %d\r\n\r\n%s", conn, len(path), path)
+			if closing {
+				break
+			}
+		}
+		c.Close()
+	}
+}
+
+func testPipelinedGets(t *testing.T, nreq, closeAfter []int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	go pipelineServer(t, l, nreq, closeAfter)
+
+	tr := &Transport{MaxPipelineDepth: nreq[0], MaxConnsPerHost: 1}
+	c := &Client{Transport: tr}
+	base := "http://" + l.Addr().String()
+
+	type result struct {
+		path, body string
+		err        os.Error
+	}
+	resch := make(chan result)
+	get := func(path string) {
+		res, err := c.Get(base + path)
+		if err != nil {
+			resch <- result{path, "", err}
+			return
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		resch <- result{path, string(b), err}
+	}
+
+	// MaxConnsPerHost lets only one request dial; the rest wait for
+	// its connection and pipeline behind it.
+	for i := 0; i < nreq[0]; i++ {
+		go get(fmt.Sprintf("/%d", i))
+	}
+	for i := 0; i < nreq[0]; i++ {
+		r := <-resch
+		if r.err != nil {
+			t.Errorf("Get %s: %v", r.path, r.err)
+		} else if r.body != r.path {
+			t.Errorf("Get %s: got body %q", r.path, r.body)
+		}
+	}
+}
+
+func TestTransportPipelining(t *testing.T) {
+	testPipelinedGets(t, []int{5}, []int{0})
+}
+
+// Five pipelined requests, the second of which is answered with
+// "Connection: close".  The final three get no response and must be
+// retried on a second connection.
+func TestTransportPipelineRetry(t *testing.T) {
+	testPipelinedGets(t, []int{5, 3}, []int{2, 0})
+}
+
 func TestTransportServerClosingUnexpectedly(t *testing.T) {
 	ts := httptest.NewServer(hostPortHandler)
 	defer ts.Close()
@@ -2,10 +2,12 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// DNS packet assembly.  See RFC 1035.
+// Package dns implements the assembly and disassembly of DNS messages.
+// See RFC 1035.
 //
-// This is intended to support name resolution during net.Dial.
-// It doesn't have to be blazing fast.
+// It supports name resolution in package net, and lets programs build
+// and parse arbitrary queries and responses. It doesn't have to be
+// blazing fast.
 //
 // Rather than write the usual handful of routines to pack and
 // unpack every message that can appear on the wire, we use
@@ -17,11 +19,7 @@
 // The second half implements the conversion to and from wire format.
 // A few of the structure elements have string tags to aid the
 // generic pack/unpack routines.
-//
-// TODO(rsc):  There are enough names defined in this file that they're all
-// prefixed with dns.  Perhaps put this in its own package later.
-
-package net
+package dns
 
 import (
 	"fmt"
@@ -33,57 +31,58 @@ import (
 
 // Wire constants.
 const (
-	// valid dnsRR_Header.Rrtype and dnsQuestion.qtype
-	dnsTypeA     = 1
-	dnsTypeNS    = 2
-	dnsTypeMD    = 3
-	dnsTypeMF    = 4
-	dnsTypeCNAME = 5
-	dnsTypeSOA   = 6
-	dnsTypeMB    = 7
-	dnsTypeMG    = 8
-	dnsTypeMR    = 9
-	dnsTypeNULL  = 10
-	dnsTypeWKS   = 11
-	dnsTypePTR   = 12
-	dnsTypeHINFO = 13
-	dnsTypeMINFO = 14
-	dnsTypeMX    = 15
-	dnsTypeTXT   = 16
-	dnsTypeAAAA  = 28
-	dnsTypeSRV   = 33
-
-	// valid dnsQuestion.qtype only
-	dnsTypeAXFR  = 252
-	dnsTypeMAILB = 253
-	dnsTypeMAILA = 254
-	dnsTypeALL   = 255
-
-	// valid dnsQuestion.qclass
-	dnsClassINET   = 1
-	dnsClassCSNET  = 2
-	dnsClassCHAOS  = 3
-	dnsClassHESIOD = 4
-	dnsClassANY    = 255
-
-	// dnsMsg.rcode
-	dnsRcodeSuccess        = 0
-	dnsRcodeFormatError    = 1
-	dnsRcodeServerFailure  = 2
-	dnsRcodeNameError      = 3
-	dnsRcodeNotImplemented = 4
-	dnsRcodeRefused        = 5
+	// valid RRHeader.Rrtype and Question.Qtype
+	TypeA     = 1
+	TypeNS    = 2
+	TypeMD    = 3
+	TypeMF    = 4
+	TypeCNAME = 5
+	TypeSOA   = 6
+	TypeMB    = 7
+	TypeMG    = 8
+	TypeMR    = 9
+	TypeNULL  = 10
+	TypeWKS   = 11
+	TypePTR   = 12
+	TypeHINFO = 13
+	TypeMINFO = 14
+	TypeMX    = 15
+	TypeTXT   = 16
+	TypeAAAA  = 28
+	TypeSRV   = 33
+	TypeOPT   = 41 // EDNS0 pseudo-record, RFC 2671
+
+	// valid Question.Qtype only
+	TypeAXFR  = 252
+	TypeMAILB = 253
+	TypeMAILA = 254
+	TypeALL   = 255
+
+	// valid Question.Qclass
+	ClassINET   = 1
+	ClassCSNET  = 2
+	ClassCHAOS  = 3
+	ClassHESIOD = 4
+	ClassANY    = 255
+
+	// MsgHdr.Rcode
+	RcodeSuccess        = 0
+	RcodeFormatError    = 1
+	RcodeServerFailure  = 2
+	RcodeNameError      = 3
+	RcodeNotImplemented = 4
+	RcodeRefused        = 5
 )
 
 // The wire format for the DNS packet header.
-type dnsHeader struct {
+type header struct {
 	Id                                 uint16
 	Bits                               uint16
 	Qdcount, Ancount, Nscount, Arcount uint16
 }
 
 const (
-	// dnsHeader.Bits
+	// header.Bits
 	_QR = 1 << 15 // query/response (response=1)
 	_AA = 1 << 10 // authoritative
 	_TC = 1 << 9  // truncated
@@ -91,9 +90,9 @@ const (
 	_RA = 1 << 7  // recursion available
 )
 
-// DNS queries.
-type dnsQuestion struct {
-	Name   string `net:"domain-name"` // `net:"domain-name"` specifies encoding; see packers below
+// A Question asks for the records of one type and class for a name.
+type Question struct {
+	Name   string `dns:"domain-name"` // `dns:"domain-name"` specifies encoding; see packers below
 	Qtype  uint16
 	Qclass uint16
 }
@@ -101,112 +100,114 @@ type dnsQuestion struct {
 // DNS responses (resource records).
 // There are many types of messages,
 // but they all share the same header.
-type dnsRR_Header struct {
-	Name     string `net:"domain-name"`
+type RRHeader struct {
+	Name     string `dns:"domain-name"`
 	Rrtype   uint16
 	Class    uint16
 	Ttl      uint32
 	Rdlength uint16 // length of data after header
 }
 
-func (h *dnsRR_Header) Header() *dnsRR_Header {
+func (h *RRHeader) Header() *RRHeader {
 	return h
 }
 
-type dnsRR interface {
-	Header() *dnsRR_Header
+// An RR is a resource record: an RRHeader, for a record of unknown
+// type or one that could not be parsed, or one of the types below.
+type RR interface {
+	Header() *RRHeader
 }
 
 // Specific DNS RR formats for each query type.
 
-type dnsRR_CNAME struct {
-	Hdr   dnsRR_Header
-	Cname string `net:"domain-name"`
+type CNAME struct {
+	Hdr   RRHeader
+	Cname string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_CNAME) Header() *dnsRR_Header {
+func (rr *CNAME) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_HINFO struct {
-	Hdr dnsRR_Header
+type HINFO struct {
+	Hdr RRHeader
 	Cpu string
 	Os  string
 }
 
-func (rr *dnsRR_HINFO) Header() *dnsRR_Header {
+func (rr *HINFO) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_MB struct {
-	Hdr dnsRR_Header
-	Mb  string `net:"domain-name"`
+type MB struct {
+	Hdr RRHeader
+	Mb  string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_MB) Header() *dnsRR_Header {
+func (rr *MB) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_MG struct {
-	Hdr dnsRR_Header
-	Mg  string `net:"domain-name"`
+type MG struct {
+	Hdr RRHeader
+	Mg  string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_MG) Header() *dnsRR_Header {
+func (rr *MG) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_MINFO struct {
-	Hdr   dnsRR_Header
-	Rmail string `net:"domain-name"`
-	Email string `net:"domain-name"`
+type MINFO struct {
+	Hdr   RRHeader
+	Rmail string `dns:"domain-name"`
+	Email string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_MINFO) Header() *dnsRR_Header {
+func (rr *MINFO) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_MR struct {
-	Hdr dnsRR_Header
-	Mr  string `net:"domain-name"`
+type MR struct {
+	Hdr RRHeader
+	Mr  string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_MR) Header() *dnsRR_Header {
+func (rr *MR) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_MX struct {
-	Hdr  dnsRR_Header
+type MX struct {
+	Hdr  RRHeader
 	Pref uint16
-	Mx   string `net:"domain-name"`
+	Mx   string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_MX) Header() *dnsRR_Header {
+func (rr *MX) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_NS struct {
-	Hdr dnsRR_Header
-	Ns  string `net:"domain-name"`
+type NS struct {
+	Hdr RRHeader
+	Ns  string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_NS) Header() *dnsRR_Header {
+func (rr *NS) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_PTR struct {
-	Hdr dnsRR_Header
-	Ptr string `net:"domain-name"`
+type PTR struct {
+	Hdr RRHeader
+	Ptr string `dns:"domain-name"`
 }
 
-func (rr *dnsRR_PTR) Header() *dnsRR_Header {
+func (rr *PTR) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_SOA struct {
-	Hdr     dnsRR_Header
-	Ns      string `net:"domain-name"`
-	Mbox    string `net:"domain-name"`
+type SOA struct {
+	Hdr     RRHeader
+	Ns      string `dns:"domain-name"`
+	Mbox    string `dns:"domain-name"`
 	Serial  uint32
 	Refresh uint32
 	Retry   uint32
@@ -214,49 +215,75 @@ type dnsRR_SOA struct {
 	Minttl  uint32
 }
 
-func (rr *dnsRR_SOA) Header() *dnsRR_Header {
+func (rr *SOA) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_TXT struct {
-	Hdr dnsRR_Header
-	Txt string // not domain name
+// TXT holds a TXT record: one or more character-strings
+// of at most 255 bytes each.
+type TXT struct {
+	Hdr RRHeader
+	Txt []string `dns:"txt"`
 }
 
-func (rr *dnsRR_TXT) Header() *dnsRR_Header {
+func (rr *TXT) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_SRV struct {
-	Hdr      dnsRR_Header
+type SRV struct {
+	Hdr      RRHeader
 	Priority uint16
 	Weight   uint16
 	Port     uint16
-	Target   string `net:"domain-name"`
+	Target   string `dns:"domain-name"`
+}
+
+func (rr *SRV) Header() *RRHeader {
+	return &rr.Hdr
+}
+
+type A struct {
+	Hdr RRHeader
+	A   uint32 `dns:"ipv4"`
 }
 
-func (rr *dnsRR_SRV) Header() *dnsRR_Header {
+func (rr *A) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_A struct {
-	Hdr dnsRR_Header
-	A   uint32 `net:"ipv4"`
+type AAAA struct {
+	Hdr  RRHeader
+	AAAA [16]byte `dns:"ipv6"`
 }
 
-func (rr *dnsRR_A) Header() *dnsRR_Header {
+func (rr *AAAA) Header() *RRHeader {
 	return &rr.Hdr
 }
 
-type dnsRR_AAAA struct {
-	Hdr  dnsRR_Header
-	AAAA [16]byte `net:"ipv6"`
+// OPT is the EDNS0 pseudo-record of RFC 2671, sent in the
+// additional section. Its header's Class holds the sender's UDP
+// payload size and its Ttl the extended rcode, version and flags.
+// Options carried in its data are skipped when unpacking.
+type OPT struct {
+	Hdr RRHeader
 }
 
-func (rr *dnsRR_AAAA) Header() *dnsRR_Header {
+func (rr *OPT) Header() *RRHeader {
 	return &rr.Hdr
 }
 
+// NewOPT returns an EDNS0 version 0 OPT record advertising the
+// given UDP payload size.
+func NewOPT(udpSize int) *OPT {
+	return &OPT{RRHeader{Name: ".", Rrtype: TypeOPT, Class: uint16(udpSize)}}
+}
+
+// UDPSize returns the largest UDP payload the sender accepts.
+func (rr *OPT) UDPSize() int { return int(rr.Hdr.Class) }
+
+// Version returns the EDNS version.
+func (rr *OPT) Version() int { return int(rr.Hdr.Ttl>>16) & 0xFF }
+
 // Packing and unpacking.
 //
 // All the packers and unpackers take a (msg []byte, off int)
@@ -266,21 +293,22 @@ func (rr *dnsRR_AAAA) Header() *dnsRR_Header {
 // packing sequence.
 
 // Map of constructors for each RR wire type.
-var rr_mk = map[int]func() dnsRR{
-	dnsTypeCNAME: func() dnsRR { return new(dnsRR_CNAME) },
-	dnsTypeHINFO: func() dnsRR { return new(dnsRR_HINFO) },
-	dnsTypeMB:    func() dnsRR { return new(dnsRR_MB) },
-	dnsTypeMG:    func() dnsRR { return new(dnsRR_MG) },
-	dnsTypeMINFO: func() dnsRR { return new(dnsRR_MINFO) },
-	dnsTypeMR:    func() dnsRR { return new(dnsRR_MR) },
-	dnsTypeMX:    func() dnsRR { return new(dnsRR_MX) },
-	dnsTypeNS:    func() dnsRR { return new(dnsRR_NS) },
-	dnsTypePTR:   func() dnsRR { return new(dnsRR_PTR) },
-	dnsTypeSOA:   func() dnsRR { return new(dnsRR_SOA) },
-	dnsTypeTXT:   func() dnsRR { return new(dnsRR_TXT) },
-	dnsTypeSRV:   func() dnsRR { return new(dnsRR_SRV) },
-	dnsTypeA:     func() dnsRR { return new(dnsRR_A) },
-	dnsTypeAAAA:  func() dnsRR { return new(dnsRR_AAAA) },
+var rrConstructors = map[int]func() RR{
+	TypeCNAME: func() RR { return new(CNAME) },
+	TypeHINFO: func() RR { return new(HINFO) },
+	TypeMB:    func() RR { return new(MB) },
+	TypeMG:    func() RR { return new(MG) },
+	TypeMINFO: func() RR { return new(MINFO) },
+	TypeMR:    func() RR { return new(MR) },
+	TypeMX:    func() RR { return new(MX) },
+	TypeNS:    func() RR { return new(NS) },
+	TypePTR:   func() RR { return new(PTR) },
+	TypeSOA:   func() RR { return new(SOA) },
+	TypeTXT:   func() RR { return new(TXT) },
+	TypeSRV:   func() RR { return new(SRV) },
+	TypeA:     func() RR { return new(A) },
+	TypeAAAA:  func() RR { return new(AAAA) },
+	TypeOPT:   func() RR { return new(OPT) },
 }
 
 // Pack a domain name s into msg[off:].
@@ -291,6 +319,14 @@ func packDomainName(s string, msg []byte, off int) (off1 int, ok bool) {
 	if n := len(s); n == 0 || s[n-1] != '.' {
 		s += "."
 	}
+	if s == "." {
+		// The root is just the trailing zero.
+		if off >= len(msg) {
+			return len(msg), false
+		}
+		msg[off] = 0
+		return off + 1, true
+	}
 
 	// Each dot ends a segment of the name.
 	// We trade each dot byte for a length byte.
@@ -383,18 +419,21 @@ Loop:
 	if ptr == 0 {
 		off1 = off
 	}
+	if s == "" {
+		s = "."
+	}
 	return s, off1, true
 }
 
 // TODO(rsc): Move into generic library?
 // Pack a reflect.StructValue into msg.  Struct members can only be uint16, uint32, string,
-// [n]byte, and other (often anonymous) structs.
+// []string tagged txt, [n]byte, and other (often anonymous) structs.
 func packStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok bool) {
 	for i := 0; i < val.NumField(); i++ {
 		f := val.Type().Field(i)
 		switch fv := val.Field(i); fv.Kind() {
 		default:
-			fmt.Fprintf(os.Stderr, "net: dns: unknown packing type %v", f.Type)
+			fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
 			return len(msg), false
 		case reflect.Struct:
 			off, ok = packStructValue(fv, msg, off)
@@ -418,7 +457,7 @@ func packStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok bool)
 			off += 4
 		case reflect.Array:
 			if fv.Type().Elem().Kind() != reflect.Uint8 {
-				fmt.Fprintf(os.Stderr, "net: dns: unknown packing type %v", f.Type)
+				fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
 				return len(msg), false
 			}
 			n := fv.Len()
@@ -427,15 +466,33 @@ func packStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok bool)
 			}
 			reflect.Copy(reflect.ValueOf(msg[off:off+n]), fv)
 			off += n
+		case reflect.Slice:
+			if f.Tag != `dns:"txt"` || fv.Type().Elem().Kind() != reflect.String {
+				fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
+				return len(msg), false
+			}
+			// Counted strings, at least one of them.
+			for j := 0; j == 0 || j < fv.Len(); j++ {
+				var s string
+				if j < fv.Len() {
+					s = fv.Index(j).String()
+				}
+				if len(s) > 255 || off+1+len(s) > len(msg) {
+					return len(msg), false
+				}
+				msg[off] = byte(len(s))
+				off++
+				off += copy(msg[off:], s)
+			}
 		case reflect.String:
 			// There are multiple string encodings.
 			// The tag distinguishes ordinary strings from domain names.
 			s := fv.String()
 			switch f.Tag {
 			default:
-				fmt.Fprintf(os.Stderr, "net: dns: unknown string tag %v", f.Tag)
+				fmt.Fprintf(os.Stderr, "dns: unknown string tag %v", f.Tag)
 				return len(msg), false
-			case `net:"domain-name"`:
+			case `dns:"domain-name"`:
 				off, ok = packDomainName(s, msg, off)
 				if !ok {
 					return len(msg), false
@@ -471,7 +528,7 @@ func unpackStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok boo
 		f := val.Type().Field(i)
 		switch fv := val.Field(i); fv.Kind() {
 		default:
-			fmt.Fprintf(os.Stderr, "net: dns: unknown packing type %v", f.Type)
+			fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
 			return len(msg), false
 		case reflect.Struct:
 			off, ok = unpackStructValue(fv, msg, off)
@@ -491,7 +548,7 @@ func unpackStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok boo
 			off += 4
 		case reflect.Array:
 			if fv.Type().Elem().Kind() != reflect.Uint8 {
-				fmt.Fprintf(os.Stderr, "net: dns: unknown packing type %v", f.Type)
+				fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
 				return len(msg), false
 			}
 			n := fv.Len()
@@ -500,13 +557,30 @@ func unpackStructValue(val reflect.Value, msg []byte, off int) (off1 int, ok boo
 			}
 			reflect.Copy(fv, reflect.ValueOf(msg[off:off+n]))
 			off += n
+		case reflect.Slice:
+			if f.Tag != `dns:"txt"` || fv.Type().Elem().Kind() != reflect.String {
+				fmt.Fprintf(os.Stderr, "dns: unknown packing type %v", f.Type)
+				return len(msg), false
+			}
+			// Counted strings running to the end of msg,
+			// which unpackRR cuts off at the end of the record.
+			var txt []string
+			for len(txt) == 0 || off < len(msg) {
+				if off >= len(msg) || off+1+int(msg[off]) > len(msg) {
+					return len(msg), false
+				}
+				n := int(msg[off])
+				txt = append(txt, string(msg[off+1:off+1+n]))
+				off += 1 + n
+			}
+			fv.Set(reflect.ValueOf(txt))
 		case reflect.String:
 			var s string
 			switch f.Tag {
 			default:
-				fmt.Fprintf(os.Stderr, "net: dns: unknown string tag %v", f.Tag)
+				fmt.Fprintf(os.Stderr, "dns: unknown string tag %v", f.Tag)
 				return len(msg), false
-			case `net:"domain-name"`:
+			case `dns:"domain-name"`:
 				s, off, ok = unpackDomainName(msg, off)
 				if !ok {
 					return len(msg), false
@@ -536,9 +610,9 @@ func unpackStruct(any interface{}, msg []byte, off int) (off1 int, ok bool) {
 }
 
 // Generic struct printer.
-// Doesn't care about the string tag `net:"domain-name"`,
-// but does look for an `net:"ipv4"` tag on uint32 variables
-// and the `net:"ipv6"` tag on array variables,
+// Doesn't care about the string tag `dns:"domain-name"`,
+// but does look for an `dns:"ipv4"` tag on uint32 variables
+// and the `dns:"ipv6"` tag on array variables,
 // printing them as IP addresses.
 func printStructValue(val reflect.Value) string {
 	s := "{"
@@ -553,12 +627,16 @@ func printStructValue(val reflect.Value) string {
 		fval := val.Field(i)
 		if fv := fval; fv.Kind() == reflect.Struct {
 			s += printStructValue(fv)
-		} else if fv := fval; (fv.Kind() == reflect.Uint || fv.Kind() == reflect.Uint8 || fv.Kind() == reflect.Uint16 || fv.Kind() == reflect.Uint32 || fv.Kind() == reflect.Uint64 || fv.Kind() == reflect.Uintptr) && f.Tag == `net:"ipv4"` {
+		} else if fv := fval; (fv.Kind() == reflect.Uint || fv.Kind() == reflect.Uint8 || fv.Kind() == reflect.Uint16 || fv.Kind() == reflect.Uint32 || fv.Kind() == reflect.Uint64 || fv.Kind() == reflect.Uintptr) && f.Tag == `dns:"ipv4"` {
 			i := fv.Uint()
-			s += IPv4(byte(i>>24), byte(i>>16), byte(i>>8), byte(i)).String()
-		} else if fv := fval; fv.Kind() == reflect.Array && f.Tag == `net:"ipv6"` {
-			i := fv.Interface().([]byte)
-			s += IP(i).String()
+			s += fmt.Sprintf("%d.%d.%d.%d", byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+		} else if fv := fval; fv.Kind() == reflect.Array && f.Tag == `dns:"ipv6"` {
+			for j := 0; j < fv.Len(); j += 2 {
+				if j > 0 {
+					s += ":"
+				}
+				s += fmt.Sprintf("%x", fv.Index(j).Uint()<<8|fv.Index(j+1).Uint())
+			}
 		} else {
 			s += fmt.Sprint(fval.Interface())
 		}
@@ -570,7 +648,7 @@ func printStructValue(val reflect.Value) string {
 func printStruct(any interface{}) string { return printStructValue(structValue(any)) }
 
 // Resource record packer.
-func packRR(rr dnsRR, msg []byte, off int) (off2 int, ok bool) {
+func packRR(rr RR, msg []byte, off int) (off2 int, ok bool) {
 	var off1 int
 	// pack twice, once to find end of header
 	// and again to find end of packet.
@@ -589,93 +667,118 @@ func packRR(rr dnsRR, msg []byte, off int) (off2 int, ok bool) {
 }
 
 // Resource record unpacker.
-func unpackRR(msg []byte, off int) (rr dnsRR, off1 int, ok bool) {
+func unpackRR(msg []byte, off int) (rr RR, off1 int, ok bool) {
 	// unpack just the header, to find the rr type and length
-	var h dnsRR_Header
+	var h RRHeader
 	off0 := off
 	if off, ok = unpackStruct(&h, msg, off); !ok {
 		return nil, len(msg), false
 	}
 	end := off + int(h.Rdlength)
+	if end > len(msg) {
+		return nil, len(msg), false
+	}
 
 	// make an rr of that type and re-unpack.
 	// again inefficient but doesn't need to be fast.
-	mk, known := rr_mk[int(h.Rrtype)]
+	// The record cannot extend past its data length, so cut msg
+	// off there; compressed names point only backward.
+	mk, known := rrConstructors[int(h.Rrtype)]
 	if !known {
 		return &h, end, true
 	}
 	rr = mk()
-	off, ok = unpackStruct(rr, msg, off0)
-	if off != end {
+	off, ok = unpackStruct(rr, msg[:end], off0)
+	if _, opt := rr.(*OPT); opt && ok && off <= end {
+		// Skip the options.
+		off = end
+	}
+	if !ok || off != end {
 		return &h, end, true
 	}
-	return rr, off, ok
+	return rr, off, true
 }
 
 // Usable representation of a DNS packet.
 
 // A manually-unpacked version of (id, bits).
 // This is in its own struct for easy printing.
-type dnsMsgHdr struct {
-	id                  uint16
-	response            bool
-	opcode              int
-	authoritative       bool
-	truncated           bool
-	recursion_desired   bool
-	recursion_available bool
-	rcode               int
-}
-
-type dnsMsg struct {
-	dnsMsgHdr
-	question []dnsQuestion
-	answer   []dnsRR
-	ns       []dnsRR
-	extra    []dnsRR
-}
-
-func (dns *dnsMsg) Pack() (msg []byte, ok bool) {
-	var dh dnsHeader
-
-	// Convert convenient dnsMsg into wire-like dnsHeader.
-	dh.Id = dns.id
-	dh.Bits = uint16(dns.opcode)<<11 | uint16(dns.rcode)
-	if dns.recursion_available {
+type MsgHdr struct {
+	Id                 uint16
+	Response           bool
+	Opcode             int
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	Rcode              int
+}
+
+// A Msg is a DNS query or response.
+type Msg struct {
+	MsgHdr
+	Question []Question
+	Answer   []RR
+	Ns       []RR // authority section
+	Extra    []RR // additional section
+}
+
+// maxMsgSize is the size of the largest DNS message, which only TCP
+// can carry.
+const maxMsgSize = 65535
+
+// Pack returns the wire format of the message.
+func (dns *Msg) Pack() (msg []byte, ok bool) {
+	// Could work harder to calculate message size,
+	// but doubling the buffer until it fits is simpler
+	// and only large responses need more than one try.
+	for size := 512; ; size *= 2 {
+		if size > maxMsgSize {
+			size = maxMsgSize
+		}
+		if msg, ok = dns.pack(make([]byte, size)); ok || size == maxMsgSize {
+			return
+		}
+	}
+	panic("unreachable")
+}
+
+func (dns *Msg) pack(msg []byte) ([]byte, bool) {
+	var dh header
+
+	// Convert convenient Msg into wire-like header.
+	dh.Id = dns.Id
+	dh.Bits = uint16(dns.Opcode)<<11 | uint16(dns.Rcode)
+	if dns.RecursionAvailable {
 		dh.Bits |= _RA
 	}
-	if dns.recursion_desired {
+	if dns.RecursionDesired {
 		dh.Bits |= _RD
 	}
-	if dns.truncated {
+	if dns.Truncated {
 		dh.Bits |= _TC
 	}
-	if dns.authoritative {
+	if dns.Authoritative {
 		dh.Bits |= _AA
 	}
-	if dns.response {
+	if dns.Response {
 		dh.Bits |= _QR
 	}
 
 	// Prepare variable sized arrays.
-	question := dns.question
-	answer := dns.answer
-	ns := dns.ns
-	extra := dns.extra
+	question := dns.Question
+	answer := dns.Answer
+	ns := dns.Ns
+	extra := dns.Extra
 
 	dh.Qdcount = uint16(len(question))
 	dh.Ancount = uint16(len(answer))
 	dh.Nscount = uint16(len(ns))
 	dh.Arcount = uint16(len(extra))
 
-	// Could work harder to calculate message size,
-	// but this is far more than we need and not
-	// big enough to hurt the allocator.
-	msg = make([]byte, 2000)
-
 	// Pack it in: header and then the pieces.
 	off := 0
-	off, ok = packStruct(&dh, msg, off)
+	off, ok := packStruct(&dh, msg, off)
 	for i := 0; i < len(question); i++ {
 		off, ok = packStruct(&question[i], msg, off)
 	}
@@ -694,54 +797,61 @@ func (dns *dnsMsg) Pack() (msg []byte, ok bool) {
 	return msg[0:off], true
 }
 
-func (dns *dnsMsg) Unpack(msg []byte) bool {
+// Unpack sets the message to the contents of the wire format msg.
+// It reports whether msg was well formed. A message with the
+// Truncated bit set may end before its last record, in which case
+// Unpack keeps the records that arrived whole.
+func (dns *Msg) Unpack(msg []byte) bool {
 	// Header.
-	var dh dnsHeader
+	var dh header
 	off := 0
 	var ok bool
 	if off, ok = unpackStruct(&dh, msg, off); !ok {
 		return false
 	}
-	dns.id = dh.Id
-	dns.response = (dh.Bits & _QR) != 0
-	dns.opcode = int(dh.Bits>>11) & 0xF
-	dns.authoritative = (dh.Bits & _AA) != 0
-	dns.truncated = (dh.Bits & _TC) != 0
-	dns.recursion_desired = (dh.Bits & _RD) != 0
-	dns.recursion_available = (dh.Bits & _RA) != 0
-	dns.rcode = int(dh.Bits & 0xF)
+	dns.Id = dh.Id
+	dns.Response = (dh.Bits & _QR) != 0
+	dns.Opcode = int(dh.Bits>>11) & 0xF
+	dns.Authoritative = (dh.Bits & _AA) != 0
+	dns.Truncated = (dh.Bits & _TC) != 0
+	dns.RecursionDesired = (dh.Bits & _RD) != 0
+	dns.RecursionAvailable = (dh.Bits & _RA) != 0
+	dns.Rcode = int(dh.Bits & 0xF)
 
 	// Arrays.
-	dns.question = make([]dnsQuestion, dh.Qdcount)
-	dns.answer = make([]dnsRR, 0, dh.Ancount)
-	dns.ns = make([]dnsRR, 0, dh.Nscount)
-	dns.extra = make([]dnsRR, 0, dh.Arcount)
+	dns.Question = make([]Question, dh.Qdcount)
+	dns.Answer = make([]RR, 0, dh.Ancount)
+	dns.Ns = make([]RR, 0, dh.Nscount)
+	dns.Extra = make([]RR, 0, dh.Arcount)
 
-	var rec dnsRR
+	var rec RR
 
-	for i := 0; i < len(dns.question); i++ {
-		off, ok = unpackStruct(&dns.question[i], msg, off)
+	for i := 0; i < len(dns.Question); i++ {
+		off, ok = unpackStruct(&dns.Question[i], msg, off)
+	}
+	if !ok {
+		return false
 	}
 	for i := 0; i < int(dh.Ancount); i++ {
 		rec, off, ok = unpackRR(msg, off)
 		if !ok {
-			return false
+			return dns.Truncated
 		}
-		dns.answer = append(dns.answer, rec)
+		dns.Answer = append(dns.Answer, rec)
 	}
 	for i := 0; i < int(dh.Nscount); i++ {
 		rec, off, ok = unpackRR(msg, off)
 		if !ok {
-			return false
+			return dns.Truncated
 		}
-		dns.ns = append(dns.ns, rec)
+		dns.Ns = append(dns.Ns, rec)
 	}
 	for i := 0; i < int(dh.Arcount); i++ {
 		rec, off, ok = unpackRR(msg, off)
 		if !ok {
-			return false
+			return dns.Truncated
 		}
-		dns.extra = append(dns.extra, rec)
+		dns.Extra = append(dns.Extra, rec)
 	}
 	//	if off != len(msg) {
 	//		println("extra bytes in dns packet", off, "<", len(msg));
@@ -749,31 +859,42 @@ func (dns *dnsMsg) Unpack(msg []byte) bool {
 	return true
 }
 
-func (dns *dnsMsg) String() string {
-	s := "DNS: " + printStruct(&dns.dnsMsgHdr) + "\n"
-	if len(dns.question) > 0 {
+// String returns a readable dump of the message.
+func (dns *Msg) String() string {
+	s := "DNS: " + printStruct(&dns.MsgHdr) + "\n"
+	if len(dns.Question) > 0 {
 		s += "-- Questions\n"
-		for i := 0; i < len(dns.question); i++ {
-			s += printStruct(&dns.question[i]) + "\n"
+		for i := 0; i < len(dns.Question); i++ {
+			s += printStruct(&dns.Question[i]) + "\n"
 		}
 	}
-	if len(dns.answer) > 0 {
+	if len(dns.Answer) > 0 {
 		s += "-- Answers\n"
-		for i := 0; i < len(dns.answer); i++ {
-			s += printStruct(dns.answer[i]) + "\n"
+		for i := 0; i < len(dns.Answer); i++ {
+			s += printStruct(dns.Answer[i]) + "\n"
 		}
 	}
-	if len(dns.ns) > 0 {
+	if len(dns.Ns) > 0 {
 		s += "-- Name servers\n"
-		for i := 0; i < len(dns.ns); i++ {
-			s += printStruct(dns.ns[i]) + "\n"
+		for i := 0; i < len(dns.Ns); i++ {
+			s += printStruct(dns.Ns[i]) + "\n"
 		}
 	}
-	if len(dns.extra) > 0 {
+	if len(dns.Extra) > 0 {
 		s += "-- Extra\n"
-		for i := 0; i < len(dns.extra); i++ {
-			s += printStruct(dns.extra[i]) + "\n"
+		for i := 0; i < len(dns.Extra); i++ {
+			s += printStruct(dns.Extra[i]) + "\n"
 		}
 	}
 	return s
 }
+
+// OPT returns the message's EDNS0 OPT record, or nil if it has none.
+func (dns *Msg) OPT() *OPT {
+	for _, rr := range dns.Extra {
+		if opt, ok := rr.(*OPT); ok {
+			return opt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackUnpack(t *testing.T) {
+	out := new(Msg)
+	out.Id = 0x1234
+	out.Response = true
+	out.Authoritative = true
+	out.Rcode = RcodeNameError
+	out.Question = []Question{{"example.com.", TypeMX, ClassINET}}
+	out.Answer = []RR{
+		&MX{RRHeader{Name: "example.com.", Rrtype: TypeMX, Class: ClassINET, Ttl: 300}, 10, "mail.example.com."},
+		&A{RRHeader{Name: "mail.example.com.", Rrtype: TypeA, Class: ClassINET, Ttl: 300}, 0x0a000001},
+	}
+	out.Ns = []RR{
+		&NS{RRHeader{Name: "example.com.", Rrtype: TypeNS, Class: ClassINET, Ttl: 3600}, "ns.example.com."},
+	}
+	out.Extra = []RR{NewOPT(4096)}
+
+	msg, ok := out.Pack()
+	if !ok {
+		t.Fatal("Pack failed")
+	}
+	in := new(Msg)
+	if !in.Unpack(msg) {
+		t.Fatal("Unpack failed")
+	}
+	if !reflect.DeepEqual(in.MsgHdr, out.MsgHdr) {
+		t.Errorf("header = %+v; want %+v", in.MsgHdr, out.MsgHdr)
+	}
+	if !reflect.DeepEqual(in.Question, out.Question) {
+		t.Errorf("questions = %+v; want %+v", in.Question, out.Question)
+	}
+	// Pack filled in the Rdlength of the records it was given.
+	for i, sec := range [][]RR{out.Answer, out.Ns, out.Extra} {
+		got := [][]RR{in.Answer, in.Ns, in.Extra}[i]
+		if !reflect.DeepEqual(got, sec) {
+			t.Errorf("section %d = %v; want %v", i, got, sec)
+		}
+	}
+	opt := in.OPT()
+	if opt == nil || opt.UDPSize() != 4096 || opt.Version() != 0 || opt.Hdr.Name != "." {
+		t.Errorf("OPT record = %+v; want root OPT with UDP size 4096", opt)
+	}
+}
+
+func TestPackLarge(t *testing.T) {
+	out := new(Msg)
+	out.Question = []Question{{"big.example.com.", TypeA, ClassINET}}
+	for i := 0; i < 100; i++ {
+		out.Answer = append(out.Answer, &A{RRHeader{Name: "big.example.com.", Rrtype: TypeA, Class: ClassINET}, uint32(i)})
+	}
+	msg, ok := out.Pack()
+	if !ok {
+		t.Fatal("Pack failed")
+	}
+	if len(msg) <= 512 {
+		t.Errorf("packed %d records into %d bytes", len(out.Answer), len(msg))
+	}
+	in := new(Msg)
+	if !in.Unpack(msg) || len(in.Answer) != len(out.Answer) {
+		t.Fatalf("Unpack: got %d answers; want %d", len(in.Answer), len(out.Answer))
+	}
+	if a := in.Answer[99].(*A).A; a != 99 {
+		t.Errorf("last answer = %d; want 99", a)
+	}
+}
+
+func TestUnpackOPTOptions(t *testing.T) {
+	out := new(Msg)
+	out.Extra = []RR{NewOPT(1232)}
+	msg, _ := out.Pack()
+	// Append a 4-byte option (code 10, length 0) to the OPT record.
+	msg = append(msg, 0, 10, 0, 0)
+	msg[len(msg)-5] = 4 // Rdlength
+	in := new(Msg)
+	if !in.Unpack(msg) {
+		t.Fatal("Unpack failed")
+	}
+	if opt := in.OPT(); opt == nil || opt.UDPSize() != 1232 {
+		t.Errorf("OPT record with options = %+v; want UDP size 1232", opt)
+	}
+}
+
+func TestTXT(t *testing.T) {
+	long := strings.Repeat("k", 255)
+	for _, txt := range [][]string{{""}, {"v=spf1 -all"}, {"v=spf1 mx", " -all"}, {long, "", long}} {
+		out := new(Msg)
+		out.Answer = []RR{&TXT{RRHeader{Name: "example.com.", Rrtype: TypeTXT, Class: ClassINET}, txt}}
+		msg, ok := out.Pack()
+		if !ok {
+			t.Errorf("Pack of TXT %q failed", txt)
+			continue
+		}
+		in := new(Msg)
+		if !in.Unpack(msg) {
+			t.Errorf("Unpack of TXT %q failed", txt)
+			continue
+		}
+		rr, ok := in.Answer[0].(*TXT)
+		if !ok || !reflect.DeepEqual(rr.Txt, txt) {
+			t.Errorf("TXT round trip: got %v; want %q", in.Answer[0], txt)
+		}
+	}
+
+	out := new(Msg)
+	out.Answer = []RR{&TXT{RRHeader{Name: "example.com.", Rrtype: TypeTXT, Class: ClassINET}, []string{long + "k"}}}
+	if _, ok := out.Pack(); ok {
+		t.Error("Pack of 256-byte TXT string succeeded")
+	}
+}
+
+func TestUnpackBadQuestion(t *testing.T) {
+	out := new(Msg)
+	out.Truncated = true
+	out.Question = []Question{{"example.com.", TypeA, ClassINET}}
+	msg, _ := out.Pack()
+	if new(Msg).Unpack(msg[:len(msg)-2]) {
+		t.Error("Unpack of message with cut-off question succeeded")
+	}
+}
+
+func TestUnpackTruncated(t *testing.T) {
+	out := new(Msg)
+	out.Truncated = true
+	for i := 0; i < 3; i++ {
+		out.Answer = append(out.Answer, &A{RRHeader{Name: "a.example.com.", Rrtype: TypeA, Class: ClassINET}, uint32(i)})
+	}
+	msg, _ := out.Pack()
+	in := new(Msg)
+	if !in.Unpack(msg[:len(msg)-2]) {
+		t.Fatal("Unpack of truncated message failed")
+	}
+	if !in.Truncated || len(in.Answer) != 2 {
+		t.Errorf("truncated message: Truncated = %v, %d answers; want true, 2", in.Truncated, len(in.Answer))
+	}
+	out.Truncated = false
+	msg, _ = out.Pack()
+	if new(Msg).Unpack(msg[:len(msg)-2]) {
+		t.Error("Unpack of cut-off message without Truncated bit succeeded")
+	}
+}
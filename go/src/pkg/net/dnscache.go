@@ -0,0 +1,158 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// In-process cache of DNS answers.
+
+package net
+
+import (
+	"net/dns"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxNegativeTTL bounds the time in seconds for which the absence
+// of a name is cached.  RFC 2308 suggests one to three hours.
+const maxNegativeTTL = 3 * 3600
+
+// dnsCacheSweep is the number of cache entries above which storing
+// another first discards the expired ones.
+const dnsCacheSweep = 1024
+
+// A dnsCacheEntry holds the outcome of one query.
+type dnsCacheEntry struct {
+	cname   string
+	rrs     []dns.RR
+	err     os.Error
+	expires int64 // time.Nanoseconds() after which the entry is stale
+}
+
+var dnsCache struct {
+	sync.Mutex
+	enabled bool
+	entries map[string]*dnsCacheEntry
+}
+
+// SetDNSCache turns caching of DNS answers on or off for the process.
+// While the cache is on, each answer the resolver receives is kept for
+// as long as the time to live of its records allows, and the absence
+// of a name or of records of the type asked for is kept for as long as
+// the SOA record of the name's zone allows (RFC 2308).  Turning the
+// cache off discards its contents.
+//
+// While the cache is on, LookupHost, LookupIP and LookupCNAME use the
+// resolver in this package instead of the C library on Unix systems,
+// so that their answers are cached too.
+func SetDNSCache(enabled bool) {
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	dnsCache.enabled = enabled
+	dnsCache.entries = nil
+}
+
+func dnsCacheEnabled() bool {
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	return dnsCache.enabled
+}
+
+// FlushDNSCache discards every answer in the DNS cache.
+func FlushDNSCache() {
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	dnsCache.entries = nil
+}
+
+func dnsCacheKey(name string, qtype uint16) string {
+	return itoa(int(qtype)) + " " + name
+}
+
+// cachedAnswer returns the cached outcome of the query for name
+// and qtype, with ok == false if there is none.
+func cachedAnswer(name string, qtype uint16) (cname string, rrs []dns.RR, err os.Error, ok bool) {
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	if !dnsCache.enabled {
+		return
+	}
+	key := dnsCacheKey(name, qtype)
+	e := dnsCache.entries[key]
+	if e == nil {
+		return
+	}
+	if time.Nanoseconds() > e.expires {
+		dnsCache.entries[key] = nil, false
+		return
+	}
+	// Copy the records so that callers cannot disturb the cache.
+	if e.rrs != nil {
+		rrs = make([]dns.RR, len(e.rrs))
+		copy(rrs, e.rrs)
+	}
+	return e.cname, rrs, e.err, true
+}
+
+// cacheAnswer stores the outcome of the query for name and qtype,
+// which answer derived from msg, if msg allows it to be cached.
+func cacheAnswer(name string, qtype uint16, msg *dns.Msg, cname string, rrs []dns.RR, err os.Error) {
+	dnsCache.Lock()
+	defer dnsCache.Unlock()
+	if !dnsCache.enabled {
+		return
+	}
+	ttl := answerTTL(msg, err)
+	if ttl == 0 {
+		return
+	}
+	now := time.Nanoseconds()
+	if dnsCache.entries == nil {
+		dnsCache.entries = make(map[string]*dnsCacheEntry)
+	}
+	if len(dnsCache.entries) >= dnsCacheSweep {
+		for k, e := range dnsCache.entries {
+			if now > e.expires {
+				dnsCache.entries[k] = nil, false
+			}
+		}
+	}
+	dnsCache.entries[dnsCacheKey(name, qtype)] = &dnsCacheEntry{cname, rrs, err, now + int64(ttl)*1e9}
+}
+
+// answerTTL returns the time in seconds for which the outcome err
+// of a query answered by msg may be cached, or 0 if it may not be.
+func answerTTL(msg *dns.Msg, err os.Error) uint32 {
+	if err == nil {
+		// The answer is good for as long as its
+		// shortest-lived record, CNAMEs included.
+		ttl := ^uint32(0)
+		for _, rr := range msg.Answer {
+			if h := rr.Header(); h.Ttl < ttl {
+				ttl = h.Ttl
+			}
+		}
+		if len(msg.Answer) == 0 {
+			ttl = 0
+		}
+		return ttl
+	}
+	if e, ok := err.(*DNSError); !ok || e.Error != noSuchHost {
+		return 0
+	}
+	// Negative answers can be cached only if they carry
+	// the SOA record of the zone (RFC 2308 section 5).
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			if ttl > maxNegativeTTL {
+				ttl = maxNegativeTTL
+			}
+			return ttl
+		}
+	}
+	return 0
+}
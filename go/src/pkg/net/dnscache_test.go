@@ -0,0 +1,175 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package net
+
+import (
+	"net/dns"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func cacheTestMsg(ttls ...uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	for i, ttl := range ttls {
+		msg.Answer = append(msg.Answer, &dns.A{dns.RRHeader{Name: "a.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, uint32(i)})
+	}
+	return msg
+}
+
+func soaTestMsg(ttl, minttl uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.Rcode = dns.RcodeNameError
+	msg.Ns = []dns.RR{&dns.SOA{Hdr: dns.RRHeader{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl}, Minttl: minttl}}
+	return msg
+}
+
+var answerTTLTests = []struct {
+	msg *dns.Msg
+	err string
+	ttl uint32
+}{
+	{cacheTestMsg(300, 60, 600), "", 60},
+	{cacheTestMsg(), "", 0},
+	{soaTestMsg(3600, 120), noSuchHost, 120},
+	{soaTestMsg(30, 120), noSuchHost, 30},
+	{soaTestMsg(86400, 86400), noSuchHost, maxNegativeTTL},
+	{new(dns.Msg), noSuchHost, 0},
+	{soaTestMsg(3600, 120), "server misbehaving", 0},
+}
+
+func TestAnswerTTL(t *testing.T) {
+	for i, tt := range answerTTLTests {
+		var err os.Error
+		if tt.err != "" {
+			err = &DNSError{Error: tt.err, Name: "a.example.com."}
+		}
+		if ttl := answerTTL(tt.msg, err); ttl != tt.ttl {
+			t.Errorf("#%d: answerTTL = %d; want %d", i, ttl, tt.ttl)
+		}
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	defer SetDNSCache(false)
+
+	msg := cacheTestMsg(300)
+	cacheAnswer("a.example.com.", dns.TypeA, msg, "a.example.com.", msg.Answer, nil)
+	if _, _, _, ok := cachedAnswer("a.example.com.", dns.TypeA); ok {
+		t.Fatal("answer cached while the cache was off")
+	}
+
+	SetDNSCache(true)
+	cacheAnswer("a.example.com.", dns.TypeA, msg, "a.example.com.", msg.Answer, nil)
+	nx := &DNSError{Error: noSuchHost, Name: "b.example.com."}
+	cacheAnswer("b.example.com.", dns.TypeA, soaTestMsg(60, 60), "", nil, nx)
+	cacheAnswer("c.example.com.", dns.TypeA, cacheTestMsg(0), "c.example.com.", nil, nil)
+
+	cname, rrs, err, ok := cachedAnswer("a.example.com.", dns.TypeA)
+	if !ok || err != nil || cname != "a.example.com." || len(rrs) != 1 || rrs[0] != msg.Answer[0] {
+		t.Errorf("cached answer = %q, %v, %v, %v", cname, rrs, err, ok)
+	}
+	rrs[0] = nil
+	if _, rrs, _, _ = cachedAnswer("a.example.com.", dns.TypeA); rrs[0] == nil {
+		t.Error("caller modified the cached records")
+	}
+	if _, _, _, ok = cachedAnswer("a.example.com.", dns.TypeAAAA); ok {
+		t.Error("answer for A records served for AAAA")
+	}
+	if _, _, err, ok = cachedAnswer("b.example.com.", dns.TypeA); !ok || err != nx {
+		t.Errorf("negative answer = %v, %v; want %v, true", err, ok, nx)
+	}
+	if _, _, _, ok = cachedAnswer("c.example.com.", dns.TypeA); ok {
+		t.Error("answer with zero TTL was cached")
+	}
+
+	FlushDNSCache()
+	if _, _, _, ok = cachedAnswer("a.example.com.", dns.TypeA); ok {
+		t.Error("answer cached after flush")
+	}
+}
+
+// countingDNSServer answers every A or AAAA query it reads from c
+// with one address, counting the queries.
+type countingDNSServer struct {
+	c       PacketConn
+	mu      sync.Mutex
+	queries int
+}
+
+func (s *countingDNSServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg := new(dns.Msg)
+		if !msg.Unpack(buf[:n]) || len(msg.Question) != 1 {
+			continue
+		}
+		s.mu.Lock()
+		s.queries++
+		s.mu.Unlock()
+
+		q := msg.Question[0]
+		hdr := dns.RRHeader{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 300}
+		switch q.Qtype {
+		case dns.TypeA:
+			msg.Answer = []dns.RR{&dns.A{hdr, 0xc0000201}}
+		case dns.TypeAAAA:
+			rr := &dns.AAAA{Hdr: hdr}
+			copy(rr.AAAA[:], ParseIP("2001:db8::1"))
+			msg.Answer = []dns.RR{rr}
+		}
+		msg.Response = true
+		msg.Extra = nil
+		if out, ok := msg.Pack(); ok {
+			s.c.WriteTo(out, addr)
+		}
+	}
+}
+
+func (s *countingDNSServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func TestLookupHostCached(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Logf("skipping test: lookups do not use the DNS cache on %s", runtime.GOOS)
+		return
+	}
+	c, err := ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := &countingDNSServer{c: c}
+	go srv.serve()
+
+	defer SetDNSCache(false)
+	SetDNSCache(true)
+	if !useGoResolver() {
+		t.Error("lookups bypass the Go resolver while the DNS cache is on")
+	}
+	if err = SetDNSConfig(&DNSConfig{Servers: []string{c.LocalAddr().String()}}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDNSConfig(nil)
+
+	for i := 0; i < 2; i++ {
+		addrs, err := LookupHost("cached.example.")
+		if err != nil || len(addrs) != 2 || addrs[0] != "192.0.2.1" || addrs[1] != "2001:db8::1" {
+			t.Fatalf("LookupHost #%d = %v, %v", i, addrs, err)
+		}
+		// One query each for the A and AAAA records, the first time only.
+		if n := srv.count(); n != 2 {
+			t.Errorf("after LookupHost #%d, server saw %d queries; want 2", i, n)
+		}
+	}
+}
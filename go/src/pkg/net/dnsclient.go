@@ -7,9 +7,11 @@ package net
 import (
 	"bytes"
 	"fmt"
+	"net/dns"
 	"os"
 	"rand"
 	"sort"
+	"sync"
 )
 
 // DNSError represents a DNS lookup error.
@@ -37,6 +39,62 @@ func (e *DNSError) Temporary() bool { return e.IsTimeout }
 
 const noSuchHost = "no such host"
 
+// A DNSConfig tells the resolver which name servers to query and
+// how, as /etc/resolv.conf does.
+type DNSConfig struct {
+	Servers  []string // IP addresses of name servers, with optional ports
+	Search   []string // domains to append to names that are not rooted
+	Ndots    int      // dots a name needs to be tried on its own first
+	Timeout  int      // seconds to wait for a reply; 0 means 5
+	Attempts int      // queries to send each server; 0 means 2
+	Rotate   bool     // spread queries across the servers
+}
+
+var dnsConfigOverride struct {
+	sync.Mutex
+	conf *DNSConfig
+}
+
+// SetDNSConfig makes the resolver use conf in place of the system
+// configuration until SetDNSConfig is called again; nil restores
+// the system configuration.  While conf is in effect, LookupHost,
+// LookupIP and LookupCNAME use the resolver in this package even where
+// they would otherwise use the C library's.  A server address given
+// without a port refers to port 53.  SetDNSConfig also flushes the
+// DNS cache.
+//
+// SetDNSConfig has no effect on Windows and Plan 9, where lookups are
+// always made by the operating system.
+func SetDNSConfig(conf *DNSConfig) os.Error {
+	var c *DNSConfig
+	if conf != nil {
+		c = new(DNSConfig)
+		*c = *conf
+		c.Servers = make([]string, len(conf.Servers))
+		for i, s := range conf.Servers {
+			if ParseIP(s) != nil {
+				s = JoinHostPort(s, "53")
+			} else if host, _, err := SplitHostPort(s); err != nil || ParseIP(host) == nil {
+				return os.NewError("net: DNS server address " + s + " is not an IP address")
+			}
+			c.Servers[i] = s
+		}
+		c.Search = make([]string, len(conf.Search))
+		copy(c.Search, conf.Search)
+		if c.Timeout <= 0 {
+			c.Timeout = 5
+		}
+		if c.Attempts <= 0 {
+			c.Attempts = 2
+		}
+	}
+	dnsConfigOverride.Lock()
+	dnsConfigOverride.conf = c
+	dnsConfigOverride.Unlock()
+	FlushDNSCache()
+	return nil
+}
+
 // reverseaddr returns the in-addr.arpa. or ip6.arpa. hostname of the IP
 // address addr suitable for rDNS (PTR) record lookup or an error if it fails
 // to parse the IP address.
@@ -64,13 +122,13 @@ func reverseaddr(addr string) (arpa string, err os.Error) {
 
 // Find answer for name in dns message.
 // On return, if err == nil, addrs != nil.
-func answer(name, server string, dns *dnsMsg, qtype uint16) (cname string, addrs []dnsRR, err os.Error) {
-	addrs = make([]dnsRR, 0, len(dns.answer))
+func answer(name, server string, msg *dns.Msg, qtype uint16) (cname string, addrs []dns.RR, err os.Error) {
+	addrs = make([]dns.RR, 0, len(msg.Answer))
 
-	if dns.rcode == dnsRcodeNameError && dns.recursion_available {
+	if msg.Rcode == dns.RcodeNameError && (msg.RecursionAvailable || msg.Authoritative) {
 		return "", nil, &DNSError{Error: noSuchHost, Name: name}
 	}
-	if dns.rcode != dnsRcodeSuccess {
+	if msg.Rcode != dns.RcodeSuccess {
 		// None of the error codes make sense
 		// for the query we sent.  If we didn't get
 		// a name error and we didn't get success,
@@ -86,8 +144,8 @@ func answer(name, server string, dns *dnsMsg, qtype uint16) (cname string, addrs
 Cname:
 	for cnameloop := 0; cnameloop < 10; cnameloop++ {
 		addrs = addrs[0:0]
-		for _, rr := range dns.answer {
-			if _, justHeader := rr.(*dnsRR_Header); justHeader {
+		for _, rr := range msg.Answer {
+			if _, justHeader := rr.(*dns.RRHeader); justHeader {
 				// Corrupt record: we only have a
 				// header. That header might say it's
 				// of type qtype, but we don't
@@ -95,13 +153,13 @@ Cname:
 				continue
 			}
 			h := rr.Header()
-			if h.Class == dnsClassINET && h.Name == name {
+			if h.Class == dns.ClassINET && h.Name == name {
 				switch h.Rrtype {
 				case qtype:
 					addrs = append(addrs, rr)
-				case dnsTypeCNAME:
+				case dns.TypeCNAME:
 					// redirect to cname
-					name = rr.(*dnsRR_CNAME).Cname
+					name = rr.(*dns.CNAME).Cname
 					continue Cname
 				}
 			}
@@ -245,3 +303,19 @@ func (s byPref) sort() {
 	}
 	sort.Sort(s)
 }
+
+// An NS represents a single DNS NS record.
+type NS struct {
+	Host string
+}
+
+// An SOA represents a DNS SOA record, which describes a zone.
+type SOA struct {
+	Ns      string // primary name server
+	Mbox    string // mailbox of the person responsible, as a domain name
+	Serial  uint32
+	Refresh uint32 // seconds
+	Retry   uint32 // seconds
+	Expire  uint32 // seconds
+	Minttl  uint32 // seconds for which to cache negative answers
+}
@@ -8,35 +8,70 @@
 // TODO(rsc):
 //	Check periodically whether /etc/resolv.conf has changed.
 //	Could potentially handle many outstanding lookups faster.
-//	Could have a small cache.
 //	Random UDP source port (net.Dial should do that for us).
 //	Random request IDs.
 
 package net
 
 import (
+	"io"
+	"net/dns"
 	"os"
 	"rand"
 	"sync"
 	"time"
 )
 
-// Send a request on the connection and hope for a reply.
-// Up to cfg.attempts attempts.
-func exchange(cfg *dnsConfig, c Conn, name string, qtype uint16) (*dnsMsg, os.Error) {
+// ednsUDPSize is the UDP payload size advertised in queries. Most
+// answers fit, and truncated ones are asked again over TCP.
+const ednsUDPSize = 4096
+
+// Send a query for name and qtype to server and return the reply.
+// The query goes out over UDP with an EDNS0 OPT record, and again
+// without one if the server does not understand it. A truncated
+// reply is retried over TCP, which can carry any answer.
+func exchange(cfg *dnsConfig, server, name string, qtype uint16) (*dns.Msg, os.Error) {
 	if len(name) >= 256 {
 		return nil, &DNSError{Error: "name too long", Name: name}
 	}
-	out := new(dnsMsg)
-	out.id = uint16(rand.Int()) ^ uint16(time.Nanoseconds())
-	out.question = []dnsQuestion{
-		{name, qtype, dnsClassINET},
+	out := new(dns.Msg)
+	out.Id = uint16(rand.Int()) ^ uint16(time.Nanoseconds())
+	out.Question = []dns.Question{
+		{name, qtype, dns.ClassINET},
+	}
+	out.RecursionDesired = true
+	out.Extra = []dns.RR{dns.NewOPT(ednsUDPSize)}
+
+	in, err := exchangeUDP(cfg, server, out)
+	if err == nil && in.Rcode == dns.RcodeFormatError && in.OPT() == nil {
+		out.Extra = nil
+		in, err = exchangeUDP(cfg, server, out)
+	}
+	if err == nil && in.Truncated {
+		in, err = exchangeTCP(cfg, server, out)
 	}
-	out.recursion_desired = true
+	return in, err
+}
+
+// Send a request on a UDP connection and hope for a reply.
+// Up to cfg.attempts attempts.
+func exchangeUDP(cfg *dnsConfig, server string, out *dns.Msg) (*dns.Msg, os.Error) {
+	name := out.Question[0].Name
 	msg, ok := out.Pack()
 	if !ok {
 		return nil, &DNSError{Error: "internal error - cannot pack message", Name: name}
 	}
+	// Calling Dial here is scary -- we have to be sure
+	// not to dial a name that will require a DNS lookup,
+	// or Dial will call back here to translate it.
+	// The DNS config parser and SetDNSConfig have already checked that
+	// all the cfg.servers[i] are IP addresses, which
+	// Dial will use without a DNS lookup.
+	c, err := Dial("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
 
 	for attempt := 0; attempt < cfg.attempts; attempt++ {
 		n, err := c.Write(msg)
@@ -46,7 +81,7 @@ func exchange(cfg *dnsConfig, c Conn, name string, qtype uint16) (*dnsMsg, os.Er
 
 		c.SetReadTimeout(int64(cfg.timeout) * 1e9) // nanoseconds
 
-		buf := make([]byte, 2000) // More than enough.
+		buf := make([]byte, ednsUDPSize)
 		n, err = c.Read(buf)
 		if err != nil {
 			if e, ok := err.(Error); ok && e.Timeout() {
@@ -55,66 +90,105 @@ func exchange(cfg *dnsConfig, c Conn, name string, qtype uint16) (*dnsMsg, os.Er
 			return nil, err
 		}
 		buf = buf[0:n]
-		in := new(dnsMsg)
-		if !in.Unpack(buf) || in.id != out.id {
+		in := new(dns.Msg)
+		if !in.Unpack(buf) || in.Id != out.Id {
 			continue
 		}
 		return in, nil
 	}
-	var server string
-	if a := c.RemoteAddr(); a != nil {
-		server = a.String()
-	}
 	return nil, &DNSError{Error: "no answer from server", Name: name, Server: server, IsTimeout: true}
 }
 
+// Send a request over TCP, where each message is preceded by
+// its length as a two-byte big-endian number.
+func exchangeTCP(cfg *dnsConfig, server string, out *dns.Msg) (*dns.Msg, os.Error) {
+	name := out.Question[0].Name
+	msg, ok := out.Pack()
+	if !ok {
+		return nil, &DNSError{Error: "internal error - cannot pack message", Name: name}
+	}
+	c, err := Dial("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetTimeout(int64(cfg.timeout) * 1e9) // nanoseconds
+
+	buf := make([]byte, 2+len(msg))
+	buf[0], buf[1] = byte(len(msg)>>8), byte(len(msg))
+	copy(buf[2:], msg)
+	if _, err = c.Write(buf); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err = io.ReadFull(c, l[:]); err != nil {
+		return nil, err
+	}
+	buf = make([]byte, int(l[0])<<8|int(l[1]))
+	if _, err = io.ReadFull(c, buf); err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if !in.Unpack(buf) || in.Id != out.Id {
+		return nil, &DNSError{Error: "bad reply over tcp", Name: name, Server: server}
+	}
+	return in, nil
+}
+
+// nextServer is the index of the server to try first
+// when the configuration says to rotate among them.
+var (
+	nextServerMu sync.Mutex
+	nextServer   int
+)
+
 // Do a lookup for a single name, which must be rooted
 // (otherwise answer will not find the answers).
-func tryOneName(cfg *dnsConfig, name string, qtype uint16) (cname string, addrs []dnsRR, err os.Error) {
-	if len(cfg.servers) == 0 {
+func tryOneName(cfg *dnsConfig, name string, qtype uint16) (cname string, addrs []dns.RR, err os.Error) {
+	if cname, addrs, err, ok := cachedAnswer(name, qtype); ok {
+		return cname, addrs, err
+	}
+	n := len(cfg.servers)
+	if n == 0 {
 		return "", nil, &DNSError{Error: "no DNS servers", Name: name}
 	}
-	for i := 0; i < len(cfg.servers); i++ {
-		// Calling Dial here is scary -- we have to be sure
-		// not to dial a name that will require a DNS lookup,
-		// or Dial will call back here to translate it.
-		// The DNS config parser has already checked that
-		// all the cfg.servers[i] are IP addresses, which
-		// Dial will use without a DNS lookup.
-		server := cfg.servers[i] + ":53"
-		c, cerr := Dial("udp", server)
-		if cerr != nil {
-			err = cerr
-			continue
-		}
-		msg, merr := exchange(cfg, c, name, qtype)
-		c.Close()
+	first := 0
+	if cfg.rotate {
+		nextServerMu.Lock()
+		first = nextServer % n
+		nextServer = first + 1
+		nextServerMu.Unlock()
+	}
+	for i := 0; i < n; i++ {
+		server := cfg.servers[(first+i)%n]
+		msg, merr := exchange(cfg, server, name, qtype)
 		if merr != nil {
 			err = merr
 			continue
 		}
 		cname, addrs, err = answer(name, server, msg, qtype)
 		if err == nil || err.(*DNSError).Error == noSuchHost {
+			cacheAnswer(name, qtype, msg, cname, addrs, err)
 			break
 		}
 	}
 	return
 }
 
-func convertRR_A(records []dnsRR) []IP {
+func convertA(records []dns.RR) []IP {
 	addrs := make([]IP, len(records))
 	for i, rr := range records {
-		a := rr.(*dnsRR_A).A
+		a := rr.(*dns.A).A
 		addrs[i] = IPv4(byte(a>>24), byte(a>>16), byte(a>>8), byte(a))
 	}
 	return addrs
 }
 
-func convertRR_AAAA(records []dnsRR) []IP {
+func convertAAAA(records []dns.RR) []IP {
 	addrs := make([]IP, len(records))
 	for i, rr := range records {
 		a := make(IP, 16)
-		copy(a, rr.(*dnsRR_AAAA).AAAA[:])
+		copy(a, rr.(*dns.AAAA).AAAA[:])
 		addrs[i] = a
 	}
 	return addrs
@@ -127,13 +201,40 @@ func loadConfig() { cfg, dnserr = dnsReadConfig() }
 
 var onceLoadConfig sync.Once
 
-func lookup(name string, qtype uint16) (cname string, addrs []dnsRR, err os.Error) {
+// resolverConfig returns the configuration set by SetDNSConfig,
+// or else the one read from /etc/resolv.conf.
+func resolverConfig() (*dnsConfig, os.Error) {
+	dnsConfigOverride.Lock()
+	c := dnsConfigOverride.conf
+	dnsConfigOverride.Unlock()
+	if c != nil {
+		return &dnsConfig{c.Servers, c.Search, c.Ndots, c.Timeout, c.Attempts, c.Rotate}, nil
+	}
+	onceLoadConfig.Do(loadConfig)
+	return cfg, dnserr
+}
+
+// haveDNSConfig reports whether SetDNSConfig
+// has replaced the system configuration.
+func haveDNSConfig() bool {
+	dnsConfigOverride.Lock()
+	defer dnsConfigOverride.Unlock()
+	return dnsConfigOverride.conf != nil
+}
+
+// useGoResolver reports whether lookups must use the resolver in
+// this package rather than the C library: when SetDNSConfig has
+// replaced the system configuration, or when the DNS cache is on.
+func useGoResolver() bool {
+	return haveDNSConfig() || dnsCacheEnabled()
+}
+
+func lookup(name string, qtype uint16) (cname string, addrs []dns.RR, err os.Error) {
 	if !isDomainName(name) {
 		return name, nil, &DNSError{Error: "invalid domain name", Name: name}
 	}
-	onceLoadConfig.Do(loadConfig)
-	if dnserr != nil || cfg == nil {
-		err = dnserr
+	cfg, err := resolverConfig()
+	if err != nil || cfg == nil {
 		return
 	}
 	// If name is rooted (trailing dot) or has enough dots,
@@ -190,9 +291,8 @@ func goLookupHost(name string) (addrs []string, err os.Error) {
 	if len(addrs) > 0 {
 		return
 	}
-	onceLoadConfig.Do(loadConfig)
-	if dnserr != nil || cfg == nil {
-		err = dnserr
+	cfg, err := resolverConfig()
+	if err != nil || cfg == nil {
 		return
 	}
 	ips, err := goLookupIP(name)
@@ -213,22 +313,21 @@ func goLookupHost(name string) (addrs []string, err os.Error) {
 // depending on our lookup code, so that Go and C get the same
 // answers.
 func goLookupIP(name string) (addrs []IP, err os.Error) {
-	onceLoadConfig.Do(loadConfig)
-	if dnserr != nil || cfg == nil {
-		err = dnserr
+	cfg, err := resolverConfig()
+	if err != nil || cfg == nil {
 		return
 	}
-	var records []dnsRR
+	var records []dns.RR
 	var cname string
-	cname, records, err = lookup(name, dnsTypeA)
+	cname, records, err = lookup(name, dns.TypeA)
 	if err != nil {
 		return
 	}
-	addrs = convertRR_A(records)
+	addrs = convertA(records)
 	if cname != "" {
 		name = cname
 	}
-	_, records, err = lookup(name, dnsTypeAAAA)
+	_, records, err = lookup(name, dns.TypeAAAA)
 	if err != nil && len(addrs) > 0 {
 		// Ignore error because A lookup succeeded.
 		err = nil
@@ -236,7 +335,7 @@ func goLookupIP(name string) (addrs []IP, err os.Error) {
 	if err != nil {
 		return
 	}
-	addrs = append(addrs, convertRR_AAAA(records)...)
+	addrs = append(addrs, convertAAAA(records)...)
 	return
 }
 
@@ -247,15 +346,14 @@ func goLookupIP(name string) (addrs []IP, err os.Error) {
 // depending on our lookup code, so that Go and C get the same
 // answers.
 func goLookupCNAME(name string) (cname string, err os.Error) {
-	onceLoadConfig.Do(loadConfig)
-	if dnserr != nil || cfg == nil {
-		err = dnserr
+	cfg, err := resolverConfig()
+	if err != nil || cfg == nil {
 		return
 	}
-	_, rr, err := lookup(name, dnsTypeCNAME)
+	_, rr, err := lookup(name, dns.TypeCNAME)
 	if err != nil {
 		return
 	}
-	cname = rr[0].(*dnsRR_CNAME).Cname
+	cname = rr[0].(*dns.CNAME).Cname
 	return
 }
@@ -9,7 +9,7 @@ package net
 import "os"
 
 type dnsConfig struct {
-	servers  []string // servers to use
+	servers  []string // server addresses (host:port) to use
 	search   []string // suffixes to append to local name
 	ndots    int      // number of dots in name to trigger absolute lookup
 	timeout  int      // seconds before giving up on packet
@@ -60,13 +60,9 @@ func dnsReadConfig() (*dnsConfig, os.Error) {
 				// just an IP address.  Otherwise we need DNS
 				// to look it up.
 				name := f[1]
-				switch len(ParseIP(name)) {
-				case 16:
-					name = "[" + name + "]"
-					fallthrough
-				case 4:
+				if ParseIP(name) != nil {
 					a = a[0 : n+1]
-					a[n] = name
+					a[n] = JoinHostPort(name, "53")
 					conf.servers = a
 				}
 			}
@@ -6,6 +6,7 @@ package net
 
 import (
 	"encoding/hex"
+	"net/dns"
 	"testing"
 )
 
@@ -14,23 +15,23 @@ func TestDNSParseSRVReply(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	msg := new(dnsMsg)
+	msg := new(dns.Msg)
 	ok := msg.Unpack(data)
 	if !ok {
 		t.Fatalf("unpacking packet failed")
 	}
-	if g, e := len(msg.answer), 5; g != e {
-		t.Errorf("len(msg.answer) = %d; want %d", g, e)
+	if g, e := len(msg.Answer), 5; g != e {
+		t.Errorf("len(msg.Answer) = %d; want %d", g, e)
 	}
-	for idx, rr := range msg.answer {
-		if g, e := rr.Header().Rrtype, uint16(dnsTypeSRV); g != e {
+	for idx, rr := range msg.Answer {
+		if g, e := rr.Header().Rrtype, uint16(dns.TypeSRV); g != e {
 			t.Errorf("rr[%d].Header().Rrtype = %d; want %d", idx, g, e)
 		}
-		if _, ok := rr.(*dnsRR_SRV); !ok {
-			t.Errorf("answer[%d] = %T; want *dnsRR_SRV", idx, rr)
+		if _, ok := rr.(*dns.SRV); !ok {
+			t.Errorf("answer[%d] = %T; want *dns.SRV", idx, rr)
 		}
 	}
-	_, addrs, err := answer("_xmpp-server._tcp.google.com.", "foo:53", msg, uint16(dnsTypeSRV))
+	_, addrs, err := answer("_xmpp-server._tcp.google.com.", "foo:53", msg, uint16(dns.TypeSRV))
 	if err != nil {
 		t.Fatalf("answer: %v", err)
 	}
@@ -45,29 +46,29 @@ func TestDNSParseCorruptSRVReply(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	msg := new(dnsMsg)
+	msg := new(dns.Msg)
 	ok := msg.Unpack(data)
 	if !ok {
 		t.Fatalf("unpacking packet failed")
 	}
-	if g, e := len(msg.answer), 5; g != e {
-		t.Errorf("len(msg.answer) = %d; want %d", g, e)
+	if g, e := len(msg.Answer), 5; g != e {
+		t.Errorf("len(msg.Answer) = %d; want %d", g, e)
 	}
-	for idx, rr := range msg.answer {
-		if g, e := rr.Header().Rrtype, uint16(dnsTypeSRV); g != e {
+	for idx, rr := range msg.Answer {
+		if g, e := rr.Header().Rrtype, uint16(dns.TypeSRV); g != e {
 			t.Errorf("rr[%d].Header().Rrtype = %d; want %d", idx, g, e)
 		}
 		if idx == 4 {
-			if _, ok := rr.(*dnsRR_Header); !ok {
-				t.Errorf("answer[%d] = %T; want *dnsRR_Header", idx, rr)
+			if _, ok := rr.(*dns.RRHeader); !ok {
+				t.Errorf("answer[%d] = %T; want *dns.RRHeader", idx, rr)
 			}
 		} else {
-			if _, ok := rr.(*dnsRR_SRV); !ok {
-				t.Errorf("answer[%d] = %T; want *dnsRR_SRV", idx, rr)
+			if _, ok := rr.(*dns.SRV); !ok {
+				t.Errorf("answer[%d] = %T; want *dns.SRV", idx, rr)
 			}
 		}
 	}
-	_, addrs, err := answer("_xmpp-server._tcp.google.com.", "foo:53", msg, uint16(dnsTypeSRV))
+	_, addrs, err := answer("_xmpp-server._tcp.google.com.", "foo:53", msg, uint16(dns.TypeSRV))
 	if err != nil {
 		t.Fatalf("answer: %v", err)
 	}
@@ -0,0 +1,215 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsserver
+
+import (
+	"net"
+	"net/dns"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultTTL is the time to live, in seconds, of records
+// parsed by ParseRR that do not give one.
+const DefaultTTL = 3600
+
+var rrTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"NS":    dns.TypeNS,
+	"PTR":   dns.TypePTR,
+	"SOA":   dns.TypeSOA,
+	"SRV":   dns.TypeSRV,
+	"TXT":   dns.TypeTXT,
+}
+
+// rrFields gives the number of data fields of the types
+// with more than one.
+var rrFields = map[uint16]int{
+	dns.TypeMX:  2,
+	dns.TypeSOA: 7,
+	dns.TypeSRV: 4,
+}
+
+// ParseRR parses a resource record written as in a zone file
+// (RFC 1035, section 5.1):
+//
+//	name [ttl] [IN] type data
+//
+// where the data depends on the type:
+//
+//	A                IPv4 address
+//	AAAA             IPv6 address
+//	CNAME, NS, PTR   domain name
+//	MX               preference, mail exchange
+//	SRV              priority, weight, port, target
+//	TXT              strings of at most 255 bytes; quote those with spaces
+//	SOA              name server, mailbox, serial, refresh,
+//	                 retry, expire, minimum TTL
+//
+// There is no $ORIGIN: domain names are made absolute by adding
+// a final dot if they lack one.  The TTL defaults to DefaultTTL.
+func ParseRR(s string) (dns.RR, os.Error) {
+	f, err := fields(s)
+	if err != nil {
+		return nil, parseError(s, err.String())
+	}
+	if len(f) < 2 {
+		return nil, parseError(s, "too few fields")
+	}
+	h := dns.RRHeader{Name: absName(f[0]), Class: dns.ClassINET, Ttl: DefaultTTL}
+	f = f[1:]
+	for i := 0; i < 2 && len(f) > 0; i++ {
+		if strings.ToUpper(f[0]) == "IN" {
+			f = f[1:]
+		} else if ttl, ok := parseUint32(f[0]); ok {
+			h.Ttl = ttl
+			f = f[1:]
+		}
+	}
+	if len(f) == 0 {
+		return nil, parseError(s, "missing type")
+	}
+	typ := strings.ToUpper(f[0])
+	h.Rrtype = rrTypes[typ]
+	if h.Rrtype == 0 {
+		return nil, parseError(s, "unsupported type "+f[0])
+	}
+	data := f[1:]
+	if h.Rrtype == dns.TypeTXT {
+		if len(data) == 0 {
+			return nil, parseError(s, "TXT record needs text")
+		}
+		for _, txt := range data {
+			if len(txt) > 255 {
+				return nil, parseError(s, "TXT string longer than 255 bytes")
+			}
+		}
+	} else {
+		want, ok := rrFields[h.Rrtype]
+		if !ok {
+			want = 1
+		}
+		if len(data) != want {
+			return nil, parseError(s, typ+" record needs "+strconv.Itoa(want)+" fields")
+		}
+	}
+
+	// Numeric fields, in the order they appear.
+	var n []uint32
+	switch h.Rrtype {
+	case dns.TypeMX:
+		n, err = parseUint32s(data[:1], 1<<16-1)
+	case dns.TypeSRV:
+		n, err = parseUint32s(data[:3], 1<<16-1)
+	case dns.TypeSOA:
+		n, err = parseUint32s(data[2:], 1<<32-1)
+	}
+	if err != nil {
+		return nil, parseError(s, err.String())
+	}
+
+	switch h.Rrtype {
+	case dns.TypeA:
+		ip := net.ParseIP(data[0]).To4()
+		if ip == nil {
+			return nil, parseError(s, "bad IPv4 address "+data[0])
+		}
+		return &dns.A{h, uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])}, nil
+	case dns.TypeAAAA:
+		ip := net.ParseIP(data[0])
+		if ip == nil || strings.Index(data[0], ":") < 0 {
+			return nil, parseError(s, "bad IPv6 address "+data[0])
+		}
+		rr := &dns.AAAA{Hdr: h}
+		copy(rr.AAAA[:], ip.To16())
+		return rr, nil
+	case dns.TypeCNAME:
+		return &dns.CNAME{h, absName(data[0])}, nil
+	case dns.TypeNS:
+		return &dns.NS{h, absName(data[0])}, nil
+	case dns.TypePTR:
+		return &dns.PTR{h, absName(data[0])}, nil
+	case dns.TypeMX:
+		return &dns.MX{h, uint16(n[0]), absName(data[1])}, nil
+	case dns.TypeSRV:
+		return &dns.SRV{h, uint16(n[0]), uint16(n[1]), uint16(n[2]), absName(data[3])}, nil
+	case dns.TypeTXT:
+		return &dns.TXT{h, data}, nil
+	case dns.TypeSOA:
+		return &dns.SOA{h, absName(data[0]), absName(data[1]), n[0], n[1], n[2], n[3], n[4]}, nil
+	}
+	panic("unreachable")
+}
+
+func parseError(s, why string) os.Error {
+	return os.NewError("dnsserver: bad record " + strconv.Quote(s) + ": " + why)
+}
+
+func absName(s string) string {
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
+
+func parseUint32(s string) (uint32, bool) {
+	n, err := strconv.Atoui64(s)
+	if err != nil || n > 1<<32-1 {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+func parseUint32s(f []string, max uint32) ([]uint32, os.Error) {
+	n := make([]uint32, len(f))
+	for i, s := range f {
+		v, ok := parseUint32(s)
+		if !ok || v > max {
+			return nil, os.NewError("bad number " + s)
+		}
+		n[i] = v
+	}
+	return n, nil
+}
+
+// fields splits s at white space, treating a double-quoted string,
+// in which a backslash escapes the next character, as one field.
+func fields(s string) ([]string, os.Error) {
+	var f []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			var b []byte
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, os.NewError("unterminated string")
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b = append(b, s[i])
+			}
+			f = append(f, string(b))
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' && s[j] != '"' {
+				j++
+			}
+			f = append(f, s[i:j])
+			i = j
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnsserver implements an authoritative DNS server that
+// answers queries over UDP and TCP from a table of resource records.
+//
+// It is meant for tests that exercise DNS lookups without the
+// network: start a server, then point the resolver at it with
+//
+//	net.SetDNSConfig(srv.DNSConfig())
+package dnsserver
+
+import (
+	"io"
+	"net"
+	"net/dns"
+	"os"
+	"sync"
+)
+
+const (
+	minUDPSize  = 512   // largest UDP reply to a query without EDNS0
+	maxUDPSize  = 4096  // largest UDP reply the server sends at all
+	maxTCPSize  = 65535 // largest reply over TCP
+	tcpIdleTime = 10e9  // nanoseconds a TCP connection may sit idle
+)
+
+// A Server answers DNS queries from a Table.
+type Server struct {
+	Addr  string // address to listen on, ":53" if empty
+	Table *Table // records to answer from; nil refuses every query
+
+	mu   sync.Mutex
+	addr string // address listened on, with the port Start picked
+	udp  net.PacketConn
+	tcp  net.Listener
+}
+
+// NewServer starts and returns a new Server answering from t on
+// a system-chosen port of the local loopback interface.
+// The caller should call Close when finished, to shut it down.
+func NewServer(t *Table) (*Server, os.Error) {
+	srv := &Server{Addr: "127.0.0.1:0", Table: t}
+	if err := srv.Start(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Start listens on srv.Addr for queries over both UDP and TCP and
+// answers them in the background until Close is called.  If srv.Addr
+// has port 0, Start picks a port free for both; LocalAddr reports it.
+func (srv *Server) Start() os.Error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":53"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	var udp net.PacketConn
+	var tcp net.Listener
+	for try := 0; ; try++ {
+		udp, err = net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		// Listen for TCP on the port UDP got.
+		_, p, _ := net.SplitHostPort(udp.LocalAddr().String())
+		tcp, err = net.Listen("tcp", net.JoinHostPort(host, p))
+		if err == nil {
+			port = p
+			break
+		}
+		udp.Close()
+		if port != "0" || try == 10 {
+			return err
+		}
+	}
+	srv.mu.Lock()
+	srv.udp, srv.tcp = udp, tcp
+	srv.addr = net.JoinHostPort(host, port)
+	srv.mu.Unlock()
+	go srv.ServeUDP(udp)
+	go srv.ServeTCP(tcp)
+	return nil
+}
+
+// Close stops a server started by Start.
+func (srv *Server) Close() os.Error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.udp == nil {
+		return os.EINVAL
+	}
+	err := srv.udp.Close()
+	if err1 := srv.tcp.Close(); err == nil {
+		err = err1
+	}
+	srv.udp, srv.tcp = nil, nil
+	return err
+}
+
+// LocalAddr returns the address srv listens on, with the port
+// that Start picked, or "" if srv has not been started.
+func (srv *Server) LocalAddr() string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.addr
+}
+
+// DNSConfig returns a resolver configuration
+// that sends every query to srv.
+func (srv *Server) DNSConfig() *net.DNSConfig {
+	return &net.DNSConfig{Servers: []string{srv.LocalAddr()}}
+}
+
+// ServeUDP answers the queries arriving on c
+// until reading from c fails.
+func (srv *Server) ServeUDP(c net.PacketConn) os.Error {
+	buf := make([]byte, maxTCPSize)
+	for {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if reply := srv.reply(buf[:n], true); reply != nil {
+			c.WriteTo(reply, addr)
+		}
+	}
+	panic("unreachable")
+}
+
+// ServeTCP accepts connections on l and answers the queries
+// arriving on them until accepting fails.
+func (srv *Server) ServeTCP(l net.Listener) os.Error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveConn(c)
+	}
+	panic("unreachable")
+}
+
+// serveConn answers the queries on a TCP connection, where each
+// message is preceded by its length as a two-byte big-endian number.
+func (srv *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	for {
+		c.SetTimeout(tcpIdleTime)
+		var l [2]byte
+		if _, err := io.ReadFull(c, l[:]); err != nil {
+			return
+		}
+		msg := make([]byte, int(l[0])<<8|int(l[1]))
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return
+		}
+		reply := srv.reply(msg, false)
+		if reply == nil {
+			return
+		}
+		buf := make([]byte, 2+len(reply))
+		buf[0], buf[1] = byte(len(reply)>>8), byte(len(reply))
+		copy(buf[2:], reply)
+		if _, err := c.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+// reply returns the reply to the query msg, which came over UDP
+// if udp is set, or nil if msg is not a query worth replying to.
+func (srv *Server) reply(msg []byte, udp bool) []byte {
+	q := new(dns.Msg)
+	if !q.Unpack(msg) || q.Response {
+		return nil
+	}
+	m := new(dns.Msg)
+	m.Id = q.Id
+	m.Response = true
+	m.Opcode = q.Opcode
+	m.RecursionDesired = q.RecursionDesired
+	m.Question = q.Question
+	switch {
+	case q.Opcode != 0:
+		m.Rcode = dns.RcodeNotImplemented
+	case len(q.Question) != 1:
+		m.Rcode = dns.RcodeFormatError
+	case q.Question[0].Qclass != dns.ClassINET && q.Question[0].Qclass != dns.ClassANY:
+		m.Rcode = dns.RcodeRefused
+	case srv.Table == nil:
+		m.Rcode = dns.RcodeRefused
+	default:
+		srv.Table.answer(m, q.Question[0])
+	}
+
+	size := maxTCPSize
+	if udp {
+		size = minUDPSize
+	}
+	var opt []dns.RR
+	if o := q.OPT(); o != nil {
+		opt = []dns.RR{dns.NewOPT(maxUDPSize)}
+		if s := o.UDPSize(); udp && s > size {
+			size = s
+			if size > maxUDPSize {
+				size = maxUDPSize
+			}
+		}
+	}
+
+	// If the reply is too big, drop the additional records,
+	// and if it is still too big, all of them and say so.
+	m.Extra = append(m.Extra, opt...)
+	out, ok := m.Pack()
+	if ok && len(out) > size {
+		m.Extra = opt
+		out, ok = m.Pack()
+	}
+	if ok && len(out) > size {
+		m.Truncated = true
+		m.Answer, m.Ns = nil, nil
+		out, ok = m.Pack()
+	}
+	if !ok {
+		m.Rcode = dns.RcodeServerFailure
+		m.Truncated = false
+		m.Answer, m.Ns, m.Extra = nil, nil, opt
+		if out, ok = m.Pack(); !ok {
+			return nil
+		}
+	}
+	return out
+}
@@ -0,0 +1,250 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsserver
+
+import (
+	"io"
+	"net"
+	"net/dns"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testRecords = []string{
+	"example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 2011101601 7200 900 604800 300",
+	"example.com. NS ns.example.com.",
+	"example.com. MX 10 mail.example.com.",
+	"example.com. TXT \"v=spf1 mx\" \" -all\"",
+	"ns.example.com. A 192.0.2.1",
+	"mail.example.com. A 192.0.2.2",
+	"www.example.com. 300 A 192.0.2.3",
+	"www.example.com. 300 AAAA 2001:db8::3",
+	"alias.example.com. CNAME www.example.com.",
+	"_xmpp-server._tcp.example.com. SRV 5 0 5269 mail.example.com.",
+	"3.2.0.192.in-addr.arpa. PTR www.example.com.",
+	"2.0.192.in-addr.arpa. SOA ns.example.com. hostmaster.example.com. 1 7200 900 604800 300",
+}
+
+func newTestTable(t *testing.T) *Table {
+	tab := NewTable()
+	for _, s := range testRecords {
+		if err := tab.AddString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 400; i++ {
+		tab.AddString("big.example.com. A 10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256))
+	}
+	return tab
+}
+
+var parseTests = []struct {
+	in  string
+	out string // recordString of the result, "" for an error
+}{
+	{"a.example A 10.0.0.1", "a.example.\t3600\tIN\tA\t10.0.0.1"},
+	{"a.example. 60 IN A 10.0.0.1", "a.example.\t60\tIN\tA\t10.0.0.1"},
+	{"a.example IN 60 mx 5 mx.example", "a.example.\t60\tIN\tMX\t5\tmx.example."},
+	{`t.example TXT "hello, " world`, "t.example.\t3600\tIN\tTXT\t\"hello, \" \"world\""},
+	{"a.example A 2001:db8::1", ""},
+	{"a.example AAAA 10.0.0.1", ""},
+	{"a.example MX mx.example", ""},
+	{"a.example MX 70000 mx.example", ""},
+	{"a.example HINFO x y", ""},
+	{`t.example TXT "unterminated`, ""},
+	{"t.example TXT " + strings.Repeat("x", 256), ""},
+	{"a.example", ""},
+}
+
+func TestParseRR(t *testing.T) {
+	for _, tt := range parseTests {
+		rr, err := ParseRR(tt.in)
+		if tt.out == "" {
+			if err == nil {
+				t.Errorf("ParseRR(%q) = %v; want error", tt.in, rr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRR(%q): %v", tt.in, err)
+			continue
+		}
+		if s := recordString(rr); s != tt.out {
+			t.Errorf("ParseRR(%q) = %q; want %q", tt.in, s, tt.out)
+		}
+	}
+}
+
+// recordString formats rr in zone file order for comparison.
+func recordString(rr dns.RR) string {
+	h := rr.Header()
+	s := h.Name + "\t" + strconv.Uitoa(uint(h.Ttl)) + "\tIN\t"
+	switch rr := rr.(type) {
+	case *dns.A:
+		a := rr.A
+		return s + "A\t" + net.IPv4(byte(a>>24), byte(a>>16), byte(a>>8), byte(a)).String()
+	case *dns.MX:
+		return s + "MX\t" + strconv.Itoa(int(rr.Pref)) + "\t" + rr.Mx
+	case *dns.TXT:
+		q := make([]string, len(rr.Txt))
+		for i, txt := range rr.Txt {
+			q[i] = strconv.Quote(txt)
+		}
+		return s + "TXT\t" + strings.Join(q, " ")
+	}
+	return s + "?"
+}
+
+// query sends a query for name and qtype to addr over network
+// and returns the reply.
+func query(t *testing.T, network, addr, name string, qtype uint16, edns bool) *dns.Msg {
+	q := new(dns.Msg)
+	q.Id = 0x5a5a
+	q.RecursionDesired = true
+	q.Question = []dns.Question{{name, qtype, dns.ClassINET}}
+	if edns {
+		q.Extra = []dns.RR{dns.NewOPT(4096)}
+	}
+	msg, _ := q.Pack()
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetTimeout(5e9)
+	buf := make([]byte, 65535)
+	if network == "tcp" {
+		msg = append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+		if _, err = c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(c, buf[:2]); err != nil {
+			t.Fatal(err)
+		}
+		buf = buf[:int(buf[0])<<8|int(buf[1])]
+		_, err = io.ReadFull(c, buf)
+	} else {
+		if _, err = c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		n, err = c.Read(buf)
+		buf = buf[:n]
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(dns.Msg)
+	if !r.Unpack(buf) {
+		t.Fatalf("%s query for %s: bad reply", network, name)
+	}
+	if r.Id != q.Id || !r.Response {
+		t.Fatalf("%s query for %s: reply has id %#x, response %v", network, name, r.Id, r.Response)
+	}
+	return r
+}
+
+var serverTests = []struct {
+	network string
+	name    string
+	qtype   uint16
+	edns    bool
+	rcode   int
+	answers int
+	ns      int
+	extra   int // not counting OPT
+	trunc   bool
+}{
+	{"udp", "www.example.com.", dns.TypeA, false, dns.RcodeSuccess, 1, 0, 0, false},
+	{"udp", "WWW.Example.COM.", dns.TypeAAAA, false, dns.RcodeSuccess, 1, 0, 0, false},
+	{"udp", "alias.example.com.", dns.TypeA, false, dns.RcodeSuccess, 2, 0, 0, false},
+	{"udp", "example.com.", dns.TypeMX, false, dns.RcodeSuccess, 1, 0, 1, false},
+	{"udp", "www.example.com.", dns.TypeMX, false, dns.RcodeSuccess, 0, 1, 0, false},
+	{"udp", "in-addr.arpa.", dns.TypeA, false, dns.RcodeRefused, 0, 0, 0, false},
+	{"udp", "nowhere.example.com.", dns.TypeA, false, dns.RcodeNameError, 0, 1, 0, false},
+	{"udp", "_tcp.example.com.", dns.TypeA, false, dns.RcodeSuccess, 0, 1, 0, false},
+	{"udp", "big.example.com.", dns.TypeA, false, dns.RcodeSuccess, 0, 0, 0, true},
+	{"udp", "big.example.com.", dns.TypeA, true, dns.RcodeSuccess, 0, 0, 0, true},
+	{"tcp", "big.example.com.", dns.TypeA, false, dns.RcodeSuccess, 400, 0, 0, false},
+	{"tcp", "example.com.", dns.TypeALL, true, dns.RcodeSuccess, 4, 0, 2, false},
+}
+
+func TestServer(t *testing.T) {
+	srv, err := NewServer(newTestTable(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	for _, tt := range serverTests {
+		r := query(t, tt.network, srv.LocalAddr(), tt.name, tt.qtype, tt.edns)
+		extra := len(r.Extra)
+		if r.OPT() != nil {
+			extra--
+		}
+		if (r.OPT() != nil) != tt.edns {
+			t.Errorf("%s %s/%d: OPT record %v; want one: %v", tt.network, tt.name, tt.qtype, r.OPT(), tt.edns)
+		}
+		if r.Rcode != tt.rcode || len(r.Answer) != tt.answers || len(r.Ns) != tt.ns || extra != tt.extra || r.Truncated != tt.trunc {
+			t.Errorf("%s %s/%d: rcode %d, %d/%d/%d records, truncated %v; want %d, %d/%d/%d, %v",
+				tt.network, tt.name, tt.qtype, r.Rcode, len(r.Answer), len(r.Ns), extra, r.Truncated,
+				tt.rcode, tt.answers, tt.ns, tt.extra, tt.trunc)
+		}
+		for _, rr := range r.Answer {
+			if rr.Header().Name != tt.name && rr.Header().Rrtype != dns.TypeA {
+				t.Errorf("%s %s/%d: answer owned by %s", tt.network, tt.name, tt.qtype, rr.Header().Name)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Logf("skipping test: the system resolver cannot be redirected")
+		return
+	}
+	srv, err := NewServer(newTestTable(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if err = net.SetDNSConfig(srv.DNSConfig()); err != nil {
+		t.Fatal(err)
+	}
+	defer net.SetDNSConfig(nil)
+
+	addrs, err := net.LookupHost("alias.example.com")
+	if err != nil || len(addrs) != 2 || addrs[0] != "192.0.2.3" || addrs[1] != "2001:db8::3" {
+		t.Errorf("LookupHost(alias) = %v, %v", addrs, err)
+	}
+	if _, err = net.LookupHost("nowhere.example.com"); err == nil {
+		t.Error("LookupHost(nowhere) succeeded")
+	} else if e, ok := err.(*net.DNSError); !ok || e.Error != "no such host" {
+		t.Errorf("LookupHost(nowhere): %v; want no such host", err)
+	}
+	// The answer is too big for UDP; the resolver must ask again over TCP.
+	if addrs, err = net.LookupHost("big.example.com"); err != nil || len(addrs) != 400 {
+		t.Errorf("LookupHost(big) = %d addresses, %v; want 400", len(addrs), err)
+	}
+	if mx, err := net.LookupMX("example.com"); err != nil || len(mx) != 1 || mx[0].Host != "mail.example.com." {
+		t.Errorf("LookupMX = %v, %v", mx, err)
+	}
+	if txt, err := net.LookupTXT("example.com"); err != nil || len(txt) != 1 || txt[0] != "v=spf1 mx -all" {
+		t.Errorf("LookupTXT = %q, %v", txt, err)
+	}
+	if ns, err := net.LookupNS("example.com"); err != nil || len(ns) != 1 || ns[0].Host != "ns.example.com." {
+		t.Errorf("LookupNS = %v, %v", ns, err)
+	}
+	if soa, err := net.LookupSOA("example.com"); err != nil || soa.Serial != 2011101601 || soa.Minttl != 300 {
+		t.Errorf("LookupSOA = %+v, %v", soa, err)
+	}
+	if _, addrs, err := net.LookupSRV("xmpp-server", "tcp", "example.com"); err != nil || len(addrs) != 1 || addrs[0].Port != 5269 {
+		t.Errorf("LookupSRV = %v, %v", addrs, err)
+	}
+	if names, err := net.LookupAddr("192.0.2.3"); err != nil || len(names) != 1 || names[0] != "www.example.com." {
+		t.Errorf("LookupAddr = %v, %v", names, err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsserver
+
+import (
+	"net/dns"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// maxCNAMEs bounds the length of the CNAME chains followed
+// in answering a query.
+const maxCNAMEs = 8
+
+// A Table is a zone table: the resource records a Server answers
+// from.  It may hold several zones.  Each zone is introduced by an SOA
+// record and holds the names at and below the SOA record's name, save
+// those in zones further down; the server is authoritative for them and
+// refuses queries for names in no zone.  A Table may be added to while
+// servers answer from it.
+type Table struct {
+	mu    sync.RWMutex
+	names map[string][]dns.RR // records by lower-case owner name
+	nodes map[string]bool     // names that exist because names below them do
+}
+
+// NewTable returns a new, empty Table.
+func NewTable() *Table {
+	return &Table{names: make(map[string][]dns.RR), nodes: make(map[string]bool)}
+}
+
+// Add adds rr to the table.  An owner name that does not end in a dot
+// is made absolute by adding one, and a zero class means the Internet.
+func (t *Table) Add(rr dns.RR) {
+	h := rr.Header()
+	if !strings.HasSuffix(h.Name, ".") {
+		h.Name += "."
+	}
+	if h.Class == 0 {
+		h.Class = dns.ClassINET
+	}
+	key := strings.ToLower(h.Name)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names[key] = append(t.names[key], rr)
+	for n := parent(key); n != ""; n = parent(n) {
+		t.nodes[n] = true
+	}
+}
+
+// AddString parses the record s with ParseRR and adds it to the table.
+func (t *Table) AddString(s string) os.Error {
+	rr, err := ParseRR(s)
+	if err != nil {
+		return err
+	}
+	t.Add(rr)
+	return nil
+}
+
+// parent returns the name one level above the absolute name n,
+// or "" if n is the root.
+func parent(n string) string {
+	if n == "." {
+		return ""
+	}
+	if i := strings.Index(n, "."); i < len(n)-1 {
+		return n[i+1:]
+	}
+	return "."
+}
+
+// zone returns the SOA record of the zone holding key,
+// or nil if there is none.  The caller must hold t.mu.
+func (t *Table) zone(key string) *dns.SOA {
+	for n := key; n != ""; n = parent(n) {
+		for _, rr := range t.names[n] {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return soa
+			}
+		}
+	}
+	return nil
+}
+
+// answer fills in m, a reply, with the answer to the question q.
+func (t *Table) answer(m *dns.Msg, q dns.Question) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	name := q.Name
+	key := strings.ToLower(name)
+	soa := t.zone(key)
+	if soa == nil {
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+	m.Authoritative = true
+	for i := 0; i < maxCNAMEs; i++ {
+		rrs := t.names[key]
+		if len(rrs) == 0 && !t.nodes[key] {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, copyRR(soa, soa.Hdr.Name))
+			return
+		}
+		found := false
+		var cname *dns.CNAME
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeALL {
+				// Answer with the name as asked, in case
+				// the resolver compares names exactly.
+				m.Answer = append(m.Answer, copyRR(rr, name))
+				found = true
+			} else if c, ok := rr.(*dns.CNAME); ok {
+				cname = c
+			}
+		}
+		if !found && cname == nil {
+			// The name exists, but not with records of this type.
+			m.Ns = append(m.Ns, copyRR(soa, soa.Hdr.Name))
+			return
+		}
+		if found {
+			t.additional(m)
+			return
+		}
+		m.Answer = append(m.Answer, copyRR(cname, name))
+		name = cname.Cname
+		key = strings.ToLower(name)
+		if soa = t.zone(key); soa == nil {
+			// The resolver must look for the target elsewhere.
+			return
+		}
+	}
+}
+
+// additional adds to m the addresses of the hosts
+// named in its answers, if the table has them.
+// The caller must hold t.mu.
+func (t *Table) additional(m *dns.Msg) {
+	seen := make(map[string]bool)
+	for _, rr := range m.Answer {
+		var host string
+		switch rr := rr.(type) {
+		case *dns.MX:
+			host = rr.Mx
+		case *dns.NS:
+			host = rr.Ns
+		case *dns.SRV:
+			host = rr.Target
+		default:
+			continue
+		}
+		key := strings.ToLower(host)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		for _, a := range t.names[key] {
+			if typ := a.Header().Rrtype; typ == dns.TypeA || typ == dns.TypeAAAA {
+				m.Extra = append(m.Extra, copyRR(a, host))
+			}
+		}
+	}
+}
+
+// copyRR returns a copy of rr with the given owner name.  Replies are
+// built from copies because packing a record writes to its header.
+func copyRR(rr dns.RR, owner string) dns.RR {
+	v := reflect.New(reflect.TypeOf(rr).Elem())
+	v.Elem().Set(reflect.ValueOf(rr).Elem())
+	c := v.Interface().(dns.RR)
+	c.Header().Name = owner
+	return c
+}
@@ -204,6 +204,78 @@ func LookupMX(name string) (mx []*MX, err os.Error) {
 	return
 }
 
+// LookupTXT returns the DNS TXT records for the given domain name.
+func LookupTXT(name string) (txt []string, err os.Error) {
+	lines, err := queryDNS(name, "txt")
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		if i := byteIndex(line, '\t'); i >= 0 {
+			txt = append(txt, line[i+1:])
+		}
+	}
+	return
+}
+
+// LookupNS returns the DNS NS records for the given domain name.
+func LookupNS(name string) (ns []*NS, err os.Error) {
+	lines, err := queryDNS(name, "ns")
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		f := getFields(line)
+		if len(f) < 3 {
+			continue
+		}
+		ns = append(ns, &NS{f[2]})
+	}
+	return
+}
+
+// LookupSOA returns the DNS SOA record for the given domain name,
+// which must be the name of a zone.
+func LookupSOA(name string) (soa *SOA, err os.Error) {
+	lines, err := queryDNS(name, "soa")
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		f := getFields(line)
+		if len(f) < 9 {
+			continue
+		}
+		var n [5]uint32
+		ok := true
+		for i := range n {
+			n[i], ok = dtou32(f[4+i])
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return &SOA{f[2], f[3], n[0], n[1], n[2], n[3], n[4]}, nil
+		}
+	}
+	return nil, os.NewError("net: bad response from ndb/dns")
+}
+
+// dtou32 parses s as a decimal uint32.  Serial numbers
+// in SOA records are too large for dtoi.
+func dtou32(s string) (n uint32, ok bool) {
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' || n > (1<<32-1-9)/10 {
+			return 0, false
+		}
+		n = n*10 + uint32(s[i]-'0')
+	}
+	return n, true
+}
+
 // LookupAddr performs a reverse lookup for the given address, returning a list
 // of names mapping to that address.
 func LookupAddr(addr string) (name []string, err os.Error) {
@@ -55,3 +55,45 @@ func TestGoogleDNSAddr(t *testing.T) {
 		t.Errorf("no results")
 	}
 }
+
+func TestGmailTXT(t *testing.T) {
+	if testing.Short() || avoidMacFirewall {
+		t.Logf("skipping test to avoid external network")
+		return
+	}
+	txt, err := LookupTXT("gmail.com")
+	if err != nil {
+		t.Errorf("failed: %s", err)
+	}
+	if len(txt) == 0 {
+		t.Errorf("no results")
+	}
+}
+
+func TestGoogleNS(t *testing.T) {
+	if testing.Short() || avoidMacFirewall {
+		t.Logf("skipping test to avoid external network")
+		return
+	}
+	ns, err := LookupNS("google.com")
+	if err != nil {
+		t.Errorf("failed: %s", err)
+	}
+	if len(ns) == 0 {
+		t.Errorf("no results")
+	}
+}
+
+func TestGoogleSOA(t *testing.T) {
+	if testing.Short() || avoidMacFirewall {
+		t.Logf("skipping test to avoid external network")
+		return
+	}
+	soa, err := LookupSOA("google.com")
+	if err != nil {
+		t.Errorf("failed: %s", err)
+	}
+	if soa == nil || soa.Ns == "" {
+		t.Errorf("no results")
+	}
+}
@@ -5,12 +5,17 @@
 package net
 
 import (
+	"net/dns"
 	"os"
+	"strings"
 )
 
 // LookupHost looks up the given host using the local resolver.
 // It returns an array of that host's addresses.
 func LookupHost(host string) (addrs []string, err os.Error) {
+	if useGoResolver() {
+		return goLookupHost(host)
+	}
 	addrs, err, ok := cgoLookupHost(host)
 	if !ok {
 		addrs, err = goLookupHost(host)
@@ -21,6 +26,9 @@ func LookupHost(host string) (addrs []string, err os.Error) {
 // LookupIP looks up host using the local resolver.
 // It returns an array of that host's IPv4 and IPv6 addresses.
 func LookupIP(host string) (addrs []IP, err os.Error) {
+	if useGoResolver() {
+		return goLookupIP(host)
+	}
 	addrs, err, ok := cgoLookupIP(host)
 	if !ok {
 		addrs, err = goLookupIP(host)
@@ -42,6 +50,9 @@ func LookupPort(network, service string) (port int, err os.Error) {
 // LookupHost or LookupIP directly; both take care of resolving
 // the canonical name as part of the lookup.
 func LookupCNAME(name string) (cname string, err os.Error) {
+	if useGoResolver() {
+		return goLookupCNAME(name)
+	}
 	cname, err, ok := cgoLookupCNAME(name)
 	if !ok {
 		cname, err = goLookupCNAME(name)
@@ -56,14 +67,14 @@ func LookupCNAME(name string) (cname string, err os.Error) {
 // and randomized by weight within a priority.
 func LookupSRV(service, proto, name string) (cname string, addrs []*SRV, err os.Error) {
 	target := "_" + service + "._" + proto + "." + name
-	var records []dnsRR
-	cname, records, err = lookup(target, dnsTypeSRV)
+	var records []dns.RR
+	cname, records, err = lookup(target, dns.TypeSRV)
 	if err != nil {
 		return
 	}
 	addrs = make([]*SRV, len(records))
 	for i, rr := range records {
-		r := rr.(*dnsRR_SRV)
+		r := rr.(*dns.SRV)
 		addrs[i] = &SRV{r.Target, r.Port, r.Priority, r.Weight}
 	}
 	byPriorityWeight(addrs).sort()
@@ -72,19 +83,57 @@ func LookupSRV(service, proto, name string) (cname string, addrs []*SRV, err os.
 
 // LookupMX returns the DNS MX records for the given domain name sorted by preference.
 func LookupMX(name string) (mx []*MX, err os.Error) {
-	_, rr, err := lookup(name, dnsTypeMX)
+	_, rr, err := lookup(name, dns.TypeMX)
 	if err != nil {
 		return
 	}
 	mx = make([]*MX, len(rr))
 	for i := range rr {
-		r := rr[i].(*dnsRR_MX)
+		r := rr[i].(*dns.MX)
 		mx[i] = &MX{r.Mx, r.Pref}
 	}
 	byPref(mx).sort()
 	return
 }
 
+// LookupTXT returns the DNS TXT records for the given domain name.
+func LookupTXT(name string) (txt []string, err os.Error) {
+	_, rr, err := lookup(name, dns.TypeTXT)
+	if err != nil {
+		return
+	}
+	txt = make([]string, len(rr))
+	for i := range rr {
+		txt[i] = strings.Join(rr[i].(*dns.TXT).Txt, "")
+	}
+	return
+}
+
+// LookupNS returns the DNS NS records for the given domain name.
+func LookupNS(name string) (ns []*NS, err os.Error) {
+	_, rr, err := lookup(name, dns.TypeNS)
+	if err != nil {
+		return
+	}
+	ns = make([]*NS, len(rr))
+	for i := range rr {
+		ns[i] = &NS{rr[i].(*dns.NS).Ns}
+	}
+	return
+}
+
+// LookupSOA returns the DNS SOA record for the given domain name,
+// which must be the name of a zone.
+func LookupSOA(name string) (soa *SOA, err os.Error) {
+	_, rr, err := lookup(name, dns.TypeSOA)
+	if err != nil {
+		return
+	}
+	r := rr[0].(*dns.SOA)
+	soa = &SOA{r.Ns, r.Mbox, r.Serial, r.Refresh, r.Retry, r.Expire, r.Minttl}
+	return
+}
+
 // LookupAddr performs a reverse lookup for the given address, returning a list
 // of names mapping to that address.
 func LookupAddr(addr string) (name []string, err os.Error) {
@@ -97,14 +146,14 @@ func LookupAddr(addr string) (name []string, err os.Error) {
 	if err != nil {
 		return
 	}
-	var records []dnsRR
-	_, records, err = lookup(arpa, dnsTypePTR)
+	var records []dns.RR
+	_, records, err = lookup(arpa, dns.TypePTR)
 	if err != nil {
 		return
 	}
 	name = make([]string, len(records))
 	for i := range records {
-		r := records[i].(*dnsRR_PTR)
+		r := records[i].(*dns.PTR)
 		name[i] = r.Ptr
 	}
 	return
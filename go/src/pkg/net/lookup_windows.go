@@ -110,6 +110,63 @@ func LookupMX(name string) (mx []*MX, err os.Error) {
 	return mx, nil
 }
 
+func LookupTXT(name string) (txt []string, err os.Error) {
+	var r *syscall.DNSRecord
+	e := syscall.DnsQuery(name, syscall.DNS_TYPE_TEXT, 0, nil, &r, nil)
+	if int(e) != 0 {
+		return nil, os.NewSyscallError("LookupTXT", int(e))
+	}
+	defer syscall.DnsRecordListFree(r, 1)
+	txt = make([]string, 0, 10)
+	for p := r; p != nil && p.Type == syscall.DNS_TYPE_TEXT; p = p.Next {
+		d := (*syscall.DNSTXTData)(unsafe.Pointer(&p.Data[0]))
+		s := ""
+		for _, v := range (*[1 << 10]*uint16)(unsafe.Pointer(&d.StringArray[0]))[:d.StringCount] {
+			s += syscall.UTF16ToString((*[256]uint16)(unsafe.Pointer(v))[:])
+		}
+		txt = append(txt, s)
+	}
+	return txt, nil
+}
+
+func LookupNS(name string) (ns []*NS, err os.Error) {
+	var r *syscall.DNSRecord
+	e := syscall.DnsQuery(name, syscall.DNS_TYPE_NS, 0, nil, &r, nil)
+	if int(e) != 0 {
+		return nil, os.NewSyscallError("LookupNS", int(e))
+	}
+	defer syscall.DnsRecordListFree(r, 1)
+	ns = make([]*NS, 0, 10)
+	for p := r; p != nil && p.Type == syscall.DNS_TYPE_NS; p = p.Next {
+		v := (*syscall.DNSPTRData)(unsafe.Pointer(&p.Data[0]))
+		ns = append(ns, &NS{syscall.UTF16ToString((*[256]uint16)(unsafe.Pointer(v.Host))[:]) + "."})
+	}
+	return ns, nil
+}
+
+func LookupSOA(name string) (soa *SOA, err os.Error) {
+	var r *syscall.DNSRecord
+	e := syscall.DnsQuery(name, syscall.DNS_TYPE_SOA, 0, nil, &r, nil)
+	if int(e) != 0 {
+		return nil, os.NewSyscallError("LookupSOA", int(e))
+	}
+	defer syscall.DnsRecordListFree(r, 1)
+	if r == nil || r.Type != syscall.DNS_TYPE_SOA {
+		return nil, &DNSError{Error: noSuchHost, Name: name}
+	}
+	v := (*syscall.DNSSOAData)(unsafe.Pointer(&r.Data[0]))
+	soa = &SOA{
+		Ns:      syscall.UTF16ToString((*[256]uint16)(unsafe.Pointer(v.NamePrimaryServer))[:]) + ".",
+		Mbox:    syscall.UTF16ToString((*[256]uint16)(unsafe.Pointer(v.NameAdministrator))[:]) + ".",
+		Serial:  v.SerialNo,
+		Refresh: v.Refresh,
+		Retry:   v.Retry,
+		Expire:  v.Expire,
+		Minttl:  v.DefaultTtl,
+	}
+	return soa, nil
+}
+
 func LookupAddr(addr string) (name []string, err os.Error) {
 	arpa, err := reverseaddr(addr)
 	if err != nil {
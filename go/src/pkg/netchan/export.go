@@ -16,12 +16,18 @@
 
 	Networked channels are not synchronized; they always behave
 	as if they are buffered channels of at least one element.
+
+	An importer made with ImportReconnecting survives the loss of
+	its connection: it dials the exporter again and resumes where
+	it was, exchanging heartbeats to notice connections that fail
+	without being closed.
 */
 package netchan
 
 // BUG: can't use range clause to receive when using ImportNValues to limit the count.
 
 import (
+	"bytes"
 	"log"
 	"io"
 	"net"
@@ -44,6 +50,7 @@ func expLog(args ...interface{}) {
 // but they must use different ports.
 type Exporter struct {
 	*clientSet
+	sessions map[uint64]*session // sessions of reconnecting importers; protected by mu
 }
 
 type expClient struct {
@@ -275,9 +282,33 @@ func (exp *Exporter) Serve(listener net.Listener) {
 }
 
 // ServeConn exports the Exporter's channels on conn.
-// It blocks until the connection is terminated.
+// It blocks until the connection is terminated.  If conn opens a session
+// for an importer made by ImportReconnecting, ServeConn blocks until the
+// session ends, and if conn resumes one, until conn fails in turn.
+// Sessions need a conn that is an io.Closer.
 func (exp *Exporter) ServeConn(conn io.ReadWriter) {
-	exp.addClient(conn).run()
+	// A session begins with a byte that cannot begin a gob stream.
+	var b [1]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		if err != os.EOF {
+			expLog("error reading from client:", err)
+		}
+		return
+	}
+	r := io.MultiReader(bytes.NewBuffer(b[:]), conn)
+	if b[0] == sessionMagic>>56 {
+		if c, ok := conn.(io.ReadWriteCloser); ok {
+			exp.serveSession(c, r)
+			return
+		}
+	}
+	exp.addClient(readWriter{r, conn}).run()
+}
+
+// readWriter joins a Reader and a Writer.
+type readWriter struct {
+	io.Reader
+	io.Writer
 }
 
 // NewExporter creates a new Exporter that exports a set of channels.
@@ -287,6 +318,7 @@ func NewExporter() *Exporter {
 			names:   make(map[string]*chanDir),
 			clients: make(map[unackedCounter]bool),
 		},
+		sessions: make(map[uint64]*session),
 	}
 	return e
 }
@@ -60,6 +60,26 @@ func Import(network, remoteaddr string) (*Importer, os.Error) {
 	return NewImporter(conn), nil
 }
 
+// ImportReconnecting is like Import, but the Importer survives the loss
+// of its connection: it dials again and resumes where the connection
+// failed, so no value is lost or delivered twice.  The importer and the
+// exporter each send a heartbeat every interval nanoseconds and drop a
+// connection on which they have heard nothing for two intervals, which
+// catches connections that fail without being closed.  If the importer
+// cannot resume within ten intervals, it gives up and shuts down as if
+// the connection had been closed.
+func ImportReconnecting(network, remoteaddr string, interval int64) (*Importer, os.Error) {
+	if interval <= 0 {
+		return nil, os.NewError("netchan import: heartbeat interval must be positive")
+	}
+	r := &redialer{network, remoteaddr}
+	s := newSession(0, interval, r.redial)
+	if err := r.dial(s); err != nil {
+		return nil, err
+	}
+	return NewImporter(s), nil
+}
+
 // shutdown closes all channels for which we are receiving data from the remote side.
 func (imp *Importer) shutdown() {
 	imp.chanLock.Lock()
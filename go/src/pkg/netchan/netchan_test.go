@@ -5,8 +5,10 @@
 package netchan
 
 import (
+	"encoding/binary"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -433,3 +435,194 @@ func pair(t *testing.T) (*Exporter, *Importer) {
 	imp := NewImporter(c1)
 	return exp, imp
 }
+
+// A proxy forwards connections to an exporter.  It can cut the
+// connections it has made, either closing them or leaving them open
+// but carrying nothing, as when the network between fails.
+type proxy struct {
+	addr  string // address to dial the exporter through
+	mu    sync.Mutex
+	cutc  chan bool // closed to cut the connections
+	conns []net.Conn
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("proxy listen:", err)
+	}
+	p := &proxy{addr: l.Addr().String(), cutc: make(chan bool)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			d, err := net.Dial("tcp", target)
+			if err != nil {
+				c.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, c, d)
+			cut := p.cutc
+			p.mu.Unlock()
+			go forward(d, c, cut)
+			go forward(c, d, cut)
+		}
+	}()
+	return p
+}
+
+// forward copies from src to dst until cut is closed.
+func forward(dst, src net.Conn, cut chan bool) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			dst.Close()
+			return
+		}
+		select {
+		case <-cut:
+			return
+		default:
+		}
+		if _, err = dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// cut cuts the connections made so far, closing them if closeConns is set.
+func (p *proxy) cut(closeConns bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.cutc)
+	p.cutc = make(chan bool)
+	if closeConns {
+		for _, c := range p.conns {
+			c.Close()
+		}
+	}
+	p.conns = nil
+}
+
+const reconnectCount = 30
+
+// Test that a reconnecting importer resumes its channels after its
+// connection fails, whether or not the failure closes the connection.
+func TestImportReconnecting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	defer l.Close()
+	exp := NewExporter()
+	go exp.Serve(l)
+	p := newProxy(t, l.Addr().String())
+	imp, err := ImportReconnecting("tcp", p.addr, 0.05e9)
+	if err != nil {
+		t.Fatal("ImportReconnecting:", err)
+	}
+
+	ech := make(chan int)
+	rch := make(chan int)
+	if err = exp.Export("exportedSend", ech, Send); err != nil {
+		t.Fatal("export exportedSend:", err)
+	}
+	if err = exp.Export("exportedRecv", rch, Recv); err != nil {
+		t.Fatal("export exportedRecv:", err)
+	}
+	ich := make(chan int)
+	sch := make(chan int)
+	if err = imp.Import("exportedSend", ich, Recv, 3); err != nil {
+		t.Fatal("import exportedSend:", err)
+	}
+	if err = imp.Import("exportedRecv", sch, Send, 3); err != nil {
+		t.Fatal("import exportedRecv:", err)
+	}
+	go func() {
+		for i := 0; i < reconnectCount; i++ {
+			ech <- i
+		}
+	}()
+	go func() {
+		for i := 0; i < reconnectCount; i++ {
+			sch <- i
+		}
+	}()
+
+	timeout := time.After(10e9)
+	for i := 0; i < reconnectCount; i++ {
+		switch i {
+		case reconnectCount / 3:
+			p.cut(false)
+		case 2 * reconnectCount / 3:
+			p.cut(true)
+		}
+		for _, ch := range []chan int{ich, rch} {
+			select {
+			case v := <-ch:
+				if v != i {
+					t.Fatalf("expected %d; got %d", i, v)
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for value %d", i)
+			}
+		}
+	}
+}
+
+// sessionHello opens a connection to the exporter at addr and sends a
+// hello asking for session id, given that recvd bytes have been received.
+// It returns the connection and the id in the exporter's welcome.
+func sessionHello(t *testing.T, addr string, id, recvd uint64) (net.Conn, uint64) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	h := &hello{sessionMagic, id, 0.1e9, recvd}
+	var w welcome
+	if err = binary.Write(c, binary.BigEndian, h); err == nil {
+		err = binary.Read(c, binary.BigEndian, &w)
+	}
+	if err != nil {
+		t.Fatal("hello:", err)
+	}
+	return c, w.Id
+}
+
+// Test that a session ends, rather than lingering forever, after a
+// hello that cannot resume it takes it from its connection.
+func TestSessionBadResume(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	defer l.Close()
+	exp := NewExporter()
+	go exp.Serve(l)
+
+	c, id := sessionHello(t, l.Addr().String(), 0, 0)
+	defer c.Close()
+	if id == 0 {
+		t.Fatal("new session refused")
+	}
+	exp.mu.Lock()
+	s := exp.sessions[id]
+	exp.mu.Unlock()
+	// Nothing has been sent on the session, so no importer can have
+	// received a byte of it.
+	c2, id2 := sessionHello(t, l.Addr().String(), id, 1)
+	defer c2.Close()
+	if id2 != id {
+		t.Fatalf("resuming session %d: welcome for %d", id, id2)
+	}
+
+	select {
+	case <-s.done:
+	case <-time.After(10e9):
+		t.Fatal("session did not end")
+	}
+}
@@ -0,0 +1,529 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netchan
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sessions
+//
+// An importer made by ImportReconnecting talks to the exporter through a
+// session rather than directly over a connection.  A session carries the
+// byte stream of the netchan protocol over a series of connections: when
+// one fails, the importer dials again and each side resends the bytes
+// the other had not received, so the gob streams and the state of the
+// channels carry on as if nothing had happened.
+//
+// Each connection of a session starts with a hello from the importer and
+// the exporter's welcome in reply.  A zero id in the hello asks for a new
+// session; in the welcome, it refuses the session asked for.  Frames
+// follow in each direction, each a type byte and a big-endian uint64:
+//	frameData, length, followed by that many bytes of the stream
+//	frameAck, number of bytes of the stream received
+// Acks double as heartbeats: each side sends one every interval, and
+// drops a connection on which it has heard nothing for two intervals.
+
+// sessionMagic starts a hello: "\x00netchan".  A gob stream
+// cannot start with a zero byte.
+const sessionMagic = 0x006e65746368616e
+
+type hello struct {
+	Magic    uint64
+	Id       uint64 // session to resume, or zero for a new one
+	Interval int64  // heartbeat interval, in nanoseconds
+	Recvd    uint64 // bytes of the stream the importer has received
+}
+
+type welcome struct {
+	Id    uint64 // session id, or zero if the session is unknown
+	Recvd uint64 // bytes of the stream the exporter has received
+}
+
+// Frame types
+const (
+	frameData = iota
+	frameAck
+)
+
+// maxFrame is the largest number of bytes of the stream in a data frame.
+const maxFrame = 64 << 10
+
+// ackWindow is the number of bytes received that prompt an ack without
+// waiting for the next heartbeat.
+const ackWindow = 32 << 10
+
+// lingerBeats is the number of heartbeat intervals for which an exporter
+// keeps a session whose connection has failed, and for which the importer
+// tries to resume it.
+const lingerBeats = 10
+
+var (
+	errSessionLost    = os.NewError("netchan: session lost")
+	errSessionRefused = os.NewError("netchan: exporter refused to resume session")
+)
+
+// A session is an io.ReadWriteCloser whose bytes travel over a series of
+// connections, one at a time.
+type session struct {
+	id       uint64
+	interval int64          // heartbeat interval, in nanoseconds
+	lost     func(*session) // called when the connection fails
+
+	writeLock sync.Mutex // keeps the stream in order
+
+	mu     sync.Mutex // protects remaining fields
+	cond   *sync.Cond // signals new input or the end of the session
+	link   *link      // current connection; nil if there is none
+	gen    int        // number of connections so far
+	in     []byte     // received but not yet read
+	recvd  uint64     // bytes received
+	out    []byte     // written but not yet acknowledged
+	outPos uint64     // position of out[0] in the stream
+	err    os.Error   // why the session ended, if it has
+	done   chan bool  // closed when the session ends
+}
+
+// A link is one connection of a session.
+type link struct {
+	conn  io.ReadWriteCloser
+	heard int64     // when a frame last arrived; protected by the session's mu
+	ack   chan bool // asks for an ack to be sent
+	done  chan bool // closed when the link is dropped
+	wmu   sync.Mutex
+	once  sync.Once
+}
+
+func newSession(id uint64, interval int64, lost func(*session)) *session {
+	s := &session{id: id, interval: interval, lost: lost, done: make(chan bool)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Read reads bytes of the stream, waiting for some to arrive if none
+// have.  Once the session has ended, it returns why.
+func (s *session) Read(b []byte) (n int, err os.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.in) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.in) == 0 {
+		return 0, s.err
+	}
+	n = copy(b, s.in)
+	s.in = s.in[n:]
+	return n, nil
+}
+
+// Write writes b to the stream.  The bytes are kept until the peer has
+// acknowledged them, to be sent again over the next connection if the
+// current one fails, so Write succeeds even when there is no connection.
+func (s *session) Write(b []byte) (n int, err os.Error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, s.err
+	}
+	s.out = append(s.out, b...)
+	l := s.link
+	s.mu.Unlock()
+	if l != nil && l.writeData(b) != nil {
+		s.fail(l)
+	}
+	return len(b), nil
+}
+
+// Close ends the session.
+func (s *session) Close() os.Error {
+	s.end(os.EOF)
+	return nil
+}
+
+// end ends the session: once the bytes received are read,
+// Read returns err.
+func (s *session) end(err os.Error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+		close(s.done)
+	}
+	l := s.link
+	s.link = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if l != nil {
+		l.drop()
+	}
+}
+
+// expire ends the session with err if it has not had a connection
+// since the gen'th one.
+func (s *session) expire(gen int, err os.Error) {
+	s.mu.Lock()
+	idle := s.link == nil && s.gen == gen
+	s.mu.Unlock()
+	if idle {
+		s.end(err)
+	}
+}
+
+// received returns the number of bytes received.
+func (s *session) received() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recvd
+}
+
+// detach drops the connection, if any, without reporting it lost,
+// and returns the number of bytes received over it and its forerunners.
+func (s *session) detach() uint64 {
+	s.mu.Lock()
+	l := s.link
+	s.link = nil
+	n := s.recvd
+	s.mu.Unlock()
+	if l != nil {
+		l.drop()
+	}
+	return n
+}
+
+// attach makes conn the session's connection, given that the peer has
+// received peerRecvd bytes of the stream, and sends the bytes after those.
+func (s *session) attach(conn io.ReadWriteCloser, peerRecvd uint64) (*link, os.Error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	if peerRecvd < s.outPos || peerRecvd > s.outPos+uint64(len(s.out)) {
+		s.mu.Unlock()
+		return nil, os.NewError("netchan: peer cannot resume session")
+	}
+	s.acked(peerRecvd)
+	old := s.link
+	l := &link{
+		conn:  conn,
+		heard: time.Nanoseconds(),
+		ack:   make(chan bool, 1),
+		done:  make(chan bool),
+	}
+	s.link = l
+	s.gen++
+	resend := s.out
+	s.mu.Unlock()
+	if old != nil {
+		old.drop()
+	}
+	go s.readFrames(l)
+	go s.sendAcks(l)
+	go s.watch(l)
+	if l.writeData(resend) != nil {
+		s.fail(l)
+	}
+	return l, nil
+}
+
+// acked discards the bytes written that the peer has received, given
+// that it has received n bytes of the stream.  s.mu must be held.
+func (s *session) acked(n uint64) {
+	if n <= s.outPos || n > s.outPos+uint64(len(s.out)) {
+		return
+	}
+	s.out = s.out[n-s.outPos:]
+	s.outPos = n
+	if len(s.out) == 0 {
+		s.out = nil
+	}
+}
+
+// fail drops l and, if it was the session's connection, reports the loss.
+func (s *session) fail(l *link) {
+	s.mu.Lock()
+	current := s.link == l
+	if current {
+		s.link = nil
+	}
+	s.mu.Unlock()
+	l.drop()
+	if current && s.lost != nil {
+		go s.lost(s)
+	}
+}
+
+// readFrames reads the frames arriving on l until it fails.
+func (s *session) readFrames(l *link) {
+	var hdr [9]byte
+	for {
+		if _, err := io.ReadFull(l.conn, hdr[:]); err != nil {
+			break
+		}
+		n := binary.BigEndian.Uint64(hdr[1:])
+		var data []byte
+		if hdr[0] == frameData {
+			if n > maxFrame {
+				break
+			}
+			data = make([]byte, n)
+			if _, err := io.ReadFull(l.conn, data); err != nil {
+				break
+			}
+		} else if hdr[0] != frameAck {
+			break
+		}
+		s.mu.Lock()
+		if s.link != l {
+			s.mu.Unlock()
+			break
+		}
+		l.heard = time.Nanoseconds()
+		if hdr[0] == frameAck {
+			s.acked(n)
+		} else {
+			s.in = append(s.in, data...)
+			s.recvd += n
+			s.cond.Broadcast()
+			if s.recvd%ackWindow < n {
+				l.requestAck()
+			}
+		}
+		s.mu.Unlock()
+	}
+	s.fail(l)
+}
+
+// sendAcks sends an ack on l whenever one is requested.
+func (s *session) sendAcks(l *link) {
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.ack:
+		}
+		if l.write(frameAck, s.received(), nil) != nil {
+			s.fail(l)
+			return
+		}
+	}
+}
+
+// watch requests an ack on l each heartbeat interval, and fails l once
+// nothing has arrived on it for two intervals.
+func (s *session) watch(l *link) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		silent := time.Nanoseconds()-l.heard > 2*s.interval
+		s.mu.Unlock()
+		if silent {
+			s.fail(l)
+			return
+		}
+		l.requestAck()
+	}
+}
+
+func (l *link) requestAck() {
+	select {
+	case l.ack <- true:
+	default:
+		// One is already on its way.
+	}
+}
+
+// write writes a frame.
+func (l *link) write(typ byte, n uint64, data []byte) os.Error {
+	var hdr [9]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint64(hdr[1:], n)
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	if _, err := l.conn.Write(hdr[:]); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if _, err := l.conn.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeData writes b as data frames.
+func (l *link) writeData(b []byte) os.Error {
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxFrame {
+			n = maxFrame
+		}
+		if err := l.write(frameData, uint64(n), b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// drop closes the connection of l.
+func (l *link) drop() {
+	l.once.Do(func() {
+		close(l.done)
+		l.conn.Close()
+	})
+}
+
+// A redialer connects an importer's session to the exporter again
+// when its connection fails.
+type redialer struct {
+	network, remoteaddr string
+}
+
+// dial connects to the exporter and opens or resumes s.
+func (r *redialer) dial(s *session) os.Error {
+	conn, err := net.Dial(r.network, r.remoteaddr)
+	if err != nil {
+		return err
+	}
+	// Don't wait long for an exporter that is not there.
+	conn.SetTimeout(2 * s.interval)
+	h := &hello{sessionMagic, s.id, s.interval, s.received()}
+	var w welcome
+	if err = binary.Write(conn, binary.BigEndian, h); err == nil {
+		err = binary.Read(conn, binary.BigEndian, &w)
+	}
+	if err == nil && w.Id == 0 {
+		err = errSessionRefused
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetTimeout(0)
+	s.id = w.Id
+	if _, err = s.attach(conn, w.Recvd); err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// redial tries to resume s, once each heartbeat interval,
+// and ends it if the exporter cannot be reached in time.
+func (r *redialer) redial(s *session) {
+	for i := 0; i < lingerBeats; i++ {
+		err := r.dial(s)
+		if err == nil {
+			return
+		}
+		impLog("reconnect:", err)
+		if err == errSessionRefused {
+			break
+		}
+		time.Sleep(s.interval)
+	}
+	s.end(errSessionLost)
+}
+
+// serveSession serves conn, whose first byte, already read,
+// began a hello: it opens or resumes a session over it.
+// For a new session, it serves the session until it ends.
+func (exp *Exporter) serveSession(conn io.ReadWriteCloser, r io.Reader) {
+	var h hello
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil || h.Magic != sessionMagic || h.Interval <= 0 {
+		expLog("bad session hello:", err)
+		conn.Close()
+		return
+	}
+	exp.mu.Lock()
+	s := exp.sessions[h.Id]
+	if h.Id == 0 {
+		s = newSession(exp.newSessionId(), h.Interval, exp.lostSession)
+		exp.sessions[s.id] = s
+	}
+	exp.mu.Unlock()
+	if s == nil {
+		binary.Write(conn, binary.BigEndian, &welcome{})
+		conn.Close()
+		return
+	}
+	w := &welcome{s.id, s.detach()}
+	if err := binary.Write(conn, binary.BigEndian, w); err != nil {
+		exp.abandon(s, conn, h.Id == 0)
+		return
+	}
+	l, err := s.attach(conn, h.Recvd)
+	if err != nil {
+		expLog("session:", err)
+		exp.abandon(s, conn, h.Id == 0)
+		return
+	}
+	if h.Id != 0 {
+		<-l.done
+		return
+	}
+	exp.addClient(s).run()
+	exp.mu.Lock()
+	exp.sessions[s.id] = nil, false
+	exp.mu.Unlock()
+}
+
+// abandon closes conn, over which s could not be opened or resumed.
+// A new session is forgotten.  A session being resumed has lost its
+// connection, which detach dropped without reporting it; it now ends
+// unless its importer resumes it in time.
+func (exp *Exporter) abandon(s *session, conn io.ReadWriteCloser, isNew bool) {
+	conn.Close()
+	if isNew {
+		exp.mu.Lock()
+		exp.sessions[s.id] = nil, false
+		exp.mu.Unlock()
+		s.end(os.EOF)
+		return
+	}
+	exp.lostSession(s)
+}
+
+// newSessionId returns an unused session id that is hard to guess,
+// so that a stale importer does not resume someone else's session
+// after the exporter restarts.  exp.mu must be held.
+func (exp *Exporter) newSessionId() uint64 {
+	var b [8]byte
+	for {
+		if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+			panic("netchan: cannot read random session id: " + err.String())
+		}
+		id := binary.BigEndian.Uint64(b[:])
+		if _, used := exp.sessions[id]; id != 0 && !used {
+			return id
+		}
+	}
+	panic("unreachable")
+}
+
+// lostSession ends s if its importer does not resume it in time.
+func (exp *Exporter) lostSession(s *session) {
+	s.mu.Lock()
+	gen := s.gen
+	s.mu.Unlock()
+	time.AfterFunc(lingerBeats*s.interval, func() {
+		s.expire(gen, os.EOF)
+	})
+}
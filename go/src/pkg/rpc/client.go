@@ -13,6 +13,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"time"
 )
 
 // ServerError represents an error that has been returned from
@@ -25,6 +26,12 @@ func (e ServerError) String() string {
 
 var ErrShutdown = os.NewError("connection is shut down")
 
+// ErrTimeout is the error of a call that did not complete by its deadline.
+var ErrTimeout = os.NewError("rpc: call timed out")
+
+// ErrCanceled is the error of a call abandoned with Client.Cancel.
+var ErrCanceled = os.NewError("rpc: call canceled")
+
 // Call represents an active RPC.
 type Call struct {
 	ServiceMethod string      // The name of the service and method to call.
@@ -32,7 +39,12 @@ type Call struct {
 	Reply         interface{} // The reply from the function (*struct).
 	Error         os.Error    // After completion, the error status.
 	Done          chan *Call  // Strobes when call is complete; value is the error status.
+	Deadline      int64       // If non-zero, time.Nanoseconds() at which the call times out.
 	seq           uint64
+	sent          bool        // the request is being or has been written; protected by Client.mutex
+	timer         *time.Timer // fires at Deadline; protected by Client.mutex
+	args          *sendStream // streams Args, if it is a channel
+	reply         *recvStream // streams to Reply, if it is a channel
 }
 
 // Client represents an RPC Client.
@@ -40,7 +52,7 @@ type Call struct {
 // with a single Client.
 type Client struct {
 	mutex    sync.Mutex // protects pending, seq, request
-	sending  sync.Mutex
+	sending  sync.Mutex // serializes writes to codec
 	request  Request
 	seq      uint64
 	codec    ClientCodec
@@ -65,28 +77,118 @@ type ClientCodec interface {
 	Close() os.Error
 }
 
+// A CancelClientCodec is a ClientCodec that can also tell the server
+// that the client has given up on a call.  The client calls WriteCancel
+// with a header holding the Seq of the abandoned call; the server reads
+// it as a request with Cancel set and no service method, followed by
+// an InvalidRequest body.  A Client sends cancellations only through
+// codecs that implement this interface.
+type CancelClientCodec interface {
+	ClientCodec
+	WriteCancel(*Request) os.Error
+}
+
 func (client *Client) send(c *Call) {
-	// Register this call.
+	if timeout, ok := client.register(c); ok {
+		client.write(c, timeout)
+	}
+}
+
+// register gives c its sequence number and adds it to the pending
+// calls, returning the nanoseconds left until its deadline, or 0 if it
+// has none.  If c cannot be sent, register completes it and returns
+// ok == false.
+func (client *Client) register(c *Call) (timeout int64, ok bool) {
+	if c.Deadline != 0 {
+		timeout = c.Deadline - time.Nanoseconds()
+		if timeout <= 0 {
+			c.Error = ErrTimeout
+			c.finish()
+			return 0, false
+		}
+	}
+
 	client.mutex.Lock()
 	if client.shutdown {
 		c.Error = ErrShutdown
 		client.mutex.Unlock()
-		c.done()
-		return
+		c.finish()
+		return 0, false
 	}
 	c.seq = client.seq
 	client.seq++
 	client.pending[c.seq] = c
 	client.mutex.Unlock()
+	return timeout, true
+}
 
-	// Encode and send the request.
+// write encodes and sends the request of c, a registered call.
+func (client *Client) write(c *Call, timeout int64) {
+	body := c.Args
+	if c.args != nil {
+		// The arguments follow.
+		body = invalidRequest
+	}
 	client.sending.Lock()
-	defer client.sending.Unlock()
+	client.mutex.Lock()
+	abandoned := client.pending[c.seq] != c
+	c.sent = !abandoned
+	client.mutex.Unlock()
+	if abandoned {
+		// Canceled already: the server need never see the call.
+		client.sending.Unlock()
+		return
+	}
 	client.request.Seq = c.seq
 	client.request.ServiceMethod = c.ServiceMethod
-	if err := client.codec.WriteRequest(&client.request, c.Args); err != nil {
+	client.request.Timeout = timeout
+	client.request.Stream = 0
+	client.request.Window = 0
+	err := client.codec.WriteRequest(&client.request, body)
+	client.sending.Unlock()
+	if err != nil {
 		panic("rpc: client encode error: " + err.String())
 	}
+	if c.args != nil {
+		c.args.start()
+	}
+
+	// Start the clock only now, so that a cancellation
+	// never reaches the server ahead of its request.
+	if timeout > 0 {
+		client.mutex.Lock()
+		if client.pending[c.seq] == c {
+			c.timer = time.AfterFunc(timeout, func() { client.abandon(c, ErrTimeout) })
+		}
+		client.mutex.Unlock()
+	}
+}
+
+// abandon completes call with err if it is still waiting for its
+// response and tells the server that the response is no longer wanted.
+// It reports whether the call was still waiting.
+func (client *Client) abandon(call *Call, err os.Error) bool {
+	client.mutex.Lock()
+	if client.pending[call.seq] != call {
+		client.mutex.Unlock()
+		return false
+	}
+	client.pending[call.seq] = nil, false
+	call.stopTimer()
+	sent := call.sent
+	client.mutex.Unlock()
+
+	call.Error = err
+	call.finish()
+	// If the request is not yet being written, it never will be,
+	// and there is nothing for the server to cancel.
+	if cc, ok := client.codec.(CancelClientCodec); ok && sent {
+		client.sending.Lock()
+		// A failure here will show up as a failure to read.
+		cc.WriteCancel(&Request{ServiceMethod: call.ServiceMethod, Seq: call.seq, Cancel: true})
+		client.sending.Unlock()
+	}
+	return true
 }
 
 func (client *Client) input() {
@@ -101,14 +203,37 @@ func (client *Client) input() {
 			}
 			break
 		}
+		if response.Stream != 0 {
+			err = client.deliver(&response)
+			if err != nil {
+				err = os.NewError("reading body " + err.String())
+			}
+			continue
+		}
 		seq := response.Seq
 		client.mutex.Lock()
 		c := client.pending[seq]
 		client.pending[seq] = c, false
+		if c != nil {
+			c.stopTimer()
+		}
 		client.mutex.Unlock()
 
+		if c == nil {
+			// The call was abandoned; discard the response.
+			err = client.codec.ReadResponseBody(nil)
+			if err != nil {
+				err = os.NewError("reading body " + err.String())
+			}
+			continue
+		}
 		if response.Error == "" {
-			err = client.codec.ReadResponseBody(c.Reply)
+			reply := c.Reply
+			if c.reply != nil {
+				// The reply was streamed.
+				reply = nil
+			}
+			err = client.codec.ReadResponseBody(reply)
 			if err != nil {
 				c.Error = os.NewError("reading body " + err.String())
 			}
@@ -122,14 +247,15 @@ func (client *Client) input() {
 				err = os.NewError("reading error body: " + err.String())
 			}
 		}
-		c.done()
+		c.finish()
 	}
 	// Terminate pending calls.
 	client.mutex.Lock()
 	client.shutdown = true
 	for _, call := range client.pending {
+		call.stopTimer()
 		call.Error = err
-		call.done()
+		call.finish()
 	}
 	client.mutex.Unlock()
 	if err != os.EOF || !client.closing {
@@ -137,6 +263,28 @@ func (client *Client) input() {
 	}
 }
 
+// stopTimer stops the timer of a call with a deadline.
+// The caller must hold the client's mutex.
+func (call *Call) stopTimer() {
+	if call.timer != nil {
+		call.timer.Stop()
+		call.timer = nil
+	}
+}
+
+// finish completes the call, ending its streams first if it has any.
+func (call *Call) finish() {
+	if call.args != nil {
+		call.args.stop()
+		call.args.start()
+	}
+	if call.reply != nil {
+		call.reply.end(call.done)
+		return
+	}
+	call.done()
+}
+
 func (call *Call) done() {
 	select {
 	case call.Done <- call:
@@ -185,6 +333,10 @@ func (c *gobClientCodec) WriteRequest(r *Request, body interface{}) (err os.Erro
 	return c.encBuf.Flush()
 }
 
+func (c *gobClientCodec) WriteCancel(r *Request) os.Error {
+	return c.WriteRequest(r, invalidRequest)
+}
+
 func (c *gobClientCodec) ReadResponseHeader(r *Response) os.Error {
 	return c.dec.Decode(r)
 }
@@ -250,11 +402,30 @@ func (client *Client) Close() os.Error {
 // the invocation.  The done channel will signal when the call is complete by returning
 // the same Call object.  If done is nil, Go will allocate a new channel.
 // If non-nil, done must be buffered or Go will deliberately crash.
+//
+// If args is a channel, the call is streaming: the values received from
+// it are sent to the server one at a time until it is closed.  Likewise
+// if reply is a channel, the server's replies are sent on it as they
+// arrive, and it is closed before the call completes.  The method must
+// take channels in the same places.  The caller must keep sending
+// arguments and receiving replies until the channels are closed, even
+// if the call fails.
 func (client *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	return client.GoTimeout(serviceMethod, args, reply, done, 0)
+}
+
+// GoTimeout is like Go but gives up on the call if it has not completed
+// within ns nanoseconds, completing it with the error ErrTimeout.  The
+// server is told the call's deadline and, if the codec can, that the
+// client gave up.  A non-positive ns means no timeout.
+func (client *Client) GoTimeout(serviceMethod string, args interface{}, reply interface{}, done chan *Call, ns int64) *Call {
 	c := new(Call)
 	c.ServiceMethod = serviceMethod
 	c.Args = args
 	c.Reply = reply
+	if ns > 0 {
+		c.Deadline = time.Nanoseconds() + ns
+	}
 	if done == nil {
 		done = make(chan *Call, 10) // buffered.
 	} else {
@@ -267,9 +438,14 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 		}
 	}
 	c.Done = done
+	if err := client.openStreams(c); err != nil {
+		c.Error = err
+		c.finish()
+		return c
+	}
 	if client.shutdown {
 		c.Error = ErrShutdown
-		c.done()
+		c.finish()
 		return c
 	}
 	client.send(c)
@@ -284,3 +460,23 @@ func (client *Client) Call(serviceMethod string, args interface{}, reply interfa
 	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
 	return call.Error
 }
+
+// CallTimeout is like Call but gives up after ns nanoseconds,
+// returning ErrTimeout.  See GoTimeout.
+func (client *Client) CallTimeout(serviceMethod string, args interface{}, reply interface{}, ns int64) os.Error {
+	if client.shutdown {
+		return ErrShutdown
+	}
+	call := <-client.GoTimeout(serviceMethod, args, reply, make(chan *Call, 1), ns).Done
+	return call.Error
+}
+
+// Cancel abandons call, which must have been started by this client.
+// If the call has not yet completed, Cancel completes it with the error
+// ErrCanceled and, if the codec can, tells the server that the reply is
+// no longer wanted.  A call canceled before its request is written is
+// never sent.  Any reply that arrives later is discarded.  Cancel
+// reports whether the call was still outstanding.
+func (client *Client) Cancel(call *Call) bool {
+	return client.abandon(call, ErrCanceled)
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"http"
 	"sort"
+	"strconv"
 	"template"
 )
 
@@ -24,11 +25,15 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th>
+		<th align=center>Mean time</th><th align=center>Latency</th>
 		{{range .Method}}
 			<tr>
 			<td align=left font=fixed>{{.Name}}({{.Type.ArgType}}, {{.Type.ReplyType}}) os.Error</td>
 			<td align=center>{{.Type.NumCalls}}</td>
+			<td align=center>{{.Stats.Errors}}</td>
+			<td align=center>{{.Mean}}</td>
+			<td align=left>{{.Latency}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -39,8 +44,32 @@ const debugText = `<html>
 var debug = template.Must(template.New("RPC debug").Parse(debugText))
 
 type debugMethod struct {
-	Type *methodType
-	Name string
+	Type  *methodType
+	Name  string
+	Stats MethodStats
+}
+
+// Mean returns the mean duration of the method's calls.
+func (m debugMethod) Mean() string {
+	if m.Stats.Calls == 0 {
+		return "-"
+	}
+	return fmtDuration(m.Stats.Time / int64(m.Stats.Calls))
+}
+
+// Latency returns the non-empty buckets of the method's latency histogram.
+func (m debugMethod) Latency() string {
+	s := ""
+	for b, n := range m.Stats.Latency {
+		if n == 0 {
+			continue
+		}
+		if s != "" {
+			s += ", "
+		}
+		s += latencyBound(b) + ": " + strconv.Uitoa(n)
+	}
+	return s
 }
 
 type methodArray []debugMethod
@@ -75,7 +104,7 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		services[i] = debugService{service, sname, make(methodArray, len(service.method))}
 		j := 0
 		for mname, method := range service.method {
-			services[i].Method[j] = debugMethod{method, mname}
+			services[i].Method[j] = debugMethod{method, mname, method.Stats()}
 			j++
 		}
 		sort.Sort(services[i].Method)
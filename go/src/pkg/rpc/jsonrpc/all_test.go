@@ -5,12 +5,17 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"fmt"
+	"http"
+	"http/httptest"
 	"json"
 	"net"
 	"os"
 	"rpc"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Args struct {
@@ -45,6 +50,43 @@ func (t *Arith) Error(args *Args, reply *Reply) os.Error {
 	panic("ERROR")
 }
 
+func (t *Arith) Count(args *Args, replies chan *Reply) os.Error {
+	for i := args.A; i < args.B; i++ {
+		replies <- &Reply{i}
+	}
+	return nil
+}
+
+func (t *Arith) SumMul(args chan *Args, reply *Reply) os.Error {
+	for a := range args {
+		reply.C += a.A * a.B
+	}
+	return nil
+}
+
+// sleepCanceled reports whether each call of Arith.Sleep
+// ended because the client gave up.
+var sleepCanceled = make(chan bool, 10)
+
+// Sleep waits args.A milliseconds, or until the client gives up.
+func (t *Arith) Sleep(call *rpc.ServerCall, args *Args, reply *Reply) os.Error {
+	select {
+	case <-call.Canceled():
+		sleepCanceled <- true
+	case <-time.After(int64(args.A) * 1e6):
+		sleepCanceled <- false
+	}
+	return nil
+}
+
+// Deadline replies with the milliseconds left until the call's deadline.
+func (t *Arith) Deadline(call *rpc.ServerCall, args *Args, reply *Reply) os.Error {
+	if call.Deadline != 0 {
+		reply.C = int((call.Deadline - time.Nanoseconds()) / 1e6)
+	}
+	return nil
+}
+
 func init() {
 	rpc.Register(new(Arith))
 }
@@ -154,3 +196,287 @@ func TestClient(t *testing.T) {
 		t.Error("Div: expected divide by zero error; got", err)
 	}
 }
+
+func TestStream(t *testing.T) {
+	cli, srv := net.Pipe()
+	go ServeConn(srv)
+
+	client := NewClient(cli)
+	defer client.Close()
+
+	replies := make(chan *Reply)
+	call := client.Go("Arith.Count", &Args{0, 40}, replies, nil)
+	n := 0
+	for r := range replies {
+		if r.C != n {
+			t.Errorf("Count: reply %d is %d", n, r.C)
+		}
+		n++
+	}
+	<-call.Done
+	if call.Error != nil || n != 40 {
+		t.Errorf("Count: %d replies, %v; want 40", n, call.Error)
+	}
+
+	args := make(chan *Args)
+	reply := new(Reply)
+	call = client.Go("Arith.SumMul", args, reply, nil)
+	for i := 0; i < 40; i++ {
+		args <- &Args{i, 2}
+	}
+	close(args)
+	<-call.Done
+	if call.Error != nil || reply.C != 1560 {
+		t.Errorf("SumMul: got %d, %v; want 1560", reply.C, call.Error)
+	}
+}
+
+// testCancel checks that client passes the deadline of a call and
+// its cancellation on to the server.
+func testCancel(t *testing.T, client *rpc.Client) {
+	reply := new(Reply)
+	if err := client.CallTimeout("Arith.Deadline", &Args{}, reply, 5e9); err != nil {
+		t.Fatalf("Deadline: %v", err)
+	}
+	if reply.C <= 0 || reply.C > 5000 {
+		t.Errorf("Deadline: server saw %dms left; want 1 to 5000", reply.C)
+	}
+
+	call := client.Go("Arith.Sleep", &Args{10000, 0}, new(Reply), nil)
+	if !client.Cancel(call) {
+		t.Error("Cancel of outstanding call returned false")
+	}
+	select {
+	case canceled := <-sleepCanceled:
+		if !canceled {
+			t.Error("Sleep ran to completion; want it canceled")
+		}
+	case <-time.After(5e9):
+		t.Error("Sleep was not canceled")
+	}
+
+	// The late reply to the canceled call must not disturb later ones.
+	if err := client.Call("Arith.Add", &Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add after Cancel: got %d, %v; want 15", reply.C, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cli, srv := net.Pipe()
+	go ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+	testCancel(t, client)
+}
+
+func TestCancel2(t *testing.T) {
+	cli, srv := net.Pipe()
+	go ServeConn2(srv)
+	client := NewClient2(cli)
+	defer client.Close()
+	testCancel(t, client)
+}
+
+type response2Test struct {
+	Version string      `json:"jsonrpc"`
+	Id      interface{} `json:"id"`
+	Result  *Reply      `json:"result"`
+	Error   *Error      `json:"error"`
+}
+
+// checkResponse2 checks that resp answers the request with the given id
+// with the result c, or, if code is not zero, with an error of that code.
+func checkResponse2(t *testing.T, what string, resp *response2Test, id interface{}, c, code int) {
+	if resp.Version != "2.0" {
+		t.Errorf("%s: version %q", what, resp.Version)
+	}
+	if resp.Id != id {
+		t.Errorf("%s: id %v want %v", what, resp.Id, id)
+	}
+	switch {
+	case code != 0 && resp.Error == nil:
+		t.Errorf("%s: no error, want code %d", what, code)
+	case code != 0 && resp.Error.Code != code:
+		t.Errorf("%s: error %v, want code %d", what, resp.Error, code)
+	case code == 0 && resp.Error != nil:
+		t.Errorf("%s: error %v", what, resp.Error)
+	case code == 0 && (resp.Result == nil || resp.Result.C != c):
+		t.Errorf("%s: result %v want %d", what, resp.Result, c)
+	}
+}
+
+var server2Tests = []struct {
+	what    string
+	request string
+	id      interface{}
+	c, code int
+}{
+	{"by name", `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}, "id": 1}`, 1.0, 3, 0},
+	{"by position", `{"jsonrpc": "2.0", "method": "Arith.Mul", "params": [{"A": 3, "B": 4}], "id": "x"}`, "x", 12, 0},
+	{"method error", `{"jsonrpc": "2.0", "method": "Arith.Div", "params": {"A": 1, "B": 0}, "id": 2}`, 2.0, 0, CodeServerError},
+	{"no method", `{"jsonrpc": "2.0", "method": "Arith.Nothing", "params": {}, "id": 3}`, 3.0, 0, CodeMethodNotFound},
+	{"bad params", `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": "one"}, "id": 4}`, 4.0, 0, CodeInvalidParams},
+	{"too many params", `{"jsonrpc": "2.0", "method": "Arith.Add", "params": [{}, {}], "id": 5}`, 5.0, 0, CodeInvalidParams},
+	{"no version", `{"method": "Arith.Add", "params": {}, "id": 6}`, 6.0, 0, CodeInvalidRequest},
+	{"not an object", `7`, nil, 0, CodeInvalidRequest},
+}
+
+func TestServer2(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	go ServeConn2(srv)
+	dec := json.NewDecoder(cli)
+
+	for _, test := range server2Tests {
+		fmt.Fprintln(cli, test.request)
+		var resp response2Test
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("%s: Decode: %s", test.what, err)
+		}
+		checkResponse2(t, test.what, &resp, test.id, test.c, test.code)
+	}
+
+	// A notification gets no response: the next one read
+	// must be for the request after it.
+	fmt.Fprintln(cli, `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 1}}`)
+	fmt.Fprintln(cli, `{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 2, "B": 2}, "id": 8}`)
+	var resp response2Test
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatalf("notification: Decode: %s", err)
+	}
+	checkResponse2(t, "notification", &resp, 8.0, 4, 0)
+
+	// A batch, answered with an array in any order.
+	fmt.Fprintln(cli, `[
+		{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}, "id": 9},
+		{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}},
+		{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 5, "B": 2}, "id": 10},
+		{"jsonrpc": "2.0", "id": 11}
+	]`)
+	var batch []response2Test
+	if err := dec.Decode(&batch); err != nil {
+		t.Fatalf("batch: Decode: %s", err)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("batch: %d responses, want 3", len(batch))
+	}
+	for i := range batch {
+		r := &batch[i]
+		switch r.Id {
+		case 9.0:
+			checkResponse2(t, "batch add", r, 9.0, 3, 0)
+		case 10.0:
+			checkResponse2(t, "batch mul", r, 10.0, 10, 0)
+		case 11.0:
+			checkResponse2(t, "batch invalid", r, 11.0, 0, CodeInvalidRequest)
+		default:
+			t.Errorf("batch: response with id %v", r.Id)
+		}
+	}
+
+	// Nothing can be read after a parse error.
+	fmt.Fprintln(cli, `{"jsonrpc": "2.0", "method": }`)
+	resp = response2Test{}
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatalf("parse error: Decode: %s", err)
+	}
+	checkResponse2(t, "parse error", &resp, nil, 0, CodeParseError)
+}
+
+func TestClient2(t *testing.T) {
+	cli, srv := net.Pipe()
+	go ServeConn2(srv)
+
+	client := NewClient2(cli)
+	defer client.Close()
+
+	args := &Args{7, 8}
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", args, reply); err != nil {
+		t.Errorf("Add: expected no error but got string %q", err.String())
+	}
+	if reply.C != args.A+args.B {
+		t.Errorf("Add: expected %d got %d", args.A+args.B, reply.C)
+	}
+
+	mulReply := new(Reply)
+	mulCall := client.Go("Arith.Mul", args, mulReply, nil)
+	addReply := new(Reply)
+	addCall := client.Go("Arith.Add", args, addReply, nil)
+	<-addCall.Done
+	<-mulCall.Done
+	if addCall.Error != nil || addReply.C != args.A+args.B {
+		t.Errorf("Add: got %d, %v", addReply.C, addCall.Error)
+	}
+	if mulCall.Error != nil || mulReply.C != args.A*args.B {
+		t.Errorf("Mul: got %d, %v", mulReply.C, mulCall.Error)
+	}
+
+	err := client.Call("Arith.Div", &Args{7, 0}, reply)
+	e := ErrorOf(err)
+	if e == nil || e.Code != CodeServerError || e.Message != "divide by zero" {
+		t.Errorf("Div: expected divide by zero error; got %v", err)
+	}
+
+	err = client.Call("Arith.Nothing", args, reply)
+	if e := ErrorOf(err); e == nil || e.Code != CodeMethodNotFound {
+		t.Errorf("Nothing: expected method not found; got %v", err)
+	}
+
+	if ErrorOf(os.NewError("divide by zero")) != nil {
+		t.Errorf("ErrorOf: found an error object in a plain error")
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	handler := NewHTTPHandler(rpc.DefaultServer)
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://example.com/rpc", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %s", err)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}, "id": 1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("call: status %d", w.Code)
+	}
+	if ct := w.HeaderMap.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("call: Content-Type %q", ct)
+	}
+	var resp response2Test
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("call: Unmarshal: %s", err)
+	}
+	checkResponse2(t, "call", &resp, 1.0, 3, 0)
+
+	w = post(`[{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}, "id": 1},
+		{"jsonrpc": "2.0", "method": "Arith.Mul", "params": {"A": 3, "B": 2}, "id": 2}]`)
+	var batch []response2Test
+	if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+		t.Fatalf("batch: Unmarshal: %s", err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("batch: %d responses, want 2", len(batch))
+	}
+
+	w = post(`{"jsonrpc": "2.0", "method": "Arith.Add", "params": {"A": 1, "B": 2}}`)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("notification: status %d, body %q", w.Code, w.Body)
+	}
+
+	w = post(`{"jsonrpc": "2.0", "method"`)
+	if !bytes.Contains(w.Body.Bytes(), []byte("-32700")) {
+		t.Errorf("truncated: status %d, body %q", w.Code, w.Body)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/rpc", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", w.Code)
+	}
+}
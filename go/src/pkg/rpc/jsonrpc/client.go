@@ -4,6 +4,20 @@
 
 // Package jsonrpc implements a JSON-RPC ClientCodec and ServerCodec
 // for the rpc package.
+//
+// NewClientCodec and NewServerCodec speak JSON-RPC 1.0.  NewClientCodec2
+// and NewServerCodec2 speak JSON-RPC 2.0, which NewHTTPHandler serves
+// over HTTP as well.
+//
+// The messages of streaming calls carry the id of the call they belong
+// to and two members beyond those of JSON-RPC: "stream", the kind of
+// stream message (see rpc.StreamData), and "window".  Other messages
+// leave them out.  Likewise, a JSON-RPC 1.0 request may carry "timeout",
+// the nanoseconds the client will wait for the response, and a client
+// that gives up on a call sends a request with the call's id, no method
+// and "cancel": true, to which the server sends no response.  JSON-RPC
+// 2.0 requests carry "timeout" too, but a client cancels a call with a
+// "$/cancelRequest" notification whose params are {"id": id}.
 package jsonrpc
 
 import (
@@ -44,18 +58,39 @@ func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
 }
 
 type clientRequest struct {
-	Method string         `json:"method"`
-	Params [1]interface{} `json:"params"`
-	Id     uint64         `json:"id"`
+	Method  string         `json:"method"`
+	Params  [1]interface{} `json:"params"`
+	Id      uint64         `json:"id"`
+	Stream  int            `json:"stream,omitempty"` // see rpc.StreamData
+	Window  int            `json:"window,omitempty"`
+	Timeout int64          `json:"timeout,omitempty"`
+	Cancel  bool           `json:"cancel,omitempty"`
 }
 
 func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) os.Error {
-	c.mutex.Lock()
-	c.pending[r.Seq] = r.ServiceMethod
-	c.mutex.Unlock()
+	if r.Stream == 0 {
+		c.mutex.Lock()
+		c.pending[r.Seq] = r.ServiceMethod
+		c.mutex.Unlock()
+	}
 	c.req.Method = r.ServiceMethod
 	c.req.Params[0] = param
 	c.req.Id = r.Seq
+	c.req.Stream = r.Stream
+	c.req.Window = r.Window
+	c.req.Timeout = r.Timeout
+	c.req.Cancel = false
+	return c.enc.Encode(&c.req)
+}
+
+func (c *clientCodec) WriteCancel(r *rpc.Request) os.Error {
+	c.req.Method = ""
+	c.req.Params[0] = nil
+	c.req.Id = r.Seq
+	c.req.Stream = 0
+	c.req.Window = 0
+	c.req.Timeout = 0
+	c.req.Cancel = true
 	return c.enc.Encode(&c.req)
 }
 
@@ -63,12 +98,16 @@ type clientResponse struct {
 	Id     uint64           `json:"id"`
 	Result *json.RawMessage `json:"result"`
 	Error  interface{}      `json:"error"`
+	Stream int              `json:"stream,omitempty"`
+	Window int              `json:"window,omitempty"`
 }
 
 func (r *clientResponse) reset() {
 	r.Id = 0
 	r.Result = nil
 	r.Error = nil
+	r.Stream = 0
+	r.Window = 0
 }
 
 func (c *clientCodec) ReadResponseHeader(r *rpc.Response) os.Error {
@@ -79,11 +118,16 @@ func (c *clientCodec) ReadResponseHeader(r *rpc.Response) os.Error {
 
 	c.mutex.Lock()
 	r.ServiceMethod = c.pending[c.resp.Id]
-	c.pending[c.resp.Id] = "", false
+	if c.resp.Stream == 0 {
+		// Only the final response ends the call.
+		c.pending[c.resp.Id] = "", false
+	}
 	c.mutex.Unlock()
 
 	r.Error = ""
 	r.Seq = c.resp.Id
+	r.Stream = c.resp.Stream
+	r.Window = c.resp.Window
 	if c.resp.Error != nil {
 		x, ok := c.resp.Error.(string)
 		if !ok {
@@ -0,0 +1,160 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"io"
+	"json"
+	"net"
+	"os"
+	"rpc"
+	"sync"
+)
+
+type clientCodec2 struct {
+	dec *json.Decoder // for reading JSON values
+	enc *json.Encoder // for writing JSON values
+	c   io.Closer
+
+	// temporary work space
+	req  clientRequest2
+	resp clientResponse2
+
+	mutex   sync.Mutex        // protects pending
+	pending map[uint64]string // map request id to method name
+}
+
+// NewClientCodec2 returns a new rpc.ClientCodec using JSON-RPC 2.0 on conn.
+// An argument that encodes as a JSON object is sent as the params by
+// name; any other is sent as the only element of the params by position.
+// Errors returned by calls have as text the JSON encoding of the error
+// object the server sent; see ErrorOf.
+func NewClientCodec2(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec2{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]string),
+	}
+}
+
+type clientRequest2 struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params,omitempty"`
+	Id      uint64           `json:"id"`
+	Timeout int64            `json:"timeout,omitempty"`
+}
+
+// cancelMethod is the method of the notification
+// with which a client cancels a call.
+const cancelMethod = "$/cancelRequest"
+
+type cancelParams struct {
+	Id uint64 `json:"id"`
+}
+
+type cancelRequest2 struct {
+	Version string       `json:"jsonrpc"`
+	Method  string       `json:"method"`
+	Params  cancelParams `json:"params"`
+}
+
+func (c *clientCodec2) WriteRequest(r *rpc.Request, param interface{}) os.Error {
+	if r.Stream != 0 {
+		return os.NewError("jsonrpc: JSON-RPC 2.0 cannot stream")
+	}
+	b, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+	c.req.Params = nil
+	switch {
+	case b[0] == '{':
+		// By name.
+		params := json.RawMessage(b)
+		c.req.Params = &params
+	case string(b) != "null":
+		params := json.RawMessage("[" + string(b) + "]")
+		c.req.Params = &params
+	}
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+	c.req.Version = "2.0"
+	c.req.Method = r.ServiceMethod
+	c.req.Id = r.Seq
+	c.req.Timeout = r.Timeout
+	return c.enc.Encode(&c.req)
+}
+
+func (c *clientCodec2) WriteCancel(r *rpc.Request) os.Error {
+	return c.enc.Encode(&cancelRequest2{"2.0", cancelMethod, cancelParams{r.Seq}})
+}
+
+type clientResponse2 struct {
+	Id     *json.RawMessage `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  *Error           `json:"error"`
+}
+
+func (r *clientResponse2) reset() {
+	r.Id = nil
+	r.Result = nil
+	r.Error = nil
+}
+
+func (c *clientCodec2) ReadResponseHeader(r *rpc.Response) os.Error {
+	c.resp.reset()
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+	var id uint64
+	if c.resp.Id == nil || json.Unmarshal(*c.resp.Id, &id) != nil {
+		// The server could not tell which request failed.
+		if c.resp.Error != nil {
+			return os.NewError("jsonrpc: server error: " + c.resp.Error.Message)
+		}
+		return os.NewError("jsonrpc: response without id")
+	}
+
+	c.mutex.Lock()
+	r.ServiceMethod = c.pending[id]
+	c.pending[id] = "", false
+	c.mutex.Unlock()
+
+	r.Error = ""
+	r.Seq = id
+	if c.resp.Error != nil {
+		r.Error = c.resp.Error.String()
+	}
+	return nil
+}
+
+func (c *clientCodec2) ReadResponseBody(x interface{}) os.Error {
+	if x == nil || c.resp.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *clientCodec2) Close() os.Error {
+	return c.c.Close()
+}
+
+// NewClient2 returns a new rpc.Client to handle requests to the
+// set of services at the other end of the connection, using JSON-RPC 2.0.
+func NewClient2(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec2(conn))
+}
+
+// Dial2 connects to a JSON-RPC 2.0 server at the specified network address.
+func Dial2(network, address string) (*rpc.Client, os.Error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient2(conn), err
+}
@@ -0,0 +1,57 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"bytes"
+	"http"
+	"io"
+	"os"
+	"rpc"
+)
+
+// maxHTTPBody is the size of the largest request body
+// the HTTP handler accepts.
+const maxHTTPBody = 1 << 20
+
+type httpHandler struct {
+	server *rpc.Server
+}
+
+// NewHTTPHandler returns an http.Handler that serves the JSON-RPC 2.0
+// requests POSTed to it with the methods of server.  The body of each
+// HTTP request holds a request or a batch of them, and the body of the
+// reply holds the responses; if there are none, because the requests
+// were all notifications, the reply has status 204 (No Content).  Calls
+// are served one at a time, and cannot stream.
+func NewHTTPHandler(server *rpc.Server) http.Handler {
+	return &httpHandler{server}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "405 must POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.ContentLength > maxHTTPBody {
+		http.Error(w, "413 request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var out bytes.Buffer
+	codec := newServerCodec2(io.LimitReader(req.Body, maxHTTPBody), &out, req.Body)
+	for {
+		err := h.server.ServeRequest(codec)
+		if err == os.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if out.Len() == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out.Bytes())
+}
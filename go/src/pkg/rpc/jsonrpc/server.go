@@ -27,9 +27,14 @@ type serverCodec struct {
 	// but save the original request ID in the pending map.
 	// When rpc responds, we use the sequence number in
 	// the response to find the original request ID.
-	mutex   sync.Mutex // protects seq, pending
+	mutex   sync.Mutex // protects seq, pending, calls
 	seq     uint64
 	pending map[uint64]*json.RawMessage
+
+	// The stream messages of a streaming call carry the request id of
+	// the call; calls maps the ids of pending requests to their
+	// sequence numbers.
+	calls map[string]uint64
 }
 
 // NewServerCodec returns a new rpc.ServerCodec using JSON-RPC on conn.
@@ -39,17 +44,26 @@ func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
 		enc:     json.NewEncoder(conn),
 		c:       conn,
 		pending: make(map[uint64]*json.RawMessage),
+		calls:   make(map[string]uint64),
 	}
 }
 
 type serverRequest struct {
-	Method string           `json:"method"`
-	Params *json.RawMessage `json:"params"`
-	Id     *json.RawMessage `json:"id"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params"`
+	Id      *json.RawMessage `json:"id"`
+	Stream  int              `json:"stream,omitempty"` // see rpc.StreamData
+	Window  int              `json:"window,omitempty"`
+	Timeout int64            `json:"timeout,omitempty"`
+	Cancel  bool             `json:"cancel,omitempty"`
 }
 
 func (r *serverRequest) reset() {
 	r.Method = ""
+	r.Stream = 0
+	r.Window = 0
+	r.Timeout = 0
+	r.Cancel = false
 	if r.Params != nil {
 		*r.Params = (*r.Params)[0:0]
 	}
@@ -62,6 +76,8 @@ type serverResponse struct {
 	Id     *json.RawMessage `json:"id"`
 	Result interface{}      `json:"result"`
 	Error  interface{}      `json:"error"`
+	Stream int              `json:"stream,omitempty"`
+	Window int              `json:"window,omitempty"`
 }
 
 func (c *serverCodec) ReadRequestHeader(r *rpc.Request) os.Error {
@@ -70,16 +86,28 @@ func (c *serverCodec) ReadRequestHeader(r *rpc.Request) os.Error {
 		return err
 	}
 	r.ServiceMethod = c.req.Method
+	r.Stream = c.req.Stream
+	r.Window = c.req.Window
+	r.Timeout = c.req.Timeout
+	r.Cancel = c.req.Cancel
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if r.Stream != 0 || r.Cancel {
+		// Part of a call in progress, or of none if
+		// its id is unknown: there is no sequence number 0.
+		r.Seq = c.calls[idKey(c.req.Id)]
+		return nil
+	}
 
 	// JSON request id can be any JSON value;
 	// RPC package expects uint64.  Translate to
 	// internal uint64 and save JSON on the side.
-	c.mutex.Lock()
 	c.seq++
 	c.pending[c.seq] = c.req.Id
+	c.calls[idKey(c.req.Id)] = c.seq
 	c.req.Id = nil
 	r.Seq = c.seq
-	c.mutex.Unlock()
 
 	return nil
 }
@@ -107,7 +135,13 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) os.Error {
 		c.mutex.Unlock()
 		return os.NewError("invalid sequence number in response")
 	}
-	c.pending[r.Seq] = nil, false
+	if r.Stream == 0 {
+		// Only the final response ends the call.
+		c.pending[r.Seq] = nil, false
+		if key := idKey(b); c.calls[key] == r.Seq {
+			c.calls[key] = 0, false
+		}
+	}
 	c.mutex.Unlock()
 
 	if b == nil {
@@ -116,6 +150,8 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) os.Error {
 	}
 	resp.Id = b
 	resp.Result = x
+	resp.Stream = r.Stream
+	resp.Window = r.Window
 	if r.Error == "" {
 		resp.Error = nil
 	} else {
@@ -124,6 +160,14 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) os.Error {
 	return c.enc.Encode(resp)
 }
 
+// idKey returns a map key for the request id id.
+func idKey(id *json.RawMessage) string {
+	if id == nil {
+		return "null"
+	}
+	return string(*id)
+}
+
 func (c *serverCodec) Close() os.Error {
 	return c.c.Close()
 }
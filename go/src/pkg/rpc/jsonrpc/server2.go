@@ -0,0 +1,383 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonrpc
+
+import (
+	"bytes"
+	"io"
+	"json"
+	"os"
+	"rpc"
+	"strings"
+	"sync"
+)
+
+// Error codes of JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700 // the request is not valid JSON
+	CodeInvalidRequest = -32600 // the request is not a request object
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000 // an error returned by the method
+)
+
+// Error is a JSON-RPC 2.0 error object.
+//
+// A method served through a codec from NewServerCodec2 that returns an
+// *Error sends it to the client as it is; other errors are sent with
+// the code CodeServerError and their text as the message.  Package rpc
+// carries errors as strings, so the String method returns the JSON
+// encoding of the object: that is the text of the errors a client using
+// NewClientCodec2 gets back.  ErrorOf decodes it again.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) String() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		// The Data cannot be encoded.
+		b, _ = json.Marshal(&Error{Code: e.Code, Message: e.Message})
+	}
+	return string(b)
+}
+
+// ErrorOf returns the JSON-RPC 2.0 error object that err, an error
+// returned by a call made over a codec from NewClientCodec2, stands for.
+// It returns nil if err is not such an error.
+func ErrorOf(err os.Error) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case rpc.ServerError:
+		return parseError(string(e))
+	}
+	return nil
+}
+
+// parseError decodes s if it is the text of an *Error, and returns nil otherwise.
+func parseError(s string) *Error {
+	if !strings.HasPrefix(s, "{") {
+		return nil
+	}
+	e := new(Error)
+	if json.Unmarshal([]byte(s), e) != nil || e.Code == 0 {
+		return nil
+	}
+	return e
+}
+
+var version2 = []byte(`"2.0"`)
+
+// A request2 is a JSON-RPC 2.0 request read by the server.
+type request2 struct {
+	method    string
+	params    *json.RawMessage // nil if absent
+	id        *json.RawMessage // JSON null if absent
+	notify    bool             // there was no id: the client wants no response
+	batch     *batch2          // the batch the request came in, if any
+	badParams bool             // the params did not suit the method
+	timeout   int64            // nanoseconds the client will wait, or 0
+	cancel    *json.RawMessage // for a cancellation, the id of the call canceled
+}
+
+// A batch2 collects the responses to a batch of requests,
+// to be sent together when the last is in.
+type batch2 struct {
+	waiting   int // responses yet to come
+	responses [][]byte
+}
+
+type serverCodec2 struct {
+	dec *json.Decoder // for reading JSON values
+	w   io.Writer
+	c   io.Closer
+
+	queue []*request2 // requests read but not yet handed out
+	req   *request2   // request being read
+
+	// Package rpc expects uint64 request IDs.
+	// We assign uint64 sequence numbers to incoming requests
+	// and keep the requests, with their ids, in pending.
+	mutex   sync.Mutex // protects seq, pending, batches and writes to w
+	seq     uint64
+	pending map[uint64]*request2
+}
+
+// NewServerCodec2 returns a new rpc.ServerCodec using JSON-RPC 2.0 on conn.
+// It accepts parameters by name, as a JSON object decoded into the
+// method's argument, or by position, as an array holding the argument.
+// It accepts notifications, which it runs but does not answer, and
+// batches.  A "$/cancelRequest" notification cancels the pending call
+// whose id is given in its params.  Streaming calls are not possible.
+func NewServerCodec2(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return newServerCodec2(conn, conn, conn)
+}
+
+func newServerCodec2(r io.Reader, w io.Writer, c io.Closer) *serverCodec2 {
+	return &serverCodec2{
+		dec:     json.NewDecoder(r),
+		w:       w,
+		c:       c,
+		pending: make(map[uint64]*request2),
+	}
+}
+
+func (c *serverCodec2) ReadRequestHeader(r *rpc.Request) os.Error {
+	for len(c.queue) == 0 {
+		var raw json.RawMessage
+		if err := c.dec.Decode(&raw); err != nil {
+			if err == os.EOF {
+				return err
+			}
+			// There is no telling where the next request starts.
+			// If the request was cut short, the client may still
+			// be there to hear why.
+			c.writeError(CodeParseError, err.String())
+			return io.ErrUnexpectedEOF
+		}
+		c.parse(raw)
+	}
+	c.req = c.queue[0]
+	c.queue = c.queue[1:]
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.req.cancel != nil {
+		// The call canceled, or none if its id is
+		// unknown: there is no sequence number 0.
+		r.Cancel = true
+		r.Seq = c.pendingSeq(c.req.cancel)
+		return nil
+	}
+	c.seq++
+	c.pending[c.seq] = c.req
+	r.Seq = c.seq
+	r.ServiceMethod = c.req.method
+	r.Timeout = c.req.timeout
+	return nil
+}
+
+// pendingSeq returns the sequence number of the pending
+// request with the given id, or 0 if there is none.
+// The caller must hold c.mutex.
+func (c *serverCodec2) pendingSeq(id *json.RawMessage) uint64 {
+	for seq, req := range c.pending {
+		if !req.notify && bytes.Equal(*req.id, *id) {
+			return seq
+		}
+	}
+	return 0
+}
+
+// parse queues the requests in raw, a request or a batch of them,
+// and answers those that are not valid.
+func (c *serverCodec2) parse(raw []byte) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '[' {
+		req, e := parseRequest(raw)
+		if e != nil {
+			c.mutex.Lock()
+			c.write(errorResponse(req.id, e))
+			c.mutex.Unlock()
+			return
+		}
+		c.queue = append(c.queue, req)
+		return
+	}
+	var items []json.RawMessage
+	json.Unmarshal(raw, &items)
+	if len(items) == 0 {
+		c.writeError(CodeInvalidRequest, "empty batch")
+		return
+	}
+	b := new(batch2)
+	for _, item := range items {
+		req, e := parseRequest(item)
+		if e != nil {
+			b.responses = append(b.responses, errorResponse(req.id, e))
+			continue
+		}
+		req.batch = b
+		if !req.notify {
+			b.waiting++
+		}
+		c.queue = append(c.queue, req)
+	}
+	if b.waiting == 0 && len(b.responses) > 0 {
+		c.mutex.Lock()
+		c.writeBatch(b)
+		c.mutex.Unlock()
+	}
+}
+
+// parseRequest parses a request object.  If it is not valid, parseRequest
+// returns the error to answer it with, and a request holding its id.
+func parseRequest(raw []byte) (*request2, *Error) {
+	req := &request2{id: &null, notify: true}
+	var m map[string]*json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil || m == nil {
+		return req, &Error{Code: CodeInvalidRequest, Message: "request is not an object"}
+	}
+	if id, ok := m["id"]; ok {
+		switch (*id)[0] {
+		case '{', '[', 't', 'f':
+			return req, &Error{Code: CodeInvalidRequest, Message: "id is not a string or number"}
+		}
+		req.id = id
+		req.notify = false
+	}
+	if v := m["jsonrpc"]; v == nil || !bytes.Equal(*v, version2) {
+		return req, &Error{Code: CodeInvalidRequest, Message: `request lacks "jsonrpc": "2.0"`}
+	}
+	if v := m["method"]; v == nil || json.Unmarshal(*v, &req.method) != nil || req.method == "" {
+		return req, &Error{Code: CodeInvalidRequest, Message: "request lacks a method"}
+	}
+	if p := m["params"]; p != nil && !bytes.Equal(*p, null) {
+		if (*p)[0] != '{' && (*p)[0] != '[' {
+			return req, &Error{Code: CodeInvalidRequest, Message: "params are neither an object nor an array"}
+		}
+		req.params = p
+	}
+	if v := m["timeout"]; v != nil && json.Unmarshal(*v, &req.timeout) != nil {
+		return req, &Error{Code: CodeInvalidRequest, Message: "timeout is not an integer"}
+	}
+	if req.method == cancelMethod && req.notify {
+		var p struct {
+			Id *json.RawMessage `json:"id"`
+		}
+		if req.params == nil || json.Unmarshal(*req.params, &p) != nil || p.Id == nil {
+			return req, &Error{Code: CodeInvalidParams, Message: "cancellation lacks the id of the call"}
+		}
+		req.cancel = p.Id
+	}
+	return req, nil
+}
+
+func (c *serverCodec2) ReadRequestBody(x interface{}) os.Error {
+	if x == nil || c.req.params == nil {
+		return nil
+	}
+	params := *c.req.params
+	if params[0] == '[' {
+		// By position: the one argument.
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil || len(list) != 1 {
+			c.req.badParams = true
+			return os.NewError("jsonrpc: method takes one parameter")
+		}
+		params = list[0]
+	}
+	if err := json.Unmarshal(params, x); err != nil {
+		c.req.badParams = true
+		return err
+	}
+	return nil
+}
+
+// A response2 is a JSON-RPC 2.0 response.
+type response2 struct {
+	Version string           `json:"jsonrpc"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+func (c *serverCodec2) WriteResponse(r *rpc.Response, x interface{}) os.Error {
+	if r.Stream != 0 {
+		return os.NewError("jsonrpc: JSON-RPC 2.0 cannot stream")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	req, ok := c.pending[r.Seq]
+	if !ok {
+		return os.NewError("invalid sequence number in response")
+	}
+	c.pending[r.Seq] = nil, false
+	if req.notify {
+		return nil
+	}
+
+	resp := &response2{Version: "2.0", Id: req.id}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = serverError(r.Error, req.badParams)
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		b, _ = json.Marshal(&response2{Version: "2.0", Id: req.id,
+			Error: &Error{Code: CodeInternalError, Message: "cannot encode result: " + err.String()}})
+	}
+	if req.batch == nil {
+		return c.write(b)
+	}
+	req.batch.responses = append(req.batch.responses, b)
+	req.batch.waiting--
+	if req.batch.waiting > 0 {
+		return nil
+	}
+	return c.writeBatch(req.batch)
+}
+
+// serverError returns the error object for an error
+// reported by package rpc or returned by a method.
+func serverError(msg string, badParams bool) *Error {
+	if e := parseError(msg); e != nil {
+		return e
+	}
+	switch {
+	case badParams:
+		return &Error{Code: CodeInvalidParams, Message: msg}
+	case strings.HasPrefix(msg, "rpc: can't find ") || strings.HasPrefix(msg, "rpc: service/method request ill-formed"):
+		return &Error{Code: CodeMethodNotFound, Message: msg}
+	}
+	return &Error{Code: CodeServerError, Message: msg}
+}
+
+// errorResponse returns the response to the request
+// with the given id that failed with e.
+func errorResponse(id *json.RawMessage, e *Error) []byte {
+	b, _ := json.Marshal(&response2{Version: "2.0", Error: e, Id: id})
+	return b
+}
+
+// writeError writes an error response to
+// a request whose id could not be read.
+func (c *serverCodec2) writeError(code int, msg string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.write(errorResponse(&null, &Error{Code: code, Message: msg}))
+}
+
+// write writes b, and a newline.  The caller must hold c.mutex.
+func (c *serverCodec2) write(b []byte) os.Error {
+	_, err := c.w.Write(append(b, '\n'))
+	return err
+}
+
+// writeBatch writes the responses to b as an array.
+// The caller must hold c.mutex.
+func (c *serverCodec2) writeBatch(b *batch2) os.Error {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(b.responses, []byte{','}))
+	buf.WriteByte(']')
+	return c.write(buf.Bytes())
+}
+
+func (c *serverCodec2) Close() os.Error {
+	return c.c.Close()
+}
+
+// ServeConn2 runs the JSON-RPC 2.0 server on a single connection.
+// ServeConn2 blocks, serving the connection until the client hangs up.
+// The caller typically invokes ServeConn2 in a go statement.
+func ServeConn2(conn io.ReadWriteCloser) {
+	rpc.ServeCodec(NewServerCodec2(conn))
+}
@@ -0,0 +1,25 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rpcstats publishes the per-method call statistics of RPC
+// servers through package expvar. It is separate from package rpc
+// because importing expvar registers the /debug/vars handler on
+// http.DefaultServeMux.
+package rpcstats
+
+import (
+	"expvar"
+	"rpc"
+)
+
+// Publish publishes the statistics of server as the expvar variable
+// name, whose value is the JSON encoding of server.Stats(). Like
+// expvar.Publish, it panics if name is already in use.
+func Publish(name string, server *rpc.Server) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return server.Stats() }))
+}
+
+// PublishDefault publishes the statistics of rpc.DefaultServer as the
+// expvar variable name.
+func PublishDefault(name string) { Publish(name, rpc.DefaultServer) }
@@ -16,8 +16,9 @@
 		- the method name is exported, that is, begins with an upper case letter.
 		- the method receiver is exported or local (defined in the package
 		  registering the service).
-		- the method has two arguments, both exported or local types.
-		- the method's second argument is a pointer.
+		- the method has two arguments, both exported or local types,
+		  optionally preceded by a *rpc.ServerCall.
+		- the method's second argument is a pointer, or a channel.
 		- the method has return type os.Error.
 
 	The method's first argument represents the arguments provided by the caller; the
@@ -25,6 +26,32 @@
 	The method's return value, if non-nil, is passed back as a string that the client
 	sees as an os.ErrorString.
 
+	A client may give up on a call, because it has a deadline (GoTimeout, CallTimeout)
+	or because it is canceled (Client.Cancel).  The server learns of the deadline with
+	the request and of the cancellation from a message on the connection.  A method
+	that may run for long can take a *ServerCall first and watch its Canceled channel,
+	which is also closed when the connection goes away.
+
+	A method may stream its arguments, its reply or both by taking a channel in
+	their place:
+
+		func (t *T) MethodName(argType T1, replies chan *T2) os.Error
+
+	receives its arguments as usual and sends any number of replies on the channel,
+	which the server closes when the method returns; the method must not close it.
+	With a channel in the first place, the arguments arrive on it one at a time
+	until the client closes its end.  The channels must be bidirectional.  The
+	client passes channels to Call or Go in the same places.  Values travel as
+	separate messages on the connection, each stream's sender never getting more
+	than a few ahead of its receiver, so other calls proceed meanwhile; a call
+	whose peer sends more is abandoned.  Streaming calls need ServeConn or
+	ServeCodec; they cannot be served by ServeRequest.
+
+	A server may wrap the calls it dispatches in interceptors (see Server.Intercept),
+	to check credentials, log or limit the rate of calls.  It keeps statistics on the
+	calls of each method (see Server.Stats), shown on its debugging page and available
+	through package expvar with rpc/rpcstats.
+
 	The server may handle requests on a single connection by calling ServeConn.  More
 	typically it will create a network listener and call Accept or, for an HTTP
 	listener, HandleHTTP and http.Serve.
@@ -123,6 +150,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"utf8"
 )
@@ -138,12 +166,18 @@ const (
 var unusedError *os.Error
 var typeOfOsError = reflect.TypeOf(unusedError).Elem()
 
+var typeOfServerCall = reflect.TypeOf((*ServerCall)(nil))
+
 type methodType struct {
-	sync.Mutex // protects counters
-	method     reflect.Method
-	ArgType    reflect.Type
-	ReplyType  reflect.Type
-	numCalls   uint
+	sync.Mutex  // protects counters
+	method      reflect.Method
+	takesCall   bool // method's first argument is a *ServerCall
+	argStream   bool // ArgType is a channel
+	replyStream bool // ReplyType is a channel
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	numCalls    uint
+	stats       MethodStats
 }
 
 type service struct {
@@ -159,6 +193,10 @@ type service struct {
 type Request struct {
 	ServiceMethod string   // format: "Service.Method"
 	Seq           uint64   // sequence number chosen by client
+	Timeout       int64    // nanoseconds the client will wait for the reply; 0 means forever
+	Cancel        bool     // the client has abandoned the call numbered Seq
+	Stream        int      // kind of stream message, if any: StreamData, StreamEnd or StreamWindow
+	Window        int      // values taken, in a StreamWindow message
 	next          *Request // for free list in Server
 }
 
@@ -169,17 +207,108 @@ type Response struct {
 	ServiceMethod string    // echoes that of the Request
 	Seq           uint64    // echoes that of the request
 	Error         string    // error, if any.
+	Stream        int       // kind of stream message, if any: StreamData or StreamWindow
+	Window        int       // values taken, in a StreamWindow message
 	next          *Response // for free list in Server
 }
 
+// A ServerCall describes a call being served.  A method that takes a
+// *ServerCall before its arguments is passed the one for its call.
+type ServerCall struct {
+	ServiceMethod string // format: "Service.Method"
+	Seq           uint64 // sequence number chosen by client
+	Deadline      int64  // if non-zero, time.Nanoseconds() at which the client gives up
+
+	once     sync.Once
+	canceled chan bool
+	timer    *time.Timer
+	args     *recvStream // streams the arguments, if they are a channel
+	reply    *sendStream // streams the replies, if they are a channel
+}
+
+// Canceled returns a channel that is closed when the client no longer
+// wants the reply: it canceled the call, the call's deadline passed, or
+// the connection closed.  The reply is sent regardless.
+func (c *ServerCall) Canceled() <-chan bool {
+	return c.canceled
+}
+
+func (c *ServerCall) cancel() {
+	c.once.Do(func() {
+		close(c.canceled)
+		// Nobody wants the replies, nor will send more arguments.
+		if c.reply != nil {
+			c.reply.stop()
+		}
+		if c.args != nil {
+			c.args.end(nil)
+		}
+	})
+}
+
+// A callSet holds the calls in progress on a connection.
+type callSet struct {
+	mu    sync.Mutex
+	calls map[uint64]*ServerCall
+}
+
+func newCallSet() *callSet {
+	return &callSet{calls: make(map[uint64]*ServerCall)}
+}
+
+// add records the start of the call made by req, with its streams.
+func (cs *callSet) add(req *Request, args *recvStream, reply *sendStream) *ServerCall {
+	c := &ServerCall{ServiceMethod: req.ServiceMethod, Seq: req.Seq, canceled: make(chan bool), args: args, reply: reply}
+	if req.Timeout > 0 {
+		c.Deadline = time.Nanoseconds() + req.Timeout
+		c.timer = time.AfterFunc(req.Timeout, func() { c.cancel() })
+	}
+	cs.mu.Lock()
+	cs.calls[c.Seq] = c
+	cs.mu.Unlock()
+	return c
+}
+
+// remove records the end of call c.
+func (cs *callSet) remove(c *ServerCall) {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	cs.mu.Lock()
+	if cs.calls[c.Seq] == c {
+		cs.calls[c.Seq] = nil, false
+	}
+	cs.mu.Unlock()
+}
+
+// cancel cancels the call numbered seq, if it is in progress.
+func (cs *callSet) cancel(seq uint64) {
+	cs.mu.Lock()
+	c := cs.calls[seq]
+	cs.mu.Unlock()
+	if c != nil {
+		c.cancel()
+	}
+}
+
+// cancelAll cancels every call in progress.
+func (cs *callSet) cancelAll() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, c := range cs.calls {
+		c.cancel()
+	}
+}
+
 // Server represents an RPC Server.
 type Server struct {
-	mu         sync.Mutex // protects the serviceMap
-	serviceMap map[string]*service
-	reqLock    sync.Mutex // protects freeReq
-	freeReq    *Request
-	respLock   sync.Mutex // protects freeResp
-	freeResp   *Response
+	mu           sync.Mutex // protects the serviceMap and interceptors
+	serviceMap   map[string]*service
+	interceptors []Interceptor
+	reqLock      sync.Mutex // protects freeReq
+	freeReq      *Request
+	respLock     sync.Mutex // protects freeResp
+	freeResp     *Response
 }
 
 // NewServer returns a new Server.
@@ -198,7 +327,7 @@ func isExported(name string) bool {
 
 // Is this type exported or a builtin?
 func isExportedOrBuiltinType(t reflect.Type) bool {
-	for t.Kind() == reflect.Ptr {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Chan {
 		t = t.Elem()
 	}
 	// PkgPath will be non-empty even for an exported type,
@@ -209,7 +338,9 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 // Register publishes in the server the set of methods of the
 // receiver value that satisfy the following conditions:
 //	- exported method
-//	- two arguments, both pointers to exported structs
+//	- two arguments, both pointers to exported structs,
+//	  optionally preceded by a *ServerCall; either may
+//	  instead be a channel, for a streaming method
 //	- one return value, of type os.Error
 // It returns an error if the receiver is not an exported type or has no
 // suitable methods.
@@ -260,20 +391,36 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 		if method.PkgPath != "" {
 			continue
 		}
-		// Method needs three ins: receiver, *args, *reply.
-		if mtype.NumIn() != 3 {
+		// Method needs three ins: receiver, *args, *reply,
+		// or four with a *ServerCall before *args.
+		takesCall := mtype.NumIn() == 4 && mtype.In(1) == typeOfServerCall
+		if mtype.NumIn() != 3 && !takesCall {
 			log.Println("method", mname, "has wrong number of ins:", mtype.NumIn())
 			continue
 		}
-		// First arg need not be a pointer.
-		argType := mtype.In(1)
+		first := 1
+		if takesCall {
+			first = 2
+		}
+		// First arg need not be a pointer; a channel streams the arguments.
+		argType := mtype.In(first)
+		argStream := argType.Kind() == reflect.Chan
+		if argStream && argType.ChanDir() != reflect.BothDir {
+			log.Println("method", mname, "argument channel not bidirectional:", argType)
+			continue
+		}
 		if !isExportedOrBuiltinType(argType) {
 			log.Println(mname, "argument type not exported or local:", argType)
 			continue
 		}
-		// Second arg must be a pointer.
-		replyType := mtype.In(2)
-		if replyType.Kind() != reflect.Ptr {
+		// Second arg must be a pointer, or a channel to stream the replies.
+		replyType := mtype.In(first + 1)
+		replyStream := replyType.Kind() == reflect.Chan
+		if replyStream && replyType.ChanDir() != reflect.BothDir {
+			log.Println("method", mname, "reply channel not bidirectional:", replyType)
+			continue
+		}
+		if replyType.Kind() != reflect.Ptr && !replyStream {
 			log.Println("method", mname, "reply type not a pointer:", replyType)
 			continue
 		}
@@ -290,7 +437,7 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 			log.Println("method", mname, "returns", returnType.String(), "not os.Error")
 			continue
 		}
-		s.method[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType}
+		s.method[mname] = &methodType{method: method, takesCall: takesCall, argStream: argStream, replyStream: replyStream, ArgType: argType, ReplyType: replyType}
 	}
 
 	if len(s.method) == 0 {
@@ -302,6 +449,36 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 	return nil
 }
 
+// An Interceptor is run around each call the server dispatches.  It is
+// given the call, the decoded arguments, the reply value the method will
+// fill in, and a function invoke that runs the method, or the next
+// interceptor, and returns its error.  An interceptor can reject the call
+// by returning an error without calling invoke; otherwise it should
+// return the error invoke returns, having looked at the reply if it wishes.
+// The error is sent to the client.
+type Interceptor func(call *ServerCall, args, reply interface{}, invoke func() os.Error) os.Error
+
+// Intercept adds f to the interceptors run around each call.
+// Interceptors run in the order they were added, each around
+// the ones added after it.
+func (server *Server) Intercept(f Interceptor) {
+	server.mu.Lock()
+	server.interceptors = append(server.interceptors, f)
+	server.mu.Unlock()
+}
+
+// intercept runs invoke inside the server's interceptors.
+func (server *Server) intercept(call *ServerCall, args, reply interface{}, invoke func() os.Error) os.Error {
+	server.mu.Lock()
+	chain := server.interceptors
+	server.mu.Unlock()
+	for i := len(chain) - 1; i >= 0; i-- {
+		f, next := chain[i], invoke
+		invoke = func() os.Error { return f(call, args, reply, next) }
+	}
+	return invoke()
+}
+
 // A value sent as a placeholder for the response when the server receives an invalid request.
 type InvalidRequest struct{}
 
@@ -332,20 +509,40 @@ func (m *methodType) NumCalls() (n uint) {
 	return n
 }
 
-func (s *service) call(server *Server, sending *sync.Mutex, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) {
+func (s *service) call(server *Server, sending *sync.Mutex, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec, calls *callSet, sc *ServerCall) {
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
 	function := mtype.method.Func
 	// Invoke the method, providing a new value for the reply.
-	returnValues := function.Call([]reflect.Value{s.rcvr, argv, replyv})
-	// The return value for the method is an os.Error.
-	errInter := returnValues[0].Interface()
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if mtype.takesCall {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(sc), argv, replyv}
+	}
+	invoke := func() os.Error {
+		returnValues := function.Call(in)
+		// The return value for the method is an os.Error.
+		errInter := returnValues[0].Interface()
+		if errInter != nil {
+			return errInter.(os.Error)
+		}
+		return nil
+	}
+	start := time.Nanoseconds()
+	err := server.intercept(sc, argv.Interface(), replyv.Interface(), invoke)
+	sc.closeStreams(argv, replyv)
+	mtype.record(time.Nanoseconds()-start, err != nil)
+	calls.remove(sc)
 	errmsg := ""
-	if errInter != nil {
-		errmsg = errInter.(os.Error).String()
+	if err != nil {
+		errmsg = err.String()
+	}
+	reply := replyv.Interface()
+	if mtype.replyStream {
+		// The replies have been sent.
+		reply = invalidRequest
 	}
-	server.sendResponse(sending, req, replyv.Interface(), codec, errmsg)
+	server.sendResponse(sending, req, reply, codec, errmsg)
 	server.freeRequest(req)
 }
 
@@ -393,6 +590,7 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 // decode requests and encode responses.
 func (server *Server) ServeCodec(codec ServerCodec) {
 	sending := new(sync.Mutex)
+	calls := newCallSet()
 	for {
 		service, mtype, req, argv, replyv, err := server.readRequest(codec)
 		if err != nil {
@@ -409,8 +607,27 @@ func (server *Server) ServeCodec(codec ServerCodec) {
 			}
 			continue
 		}
-		go service.call(server, sending, mtype, req, argv, replyv, codec)
+		if req.Stream != 0 {
+			err = calls.deliver(codec, req)
+			server.freeRequest(req)
+			if err == os.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				log.Println("rpc: reading stream:", err)
+			}
+			continue
+		}
+		if req.Cancel {
+			calls.cancel(req.Seq)
+			server.freeRequest(req)
+			continue
+		}
+		args, reply := server.openStreams(req, mtype, argv, replyv, sending, codec)
+		go service.call(server, sending, mtype, req, argv, replyv, codec, calls, calls.add(req, args, reply))
 	}
+	// Nobody is left to read the replies.
+	calls.cancelAll()
 	codec.Close()
 }
 
@@ -430,7 +647,25 @@ func (server *Server) ServeRequest(codec ServerCodec) os.Error {
 		}
 		return err
 	}
-	service.call(server, sending, mtype, req, argv, replyv, codec)
+	// There is no call in progress for a stream message
+	// or cancellation to belong to.
+	if req.Stream != 0 {
+		err = codec.ReadRequestBody(nil)
+		server.freeRequest(req)
+		return err
+	}
+	if req.Cancel {
+		server.freeRequest(req)
+		return nil
+	}
+	if mtype.argStream || mtype.replyStream {
+		err = os.NewError("rpc: cannot serve streaming call " + req.ServiceMethod + " alone")
+		server.sendResponse(sending, req, invalidRequest, codec, err.String())
+		server.freeRequest(req)
+		return err
+	}
+	calls := newCallSet()
+	service.call(server, sending, mtype, req, argv, replyv, codec, calls, calls.add(req, nil, nil))
 	return nil
 }
 
@@ -484,6 +719,26 @@ func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *m
 		codec.ReadRequestBody(nil)
 		return
 	}
+	if req.Stream != 0 {
+		// The caller reads the body.
+		return
+	}
+	if req.Cancel {
+		// The body is a placeholder.
+		err = codec.ReadRequestBody(nil)
+		return
+	}
+	if mtype.replyStream {
+		replyv = reflect.MakeChan(mtype.ReplyType, 0)
+	} else {
+		replyv = reflect.New(mtype.ReplyType.Elem())
+	}
+	if mtype.argStream {
+		// The arguments follow; the body is a placeholder.
+		argv = reflect.MakeChan(mtype.ArgType, 0)
+		err = codec.ReadRequestBody(nil)
+		return
+	}
 
 	// Decode the argument value.
 	argIsValue := false // if true, need to indirect before calling.
@@ -500,8 +755,6 @@ func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *m
 	if argIsValue {
 		argv = argv.Elem()
 	}
-
-	replyv = reflect.New(mtype.ReplyType.Elem())
 	return
 }
 
@@ -517,6 +770,9 @@ func (server *Server) readRequestHeader(codec ServerCodec) (service *service, mt
 		err = os.NewError("rpc: server cannot decode request: " + err.String())
 		return
 	}
+	if req.Stream != 0 || req.Cancel {
+		return
+	}
 
 	serviceMethod := strings.Split(req.ServiceMethod, ".")
 	if len(serviceMethod) != 2 {
@@ -551,6 +807,9 @@ func (server *Server) Accept(lis net.Listener) {
 	}
 }
 
+// Intercept adds f to the interceptors of the DefaultServer.
+func Intercept(f Interceptor) { DefaultServer.Intercept(f) }
+
 // Register publishes the receiver's methods in the DefaultServer.
 func Register(rcvr interface{}) os.Error { return DefaultServer.Register(rcvr) }
 
@@ -11,6 +11,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
@@ -74,6 +75,48 @@ func (t *Arith) Error(args *Args, reply *Reply) os.Error {
 	panic("ERROR")
 }
 
+// Count replies with args.A, args.A+1, ..., args.B-1.
+func (t *Arith) Count(args *Args, replies chan *Reply) os.Error {
+	if args.A > args.B {
+		return os.NewError("bad range")
+	}
+	for i := args.A; i < args.B; i++ {
+		replies <- &Reply{i}
+	}
+	return nil
+}
+
+// SumMul replies with the sum of the products of its arguments.
+func (t *Arith) SumMul(args chan *Args, reply *Reply) os.Error {
+	for a := range args {
+		reply.C += a.A * a.B
+	}
+	return nil
+}
+
+// Muls replies with the product of each of its arguments.
+func (t *Arith) Muls(args chan Args, replies chan Reply) os.Error {
+	for a := range args {
+		replies <- Reply{a.A * a.B}
+	}
+	return nil
+}
+
+// sleepCanceled reports whether each call of Arith.Sleep
+// ended because the client gave up.
+var sleepCanceled = make(chan bool, 10)
+
+// Sleep waits args.A milliseconds, or until the client gives up.
+func (t *Arith) Sleep(call *ServerCall, args *Args, reply *Reply) os.Error {
+	select {
+	case <-call.Canceled():
+		sleepCanceled <- true
+	case <-time.After(int64(args.A) * 1e6):
+		sleepCanceled <- false
+	}
+	return nil
+}
+
 func listenTCP() (net.Listener, string) {
 	l, e := net.Listen("tcp", "127.0.0.1:0") // any available address
 	if e != nil {
@@ -378,6 +421,361 @@ func TestRegistrationError(t *testing.T) {
 	}
 }
 
+func waitSleepCanceled(t *testing.T) {
+	select {
+	case canceled := <-sleepCanceled:
+		if !canceled {
+			t.Error("Sleep ran to completion; want it canceled")
+		}
+	case <-time.After(5 * second):
+		t.Error("Sleep was not canceled")
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	once.Do(startServer)
+	client, err := dialDirect()
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	start := time.Nanoseconds()
+	err = client.CallTimeout("Arith.Sleep", &Args{10000, 0}, new(Reply), 0.1*second)
+	if err != ErrTimeout {
+		t.Errorf("CallTimeout: got %v; want %v", err, ErrTimeout)
+	}
+	if d := time.Nanoseconds() - start; d > 5*second {
+		t.Errorf("CallTimeout returned after %.1fs", float64(d)/second)
+	}
+	waitSleepCanceled(t)
+
+	// A call that finishes in time is unaffected.
+	reply := new(Reply)
+	err = client.CallTimeout("Arith.Add", Args{7, 8}, reply, 5*second)
+	if err != nil || reply.C != 15 {
+		t.Errorf("CallTimeout Add: got %d, %v; want 15", reply.C, err)
+	}
+	client.mutex.Lock()
+	n := len(client.pending)
+	client.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("%d calls pending after CallTimeout", n)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	once.Do(startServer)
+	client, err := dialDirect()
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	call := client.Go("Arith.Sleep", &Args{10000, 0}, new(Reply), nil)
+	if !client.Cancel(call) {
+		t.Error("Cancel of outstanding call returned false")
+	}
+	<-call.Done
+	if call.Error != ErrCanceled {
+		t.Errorf("canceled call: got %v; want %v", call.Error, ErrCanceled)
+	}
+	waitSleepCanceled(t)
+	if client.Cancel(call) {
+		t.Error("second Cancel returned true")
+	}
+
+	// The late reply to the canceled call must not disturb later ones.
+	reply := new(Reply)
+	err = client.Call("Arith.Mul", &Args{7, 8}, reply)
+	if err != nil || reply.C != 56 {
+		t.Errorf("Mul after Cancel: got %d, %v; want 56", reply.C, err)
+	}
+}
+
+// A HoldCodec records the requests and cancellations written to it,
+// holding up the first request until release is closed.  It sends on
+// holding once it holds the first request.
+type HoldCodec struct {
+	mu      sync.Mutex
+	methods []string
+	cancels []uint64
+	holding chan bool
+	release chan bool
+	closed  chan bool
+}
+
+func (c *HoldCodec) WriteRequest(r *Request, body interface{}) os.Error {
+	c.mu.Lock()
+	c.methods = append(c.methods, r.ServiceMethod)
+	first := len(c.methods) == 1
+	c.mu.Unlock()
+	if first {
+		c.holding <- true
+		<-c.release
+	}
+	return nil
+}
+
+func (c *HoldCodec) WriteCancel(r *Request) os.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancels = append(c.cancels, r.Seq)
+	return nil
+}
+
+func (c *HoldCodec) written() (methods []string, cancels []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.methods...), append([]uint64(nil), c.cancels...)
+}
+
+func (c *HoldCodec) ReadResponseHeader(*Response) os.Error {
+	<-c.closed
+	return os.EOF
+}
+
+func (c *HoldCodec) ReadResponseBody(interface{}) os.Error {
+	return nil
+}
+
+func (c *HoldCodec) Close() os.Error {
+	close(c.closed)
+	return nil
+}
+
+func TestCancelBeforeSend(t *testing.T) {
+	codec := &HoldCodec{holding: make(chan bool), release: make(chan bool), closed: make(chan bool)}
+	client := NewClientWithCodec(codec)
+	defer client.Close()
+
+	// The first call holds the connection while the second, canceled
+	// in the meantime, waits to be written.
+	go client.Go("Arith.Add", &Args{1, 2}, new(Reply), nil)
+	<-codec.holding
+	call := &Call{ServiceMethod: "Arith.Mul", Args: &Args{1, 2}, Reply: new(Reply), Done: make(chan *Call, 1)}
+	timeout, ok := client.register(call)
+	if !ok {
+		t.Fatalf("register: %v", call.Error)
+	}
+	written := make(chan bool)
+	go func() {
+		client.write(call, timeout)
+		written <- true
+	}()
+	if !client.Cancel(call) {
+		t.Fatal("Cancel of unsent call returned false")
+	}
+	close(codec.release)
+	<-written
+
+	if call.Error != ErrCanceled {
+		t.Errorf("canceled call: got %v; want %v", call.Error, ErrCanceled)
+	}
+	methods, cancels := codec.written()
+	if len(methods) != 1 || methods[0] != "Arith.Add" {
+		t.Errorf("requests written: %q; want only Arith.Add", methods)
+	}
+	if len(cancels) != 0 {
+		t.Errorf("cancellations written for calls %v; want none", cancels)
+	}
+}
+
+func TestStream(t *testing.T) {
+	once.Do(startServer)
+	client, err := dialDirect()
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer client.Close()
+
+	// Replies streamed, more of them than the window holds.
+	replies := make(chan *Reply)
+	call := client.Go("Arith.Count", &Args{0, 100}, replies, nil)
+	n := 0
+	for r := range replies {
+		if r.C != n {
+			t.Errorf("Count: reply %d is %d", n, r.C)
+		}
+		n++
+	}
+	<-call.Done
+	if call.Error != nil || n != 100 {
+		t.Errorf("Count: %d replies, %v; want 100", n, call.Error)
+	}
+
+	// An error ends the replies.
+	replies = make(chan *Reply)
+	call = client.Go("Arith.Count", &Args{1, 0}, replies, nil)
+	for _ = range replies {
+		t.Error("Count of bad range replied")
+	}
+	<-call.Done
+	if call.Error == nil || call.Error.String() != "bad range" {
+		t.Errorf("Count of bad range: got %v; want bad range", call.Error)
+	}
+
+	// Arguments streamed.
+	args := make(chan *Args)
+	reply := new(Reply)
+	call = client.Go("Arith.SumMul", args, reply, nil)
+	for i := 0; i < 50; i++ {
+		args <- &Args{i, 2}
+	}
+	close(args)
+	<-call.Done
+	if call.Error != nil || reply.C != 2450 {
+		t.Errorf("SumMul: got %d, %v; want 2450", reply.C, call.Error)
+	}
+
+	// Both, with another call while they stream.
+	in, out := make(chan Args), make(chan Reply)
+	call = client.Go("Arith.Muls", in, out, nil)
+	go func() {
+		for i := 0; i < 40; i++ {
+			in <- Args{i, i}
+		}
+		close(in)
+	}()
+	if err = client.Call("Arith.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add while streaming: got %d, %v; want 15", reply.C, err)
+	}
+	n = 0
+	for r := range out {
+		if r.C != n*n {
+			t.Errorf("Muls: reply %d is %d", n, r.C)
+		}
+		n++
+	}
+	<-call.Done
+	if call.Error != nil || n != 40 {
+		t.Errorf("Muls: %d replies, %v; want 40", n, call.Error)
+	}
+}
+
+func TestStreamOverflow(t *testing.T) {
+	ch := make(chan int)
+	granted := make(chan int, 1)
+	r := newRecvStream(reflect.ValueOf(ch), func(n int) os.Error {
+		granted <- n
+		return nil
+	})
+	for i := 0; i < streamWindow; i++ {
+		if err := r.put(reflect.ValueOf(i)); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+	if err := r.put(reflect.ValueOf(streamWindow)); err != ErrStreamOverflow {
+		t.Fatalf("put past the window: got %v; want ErrStreamOverflow", err)
+	}
+
+	// Taking values makes room again once it is granted.
+	for i := 0; i < streamWindow/2; i++ {
+		if v := <-ch; v != i {
+			t.Fatalf("got %d; want %d", v, i)
+		}
+	}
+	if n := <-granted; n != streamWindow/2 {
+		t.Errorf("granted %d; want %d", n, streamWindow/2)
+	}
+	for i := 0; i < streamWindow/2; i++ {
+		if err := r.put(reflect.ValueOf(streamWindow + i)); err != nil {
+			t.Fatalf("put after grant: %v", err)
+		}
+	}
+	if err := r.put(reflect.ValueOf(0)); err != ErrStreamOverflow {
+		t.Errorf("put past the granted window: got %v; want ErrStreamOverflow", err)
+	}
+	r.end(nil)
+	drain(reflect.ValueOf(ch))
+}
+
+func TestInterceptors(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Arith))
+	var trace []string
+	server.Intercept(func(call *ServerCall, args, reply interface{}, invoke func() os.Error) os.Error {
+		if a, ok := args.(Args); ok && a.A < 0 {
+			return os.NewError("negative")
+		}
+		trace = append(trace, "outer "+call.ServiceMethod)
+		err := invoke()
+		trace = append(trace, fmt.Sprint("outer done ", reply.(*Reply).C, " ", err))
+		return err
+	})
+	server.Intercept(func(call *ServerCall, args, reply interface{}, invoke func() os.Error) os.Error {
+		trace = append(trace, "inner")
+		return invoke()
+	})
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	reply := new(Reply)
+	if err := client.Call("Arith.Add", Args{7, 8}, reply); err != nil || reply.C != 15 {
+		t.Errorf("Add: got %d, %v; want 15", reply.C, err)
+	}
+	want := "outer Arith.Add; inner; outer done 15 <nil>"
+	if s := strings.Join(trace, "; "); s != want {
+		t.Errorf("trace %q; want %q", s, want)
+	}
+	trace = nil
+	if err := client.Call("Arith.Add", Args{-1, 8}, reply); err == nil || err.String() != "negative" {
+		t.Errorf("Add rejected by interceptor: got %v; want negative", err)
+	}
+	if err := client.Call("Arith.Div", Args{7, 0}, reply); err == nil {
+		t.Error("Div by zero succeeded")
+	}
+	want = "outer Arith.Div; inner; outer done 0 divide by zero"
+	if s := strings.Join(trace, "; "); s != want {
+		t.Errorf("trace %q; want %q", s, want)
+	}
+
+	stats := server.Stats()
+	if st := stats["Arith.Add"]; st.Calls != 2 || st.Errors != 1 {
+		t.Errorf("Arith.Add stats: %d calls, %d errors; want 2, 1", st.Calls, st.Errors)
+	}
+	st := stats["Arith.Div"]
+	if st.Calls != 1 || st.Errors != 1 {
+		t.Errorf("Arith.Div stats: %d calls, %d errors; want 1, 1", st.Calls, st.Errors)
+	}
+	var n uint
+	for _, c := range st.Latency {
+		n += c
+	}
+	if n != st.Calls {
+		t.Errorf("Arith.Div latency histogram counts %d calls; want %d", n, st.Calls)
+	}
+}
+
+var latencyTests = []struct {
+	ns     int64
+	bucket int
+}{
+	{0, 0},
+	{99999, 0},
+	{1e5, 1},
+	{5e6, 2},
+	{9999999999, 5},
+	{1e10, 6},
+	{1e12, 6},
+}
+
+func TestLatencyBucket(t *testing.T) {
+	for _, tt := range latencyTests {
+		if b := latencyBucket(tt.ns); b != tt.bucket {
+			t.Errorf("latencyBucket(%d) = %d; want %d", tt.ns, b, tt.bucket)
+		}
+	}
+	if s := latencyBound(0); s != "<100µs" {
+		t.Errorf("latencyBound(0) = %q; want <100µs", s)
+	}
+	if s := latencyBound(latencyBuckets - 1); s != "≥10s" {
+		t.Errorf("latencyBound(last) = %q; want ≥10s", s)
+	}
+}
+
 type WriteFailCodec int
 
 func (WriteFailCodec) WriteRequest(*Request, interface{}) os.Error {
@@ -0,0 +1,101 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import "strconv"
+
+// latencyBuckets is the number of buckets in a latency histogram.
+const latencyBuckets = 7
+
+// MethodStats holds statistics about the completed calls of a method.
+// Interceptors run as part of the call and their time is counted.
+type MethodStats struct {
+	Calls  uint  // calls completed
+	Errors uint  // calls that returned an error
+	Time   int64 // total nanoseconds spent in calls
+
+	// Latency is a histogram of the duration of calls.
+	// Latency[i] counts the calls that took less than
+	// 100µs times 10 to the i; the last element counts the rest.
+	Latency [latencyBuckets]uint
+}
+
+// latencyBucket returns the index in MethodStats.Latency
+// of the bucket for a call that took ns nanoseconds.
+func latencyBucket(ns int64) int {
+	b := 0
+	for bound := int64(1e5); b < latencyBuckets-1 && ns >= bound; bound *= 10 {
+		b++
+	}
+	return b
+}
+
+// latencyBound returns a description of the durations
+// counted by bucket b of MethodStats.Latency.
+func latencyBound(b int) string {
+	if b == latencyBuckets-1 {
+		return "≥" + fmtDuration(1e5*pow10(b-1))
+	}
+	return "<" + fmtDuration(1e5*pow10(b))
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
+// fmtDuration formats ns nanoseconds in the largest unit
+// that leaves an integral or nearly so number.
+func fmtDuration(ns int64) string {
+	switch {
+	case ns >= 1e9:
+		return strconv.Ftoa64(float64(ns)/1e9, 'g', 3) + "s"
+	case ns >= 1e6:
+		return strconv.Ftoa64(float64(ns)/1e6, 'g', 3) + "ms"
+	case ns >= 1e3:
+		return strconv.Ftoa64(float64(ns)/1e3, 'g', 3) + "µs"
+	}
+	return strconv.Itoa64(ns) + "ns"
+}
+
+// record adds a call that took ns nanoseconds
+// and failed if failed is set to the statistics.
+func (m *methodType) record(ns int64, failed bool) {
+	m.Lock()
+	s := &m.stats
+	s.Calls++
+	if failed {
+		s.Errors++
+	}
+	s.Time += ns
+	s.Latency[latencyBucket(ns)]++
+	m.Unlock()
+}
+
+// Stats returns the statistics of the method.
+func (m *methodType) Stats() (s MethodStats) {
+	m.Lock()
+	s = m.stats
+	m.Unlock()
+	return
+}
+
+// Stats returns the statistics of the calls of each registered method,
+// keyed by the name clients call it by, "Service.Method". Package
+// rpc/rpcstats publishes them through package expvar.
+func (server *Server) Stats() map[string]MethodStats {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	stats := make(map[string]MethodStats)
+	for sname, service := range server.serviceMap {
+		for mname, method := range service.method {
+			stats[sname+"."+mname] = method.Stats()
+		}
+	}
+	return stats
+}
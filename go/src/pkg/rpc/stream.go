@@ -0,0 +1,361 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"reflect"
+	"sync"
+)
+
+// Values of the Stream field of Request and Response headers.  The
+// arguments or reply of a streaming call, whose method takes a channel,
+// travel as a series of messages with the Seq of the call: the values
+// go in data messages, ended by an end message from the client or by
+// the final response from the server, and the receiver of the values
+// sends window messages as it takes them.  Other messages have Stream
+// set to zero.
+const (
+	StreamData   = 1 + iota // the body is the next value of the stream
+	StreamEnd               // the client has sent all its values; the body is an InvalidRequest
+	StreamWindow            // the receiver has taken Window more values; the body is an InvalidRequest
+)
+
+// streamWindow is the number of values the sender of a stream may have
+// sent that the receiver has not yet taken.
+const streamWindow = 16
+
+// ErrStreamOverflow is the error of a call whose peer sent more stream
+// values than the window allows.
+var ErrStreamOverflow = os.NewError("rpc: stream sent more values than its window allows")
+
+// A sendStream sends the values received from a channel as data
+// messages, never more than the receiver has room for.
+type sendStream struct {
+	ch     reflect.Value                                     // channel whose values are sent
+	write  func(kind, window int, body interface{}) os.Error // sends a stream message
+	credit chan int                                          // windows granted by the receiver
+	stopc  chan bool                                         // closed when the values are no longer wanted
+	done   chan bool                                         // closed when run returns
+
+	onceStart, onceStop sync.Once
+}
+
+func newSendStream(ch reflect.Value, write func(kind, window int, body interface{}) os.Error) *sendStream {
+	return &sendStream{
+		ch:     ch,
+		write:  write,
+		credit: make(chan int, streamWindow),
+		stopc:  make(chan bool),
+		done:   make(chan bool),
+	}
+}
+
+// start starts sending, if it has not started already.
+func (s *sendStream) start() {
+	s.onceStart.Do(func() { go s.run() })
+}
+
+// stop makes the stream discard the values it has not yet sent.
+func (s *sendStream) stop() {
+	s.onceStop.Do(func() { close(s.stopc) })
+}
+
+// grant adds n to the values the receiver has room for.
+func (s *sendStream) grant(n int) {
+	select {
+	case s.credit <- n:
+	default:
+		// The receiver has granted more than was sent.
+	}
+}
+
+func (s *sendStream) stopped() bool {
+	select {
+	case <-s.stopc:
+		return true
+	default:
+	}
+	return false
+}
+
+// run sends the values received from the channel until it is closed.
+// If the values stop being wanted or cannot be sent, it receives and
+// discards the rest, so that whoever sends them does not block.
+func (s *sendStream) run() {
+	defer close(s.done)
+	window := streamWindow
+	for {
+		v, ok := s.ch.Recv()
+		if !ok {
+			break
+		}
+		for window == 0 && !s.stopped() {
+			select {
+			case n := <-s.credit:
+				window += n
+			case <-s.stopc:
+			}
+		}
+		if s.stopped() || s.write(StreamData, 0, v.Interface()) != nil {
+			s.discard()
+			return
+		}
+		window--
+	}
+	if !s.stopped() {
+		s.write(StreamEnd, 0, invalidRequest)
+	}
+}
+
+func (s *sendStream) discard() {
+	for {
+		if _, ok := s.ch.Recv(); !ok {
+			return
+		}
+	}
+}
+
+// A recvStream delivers the values of data messages to a channel, in
+// order, and grants the sender more room as they are taken.  It closes
+// the channel once the stream has ended and the values are delivered.
+type recvStream struct {
+	ch    reflect.Value             // channel to deliver the values to
+	grant func(window int) os.Error // sends a window message
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signals a change to queue or ended
+	queue   []reflect.Value
+	unacked int // values put that no window message has yet acknowledged
+	ended   bool
+	done    func() // run after the channel is closed, if not nil
+}
+
+// newRecvStream returns a stream delivering to ch, already running.
+func newRecvStream(ch reflect.Value, grant func(window int) os.Error) *recvStream {
+	r := &recvStream{ch: ch, grant: grant}
+	r.cond = sync.NewCond(&r.mu)
+	go r.run()
+	return r
+}
+
+// newValue returns a new value to decode the body of a data message
+// into: ptr points at it, and v is it as sent on the channel.
+func (r *recvStream) newValue() (ptr, v reflect.Value) {
+	elem := r.ch.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		ptr = reflect.New(elem.Elem())
+		return ptr, ptr
+	}
+	ptr = reflect.New(elem)
+	return ptr, ptr.Elem()
+}
+
+// put queues v for delivery.  It drops v and returns ErrStreamOverflow
+// if the sender has gone past the window.
+func (r *recvStream) put(v reflect.Value) os.Error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ended {
+		return nil
+	}
+	if r.unacked >= streamWindow {
+		return ErrStreamOverflow
+	}
+	r.unacked++
+	r.queue = append(r.queue, v)
+	r.cond.Signal()
+	return nil
+}
+
+// end ends the stream: no more values will be put, and done, if not
+// nil, is run once those queued have been delivered.
+func (r *recvStream) end(done func()) {
+	r.mu.Lock()
+	if !r.ended {
+		r.ended = true
+		r.done = done
+		r.cond.Signal()
+	}
+	r.mu.Unlock()
+}
+
+func (r *recvStream) run() {
+	taken := 0
+	for {
+		r.mu.Lock()
+		for len(r.queue) == 0 && !r.ended {
+			r.cond.Wait()
+		}
+		if len(r.queue) == 0 {
+			done := r.done
+			r.mu.Unlock()
+			r.ch.Close()
+			if done != nil {
+				done()
+			}
+			return
+		}
+		v := r.queue[0]
+		r.queue = r.queue[1:]
+		ended := r.ended
+		r.mu.Unlock()
+
+		r.ch.Send(v)
+		taken++
+		if taken >= streamWindow/2 && !ended {
+			// Free the room before granting it, or values sent
+			// on the strength of the grant could arrive first.
+			r.mu.Lock()
+			r.unacked -= taken
+			r.mu.Unlock()
+			// An error here will show up as an error reading.
+			r.grant(taken)
+			taken = 0
+		}
+	}
+}
+
+// drain receives and discards values from ch until it is closed.
+func drain(ch reflect.Value) {
+	for {
+		if _, ok := ch.Recv(); !ok {
+			return
+		}
+	}
+}
+
+// openStreams starts the streams of the call made by req, if its
+// arguments or reply are channels.  It must be done before the next
+// request is read, which may belong to the stream.
+func (server *Server) openStreams(req *Request, mtype *methodType, argv, replyv reflect.Value, sending *sync.Mutex, codec ServerCodec) (args *recvStream, reply *sendStream) {
+	serviceMethod, seq := req.ServiceMethod, req.Seq
+	write := func(kind, window int, body interface{}) os.Error {
+		return server.writeStream(sending, codec, serviceMethod, seq, kind, window, body)
+	}
+	if mtype.argStream {
+		args = newRecvStream(argv, func(n int) os.Error { return write(StreamWindow, n, invalidRequest) })
+	}
+	if mtype.replyStream {
+		reply = newSendStream(replyv, write)
+		reply.start()
+	}
+	return
+}
+
+// writeStream sends a stream message for the call numbered seq.
+func (server *Server) writeStream(sending *sync.Mutex, codec ServerCodec, serviceMethod string, seq uint64, kind, window int, body interface{}) os.Error {
+	resp := server.getResponse()
+	resp.ServiceMethod = serviceMethod
+	resp.Seq = seq
+	resp.Stream = kind
+	resp.Window = window
+	sending.Lock()
+	err := codec.WriteResponse(resp, body)
+	sending.Unlock()
+	server.freeResponse(resp)
+	return err
+}
+
+// deliver reads the body of the stream message req and passes
+// it on to the streams of the call it belongs to, if any.
+func (cs *callSet) deliver(codec ServerCodec, req *Request) os.Error {
+	cs.mu.Lock()
+	c := cs.calls[req.Seq]
+	cs.mu.Unlock()
+	if c != nil {
+		switch {
+		case req.Stream == StreamData && c.args != nil:
+			ptr, v := c.args.newValue()
+			if err := codec.ReadRequestBody(ptr.Interface()); err != nil {
+				return err
+			}
+			if err := c.args.put(v); err != nil {
+				// The client ignored the window; give up on the call.
+				c.cancel()
+				return err
+			}
+			return nil
+		case req.Stream == StreamEnd && c.args != nil:
+			c.args.end(nil)
+		case req.Stream == StreamWindow && c.reply != nil:
+			c.reply.grant(req.Window)
+		}
+	}
+	return codec.ReadRequestBody(nil)
+}
+
+// closeStreams ends the streams of a call once its method has returned:
+// any arguments still arriving are discarded, and the reply waits for
+// the values sent so far.
+func (c *ServerCall) closeStreams(argv, replyv reflect.Value) {
+	if c.args != nil {
+		c.args.end(nil)
+		go drain(argv)
+	}
+	if c.reply != nil {
+		replyv.Close()
+		<-c.reply.done
+	}
+}
+
+// writeStream sends a stream message for call.
+func (client *Client) writeStream(call *Call, kind, window int, body interface{}) os.Error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.request.Seq = call.seq
+	client.request.ServiceMethod = call.ServiceMethod
+	client.request.Timeout = 0
+	client.request.Stream = kind
+	client.request.Window = window
+	return client.codec.WriteRequest(&client.request, body)
+}
+
+// deliver reads the body of the stream message resp and passes
+// it on to the streams of the call it belongs to, if any.
+func (client *Client) deliver(resp *Response) os.Error {
+	client.mutex.Lock()
+	c := client.pending[resp.Seq]
+	client.mutex.Unlock()
+	if c != nil {
+		switch {
+		case resp.Stream == StreamData && c.reply != nil:
+			ptr, v := c.reply.newValue()
+			if err := client.codec.ReadResponseBody(ptr.Interface()); err != nil {
+				return err
+			}
+			if err := c.reply.put(v); err != nil {
+				// The server ignored the window; give up on the call.
+				client.abandon(c, err)
+			}
+			return nil
+		case resp.Stream == StreamWindow && c.args != nil:
+			c.args.grant(resp.Window)
+		}
+	}
+	return client.codec.ReadResponseBody(nil)
+}
+
+// openStreams prepares the streams of call, if its arguments or reply
+// are channels, or reports why they cannot be streamed.
+func (client *Client) openStreams(call *Call) os.Error {
+	if v := reflect.ValueOf(call.Args); v.Kind() == reflect.Chan {
+		if v.Type().ChanDir()&reflect.RecvDir == 0 {
+			return os.NewError("rpc: cannot receive arguments from " + v.Type().String())
+		}
+		call.args = newSendStream(v, func(kind, window int, body interface{}) os.Error {
+			return client.writeStream(call, kind, window, body)
+		})
+	}
+	if v := reflect.ValueOf(call.Reply); v.Kind() == reflect.Chan {
+		if v.Type().ChanDir()&reflect.SendDir == 0 {
+			return os.NewError("rpc: cannot send replies on " + v.Type().String())
+		}
+		call.reply = newRecvStream(v, func(n int) os.Error {
+			return client.writeStream(call, StreamWindow, n, invalidRequest)
+		})
+	}
+	return nil
+}
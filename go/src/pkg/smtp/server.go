@@ -0,0 +1,503 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package smtp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mail"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Envelope describes how a message was sent: who by and to whom.
+type Envelope struct {
+	From string   // reverse path given by MAIL; "" for the null path <>
+	To   []string // forward paths given by RCPT
+	User string   // user the client authenticated as; "" if it did not
+	TLS  bool     // whether the message came over TLS
+}
+
+// A Handler delivers the messages a Server receives.
+//
+// ServeSMTP is called once the whole message has been received.  If it
+// returns an error, the server rejects the message, sending the error's
+// text to the client; otherwise it accepts it.
+type Handler interface {
+	ServeSMTP(env *Envelope, msg *mail.Message) os.Error
+}
+
+// The HandlerFunc type is an adapter to allow the use of
+// ordinary functions as SMTP handlers.  If f is a function
+// with the appropriate signature, HandlerFunc(f) is a
+// Handler object that calls f.
+type HandlerFunc func(env *Envelope, msg *mail.Message) os.Error
+
+// ServeSMTP calls f(env, msg).
+func (f HandlerFunc) ServeSMTP(env *Envelope, msg *mail.Message) os.Error {
+	return f(env, msg)
+}
+
+// A Server defines parameters for running an SMTP server.
+// It supports the 8BITMIME, AUTH, SIZE and STARTTLS extensions.
+type Server struct {
+	Addr    string  // TCP address to listen on, ":smtp" if empty
+	Name    string  // host name to announce, the system's if empty
+	Handler Handler // handler to deliver messages to; if nil, they are discarded
+	MaxSize int64   // largest message accepted, in bytes; DefaultMaxSize if 0
+
+	// TLSConfig, if not nil, is the configuration of the TLS
+	// connections clients can start with STARTTLS.
+	TLSConfig *tls.Config
+
+	// Password, if not nil, enables AUTH and makes clients authenticate
+	// before sending mail.  It returns the password of the named user,
+	// and false if there is no such user.  The server offers CRAM-MD5,
+	// and PLAIN on TLS connections only.
+	Password func(user string) (password string, ok bool)
+}
+
+// DefaultMaxSize is the largest message a Server with no MaxSize accepts.
+// Messages are held in memory until they are delivered.
+const DefaultMaxSize = 10 << 20
+
+func (srv *Server) maxSize() int64 {
+	if srv.MaxSize > 0 {
+		return srv.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+// ListenAndServe listens on the TCP network address srv.Addr and then
+// calls Serve to handle connections.
+func (srv *Server) ListenAndServe() os.Error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":smtp"
+	}
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts incoming connections on the Listener l, serving each
+// in a new goroutine.
+func (srv *Server) Serve(l net.Listener) os.Error {
+	defer l.Close()
+	for {
+		rw, e := l.Accept()
+		if e != nil {
+			if ne, ok := e.(net.Error); ok && ne.Temporary() {
+				log.Printf("smtp: Accept error: %v", e)
+				continue
+			}
+			return e
+		}
+		go srv.ServeConn(rw)
+	}
+	panic("not reached")
+}
+
+// ServeConn serves SMTP on conn until the client quits.
+// It closes conn when done.
+func (srv *Server) ServeConn(conn net.Conn) {
+	name := srv.Name
+	if name == "" {
+		var err os.Error
+		if name, err = os.Hostname(); err != nil {
+			name = "localhost"
+		}
+	}
+	c := &serverConn{srv: srv, name: name, conn: conn, text: textproto.NewConn(conn)}
+	c.serve()
+	c.text.Close()
+}
+
+// A serverConn is the server side of an SMTP connection.
+type serverConn struct {
+	srv  *Server
+	name string
+	conn net.Conn
+	text *textproto.Conn
+	tls  bool   // whether STARTTLS has been done
+	helo string // name given by HELO or EHLO; "" if not yet greeted
+	user string // name of the authenticated user
+
+	// the mail transaction under way
+	inMail bool
+	from   string
+	to     []string
+}
+
+// Errors ending a connection.
+var (
+	errQuit   = os.NewError("smtp: client quit")
+	errNoData = os.NewError("smtp: connection closed during DATA")
+)
+
+func (c *serverConn) serve() {
+	if c.reply(220, c.name+" ESMTP ready") != nil {
+		return
+	}
+	for {
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if err = c.command(strings.ToUpper(verb), arg); err != nil {
+			if err != errQuit {
+				log.Printf("smtp: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// command carries out a command.  An error ends the connection.
+func (c *serverConn) command(verb, arg string) os.Error {
+	switch verb {
+	case "HELO", "EHLO":
+		if arg == "" {
+			return c.reply(501, "Domain name required")
+		}
+		c.helo = arg
+		c.reset()
+		if verb == "HELO" {
+			return c.reply(250, c.name)
+		}
+		return c.reply(250, strings.Join(c.extensions(), "\n"))
+	case "STARTTLS":
+		return c.startTLS(arg)
+	case "AUTH":
+		return c.auth(arg)
+	case "MAIL":
+		return c.mail(arg)
+	case "RCPT":
+		return c.rcpt(arg)
+	case "DATA":
+		return c.data(arg)
+	case "RSET":
+		c.reset()
+		return c.reply(250, "OK")
+	case "NOOP":
+		return c.reply(250, "OK")
+	case "VRFY":
+		return c.reply(252, "Cannot VRFY user, but will accept message and attempt delivery")
+	case "QUIT":
+		c.reply(221, c.name+" closing connection")
+		return errQuit
+	}
+	return c.reply(500, "Command not recognized")
+}
+
+// reply sends a reply with the given code.  Each line
+// of text becomes a line of the reply.
+func (c *serverConn) reply(code int, text string) os.Error {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if err := c.text.PrintfLine("%d%s%s", code, sep, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extensions returns the lines of the reply to EHLO.
+func (c *serverConn) extensions() []string {
+	ext := []string{c.name + " greets " + c.helo, "8BITMIME"}
+	ext = append(ext, "SIZE "+strconv.Itoa64(c.srv.maxSize()))
+	if c.srv.TLSConfig != nil && !c.tls {
+		ext = append(ext, "STARTTLS")
+	}
+	if c.srv.Password != nil {
+		if c.tls {
+			ext = append(ext, "AUTH CRAM-MD5 PLAIN")
+		} else {
+			ext = append(ext, "AUTH CRAM-MD5")
+		}
+	}
+	return ext
+}
+
+// reset aborts the mail transaction under way, if any.
+func (c *serverConn) reset() {
+	c.inMail = false
+	c.from = ""
+	c.to = nil
+}
+
+func (c *serverConn) startTLS(arg string) os.Error {
+	switch {
+	case c.srv.TLSConfig == nil:
+		return c.reply(502, "Command not implemented")
+	case c.tls:
+		return c.reply(503, "Already running TLS")
+	case arg != "":
+		return c.reply(501, "Syntax error (no parameters allowed)")
+	}
+	if err := c.reply(220, "Ready to start TLS"); err != nil {
+		return err
+	}
+	conn := tls.Server(c.conn, c.srv.TLSConfig)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	// Start over, as if the client had just connected.
+	c.conn = conn
+	c.text = textproto.NewConn(conn)
+	c.tls = true
+	c.helo = ""
+	c.user = ""
+	c.reset()
+	return nil
+}
+
+func (c *serverConn) auth(arg string) os.Error {
+	switch {
+	case c.srv.Password == nil:
+		return c.reply(502, "Command not implemented")
+	case c.helo == "":
+		return c.reply(503, "Send EHLO first")
+	case c.user != "":
+		return c.reply(503, "Already authenticated")
+	case c.inMail:
+		return c.reply(503, "Not allowed during a mail transaction")
+	}
+	mech, resp := arg, ""
+	if i := strings.Index(arg, " "); i >= 0 {
+		mech, resp = arg[:i], strings.TrimSpace(arg[i+1:])
+	}
+	switch strings.ToUpper(mech) {
+	case "PLAIN":
+		if !c.tls {
+			return c.reply(538, "Encryption required for requested authentication mechanism")
+		}
+		if resp == "" {
+			// No initial response: ask for it.
+			b, err := c.challenge(nil)
+			if err != nil || b == nil {
+				return err
+			}
+			resp = string(b)
+		} else if resp == "=" {
+			resp = ""
+		} else {
+			b, err := base64.StdEncoding.DecodeString(resp)
+			if err != nil {
+				return c.reply(501, "Cannot decode response")
+			}
+			resp = string(b)
+		}
+		// identity NUL user NUL password
+		f := strings.Split(resp, "\x00")
+		if len(f) != 3 {
+			return c.reply(501, "Malformed PLAIN response")
+		}
+		if f[0] != "" && f[0] != f[1] {
+			// Acting for someone else is not supported.
+			return c.reply(535, "Authentication credentials invalid")
+		}
+		return c.login(f[1], func(password string) bool { return secureEqual(password, f[2]) })
+	case "CRAM-MD5":
+		if resp != "" {
+			return c.reply(501, "CRAM-MD5 takes no initial response")
+		}
+		var nonce [8]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return err
+		}
+		challenge := []byte(fmt.Sprintf("<%x.%d@%s>", nonce[:], time.Nanoseconds(), c.name))
+		b, err := c.challenge(challenge)
+		if err != nil || b == nil {
+			return err
+		}
+		// user SP hex(HMAC-MD5(password, challenge))
+		i := bytes.LastIndex(b, []byte{' '})
+		if i < 0 {
+			return c.reply(501, "Malformed CRAM-MD5 response")
+		}
+		user, digest := string(b[:i]), string(b[i+1:])
+		return c.login(user, func(password string) bool {
+			h := hmac.NewMD5([]byte(password))
+			h.Write(challenge)
+			return secureEqual(fmt.Sprintf("%x", h.Sum()), digest)
+		})
+	}
+	return c.reply(504, "Unrecognized authentication type")
+}
+
+// challenge sends a 334 reply holding challenge and returns the decoded
+// response.  If the client cancels the exchange or the response cannot
+// be decoded, challenge replies to say so and returns nil.
+func (c *serverConn) challenge(challenge []byte) ([]byte, os.Error) {
+	if err := c.reply(334, base64.StdEncoding.EncodeToString(challenge)); err != nil {
+		return nil, err
+	}
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "*" {
+		return nil, c.reply(501, "Authentication canceled")
+	}
+	b, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, c.reply(501, "Cannot decode response")
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// secureEqual reports whether a and b are equal, in a time that depends
+// on their lengths only.
+func secureEqual(a, b string) bool {
+	return len(a) == len(b) && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// login authenticates the client as user if check
+// accepts the user's password.
+func (c *serverConn) login(user string, check func(password string) bool) os.Error {
+	password, ok := c.srv.Password(user)
+	if !ok || !check(password) {
+		return c.reply(535, "Authentication credentials invalid")
+	}
+	c.user = user
+	return c.reply(235, "Authentication successful")
+}
+
+// parsePath parses "<path> params" following the prefix of a MAIL or
+// RCPT command, returning the path and the parameters.
+func parsePath(prefix, arg string) (path string, params []string, ok bool) {
+	if len(arg) < len(prefix) || strings.ToUpper(arg[:len(prefix)]) != prefix {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	i := strings.Index(arg, ">")
+	if i < 0 {
+		return "", nil, false
+	}
+	return arg[1:i], strings.Fields(arg[i+1:]), true
+}
+
+func (c *serverConn) mail(arg string) os.Error {
+	switch {
+	case c.helo == "":
+		return c.reply(503, "Send HELO or EHLO first")
+	case c.inMail:
+		return c.reply(503, "Mail transaction already under way")
+	case c.srv.Password != nil && c.user == "":
+		return c.reply(530, "Authentication required")
+	}
+	from, params, ok := parsePath("FROM:", arg)
+	if !ok {
+		return c.reply(501, "Syntax: MAIL FROM:<address>")
+	}
+	for _, p := range params {
+		kv := strings.SplitN(strings.ToUpper(p), "=", 2)
+		if len(kv) != 2 {
+			return c.reply(555, "Unrecognized parameter "+p)
+		}
+		switch kv[0] {
+		case "SIZE":
+			size, err := strconv.Atoi64(kv[1])
+			if err != nil || size < 0 {
+				return c.reply(501, "Malformed SIZE")
+			}
+			if size > c.srv.maxSize() {
+				return c.reply(552, "Message size exceeds fixed maximum message size")
+			}
+		case "BODY":
+			if kv[1] != "7BIT" && kv[1] != "8BITMIME" {
+				return c.reply(501, "Unrecognized BODY type")
+			}
+		default:
+			return c.reply(555, "Unrecognized parameter "+p)
+		}
+	}
+	c.inMail = true
+	c.from = from
+	return c.reply(250, "OK")
+}
+
+func (c *serverConn) rcpt(arg string) os.Error {
+	if !c.inMail {
+		return c.reply(503, "Need MAIL before RCPT")
+	}
+	to, params, ok := parsePath("TO:", arg)
+	if !ok || to == "" {
+		return c.reply(501, "Syntax: RCPT TO:<address>")
+	}
+	if len(params) > 0 {
+		return c.reply(555, "Unrecognized parameter "+params[0])
+	}
+	c.to = append(c.to, to)
+	return c.reply(250, "OK")
+}
+
+func (c *serverConn) data(arg string) os.Error {
+	switch {
+	case arg != "":
+		return c.reply(501, "Syntax error (no parameters allowed)")
+	case len(c.to) == 0:
+		return c.reply(503, "Need RCPT before DATA")
+	}
+	if err := c.reply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+		return err
+	}
+	// The dot reader undoes the dot-stuffing.
+	r := c.text.DotReader()
+	var buf bytes.Buffer
+	max := c.srv.maxSize()
+	_, err := io.Copy(&buf, io.LimitReader(r, max+1))
+	if err == nil && int64(buf.Len()) > max {
+		if _, err = io.Copy(ioutil.Discard, r); err != nil {
+			return errNoData
+		}
+		c.reset()
+		return c.reply(552, "Message size exceeds fixed maximum message size")
+	}
+	if err != nil {
+		return errNoData
+	}
+	env := &Envelope{From: c.from, To: c.to, User: c.user, TLS: c.tls}
+	c.reset()
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		return c.reply(554, "Malformed message: "+err.String())
+	}
+	if c.srv.Handler != nil {
+		if err = c.srv.Handler.ServeSMTP(env, msg); err != nil {
+			return c.reply(554, err.String())
+		}
+	}
+	return c.reply(250, "OK")
+}
@@ -2,10 +2,12 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package smtp implements the Simple Mail Transfer Protocol as defined in RFC 5321.
+// Package smtp implements the Simple Mail Transfer Protocol as defined in RFC 5321,
+// both the client and the server side.
 // It also implements the following extensions:
 //	8BITMIME  RFC 1652
 //	AUTH      RFC 2554
+//	SIZE      RFC 1870 (Server only)
 //	STARTTLS  RFC 3207
 // Additional extensions may be handled by clients.
 package smtp
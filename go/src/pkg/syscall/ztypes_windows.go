@@ -484,6 +484,21 @@ type DNSMXData struct {
 	Pad          uint16
 }
 
+type DNSSOAData struct {
+	NamePrimaryServer *uint16
+	NameAdministrator *uint16
+	SerialNo          uint32
+	Refresh           uint32
+	Retry             uint32
+	Expire            uint32
+	DefaultTtl        uint32
+}
+
+type DNSTXTData struct {
+	StringCount uint32
+	StringArray [1]*uint16
+}
+
 type DNSRecord struct {
 	Next     *DNSRecord
 	Name     *uint16
@@ -74,7 +74,8 @@ func newClient(resourceName, host, origin, location, protocol string, rwc io.Rea
 }
 
 /*
-Dial opens a new client connection to a Web Socket.
+Dial opens a new client connection to a Web Socket, using the protocol
+of RFC 6455.
 
 A trivial example client:
 
@@ -122,7 +123,7 @@ func Dial(url_, protocol, origin string) (ws *Conn, err os.Error) {
 		goto Error
 	}
 
-	ws, err = newClient(parsedUrl.RawPath, parsedUrl.Host, origin, url_, protocol, client, handshake)
+	ws, err = newHybiClient(parsedUrl.RawPath, parsedUrl.Host, origin, url_, protocol, client)
 	if err != nil {
 		goto Error
 	}
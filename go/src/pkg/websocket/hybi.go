@@ -0,0 +1,644 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+// The WebSocket protocol of RFC 6455.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"http"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"utf8"
+)
+
+// Frame types (opcodes) of RFC 6455, section 5.2.
+const (
+	ContinuationFrame = 0
+	TextFrame         = 1
+	BinaryFrame       = 2
+	CloseFrame        = 8
+	PingFrame         = 9
+	PongFrame         = 10
+)
+
+// Status codes sent in close frames, RFC 6455 section 7.4.1.
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005 // never sent; no code was received
+	CloseAbnormal           = 1006 // never sent; connection closed without a close frame
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+// ProtocolVersion is the value of Sec-WebSocket-Version that Dial
+// sends and Handler accepts.
+const ProtocolVersion = 13
+
+// websocketGUID is appended to Sec-WebSocket-Key to compute
+// Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	maxControlPayload = 125
+	maxReadChunk      = 64 << 10
+)
+
+var (
+	ErrBadAccept        = &ProtocolError{"missing or bad Sec-WebSocket-Accept"}
+	ErrBadFrame         = &ProtocolError{"bad frame"}
+	ErrBadMask          = &ProtocolError{"bad frame masking"}
+	ErrBadFrameType     = &ProtocolError{"bad frame type"}
+	ErrBadUTF8          = &ProtocolError{"invalid UTF-8 in text message"}
+	ErrMessageTooBig    = &ProtocolError{"message too big"}
+	ErrCloseSent        = &ProtocolError{"close frame already sent"}
+	ErrNotSupported     = &ProtocolError{"not supported by the negotiated protocol"}
+	ErrControlTooLong   = &ProtocolError{"control frame payload too long"}
+	ErrBadClosePayload  = &ProtocolError{"bad close frame payload"}
+	ErrUnexpectedFrames = &ProtocolError{"unexpected continuation or data frame"}
+)
+
+// CloseError is returned by ReadMessage once the peer has sent a
+// close frame. Read returns os.EOF instead.
+type CloseError struct {
+	Code   int // CloseNoStatus if the frame had no status code
+	Reason string
+}
+
+func (e *CloseError) String() string {
+	s := "websocket: closed with status " + strconv.Itoa(e.Code)
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// hybiState is the framing state of an RFC 6455 connection.
+type hybiState struct {
+	client bool // mask outgoing frames; expect unmasked ones
+
+	wmu       sync.Mutex // serializes frame writes
+	closeSent bool       // guarded by wmu
+
+	// Reader state, used by one reader at a time.
+	remaining     int64 // unread payload of the current data frame
+	final         bool  // current data frame ends its message
+	msgType       int   // type of the message being read
+	masked        bool
+	mask          [4]byte
+	maskPos       int
+	closeReceived *CloseError
+	rerr          os.Error // sticky read error
+}
+
+func newHybiConn(origin, location, protocol string, buf *bufio.ReadWriter, rwc io.ReadWriteCloser, client bool) *Conn {
+	ws := newConn(origin, location, protocol, buf, rwc)
+	ws.hybi = &hybiState{client: client, final: true}
+	return ws
+}
+
+// computeAcceptKey returns the Sec-WebSocket-Accept value for key.
+func computeAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum())
+}
+
+// generateNonce returns a new Sec-WebSocket-Key value.
+func generateNonce() (string, os.Error) {
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// headerContains reports whether the comma-separated header value v
+// contains the lower-case token, ignoring case.
+func headerContains(v, token string) bool {
+	for _, s := range strings.Split(v, ",") {
+		if strings.ToLower(strings.TrimSpace(s)) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseProtocol returns the first of protocols that is listed in the
+// comma-separated header values offered, or "" if there is none.
+func chooseProtocol(protocols, offered []string) string {
+	for _, p := range protocols {
+		for _, v := range offered {
+			for _, o := range strings.Split(v, ",") {
+				if strings.TrimSpace(o) == p {
+					return p
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// hybiClientHandshake performs the client side of the RFC 6455
+// opening handshake, section 4.1.
+func hybiClientHandshake(resourceName, host, origin, location, protocol string, br *bufio.Reader, bw *bufio.Writer) (err os.Error) {
+	key, err := generateNonce()
+	if err != nil {
+		return err
+	}
+	bw.WriteString("GET " + resourceName + " HTTP/1.1\r\n")
+	bw.WriteString("Host: " + host + "\r\n")
+	bw.WriteString("Upgrade: websocket\r\n")
+	bw.WriteString("Connection: Upgrade\r\n")
+	bw.WriteString("Sec-WebSocket-Key: " + key + "\r\n")
+	bw.WriteString("Sec-WebSocket-Version: " + strconv.Itoa(ProtocolVersion) + "\r\n")
+	if origin != "" {
+		bw.WriteString("Origin: " + origin + "\r\n")
+	}
+	if protocol != "" {
+		bw.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	bw.WriteString("\r\n")
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return ErrBadStatus
+	}
+	if strings.ToLower(resp.Header.Get("Upgrade")) != "websocket" ||
+		!headerContains(resp.Header.Get("Connection"), "upgrade") {
+		return ErrBadUpgrade
+	}
+	if resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(key) {
+		return ErrBadAccept
+	}
+	if p := resp.Header.Get("Sec-Websocket-Protocol"); p != "" && p != protocol {
+		return ErrBadWebSocketProtocol
+	}
+	return nil
+}
+
+// newHybiClient performs the RFC 6455 handshake on rwc and returns
+// the client end of the connection.
+func newHybiClient(resourceName, host, origin, location, protocol string, rwc io.ReadWriteCloser) (ws *Conn, err os.Error) {
+	br := bufio.NewReader(rwc)
+	bw := bufio.NewWriter(rwc)
+	err = hybiClientHandshake(resourceName, host, origin, location, protocol, br, bw)
+	if err != nil {
+		return
+	}
+	return newHybiConn(origin, location, protocol, bufio.NewReadWriter(br, bw), rwc, true), nil
+}
+
+// hybiServerHandshake answers an RFC 6455 opening handshake,
+// section 4.2, choosing the subprotocol from protocols. It returns
+// the negotiated subprotocol, or ok == false if it rejected the
+// request.
+func hybiServerHandshake(req *http.Request, buf *bufio.ReadWriter, protocols []string) (protocol string, ok bool) {
+	reject := func(status string, extra string) {
+		buf.WriteString("HTTP/1.1 " + status + "\r\n" + extra + "\r\n")
+		buf.Flush()
+	}
+	if req.Method != "GET" ||
+		strings.ToLower(req.Header.Get("Upgrade")) != "websocket" ||
+		!headerContains(req.Header.Get("Connection"), "upgrade") {
+		reject("400 Bad Request", "")
+		return "", false
+	}
+	if req.Header.Get("Sec-Websocket-Version") != strconv.Itoa(ProtocolVersion) {
+		reject("426 Upgrade Required", "Sec-WebSocket-Version: "+strconv.Itoa(ProtocolVersion)+"\r\n")
+		return "", false
+	}
+	key := strings.TrimSpace(req.Header.Get("Sec-Websocket-Key"))
+	if key == "" {
+		reject("400 Bad Request", "")
+		return "", false
+	}
+	protocol = chooseProtocol(protocols, req.Header["Sec-Websocket-Protocol"])
+
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	buf.WriteString("Upgrade: websocket\r\n")
+	buf.WriteString("Connection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + computeAcceptKey(key) + "\r\n")
+	if protocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	return protocol, buf.Flush() == nil
+}
+
+// writeFrame sends one frame, masking it if ws is a client.
+func (ws *Conn) writeFrame(opcode int, fin bool, payload []byte) os.Error {
+	h := ws.hybi
+	h.wmu.Lock()
+	defer h.wmu.Unlock()
+	if h.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseFrame {
+		h.closeSent = true
+	}
+
+	var hdr [14]byte
+	hdr[0] = byte(opcode)
+	if fin {
+		hdr[0] |= 0x80
+	}
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n += 2
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n += 8
+	}
+	if h.client {
+		hdr[1] |= 0x80
+		mask := hdr[n : n+4]
+		if _, err := io.ReadFull(rand.Reader, mask); err != nil {
+			return err
+		}
+		n += 4
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	ws.buf.Write(hdr[:n])
+	ws.buf.Write(payload)
+	return ws.buf.Flush()
+}
+
+// readPayload reads exactly len(p) payload bytes of the current frame
+// and unmasks them.
+func (ws *Conn) readPayload(p []byte) (int, os.Error) {
+	h := ws.hybi
+	n, err := io.ReadFull(ws.buf, p)
+	if h.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= h.mask[h.maskPos]
+			h.maskPos = (h.maskPos + 1) % 4
+		}
+	}
+	if err == os.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// fail sends a close frame with the given code and makes err the
+// result of every later read.
+func (ws *Conn) fail(code int, err os.Error) os.Error {
+	ws.WriteClose(code, "")
+	ws.hybi.rerr = err
+	return err
+}
+
+// nextFrame reads frames until the header of the next data frame,
+// answering control frames on the way.
+func (ws *Conn) nextFrame() os.Error {
+	h := ws.hybi
+	for {
+		if h.rerr != nil {
+			return h.rerr
+		}
+		if h.closeReceived != nil {
+			return h.closeReceived
+		}
+		var b [8]byte
+		if _, err := io.ReadFull(ws.buf, b[:2]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = os.EOF
+			}
+			return err
+		}
+		fin, opcode := b[0]&0x80 != 0, int(b[0]&0x0f)
+		if b[0]&0x70 != 0 {
+			// No extensions are negotiated, so RSV bits must be clear.
+			return ws.fail(CloseProtocolError, ErrBadFrame)
+		}
+		h.masked = b[1]&0x80 != 0
+		if h.masked == h.client {
+			return ws.fail(CloseProtocolError, ErrBadMask)
+		}
+		length := int64(b[1] & 0x7f)
+		switch length {
+		case 126:
+			if _, err := io.ReadFull(ws.buf, b[:2]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(b[:2]))
+		case 127:
+			if _, err := io.ReadFull(ws.buf, b[:8]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(b[:8]))
+			if length < 0 {
+				return ws.fail(CloseProtocolError, ErrBadFrame)
+			}
+		}
+		if h.masked {
+			if _, err := io.ReadFull(ws.buf, h.mask[:]); err != nil {
+				return err
+			}
+		}
+		h.maskPos = 0
+
+		switch opcode {
+		case CloseFrame, PingFrame, PongFrame:
+			if length > maxControlPayload || !fin {
+				return ws.fail(CloseProtocolError, ErrControlTooLong)
+			}
+			payload := make([]byte, length)
+			if _, err := ws.readPayload(payload); err != nil {
+				return err
+			}
+			if err := ws.handleControl(opcode, payload); err != nil {
+				return err
+			}
+			continue
+		case ContinuationFrame:
+			if h.final {
+				return ws.fail(CloseProtocolError, ErrUnexpectedFrames)
+			}
+		case TextFrame, BinaryFrame:
+			if !h.final {
+				return ws.fail(CloseProtocolError, ErrUnexpectedFrames)
+			}
+			h.msgType = opcode
+		default:
+			return ws.fail(CloseProtocolError, ErrBadFrameType)
+		}
+		h.final = fin
+		h.remaining = length
+		return nil
+	}
+	panic("unreachable")
+}
+
+func (ws *Conn) handleControl(opcode int, payload []byte) os.Error {
+	h := ws.hybi
+	switch opcode {
+	case PingFrame:
+		if err := ws.writeFrame(PongFrame, true, payload); err != nil && err != ErrCloseSent {
+			return err
+		}
+	case PongFrame:
+		if ws.PongHandler != nil {
+			ws.PongHandler(payload)
+		}
+	case CloseFrame:
+		ce := &CloseError{Code: CloseNoStatus}
+		switch {
+		case len(payload) == 1:
+			return ws.fail(CloseProtocolError, ErrBadClosePayload)
+		case len(payload) >= 2:
+			ce.Code = int(binary.BigEndian.Uint16(payload))
+			ce.Reason = string(payload[2:])
+			if !validCloseCode(ce.Code) || !validUTF8(payload[2:]) {
+				return ws.fail(CloseProtocolError, ErrBadClosePayload)
+			}
+		}
+		h.closeReceived = ce
+		// Echo the status code, as section 5.5.1 asks.
+		code := ce.Code
+		if code == CloseNoStatus {
+			code = 0
+		}
+		ws.WriteClose(code, "")
+		return ce
+	}
+	return nil
+}
+
+// validCloseCode reports whether a close frame may carry code, RFC 6455
+// section 7.4: the codes defined there or registered since, other than
+// those that are never sent, and the codes for libraries and
+// applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= CloseNormal && code <= CloseUnsupportedData:
+		return true
+	case code >= CloseInvalidPayload && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+func validUTF8(p []byte) bool {
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		p = p[size:]
+	}
+	return true
+}
+
+// hybiRead implements Read for RFC 6455 connections.
+func (ws *Conn) hybiRead(msg []byte) (n int, err os.Error) {
+	h := ws.hybi
+	for h.remaining == 0 {
+		if err = ws.nextFrame(); err != nil {
+			if _, ok := err.(*CloseError); ok {
+				err = os.EOF
+			}
+			return 0, err
+		}
+	}
+	if int64(len(msg)) > h.remaining {
+		msg = msg[:h.remaining]
+	}
+	n, err = ws.readPayload(msg)
+	h.remaining -= int64(n)
+	return n, err
+}
+
+// ReadMessage reads the next message, or the rest of the current
+// one if Read consumed only part of it. Fragmented messages are
+// reassembled; ping frames received on the way are answered. Once
+// the peer has sent a close frame ReadMessage returns a *CloseError.
+//
+// On connections using the older draft protocols only text messages
+// are supported.
+func (ws *Conn) ReadMessage() (msgType int, data []byte, err os.Error) {
+	h := ws.hybi
+	if h == nil {
+		data, err = ws.readDraftMessage()
+		return TextFrame, data, err
+	}
+	inMessage := h.remaining > 0 || !h.final
+	for {
+		if h.remaining == 0 {
+			if h.final && inMessage {
+				break
+			}
+			if err = ws.nextFrame(); err != nil {
+				return 0, nil, err
+			}
+			inMessage = true
+			continue
+		}
+		if ws.MaxMessageSize > 0 && int64(len(data))+h.remaining > int64(ws.MaxMessageSize) {
+			return 0, nil, ws.fail(CloseMessageTooBig, ErrMessageTooBig)
+		}
+		// Grow data in chunks rather than trusting the frame length.
+		chunk := h.remaining
+		if chunk > maxReadChunk {
+			chunk = maxReadChunk
+		}
+		start := len(data)
+		data = append(data, make([]byte, chunk)...)
+		n, err := ws.readPayload(data[start:])
+		h.remaining -= int64(n)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if h.msgType == TextFrame && !validUTF8(data) {
+		return 0, nil, ws.fail(CloseInvalidPayload, ErrBadUTF8)
+	}
+	return h.msgType, data, nil
+}
+
+// readDraftMessage reads one text frame of the draft protocols.
+func (ws *Conn) readDraftMessage() ([]byte, os.Error) {
+	var msg []byte
+	buf := make([]byte, 512)
+	for {
+		n, err := ws.Read(buf)
+		msg = append(msg, buf[:n]...)
+		if err != nil {
+			return nil, err
+		}
+		if !ws.reading && len(ws.data) == 0 {
+			return msg, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// WriteMessage sends data as a single, unfragmented message of the
+// given type, TextFrame or BinaryFrame, or as a PingFrame or
+// PongFrame control frame.
+//
+// On connections using the older draft protocols only text messages
+// are supported.
+func (ws *Conn) WriteMessage(msgType int, data []byte) os.Error {
+	if ws.hybi == nil {
+		if msgType != TextFrame {
+			return ErrNotSupported
+		}
+		_, err := ws.Write(data)
+		return err
+	}
+	switch msgType {
+	case TextFrame, BinaryFrame:
+	case PingFrame, PongFrame:
+		if len(data) > maxControlPayload {
+			return ErrControlTooLong
+		}
+	default:
+		return ErrBadFrameType
+	}
+	return ws.writeFrame(msgType, true, data)
+}
+
+// Ping sends a ping frame carrying appData, at most 125 bytes. The
+// peer's pong is passed to PongHandler.
+func (ws *Conn) Ping(appData []byte) os.Error {
+	return ws.WriteMessage(PingFrame, appData)
+}
+
+// WriteClose starts the closing handshake by sending a close frame
+// with the given status code and reason; a code of 0 sends no
+// status. Nothing may be written after it. The peer answers with a
+// close frame of its own, after which reads return os.EOF or a
+// *CloseError.
+func (ws *Conn) WriteClose(code int, reason string) os.Error {
+	if ws.hybi == nil {
+		return ErrNotSupported
+	}
+	var payload []byte
+	if code != 0 {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlPayload {
+			return ErrControlTooLong
+		}
+	}
+	return ws.writeFrame(CloseFrame, true, payload)
+}
+
+// NewMessageWriter returns a writer that sends a single message of
+// the given type, TextFrame or BinaryFrame, as a sequence of
+// fragments: each call to Write sends one frame, and Close ends the
+// message. No other message may be written until the writer is
+// closed.
+func (ws *Conn) NewMessageWriter(msgType int) (io.WriteCloser, os.Error) {
+	if ws.hybi == nil {
+		return nil, ErrNotSupported
+	}
+	if msgType != TextFrame && msgType != BinaryFrame {
+		return nil, ErrBadFrameType
+	}
+	return &messageWriter{ws: ws, opcode: msgType}, nil
+}
+
+type messageWriter struct {
+	ws     *Conn
+	opcode int // ContinuationFrame after the first frame
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, os.Error) {
+	if w.closed {
+		return 0, os.EINVAL
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.ws.writeFrame(w.opcode, false, p); err != nil {
+		return 0, err
+	}
+	w.opcode = ContinuationFrame
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() os.Error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.ws.writeFrame(w.opcode, true, nil)
+}
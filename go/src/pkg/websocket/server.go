@@ -13,6 +13,12 @@ import (
 /*
 Handler is an interface to a WebSocket.
 
+It speaks the protocol of RFC 6455 to clients that send
+Sec-WebSocket-Version: 13, and draft-hixie-thewebsocketprotocol-76
+to clients that send Sec-WebSocket-Key1 and Sec-WebSocket-Key2.
+It chooses none of the subprotocols an RFC 6455 client offers;
+a Server does.
+
 A trivial example server:
 
 	package main
@@ -38,6 +44,25 @@ A trivial example server:
 */
 type Handler func(*Conn)
 
+// A Server is like Handler, but speaks the subprotocols in Protocols.
+type Server struct {
+	// Protocols lists the subprotocols the server speaks, most
+	// preferred first.  Of those an RFC 6455 client offers, the
+	// first in this list is chosen; if it offers none of them, the
+	// connection has no subprotocol.  A draft-hixie-76 client that
+	// asks for a subprotocol not in the list is refused.
+	Protocols []string
+
+	// Handler is called with each connection, whose Protocol field
+	// holds the chosen subprotocol.
+	Handler Handler
+}
+
+// ServeHTTP implements the http.Handler interface for a Web Socket.
+func (s Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.Handler.serve(w, req, s.Protocols)
+}
+
 /*
 Gets key number from Sec-WebSocket-Key<n>: field as described
 in 5.2 Sending the server's opening handshake, 4.
@@ -56,8 +81,23 @@ func getKeyNumber(s string) (r uint32) {
 	return
 }
 
+// webSocketLocation returns the WebSocket URL req was sent to.
+func webSocketLocation(req *http.Request) string {
+	if req.TLS != nil {
+		return "wss://" + req.Host + req.URL.RawPath
+	}
+	return "ws://" + req.Host + req.URL.RawPath
+}
+
 // ServeHTTP implements the http.Handler interface for a Web Socket
 func (f Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.serve(w, req, nil)
+}
+
+// serve answers the WebSocket handshake in req and calls f with the
+// connection.  Unless protocols is nil, the subprotocol is chosen
+// from it.
+func (f Handler) serve(w http.ResponseWriter, req *http.Request, protocols []string) {
 	rwc, buf, err := w.(http.Hijacker).Hijack()
 	if err != nil {
 		panic("Hijack failed: " + err.String())
@@ -68,6 +108,17 @@ func (f Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// specification.
 	defer rwc.Close()
 
+	if req.Header.Get("Sec-Websocket-Version") != "" {
+		protocol, ok := hybiServerHandshake(req, buf, protocols)
+		if !ok {
+			return
+		}
+		ws := newHybiConn(req.Header.Get("Origin"), webSocketLocation(req), protocol, buf, rwc, false)
+		ws.Request = req
+		f(ws)
+		return
+	}
+
 	if req.Method != "GET" {
 		return
 	}
@@ -84,6 +135,12 @@ func (f Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The draft has no way to decline a subprotocol.
+	protocol := strings.TrimSpace(req.Header.Get("Sec-Websocket-Protocol"))
+	if protocol != "" && protocols != nil && chooseProtocol(protocols, []string{protocol}) == "" {
+		return
+	}
+
 	key1 := req.Header.Get("Sec-Websocket-Key1")
 	if key1 == "" {
 		return
@@ -97,12 +154,7 @@ func (f Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var location string
-	if req.TLS != nil {
-		location = "wss://" + req.Host + req.URL.RawPath
-	} else {
-		location = "ws://" + req.Host + req.URL.RawPath
-	}
+	location := webSocketLocation(req)
 
 	// Step 4. get key number in Sec-WebSocket-Key<n> fields.
 	keyNumber1 := getKeyNumber(key1)
@@ -138,7 +190,6 @@ func (f Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	buf.WriteString("Connection: Upgrade\r\n")
 	buf.WriteString("Sec-WebSocket-Location: " + location + "\r\n")
 	buf.WriteString("Sec-WebSocket-Origin: " + origin + "\r\n")
-	protocol := strings.TrimSpace(req.Header.Get("Sec-Websocket-Protocol"))
 	if protocol != "" {
 		buf.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
 	}
@@ -191,12 +242,7 @@ func (f Draft75Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	defer rwc.Close()
 
-	var location string
-	if req.TLS != nil {
-		location = "wss://" + req.Host + req.URL.RawPath
-	} else {
-		location = "ws://" + req.Host + req.URL.RawPath
-	}
+	location := webSocketLocation(req)
 
 	// TODO(ukai): verify origin,location,protocol.
 
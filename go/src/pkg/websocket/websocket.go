@@ -2,8 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package websocket implements a client and server for the Web Socket protocol.
-// The protocol is defined at http://tools.ietf.org/html/draft-hixie-thewebsocketprotocol
+// Package websocket implements a client and server for the Web Socket protocol
+// as specified in RFC 6455. For older clients the server also accepts
+// the earlier protocol drafts defined at
+// http://tools.ietf.org/html/draft-hixie-thewebsocketprotocol
 package websocket
 
 // TODO(ukai):
@@ -47,12 +49,24 @@ type Conn struct {
 	// The initial http Request (for the Server side only).
 	Request *http.Request
 
+	// PayloadType is the type of the messages Write sends on an
+	// RFC 6455 connection: TextFrame, the default, or BinaryFrame.
+	PayloadType int
+	// PongHandler, if not nil, is called by the reading goroutine
+	// with the application data of each pong frame received.
+	PongHandler func(appData []byte)
+	// MaxMessageSize, if positive, limits the size of the messages
+	// ReadMessage accepts; larger ones fail the connection.
+	MaxMessageSize int
+
 	buf *bufio.ReadWriter
 	rwc io.ReadWriteCloser
 
 	// It holds text data in previous Read() that failed with small buffer.
 	data    []byte
 	reading bool
+
+	hybi *hybiState // framing state if the connection uses RFC 6455
 }
 
 // newConn creates a new Web Socket.
@@ -67,7 +81,13 @@ func newConn(origin, location, protocol string, buf *bufio.ReadWriter, rwc io.Re
 }
 
 // Read implements the io.Reader interface for a Conn.
+// On an RFC 6455 connection it reads the payload of the data frames
+// received without regard to message boundaries, answering pings on
+// the way, and returns os.EOF once the peer has sent a close frame.
 func (ws *Conn) Read(msg []byte) (n int, err os.Error) {
+	if ws.hybi != nil {
+		return ws.hybiRead(msg)
+	}
 Frame:
 	for !ws.reading && len(ws.data) == 0 {
 		// Beginning of frame, possibly.
@@ -125,7 +145,19 @@ Frame:
 }
 
 // Write implements the io.Writer interface for a Conn.
+// On an RFC 6455 connection each Write sends one message of type
+// PayloadType.
 func (ws *Conn) Write(msg []byte) (n int, err os.Error) {
+	if ws.hybi != nil {
+		typ := ws.PayloadType
+		if typ == 0 {
+			typ = TextFrame
+		}
+		if err = ws.WriteMessage(typ, msg); err != nil {
+			return 0, err
+		}
+		return len(msg), nil
+	}
 	ws.buf.WriteByte(0)
 	ws.buf.Write(msg)
 	ws.buf.WriteByte(0xff)
@@ -134,7 +166,15 @@ func (ws *Conn) Write(msg []byte) (n int, err os.Error) {
 }
 
 // Close implements the io.Closer interface for a Conn.
-func (ws *Conn) Close() os.Error { return ws.rwc.Close() }
+// On an RFC 6455 connection it first sends a close frame with status
+// CloseNormal unless WriteClose has been called; it does not wait for
+// the peer's reply.
+func (ws *Conn) Close() os.Error {
+	if ws.hybi != nil {
+		ws.WriteClose(CloseNormal, "")
+	}
+	return ws.rwc.Close()
+}
 
 // LocalAddr returns the WebSocket Origin for the connection.
 func (ws *Conn) LocalAddr() net.Addr { return WebSocketAddr(ws.Origin) }
@@ -23,9 +23,25 @@ var once sync.Once
 
 func echoServer(ws *Conn) { io.Copy(ws, ws) }
 
+// echoMessageServer echoes each message with its type until the
+// connection is closed.
+func echoMessageServer(ws *Conn) {
+	for {
+		typ, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := ws.WriteMessage(typ, msg); err != nil {
+			return
+		}
+	}
+}
+
 func startServer() {
 	http.Handle("/echo", Handler(echoServer))
 	http.Handle("/echoDraft75", Draft75Handler(echoServer))
+	http.Handle("/echoMessages", Handler(echoMessageServer))
+	http.Handle("/chat", Server{Protocols: []string{"chat", "superchat"}, Handler: echoServer})
 	server := httptest.NewServer(nil)
 	serverAddr = server.Listener.Addr().String()
 	log.Print("Test WebSocket server listening on ", serverAddr)
@@ -269,3 +285,190 @@ func testSkipNoUTF8Frame(t *testing.T) {
 		t.Errorf("Read: expected %q got %q", msg[4:8], msg[0:n])
 	}
 }
+
+// Test computeAcceptKey with the example of RFC 6455 section 1.3.
+func TestAcceptKey(t *testing.T) {
+	if g, e := computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; g != e {
+		t.Errorf("computeAcceptKey = %q; want %q", g, e)
+	}
+}
+
+func TestHybiEcho(t *testing.T) {
+	once.Do(startServer)
+
+	ws, err := Dial(fmt.Sprintf("ws://%s/echo", serverAddr), "", "http://localhost/")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer ws.Close()
+	msg := []byte("hello, world\n")
+	if _, err := ws.Write(msg); err != nil {
+		t.Fatal("Write:", err)
+	}
+	actual := make([]byte, 512)
+	n, err := ws.Read(actual)
+	if err != nil {
+		t.Fatal("Read:", err)
+	}
+	if !bytes.Equal(msg, actual[:n]) {
+		t.Errorf("Echo: expected %q got %q", msg, actual[:n])
+	}
+}
+
+func TestHybiMessages(t *testing.T) {
+	once.Do(startServer)
+
+	ws, err := Dial(fmt.Sprintf("ws://%s/echoMessages", serverAddr), "", "http://localhost/")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer ws.Close()
+	var pong string
+	ws.PongHandler = func(appData []byte) { pong = string(appData) }
+
+	w, err := ws.NewMessageWriter(BinaryFrame)
+	if err != nil {
+		t.Fatal("NewMessageWriter:", err)
+	}
+	w.Write([]byte("frag"))
+	if err := ws.Ping([]byte("ping data")); err != nil {
+		t.Fatal("Ping:", err)
+	}
+	w.Write([]byte("mented"))
+	if err := w.Close(); err != nil {
+		t.Fatal("closing message writer:", err)
+	}
+	typ, msg, err := ws.ReadMessage()
+	if err != nil || typ != BinaryFrame || string(msg) != "fragmented" {
+		t.Errorf("ReadMessage = %d, %q, %v; want %d, %q, nil", typ, msg, err, BinaryFrame, "fragmented")
+	}
+	if pong != "ping data" {
+		t.Errorf("pong data = %q; want %q", pong, "ping data")
+	}
+
+	if err := ws.WriteMessage(TextFrame, []byte("héllo")); err != nil {
+		t.Fatal("WriteMessage:", err)
+	}
+	typ, msg, err = ws.ReadMessage()
+	if err != nil || typ != TextFrame || string(msg) != "héllo" {
+		t.Errorf("ReadMessage = %d, %q, %v; want %d, %q, nil", typ, msg, err, TextFrame, "héllo")
+	}
+
+	if err := ws.WriteClose(CloseGoingAway, "bye"); err != nil {
+		t.Fatal("WriteClose:", err)
+	}
+	if _, err := ws.Write([]byte("late")); err != ErrCloseSent {
+		t.Errorf("Write after WriteClose: error %v; want ErrCloseSent", err)
+	}
+	_, _, err = ws.ReadMessage()
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseGoingAway {
+		t.Errorf("ReadMessage after close: error %v; want close with status %d", err, CloseGoingAway)
+	}
+}
+
+func TestHybiInvalidUTF8(t *testing.T) {
+	once.Do(startServer)
+
+	ws, err := Dial(fmt.Sprintf("ws://%s/echoMessages", serverAddr), "", "http://localhost/")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(TextFrame, []byte{'a', 0xff}); err != nil {
+		t.Fatal("WriteMessage:", err)
+	}
+	_, _, err = ws.ReadMessage()
+	if ce, ok := err.(*CloseError); !ok || ce.Code != CloseInvalidPayload {
+		t.Errorf("ReadMessage: error %v; want close with status %d", err, CloseInvalidPayload)
+	}
+}
+
+func TestHybiBadVersion(t *testing.T) {
+	once.Do(startServer)
+
+	c, err := net.Dial("tcp", serverAddr)
+	if err != nil {
+		t.Fatal("dialing", err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 8\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatal("ReadResponse:", err)
+	}
+	if resp.StatusCode != 426 || resp.Header.Get("Sec-Websocket-Version") != "13" {
+		t.Errorf("response %d with version %q; want 426 with version 13",
+			resp.StatusCode, resp.Header.Get("Sec-Websocket-Version"))
+	}
+}
+
+func TestHybiProtocol(t *testing.T) {
+	once.Do(startServer)
+
+	tests := []struct {
+		path, offered, want string
+	}{
+		{"/chat", "superchat, chat", "chat"},
+		{"/chat", "foo,superchat", "superchat"},
+		{"/chat", "foo", ""},
+		{"/echo", "chat", ""},
+	}
+	for _, tt := range tests {
+		c, err := net.Dial("tcp", serverAddr)
+		if err != nil {
+			t.Fatal("dialing", err)
+		}
+		io.WriteString(c, "GET "+tt.path+" HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"+
+			"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: "+tt.offered+"\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(c), &http.Request{Method: "GET"})
+		c.Close()
+		if err != nil {
+			t.Fatal("ReadResponse:", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("%s offered %q: status %d; want 101", tt.path, tt.offered, resp.StatusCode)
+		}
+		if p := resp.Header.Get("Sec-Websocket-Protocol"); p != tt.want {
+			t.Errorf("%s offered %q: protocol %q; want %q", tt.path, tt.offered, p, tt.want)
+		}
+	}
+}
+
+func TestHybiCloseCodes(t *testing.T) {
+	once.Do(startServer)
+
+	tests := []struct {
+		code, echo int
+	}{
+		{999, CloseProtocolError},
+		{CloseNormal, CloseNormal},
+		{CloseUnsupportedData, CloseUnsupportedData},
+		{1004, CloseProtocolError},
+		{CloseNoStatus, CloseProtocolError},
+		{CloseAbnormal, CloseProtocolError},
+		{CloseInvalidPayload, CloseInvalidPayload},
+		{1014, 1014},
+		{1015, CloseProtocolError},
+		{2999, CloseProtocolError},
+		{3000, 3000},
+		{4999, 4999},
+		{5000, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		ws, err := Dial(fmt.Sprintf("ws://%s/echoMessages", serverAddr), "", "http://localhost/")
+		if err != nil {
+			t.Fatal("Dial:", err)
+		}
+		if err := ws.WriteClose(tt.code, ""); err != nil {
+			t.Fatal("WriteClose:", err)
+		}
+		_, _, err = ws.ReadMessage()
+		if ce, ok := err.(*CloseError); !ok || ce.Code != tt.echo {
+			t.Errorf("close with status %d: error %v; want close with status %d", tt.code, err, tt.echo)
+		}
+		ws.Close()
+	}
+}